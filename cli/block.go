@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"xdago/common"
+	"xdago/core"
+)
+
+var blockCommand = &Command{
+	Name: "block",
+	Help: "decode and encode raw 512 byte blocks offline",
+	Commands: []*Command{
+		{Name: "decode", Args: "[RAW]", Help: "print the fields of a hex or base64 raw block as json", Setup: setupBlockDecode},
+		{Name: "encode", Args: "[JSON_FILE]", Help: "build the raw block of a decoded json", Setup: setupBlockEncode},
+	},
+}
+
+// parseRawBlock reads a binary block or its hex or base64 text
+func parseRawBlock(data []byte) ([]byte, error) {
+	if len(data) == common.XDAG_BLOCK_SIZE {
+		return data, nil
+	}
+	s := strings.Join(strings.Fields(string(data)), "")
+	raw, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		if raw, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("the block is neither hex nor base64")
+		}
+	}
+	if len(raw) != common.XDAG_BLOCK_SIZE {
+		return nil, core.ErrBlockSize
+	}
+	return raw, nil
+}
+
+// readInput returns the arg, the file or the stdin when both are empty
+func (a *App) readInput(arg, file string) ([]byte, error) {
+	switch {
+	case arg != "" && file != "":
+		return nil, usageErrorf("both an argument and a file given")
+	case arg != "":
+		return []byte(arg), nil
+	case file != "":
+		return os.ReadFile(file)
+	}
+	return io.ReadAll(a.Stdin)
+}
+
+func setupBlockDecode(fs *flag.FlagSet) runFunc {
+	file := fs.String("file", "", "read the block from the file, binary, hex or base64")
+	return func(a *App, args []string) error {
+		if len(args) > 1 {
+			return usageErrorf("unexpected argument %q", args[1])
+		}
+		var arg string
+		if len(args) == 1 && args[0] != "-" {
+			arg = args[0]
+		}
+		data, err := a.readInput(arg, *file)
+		if err != nil {
+			return err
+		}
+		raw, err := parseRawBlock(data)
+		if err != nil {
+			return err
+		}
+		bj, err := core.DecodeBlock(raw)
+		if err != nil {
+			return err
+		}
+		out, _ := json.MarshalIndent(bj, "", "  ")
+		a.printf("%s\n", out)
+		return nil
+	}
+}
+
+func setupBlockEncode(fs *flag.FlagSet) runFunc {
+	b64 := fs.Bool("base64", false, "print base64 instead of hex")
+	out := fs.String("out", "", "write the binary block to the file")
+	return func(a *App, args []string) error {
+		if len(args) > 1 {
+			return usageErrorf("unexpected argument %q", args[1])
+		}
+		var file string
+		if len(args) == 1 && args[0] != "-" {
+			file = args[0]
+		}
+		data, err := a.readInput("", file)
+		if err != nil {
+			return err
+		}
+		var bj core.BlockJson
+		if err = json.Unmarshal(data, &bj); err != nil {
+			return err
+		}
+		raw, err := core.EncodeBlock(&bj)
+		if err != nil {
+			return err
+		}
+		switch {
+		case *out != "":
+			return os.WriteFile(*out, raw, 0644)
+		case *b64:
+			a.printf("%s\n", base64.StdEncoding.EncodeToString(raw))
+		default:
+			a.printf("%s\n", hex.EncodeToString(raw))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"xdago/consensus"
+	"xdago/net/rpc"
+)
+
+const (
+	DEFAULT_RPC_URL = "http://127.0.0.1:10001"
+	RPC_TIMEOUT     = 30 * time.Second
+)
+
+var chainCommand = &Command{
+	Name: "chain",
+	Help: "query the chain of a running node over json-rpc",
+	Commands: []*Command{
+		{Name: "block", Args: "ADDRESS|HASH", Help: "print a block", Setup: setupChainBlock},
+		{Name: "height", Help: "print the number of main blocks", Setup: setupChainHeight},
+		{Name: "mainblocks", Help: "print the latest main blocks", Setup: setupChainMainBlocks},
+		{Name: "stats", Help: "print the state and statistics of the node", Setup: setupChainStats},
+		{Name: "generate", Args: "[N]", Help: "make N (1 by default) main blocks at once on a regtest node", Setup: setupChainGenerate},
+	},
+}
+
+// rpcClient calls the json-rpc api of the node
+type rpcClient struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func addRpcFlags(fs *flag.FlagSet) *rpcClient {
+	c := &rpcClient{http: &http.Client{Timeout: RPC_TIMEOUT}}
+	fs.StringVar(&c.url, "rpc", DEFAULT_RPC_URL, "url of the json-rpc api")
+	fs.StringVar(&c.token, "token", "", "bearer token of the rpc credential")
+	return c
+}
+
+// call returns the raw result of method
+func (c *rpcClient) call(method string, params ...interface{}) (json.RawMessage, error) {
+	id := json.RawMessage("1")
+	req := rpc.Request{JSONRPC: rpc.JSONRPC_VERSION, ID: &id, Method: method}
+	if len(params) > 0 {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		req.Params = p
+	}
+	body, _ := json.Marshal(&req)
+	r, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpc.Error      `json:"error"`
+	}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("bad response: %v", err)
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("%s (code %d)", res.Error.Message, res.Error.Code)
+	}
+	return res.Result, nil
+}
+
+// printJson writes the result indented
+func (a *App) printJson(raw json.RawMessage) error {
+	var b bytes.Buffer
+	if err := json.Indent(&b, raw, "", "  "); err != nil {
+		return err
+	}
+	b.WriteByte('\n')
+	_, err := b.WriteTo(a.Stdout)
+	return err
+}
+
+func (a *App) printCall(c *rpcClient, method string, params ...interface{}) error {
+	res, err := c.call(method, params...)
+	if err != nil {
+		return err
+	}
+	return a.printJson(res)
+}
+
+func setupChainBlock(fs *flag.FlagSet) runFunc {
+	c := addRpcFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) != 1 {
+			return usageErrorf("the block address or hash is required")
+		}
+		res, err := c.call("xdag_getBlockByHash", args[0])
+		if err != nil {
+			return err
+		}
+		if string(res) == "null" {
+			return fmt.Errorf("block %s not found", args[0])
+		}
+		return a.printJson(res)
+	}
+}
+
+func setupChainHeight(fs *flag.FlagSet) runFunc {
+	c := addRpcFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		return a.printCall(c, "xdag_blockNumber")
+	}
+}
+
+func setupChainMainBlocks(fs *flag.FlagSet) runFunc {
+	c := addRpcFlags(fs)
+	count := fs.Int("n", 20, "number of main blocks")
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		if *count <= 0 || *count > rpc.MAX_MAIN_BLOCKS {
+			return usageErrorf("n must be 1 to %d", rpc.MAX_MAIN_BLOCKS)
+		}
+		return a.printCall(c, "xdag_getMainBlocks", *count)
+	}
+}
+
+func setupChainStats(fs *flag.FlagSet) runFunc {
+	c := addRpcFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		return a.printCall(c, "xdag_getStatus")
+	}
+}
+
+func setupChainGenerate(fs *flag.FlagSet) runFunc {
+	c := addRpcFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 1 {
+			return usageErrorf("unexpected argument %q", args[1])
+		}
+		count := 1
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 || n > consensus.MAX_GENERATE_BLOCKS {
+				return usageErrorf("N must be 1 to %d", consensus.MAX_GENERATE_BLOCKS)
+			}
+			count = n
+		}
+		return a.printCall(c, "xdag_generate", count)
+	}
+}
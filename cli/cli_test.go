@@ -0,0 +1,297 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/magiconair/properties/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/db/factory"
+	"xdago/db/store"
+	"xdago/secp256k1"
+)
+
+const TEST_MNEMONIC = "scatter major grant return flee easy female jungle" +
+	" vivid movie bicycle absent weather inspire carry"
+
+// testApp runs the commands with the config of a temp dir and stdin as input
+func testApp(t *testing.T, c *config.Config, stdin string) (*App, *bytes.Buffer, *bytes.Buffer) {
+	var stdout, stderr bytes.Buffer
+	a := NewApp()
+	a.Stdin = strings.NewReader(stdin)
+	a.Stdout = &stdout
+	a.Stderr = &stderr
+	a.LoadConfig = func(network common.NetworkType) (*config.Config, error) {
+		c.SetNetwork(network)
+		return c, nil
+	}
+	a.RunMonitor = func(*config.Config) error {
+		t.Fatal("node monitor started")
+		return nil
+	}
+	return a, &stdout, &stderr
+}
+
+func testConfig(t *testing.T) *config.Config {
+	dir := t.TempDir()
+	c := &config.Config{}
+	c.SetStoreDir(path.Join(dir, "store"))
+	c.SetWalletFilePath(path.Join(dir, "wallet", common.WALLET_FILE_NAME))
+	return c
+}
+
+func run(t *testing.T, c *config.Config, stdin string, args ...string) (int, string, string) {
+	a, stdout, stderr := testApp(t, c, stdin)
+	code := a.Run(args)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestUsage(t *testing.T) {
+	c := testConfig(t)
+	code, out, _ := run(t, c, "")
+	assert.Equal(t, code, EXIT_USAGE)
+	assert.Matches(t, out, "(?s)Commands:.*chain.*db.*node.*wallet")
+
+	code, out, _ = run(t, c, "", "--help")
+	assert.Equal(t, code, EXIT_OK)
+	code, out, _ = run(t, c, "", "wallet", "-h")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Matches(t, out, "(?s)change-password.*new-address")
+
+	code, _, errOut := run(t, c, "", "wallet", "burn")
+	assert.Equal(t, code, EXIT_USAGE)
+	assert.Matches(t, errOut, `unknown command "burn"`)
+
+	for _, args := range [][]string{{"wallet", "create"}, {"wallet", "restore"}, {"wallet", "list"},
+		{"wallet", "new-address"}, {"wallet", "export"}, {"wallet", "import"}, {"wallet", "change-password"},
+		{"chain", "block"}, {"chain", "height"}, {"chain", "mainblocks"}, {"chain", "stats"},
+		{"node", "monitor"}, {"db", "check"}, {"db", "compact"}, {"block", "decode"}, {"block", "encode"}} {
+		code, out, _ = run(t, c, "", append(args, "--help")...)
+		assert.Equal(t, code, EXIT_OK)
+		assert.Matches(t, out, "^Usage: xdago "+strings.Join(args, " "))
+	}
+
+	code, _, errOut = run(t, c, "", "chain", "height", "--bogus")
+	assert.Equal(t, code, EXIT_USAGE)
+	assert.Matches(t, errOut, "flag provided but not defined")
+	code, _, errOut = run(t, c, "", "node", "monitor", "-d", "-t")
+	assert.Equal(t, code, EXIT_USAGE)
+	assert.Matches(t, errOut, "more than one network")
+	code, _, errOut = run(t, c, "", "wallet", "list", "--network", "moon")
+	assert.Equal(t, code, EXIT_USAGE)
+	assert.Matches(t, errOut, `unknown network "moon"`)
+}
+
+func TestNetworkFlags(t *testing.T) {
+	for _, tt := range []struct {
+		args    []string
+		network common.NetworkType
+	}{
+		{nil, common.MAINNET},
+		{[]string{"-d"}, common.DEVNET},
+		{[]string{"-t"}, common.TESTNET},
+		{[]string{"--network", "testnet"}, common.TESTNET},
+		{[]string{"-t", "--network", "testnet"}, common.TESTNET},
+	} {
+		var started *config.Config
+		a, _, _ := testApp(t, testConfig(t), "")
+		a.RunMonitor = func(c *config.Config) error {
+			started = c
+			return nil
+		}
+		assert.Equal(t, a.Run(append([]string{"node", "monitor"}, tt.args...)), EXIT_OK)
+		assert.Equal(t, started.Network(), tt.network)
+	}
+	// there is no node start until the blockchain services exist
+	code, _, errOut := run(t, testConfig(t), "", "node", "start")
+	assert.Equal(t, code, EXIT_USAGE)
+	assert.Matches(t, errOut, `unknown command "start"`)
+	code, _, _ = run(t, testConfig(t), "", "node", "monitor", "--tag", "pool")
+	assert.Equal(t, code, EXIT_USAGE)
+}
+
+func TestWallet(t *testing.T) {
+	c := testConfig(t)
+	code, _, errOut := run(t, c, "", "wallet", "list")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "wallet not found")
+
+	code, _, errOut = run(t, c, "pw\nother\n", "wallet", "create")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "passwords do not match")
+
+	code, _, errOut = run(t, c, "not a mnemonic\n", "wallet", "restore")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "invalid mnemonic")
+
+	code, out, _ := run(t, c, TEST_MNEMONIC+"\npw\npw\n", "wallet", "restore", "--accounts", "2")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Matches(t, out, "created with 2 account")
+	code, _, errOut = run(t, c, "pw\npw\n", "wallet", "create")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "wallet already exists")
+
+	code, _, errOut = run(t, c, "wrong\n", "wallet", "list")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "wrong password")
+	code, list, _ := run(t, c, "pw\n", "wallet", "list")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, len(strings.Split(strings.TrimSpace(list), "\n")), 2)
+
+	// the next hd key follows the restored ones
+	code, out, _ = run(t, c, "pw\n", "wallet", "new-address")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Matches(t, out, "^2 1[1-9A-HJ-NP-Za-km-z]{25,34}\n$")
+
+	code, key, errOut := run(t, c, "pw\n", "wallet", "export", "--index", "1")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Matches(t, key, "^[0-9a-f]{64}\n$")
+	assert.Matches(t, errOut, "spend the funds")
+	code, _, _ = run(t, c, "pw\n", "wallet", "export", "--index", "3")
+	assert.Equal(t, code, EXIT_ERROR)
+
+	code, _, errOut = run(t, c, "pw\n", "wallet", "import", strings.TrimSpace(key))
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "already in the wallet")
+	code, _, _ = run(t, c, "pw\n", "wallet", "import", "zz")
+	assert.Equal(t, code, EXIT_ERROR)
+	newKey, _ := secp256k1.GeneratePrivateKey()
+	code, out, _ = run(t, c, "pw\n", "wallet", "import", "0x"+hex.EncodeToString(newKey.Serialize()))
+	assert.Equal(t, code, EXIT_OK)
+	assert.Matches(t, out, "^3 ")
+
+	code, _, _ = run(t, c, "pw\nnew\nnew\n", "wallet", "change-password")
+	assert.Equal(t, code, EXIT_OK)
+	code, _, _ = run(t, c, "pw\n", "wallet", "list")
+	assert.Equal(t, code, EXIT_ERROR)
+	code, out, _ = run(t, c, "new\n", "wallet", "list")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, strings.HasPrefix(out, list), true)
+}
+
+func TestChain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.Unmarshal(body, &req)
+		switch req.Method {
+		case "xdag_blockNumber":
+			io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":42}`)
+		case "xdag_getMainBlocks":
+			io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":[{"height":`+string(req.Params[0])+`}]}`)
+		case "xdag_getBlockByHash":
+			io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":null}`)
+		default:
+			io.WriteString(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found"}}`)
+		}
+	}))
+	defer srv.Close()
+	c := testConfig(t)
+
+	code, out, _ := run(t, c, "", "chain", "height", "--rpc", srv.URL, "--token", "secret")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, out, "42\n")
+	code, out, _ = run(t, c, "", "chain", "mainblocks", "--rpc", srv.URL, "--token", "secret", "-n", "3")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, out, "[\n  {\n    \"height\": 3\n  }\n]\n")
+	code, _, errOut := run(t, c, "", "chain", "block", "--rpc", srv.URL, "--token", "secret", "abc")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "block abc not found")
+	code, _, errOut = run(t, c, "", "chain", "stats", "--rpc", srv.URL, "--token", "secret")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "method not found")
+	code, _, errOut = run(t, c, "", "chain", "height", "--rpc", srv.URL)
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "401")
+	code, _, _ = run(t, c, "", "chain", "mainblocks", "-n", "0")
+	assert.Equal(t, code, EXIT_USAGE)
+}
+
+func TestDb(t *testing.T) {
+	c := testConfig(t)
+	code, _, errOut := run(t, c, "", "db", "check")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "no store")
+
+	kv := factory.NewKvStoreFactory(c)
+	bs := store.NewBlockStore(kv.GetDB(common.DB_INDEX), kv.GetDB(common.DB_TIME), kv.GetDB(common.DB_BLOCK))
+	bs.Init()
+	key, _ := secp256k1.GeneratePrivateKey()
+	for i := uint64(1); i <= 3; i++ {
+		bs.SaveBlock(core.GenerateAddressBlock(c, key, 0x1000<<16+i))
+	}
+	kv.Close()
+
+	code, out, _ := run(t, c, "", "db", "check")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Matches(t, out, "(?s)index +[1-9][0-9]* keys\nblock +3 blocks, 0 bad\ntime +3 keys\nok\n")
+	code, out, _ = run(t, c, "", "db", "compact")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Matches(t, out, "(?s)index +compacted\nblock +compacted\ntime +compacted")
+
+	kv = factory.NewKvStoreFactory(c)
+	blocks := kv.GetDB(common.DB_BLOCK)
+	blocks.Init()
+	blocks.Put(bytes.Repeat([]byte{1}, 32), make([]byte, common.XDAG_BLOCK_SIZE))
+	kv.Close()
+	code, out, errOut = run(t, c, "", "db", "check")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, out, "block +4 blocks, 1 bad")
+	assert.Matches(t, errOut, "bad block 0101")
+}
+
+func TestBlockCodec(t *testing.T) {
+	c := testConfig(t)
+	key, _ := secp256k1.GeneratePrivateKey()
+	raw := core.GenerateAddressBlock(c, key, 0x1000<<16).ToBytes()
+
+	code, decoded, _ := run(t, c, "", "block", "decode", hex.EncodeToString(raw))
+	assert.Equal(t, code, EXIT_OK)
+	assert.Matches(t, decoded, `"verified": false`)
+	code, out, _ := run(t, c, base64.StdEncoding.EncodeToString(raw), "block", "decode")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, out, decoded)
+	file := path.Join(t.TempDir(), "block.bin")
+	os.WriteFile(file, raw, 0600)
+	code, out, _ = run(t, c, "", "block", "decode", "--file", file)
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, out, decoded)
+	code, _, errOut := run(t, c, "", "block", "decode", "abcd")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Matches(t, errOut, "not 512 bytes")
+
+	code, out, _ = run(t, c, decoded, "block", "encode")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, out, hex.EncodeToString(raw)+"\n")
+	code, out, _ = run(t, c, decoded, "block", "encode", "--base64")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, out, base64.StdEncoding.EncodeToString(raw)+"\n")
+	code, _, _ = run(t, c, decoded, "block", "encode", "--out", file+".out")
+	assert.Equal(t, code, EXIT_OK)
+	written, _ := os.ReadFile(file + ".out")
+	assert.Equal(t, bytes.Equal(written, raw), true)
+	code, _, _ = run(t, c, "{}", "block", "encode")
+	assert.Equal(t, code, EXIT_ERROR)
+}
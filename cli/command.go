@@ -1 +1,238 @@
+// Package cli is the operator command line of the node:
+//
+//	xdago wallet create|restore|list|new-address|export|import|change-password
+//	xdago chain block|height|mainblocks|stats|generate
+//	xdago node monitor
+//	xdago db check|compact
+//	xdago block decode|encode
+//
+// Every command takes --help. Run returns the exit code, 0 on success, 1 when
+// the command failed and 2 on a usage error.
 package cli
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"xdago/common"
+	"xdago/config"
+)
+
+const PROGRAM = "xdago"
+
+// exit codes of Run
+const (
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	EXIT_USAGE = 2
+)
+
+// UsageError is a wrong command line, the usage of the command is printed
+type UsageError struct {
+	msg string
+}
+
+func (e *UsageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &UsageError{fmt.Sprintf(format, args...)}
+}
+
+type runFunc func(a *App, args []string) error
+
+// Command is a group of subcommands or a command run by the func returned
+// from Setup, which registers the flags of the command first
+type Command struct {
+	Name     string
+	Args     string // synopsis of the positional arguments
+	Help     string
+	Commands []*Command
+	Setup    func(fs *flag.FlagSet) runFunc
+}
+
+// App runs the commands, the fields may be replaced before Run
+type App struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// LoadConfig returns the config of the network
+	LoadConfig func(network common.NetworkType) (*config.Config, error)
+	// RunMonitor serves the metrics of the node until it is stopped
+	RunMonitor func(c *config.Config) error
+
+	root *Command
+	in   *bufio.Reader
+}
+
+func NewApp() *App {
+	return &App{
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		LoadConfig: config.Load,
+		RunMonitor: runMonitor,
+		root: &Command{
+			Name:     PROGRAM,
+			Help:     "XDAG node written in Go",
+			Commands: []*Command{walletCommand, chainCommand, nodeCommand, dbCommand, blockCommand},
+		},
+	}
+}
+
+// Run runs the command line args, without the program name
+func (a *App) Run(args []string) int {
+	a.in = bufio.NewReader(a.Stdin)
+	cmd, path := a.root, []string{PROGRAM}
+	for cmd.Setup == nil {
+		if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+			a.groupUsage(a.Stdout, cmd, path)
+			if len(args) == 0 {
+				return EXIT_USAGE
+			}
+			return EXIT_OK
+		}
+		sub := cmd.find(args[0])
+		if sub == nil {
+			fmt.Fprintf(a.Stderr, "%s: unknown command %q\n\n", strings.Join(path, " "), args[0])
+			a.groupUsage(a.Stderr, cmd, path)
+			return EXIT_USAGE
+		}
+		cmd, path, args = sub, append(path, sub.Name), args[1:]
+	}
+
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	run := cmd.Setup(fs)
+	usage := func(w io.Writer) {
+		fmt.Fprintf(w, "Usage: %s [flags] %s\n\n%s\n", strings.Join(path, " "), cmd.Args, cmd.Help)
+		fs.SetOutput(w)
+		fmt.Fprintln(w, "\nFlags:")
+		fs.PrintDefaults()
+		fs.SetOutput(io.Discard)
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			usage(a.Stdout)
+			return EXIT_OK
+		}
+		fmt.Fprintf(a.Stderr, "%s: %v\n\n", fs.Name(), err)
+		usage(a.Stderr)
+		return EXIT_USAGE
+	}
+	if err := run(a, fs.Args()); err != nil {
+		fmt.Fprintf(a.Stderr, "%s: %v\n", fs.Name(), err)
+		var ue *UsageError
+		if errors.As(err, &ue) {
+			fmt.Fprintln(a.Stderr)
+			usage(a.Stderr)
+			return EXIT_USAGE
+		}
+		return EXIT_ERROR
+	}
+	return EXIT_OK
+}
+
+func (c *Command) find(name string) *Command {
+	for _, sub := range c.Commands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (a *App) groupUsage(w io.Writer, cmd *Command, path []string) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags] [args]\n\n%s\n\nCommands:\n", strings.Join(path, " "), cmd.Help)
+	subs := append([]*Command{}, cmd.Commands...)
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Name < subs[j].Name })
+	for _, sub := range subs {
+		fmt.Fprintf(w, "  %-16s %s\n", sub.Name, sub.Help)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> --help' for the help of a command.\n", strings.Join(path, " "))
+}
+
+func (a *App) printf(format string, args ...interface{}) {
+	fmt.Fprintf(a.Stdout, format, args...)
+}
+
+// readLine reads a line of the input without the line end
+func (a *App) readLine(prompt string) (string, error) {
+	fmt.Fprint(a.Stderr, prompt)
+	line, err := a.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readPassword reads a line without echo when the input is a terminal
+func (a *App) readPassword(prompt string) (string, error) {
+	if f, ok := a.Stdin.(*os.File); ok {
+		if restore, err := disableEcho(f); err == nil {
+			defer func() {
+				restore()
+				fmt.Fprintln(a.Stderr)
+			}()
+		}
+	}
+	return a.readLine(prompt)
+}
+
+// networkFlags are the network selection of the commands using the config
+type networkFlags struct {
+	devnet  bool
+	testnet bool
+	network string
+}
+
+func addNetworkFlags(fs *flag.FlagSet) *networkFlags {
+	f := &networkFlags{}
+	fs.BoolVar(&f.devnet, "d", false, "use the devnet")
+	fs.BoolVar(&f.testnet, "t", false, "use the testnet")
+	fs.StringVar(&f.network, "network", "", "the network: mainnet, testnet, devnet or regtest (default mainnet)")
+	return f
+}
+
+func (f *networkFlags) selected() (common.NetworkType, error) {
+	var nets []common.NetworkType
+	if f.devnet {
+		nets = append(nets, common.DEVNET)
+	}
+	if f.testnet {
+		nets = append(nets, common.TESTNET)
+	}
+	if f.network != "" {
+		n, ok := map[string]common.NetworkType{
+			common.MAINNET.String(): common.MAINNET,
+			common.TESTNET.String(): common.TESTNET,
+			common.DEVNET.String():  common.DEVNET,
+			common.REGTEST.String(): common.REGTEST,
+		}[f.network]
+		if !ok {
+			return 0, usageErrorf("unknown network %q", f.network)
+		}
+		nets = append(nets, n)
+	}
+	switch {
+	case len(nets) == 0:
+		return common.MAINNET, nil
+	case len(nets) > 1 && (nets[0] != nets[1] || len(nets) > 2):
+		return 0, usageErrorf("more than one network selected")
+	}
+	return nets[0], nil
+}
+
+func (f *networkFlags) config(a *App) (*config.Config, error) {
+	network, err := f.selected()
+	if err != nil {
+		return nil, err
+	}
+	return a.LoadConfig(network)
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/db"
+	"xdago/db/factory"
+)
+
+// stores are the databases of the node in the order they are checked
+var stores = []common.DatabaseName{common.DB_INDEX, common.DB_BLOCK, common.DB_TIME, common.DB_ORPHANIND,
+	common.DB_SNAPSHOT, common.DB_POOL, common.DB_MINERS}
+
+var dbCommand = &Command{
+	Name: "db",
+	Help: "check and maintain the stores of a stopped node",
+	Commands: []*Command{
+		{Name: "check", Help: "verify the raw blocks and count the keys of the stores", Setup: setupDbCheck},
+		{Name: "compact", Help: "compact the stores", Setup: setupDbCompact},
+	},
+}
+
+// openStores calls f with the existing stores, the missing ones are skipped
+func (a *App) openStores(c *config.Config, f func(name common.DatabaseName, kv db.IKVSource) error) error {
+	kv := factory.NewKvStoreFactory(c)
+	defer kv.Close()
+	found := false
+	for _, name := range stores {
+		if _, err := os.Stat(path.Join(c.StoreDir(), strconv.Itoa(int(name)))); err != nil {
+			continue
+		}
+		found = true
+		source := kv.GetDB(name)
+		source.Init()
+		if err := f(name, source); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("no store in %s", c.StoreDir())
+	}
+	return nil
+}
+
+// checkBlocks verifies that the raw blocks are stored by their hash, it
+// returns the number of blocks and the keys of the bad ones
+func checkBlocks(kv db.IKVSource) (count int, bad [][]byte) {
+	kv.FetchPrefix(nil, func(k, v []byte) bool {
+		count++
+		if len(v) != common.XDAG_BLOCK_SIZE {
+			bad = append(bad, k)
+			return false
+		}
+		h := core.NewBlockFromXdag(core.NewXdagBlock(v)).GetHashLow()
+		if !bytes.Equal(h[:], k) {
+			bad = append(bad, k)
+		}
+		return false
+	})
+	return
+}
+
+func setupDbCheck(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		c, err := nf.config(a)
+		if err != nil {
+			return err
+		}
+		bad := 0
+		err = a.openStores(c, func(name common.DatabaseName, kv db.IKVSource) error {
+			if name != common.DB_BLOCK {
+				a.printf("%-8s %d keys\n", name, len(kv.Keys()))
+				return nil
+			}
+			count, keys := checkBlocks(kv)
+			a.printf("%-8s %d blocks, %d bad\n", name, count, len(keys))
+			for _, k := range keys {
+				fmt.Fprintf(a.Stderr, "bad block %s\n", hex.EncodeToString(k))
+			}
+			bad += len(keys)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if bad > 0 {
+			return fmt.Errorf("%d bad blocks", bad)
+		}
+		a.printf("ok\n")
+		return nil
+	}
+}
+
+func setupDbCompact(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		c, err := nf.config(a)
+		if err != nil {
+			return err
+		}
+		return a.openStores(c, func(name common.DatabaseName, kv db.IKVSource) error {
+			compactor, ok := kv.(db.IKVCompactor)
+			if !ok {
+				return errors.New("the kv store can't be compacted")
+			}
+			if err := compactor.Compact(); err != nil {
+				return fmt.Errorf("compact %s: %w", name, err)
+			}
+			a.printf("%-8s compacted\n", name)
+			return nil
+		})
+	}
+}
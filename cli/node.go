@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"xdago/common"
+	"xdago/config"
+	"xdago/db/factory"
+	"xdago/db/store"
+	"xdago/log"
+	"xdago/metrics"
+	"xdago/net/monitor"
+)
+
+var nodeCommand = &Command{
+	Name: "node",
+	Help: "run the node",
+	Commands: []*Command{
+		{Name: "monitor", Help: "open the stores and serve the metrics until interrupted", Setup: setupNodeMonitor},
+	},
+}
+
+// setupNodeMonitor runs the part of the node that exists today, the
+// blockchain, p2p, pool and rpc services need a core.IBlockchain which is not
+// implemented yet
+func setupNodeMonitor(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		c, err := nf.config(a)
+		if err != nil {
+			return err
+		}
+		return a.RunMonitor(c)
+	}
+}
+
+// runMonitor opens the stores and serves the metrics and health endpoints
+// until SIGINT or SIGTERM, SIGHUP and a change of the config file reload the
+// config
+func runMonitor(c *config.Config) error {
+	logs := log.NewGlogHandler(log.StderrHandler)
+	logs.Verbosity(log.Lvl(c.LogVerbosity()))
+	log.Root().SetHandler(logs)
+	c.OnReload(func(c *config.Config) {
+		logs.Verbosity(log.Lvl(c.LogVerbosity()))
+	})
+	if err := c.Watch(); err != nil {
+		log.Warn("config file not watched", log.Ctx{"err": err.Error()})
+	}
+
+	kv := factory.NewKvStoreFactory(c)
+	defer kv.Close()
+	blockStore := store.NewBlockStore(kv.GetDB(common.DB_INDEX), kv.GetDB(common.DB_TIME), kv.GetDB(common.DB_BLOCK))
+	blockStore.Init()
+	orphanPool := store.NewOrphanPool(kv.GetDB(common.DB_ORPHANIND))
+	orphanPool.Init()
+
+	m := monitor.NewServer(c, metrics.DefaultRegistry)
+	m.SetOrphanPool(orphanPool)
+	m.SetStores(kv.Sources())
+	if err := m.Start(); err != nil {
+		return err
+	}
+	defer m.Stop()
+	log.Info("node monitor started", log.Ctx{"network": c.Network().String(),
+		"metrics": net.JoinHostPort(c.MetricsHost(), strconv.Itoa(c.MetricsPort()))})
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	for s := range sig {
+		if s == syscall.SIGHUP {
+			c.Reload(config.RELOAD_SIGHUP)
+			continue
+		}
+		log.Info("node monitor stopped", log.Ctx{"signal": s.String()})
+		break
+	}
+	return nil
+}
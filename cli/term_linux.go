@@ -0,0 +1,25 @@
+//go:build linux
+
+package cli
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// disableEcho turns the echo of the terminal f off, the returned func turns
+// it back on
+func disableEcho(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	t := *old
+	t.Lflag &^= unix.ECHO
+	t.Lflag |= unix.ICANON | unix.ISIG
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, &t); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, unix.TCSETS, old) }, nil
+}
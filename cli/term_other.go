@@ -0,0 +1,13 @@
+//go:build !linux
+
+package cli
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho is only supported on linux, the password is echoed elsewhere
+func disableEcho(f *os.File) (func(), error) {
+	return nil, errors.New("echo can't be disabled")
+}
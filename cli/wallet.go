@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/tyler-smith/go-bip39"
+	"strings"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/crypto"
+	"xdago/secp256k1"
+	"xdago/wallet"
+)
+
+const MNEMONIC_ENTROPY_BITS = 128 // 12 words
+
+var (
+	ErrWalletExists    = errors.New("wallet already exists")
+	ErrNoWallet        = errors.New("wallet not found, create or restore it first")
+	ErrWrongPassword   = errors.New("wrong password")
+	ErrEmptyPassword   = errors.New("password can not be empty")
+	ErrPasswordsDiffer = errors.New("passwords do not match")
+)
+
+var walletCommand = &Command{
+	Name: "wallet",
+	Help: "create and manage the wallet",
+	Commands: []*Command{
+		{Name: "create", Help: "create a HD wallet and print its mnemonic", Setup: setupWalletCreate},
+		{Name: "restore", Help: "restore a HD wallet from its mnemonic", Setup: setupWalletRestore},
+		{Name: "list", Help: "list the accounts of the wallet", Setup: setupWalletList},
+		{Name: "new-address", Help: "add an account to the wallet", Setup: setupWalletNewAddress},
+		{Name: "export", Help: "print the private key of an account", Setup: setupWalletExport},
+		{Name: "import", Args: "KEY", Help: "add the account of a hex private key", Setup: setupWalletImport},
+		{Name: "change-password", Help: "encrypt the wallet with a new password", Setup: setupWalletChangePassword},
+	},
+}
+
+// newPassword asks the password twice
+func (a *App) newPassword(prompt string) (string, error) {
+	pwd, err := a.readPassword(prompt + ": ")
+	if err != nil {
+		return "", err
+	}
+	if pwd == "" {
+		return "", ErrEmptyPassword
+	}
+	again, err := a.readPassword("Repeat " + strings.ToLower(prompt) + ": ")
+	if err != nil {
+		return "", err
+	}
+	if pwd != again {
+		return "", ErrPasswordsDiffer
+	}
+	return pwd, nil
+}
+
+// openWallet unlocks the existing wallet of the network
+func (a *App) openWallet(nf *networkFlags) (*wallet.Wallet, common.NetworkType, error) {
+	c, err := nf.config(a)
+	if err != nil {
+		return nil, 0, err
+	}
+	w := wallet.NewWallet(c)
+	if !w.Exists() {
+		return nil, 0, ErrNoWallet
+	}
+	pwd, err := a.readPassword("Password: ")
+	if err != nil {
+		return nil, 0, err
+	}
+	if pwd == "" {
+		return nil, 0, ErrEmptyPassword
+	}
+	if !w.UnlockWallet(pwd) {
+		return nil, 0, ErrWrongPassword
+	}
+	return &w, c.Network(), nil
+}
+
+// printAccount prints the index and the base58check address of an account
+func (a *App) printAccount(index int, key *secp256k1.PrivateKey, network common.NetworkType) {
+	a.printf("%d %s\n", index, codec.Hash160ToAccount(crypto.ToBytesAddress(key), network))
+}
+
+// newWallet creates the HD wallet of mnemonic with accounts keys
+func (a *App) newWallet(nf *networkFlags, mnemonic string, accounts int) error {
+	c, err := nf.config(a)
+	if err != nil {
+		return err
+	}
+	w := wallet.NewWallet(c)
+	if w.Exists() {
+		return fmt.Errorf("%w: %s", ErrWalletExists, w.GetFile())
+	}
+	if mnemonic == "" {
+		entropy, err := bip39.NewEntropy(MNEMONIC_ENTROPY_BITS)
+		if err != nil {
+			return err
+		}
+		if mnemonic, err = bip39.NewMnemonic(entropy); err != nil {
+			return err
+		}
+		a.printf("Mnemonic: %s\n", mnemonic)
+		fmt.Fprintln(a.Stderr, "Write the mnemonic down, it is the only backup of the wallet.")
+	}
+	pwd, err := a.newPassword("Password")
+	if err != nil {
+		return err
+	}
+	w.UnlockWallet(pwd)
+	w.InitializeHdWallet(mnemonic)
+	for i := 0; i < accounts; i++ {
+		w.AddAccountWithNextHdKey()
+	}
+	w.Flush()
+	a.printf("Wallet %s created with %d account(s)\n", w.GetFile(), accounts)
+	return nil
+}
+
+func setupWalletCreate(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		return a.newWallet(nf, "", 1)
+	}
+}
+
+func setupWalletRestore(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	mnemonic := fs.String("mnemonic", "", "the mnemonic, asked when not set")
+	accounts := fs.Int("accounts", 1, "number of accounts to derive")
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		if *accounts < 1 {
+			return usageErrorf("accounts must be at least 1")
+		}
+		m := *mnemonic
+		if m == "" {
+			var err error
+			if m, err = a.readLine("Mnemonic: "); err != nil {
+				return err
+			}
+		}
+		m = strings.Join(strings.Fields(m), " ")
+		if !bip39.IsMnemonicValid(m) {
+			return errors.New("invalid mnemonic")
+		}
+		return a.newWallet(nf, m, *accounts)
+	}
+}
+
+func setupWalletList(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		w, network, err := a.openWallet(nf)
+		if err != nil {
+			return err
+		}
+		for i, key := range w.GetAccounts() {
+			a.printAccount(i, key, network)
+		}
+		return nil
+	}
+}
+
+func setupWalletNewAddress(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		w, network, err := a.openWallet(nf)
+		if err != nil {
+			return err
+		}
+		if w.IsHdWalletInitialized() {
+			w.AddAccountWithNextHdKey()
+		} else {
+			w.AddAccountRandom()
+		}
+		w.Flush()
+		accounts := w.GetAccounts()
+		a.printAccount(len(accounts)-1, accounts[len(accounts)-1], network)
+		return nil
+	}
+}
+
+func setupWalletExport(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	index := fs.Int("index", 0, "index of the account")
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		w, _, err := a.openWallet(nf)
+		if err != nil {
+			return err
+		}
+		if *index < 0 || *index >= len(w.GetAccounts()) {
+			return fmt.Errorf("no account %d", *index)
+		}
+		fmt.Fprintln(a.Stderr, "Anyone knowing the private key can spend the funds of the account.")
+		a.printf("%s\n", hex.EncodeToString(w.GetAccount(*index).Serialize()))
+		return nil
+	}
+}
+
+func setupWalletImport(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) != 1 {
+			return usageErrorf("the private key is required")
+		}
+		b, err := hex.DecodeString(strings.TrimPrefix(args[0], "0x"))
+		if err != nil || len(b) != secp256k1.PrivKeyBytesLen {
+			return errors.New("the private key must be 32 hex bytes")
+		}
+		key := secp256k1.PrivKeyFromBytes(b)
+		w, network, err := a.openWallet(nf)
+		if err != nil {
+			return err
+		}
+		address := crypto.ToBytesAddress(key)
+		if w.GetAccountByAddress(address) != nil {
+			return fmt.Errorf("account %s already in the wallet", codec.Hash160ToAccount(address, network))
+		}
+		w.AddAccount(key)
+		w.Flush()
+		a.printAccount(len(w.GetAccounts())-1, key, network)
+		return nil
+	}
+}
+
+func setupWalletChangePassword(fs *flag.FlagSet) runFunc {
+	nf := addNetworkFlags(fs)
+	return func(a *App, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf("unexpected argument %q", args[0])
+		}
+		w, _, err := a.openWallet(nf)
+		if err != nil {
+			return err
+		}
+		pwd, err := a.newPassword("New password")
+		if err != nil {
+			return err
+		}
+		w.ChangePassword(pwd)
+		w.Flush()
+		a.printf("Password changed\n")
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package codec
+
+import (
+	"errors"
+	"xdago/common"
+)
+
+var ErrInvalidAccount = errors.New("invalid account address")
+
+// AddressVersion returns the base58check version byte of the network
+func AddressVersion(network common.NetworkType) byte {
+	switch network {
+	case common.TESTNET:
+		return common.ADDRESS_VERSION_TESTNET
+	case common.DEVNET:
+		return common.ADDRESS_VERSION_DEVNET
+	case common.REGTEST:
+		return common.ADDRESS_VERSION_REGTEST
+	default:
+		return common.ADDRESS_VERSION_MAINNET
+	}
+}
+
+// Hash160ToAccount encodes a wallet account of the network in base58check
+func Hash160ToAccount(h common.Hash160, network common.NetworkType) string {
+	return Base58CheckEncode(AddressVersion(network), h[:])
+}
+
+// AccountToHash160 decodes a base58check account and returns its network
+func AccountToHash160(account string) (common.Hash160, common.NetworkType, error) {
+	var h common.Hash160
+	version, payload, err := Base58CheckDecode(account)
+	if err != nil {
+		return h, 0, err
+	}
+	if len(payload) != len(h) {
+		return h, 0, ErrInvalidAccount
+	}
+	for _, network := range []common.NetworkType{common.MAINNET, common.TESTNET, common.DEVNET, common.REGTEST} {
+		if AddressVersion(network) == version {
+			copy(h[:], payload)
+			return h, network, nil
+		}
+	}
+	return h, 0, ErrInvalidAccount
+}
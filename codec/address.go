@@ -0,0 +1,57 @@
+// Package codec converts the hashes of the node to the text forms shown to
+// the users: the 32 character base64 xdag address of a block hashlow and the
+// base58check address of a Hash160 wallet account.
+package codec
+
+import (
+	"encoding/base64"
+	"errors"
+	"xdago/common"
+	"xdago/utils"
+)
+
+const XDAG_ADDRESS_LENGTH = 32
+
+var ErrInvalidAddress = errors.New("invalid xdag address")
+
+// xdagEncoding is base64 with the alphabet of classic xdag, which ends in "/+"
+// where the standard one ends in "+/"
+var xdagEncoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789/+")
+
+// Hash2Address encodes the last 24 bytes of a hashlow in xdag base64
+func Hash2Address(h common.Hash) string {
+	return xdagEncoding.EncodeToString(h[8:])
+}
+
+// Address2Hash decodes an xdag address to its hashlow
+func Address2Hash(address string) (common.Hash, error) {
+	var h common.Hash
+	if len(address) != XDAG_ADDRESS_LENGTH {
+		return h, ErrInvalidAddress
+	}
+	b, err := xdagEncoding.DecodeString(address)
+	if err != nil {
+		return h, ErrInvalidAddress
+	}
+	copy(h[8:], b)
+	return h, nil
+}
+
+// IsAddress reports whether s is an xdag address
+func IsAddress(s string) bool {
+	_, err := Address2Hash(s)
+	return err == nil
+}
+
+// ParseHash accepts an xdag address or a hashlow as printed by
+// utils.Hash2String
+func ParseHash(s string) (common.Hash, error) {
+	if h, err := Address2Hash(s); err == nil {
+		return h, nil
+	}
+	h, err := utils.String2Hash(s)
+	if err != nil {
+		return h, ErrInvalidAddress
+	}
+	return h, nil
+}
@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+const BASE58_ALPHABET = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+const CHECKSUM_LENGTH = 4
+
+var (
+	ErrInvalidBase58 = errors.New("invalid base58 string")
+	ErrChecksum      = errors.New("base58check checksum mismatch")
+)
+
+var base58Index = func() (index [256]int) {
+	for i := range index {
+		index[i] = -1
+	}
+	for i := 0; i < len(BASE58_ALPHABET); i++ {
+		index[BASE58_ALPHABET[i]] = i
+	}
+	return
+}()
+
+var bigRadix = big.NewInt(58)
+
+// Base58Encode encodes b, every leading zero byte becomes a '1'
+func Base58Encode(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+	x := new(big.Int).SetBytes(b)
+	mod := new(big.Int)
+	var res []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, bigRadix, mod)
+		res = append(res, BASE58_ALPHABET[mod.Int64()])
+	}
+	for i := 0; i < zeros; i++ {
+		res = append(res, BASE58_ALPHABET[0])
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return string(res)
+}
+
+// Base58Decode is the inverse of Base58Encode
+func Base58Decode(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == BASE58_ALPHABET[0] {
+		zeros++
+	}
+	x := new(big.Int)
+	for i := 0; i < len(s); i++ {
+		d := base58Index[s[i]]
+		if d < 0 {
+			return nil, ErrInvalidBase58
+		}
+		x.Mul(x, bigRadix)
+		x.Add(x, big.NewInt(int64(d)))
+	}
+	return append(make([]byte, zeros), x.Bytes()...), nil
+}
+
+func checksum(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+	return second[:CHECKSUM_LENGTH]
+}
+
+// Base58CheckEncode encodes the version, the payload and the first 4 bytes
+// of their double sha256
+func Base58CheckEncode(version byte, payload []byte) string {
+	b := make([]byte, 0, 1+len(payload)+CHECKSUM_LENGTH)
+	b = append(b, version)
+	b = append(b, payload...)
+	return Base58Encode(append(b, checksum(b)...))
+}
+
+// Base58CheckDecode returns the version and the payload of a checked string
+func Base58CheckDecode(s string) (byte, []byte, error) {
+	b, err := Base58Decode(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(b) < 1+CHECKSUM_LENGTH {
+		return 0, nil, ErrInvalidBase58
+	}
+	data, sum := b[:len(b)-CHECKSUM_LENGTH], b[len(b)-CHECKSUM_LENGTH:]
+	if string(checksum(data)) != string(sum) {
+		return 0, nil, ErrChecksum
+	}
+	return data[0], data[1:], nil
+}
@@ -0,0 +1,82 @@
+package codec
+
+import (
+	"encoding/hex"
+	"github.com/magiconair/properties/assert"
+	"testing"
+	"xdago/common"
+	"xdago/utils"
+)
+
+func TestXdagAddress(t *testing.T) {
+	h, err := Address2Hash(common.FUND_ADDRESS)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, h[:8], make([]byte, 8))
+	assert.Equal(t, Hash2Address(h), common.FUND_ADDRESS)
+	assert.Equal(t, IsAddress(common.FUND_ADDRESS), true)
+	assert.Equal(t, IsAddress(common.FUND_ADDRESS[1:]), false)
+	assert.Equal(t, IsAddress("FQglVQtb60vQv2DOWEUL7yh3smtj7g1!"), false)
+
+	parsed, err := ParseHash(utils.Hash2String(h))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, parsed, h)
+	parsed, _ = ParseHash(common.FUND_ADDRESS)
+	assert.Equal(t, parsed, h)
+	_, err = ParseHash("xyz")
+	assert.Equal(t, err, ErrInvalidAddress)
+
+	// the xdag alphabet ends in "/+", the bits are packed from the first byte
+	for i := range h[8:] {
+		h[8+i] = byte(0xe8 + i)
+	}
+	assert.Equal(t, Hash2Address(h), "6Onq6/zt7u+w8fLz9PX29+j5/vv8+f7+")
+	parsed, err = Address2Hash("6Onq6/zt7u+w8fLz9PX29+j5/vv8+f7+")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, parsed, h)
+	parsed, _ = Address2Hash("++++++++++++++++++++++++++++++++")
+	assert.Equal(t, hex.EncodeToString(parsed[8:]), "ffffffffffffffffffffffffffffffffffffffffffffffff")
+	parsed, _ = Address2Hash("////////////////////////////////")
+	assert.Equal(t, hex.EncodeToString(parsed[8:]), "fbefbefbefbefbefbefbefbefbefbefbefbefbefbefbefbe")
+}
+
+func TestBase58(t *testing.T) {
+	assert.Equal(t, Base58Encode([]byte("hello world")), "StV1DL6CwTryKyV")
+	assert.Equal(t, Base58Encode([]byte{0, 0, 1}), "112")
+	assert.Equal(t, Base58Encode(nil), "")
+	b, err := Base58Decode("112")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, b, []byte{0, 0, 1})
+	_, err = Base58Decode("0OIl")
+	assert.Equal(t, err, ErrInvalidBase58)
+
+	payload, _ := hex.DecodeString("010966776006953d5567439e5e39f86a0d273bee")
+	s := Base58CheckEncode(0, payload)
+	assert.Equal(t, s, "16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM")
+	version, decoded, err := Base58CheckDecode(s)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, version, byte(0))
+	assert.Equal(t, decoded, payload)
+	_, _, err = Base58CheckDecode("16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvN")
+	assert.Equal(t, err, ErrChecksum)
+	_, _, err = Base58CheckDecode("1")
+	assert.Equal(t, err, ErrInvalidBase58)
+}
+
+func TestAccount(t *testing.T) {
+	var h common.Hash160
+	copy(h[:], []byte("0123456789abcdefghij"))
+	for _, network := range []common.NetworkType{common.MAINNET, common.TESTNET, common.DEVNET, common.REGTEST} {
+		account := Hash160ToAccount(h, network)
+		back, n, err := AccountToHash160(account)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, back, h)
+		assert.Equal(t, n, network)
+	}
+	assert.Equal(t, Hash160ToAccount(h, common.MAINNET)[0], byte('1'))
+	assert.Equal(t, Hash160ToAccount(h, common.MAINNET) != Hash160ToAccount(h, common.TESTNET), true)
+
+	_, _, err := AccountToHash160(Base58CheckEncode(common.ADDRESS_VERSION_MAINNET, h[:19]))
+	assert.Equal(t, err, ErrInvalidAccount)
+	_, _, err = AccountToHash160(Base58CheckEncode(0x42, h[:]))
+	assert.Equal(t, err, ErrInvalidAccount)
+}
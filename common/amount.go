@@ -0,0 +1,134 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Amount is a xdag amount in 32.32 fixed point, the high 32 bits are the
+// whole xdag and the low 32 bits the fraction
+type Amount uint64
+
+const (
+	AMOUNT_DECIMALS        = 9 // decimals printed and parsed, 1 nano xdag
+	AMOUNT_ONE_XDAG Amount = 1 << 32
+	MAX_AMOUNT      Amount = math.MaxUint64
+
+	nanoPerXdag = 1000000000
+)
+
+var (
+	ErrInvalidAmount   = errors.New("invalid xdag amount")
+	ErrAmountOverflow  = errors.New("xdag amount overflow")
+	ErrAmountUnderflow = errors.New("xdag amount underflow")
+)
+
+// Nano returns the whole xdag and the nano xdag of the amount, rounded to
+// the nearest nano xdag
+func (a Amount) Nano() (uint64, uint64) {
+	integer := uint64(a) >> 32
+	nano := ((uint64(a)&0xffffffff)*nanoPerXdag + 1<<31) >> 32
+	if nano == nanoPerXdag {
+		integer++
+		nano = 0
+	}
+	return integer, nano
+}
+
+// String prints the amount with 9 decimals, rounded to the nearest nano xdag,
+// so it is not lossless: Amount(1) prints as 0.000000000. ParseAmount reads
+// the printed value back to an amount that prints the same, except for the
+// amounts from 4294967295.9999999995 xdag to MAX_AMOUNT. They round up to
+// 4294967296.000000000, which is past the range of ParseAmount.
+func (a Amount) String() string {
+	integer, nano := a.Nano()
+	return fmt.Sprintf("%d.%0*d", integer, AMOUNT_DECIMALS, nano)
+}
+
+// ParseAmount reads a decimal xdag amount like "12.5" or "0.000000001" with
+// at most 9 decimals, rounded to the nearest 1/2^32 xdag. The whole part is
+// at most 4294967295.
+func ParseAmount(s string) (Amount, error) {
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+		if fracPart == "" {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+		}
+	}
+	if !isDigits(intPart) || (fracPart != "" && !isDigits(fracPart)) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if len(fracPart) > AMOUNT_DECIMALS {
+		return 0, fmt.Errorf("%w: more than %d decimals", ErrInvalidAmount, AMOUNT_DECIMALS)
+	}
+	integer, err := strconv.ParseUint(intPart, 10, 64)
+	if err != nil || integer > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: %s", ErrAmountOverflow, s)
+	}
+	var nano uint64
+	if fracPart != "" {
+		nano, _ = strconv.ParseUint(fracPart+strings.Repeat("0", AMOUNT_DECIMALS-len(fracPart)), 10, 64)
+	}
+	frac := (nano<<32 + nanoPerXdag/2) / nanoPerXdag
+	return Amount(integer<<32 + frac), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Add returns a + b
+func (a Amount) Add(b Amount) (Amount, error) {
+	sum, carry := bits.Add64(uint64(a), uint64(b), 0)
+	if carry != 0 {
+		return 0, ErrAmountOverflow
+	}
+	return Amount(sum), nil
+}
+
+// Sub returns a - b
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b > a {
+		return 0, ErrAmountUnderflow
+	}
+	return a - b, nil
+}
+
+// MulRatio returns a * num / den rounded down, without overflow of the
+// product
+func (a Amount) MulRatio(num, den uint64) (Amount, error) {
+	if den == 0 {
+		return 0, fmt.Errorf("%w: ratio with zero denominator", ErrInvalidAmount)
+	}
+	hi, lo := bits.Mul64(uint64(a), num)
+	if hi >= den {
+		return 0, ErrAmountOverflow
+	}
+	q, _ := bits.Div64(hi, lo, den)
+	return Amount(q), nil
+}
+
+// Percent returns p percent of the amount rounded down, p is taken in 0..100
+func (a Amount) Percent(p float64) Amount {
+	switch {
+	case p <= 0 || math.IsNaN(p):
+		return 0
+	case p >= 100:
+		return a
+	}
+	r := new(big.Rat).SetFloat64(p)
+	res := new(big.Int).Mul(new(big.Int).SetUint64(uint64(a)), r.Num())
+	res.Quo(res, new(big.Int).Mul(r.Denom(), big.NewInt(100)))
+	return Amount(res.Uint64())
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"errors"
+	"github.com/magiconair/properties/assert"
+	"math"
+	"testing"
+)
+
+func TestAmountString(t *testing.T) {
+	assert.Equal(t, Amount(0).String(), "0.000000000")
+	assert.Equal(t, AMOUNT_ONE_XDAG.String(), "1.000000000")
+	assert.Equal(t, (1024 * AMOUNT_ONE_XDAG).String(), "1024.000000000")
+	assert.Equal(t, (AMOUNT_ONE_XDAG / 2).String(), "0.500000000")
+	assert.Equal(t, Amount(4).String(), "0.000000001")
+	assert.Equal(t, Amount(1).String(), "0.000000000")
+	// rounded to the nearest nano xdag with the carry into the whole xdag
+	assert.Equal(t, (AMOUNT_ONE_XDAG - 1).String(), "1.000000000")
+	assert.Equal(t, MAX_AMOUNT.String(), "4294967296.000000000")
+	assert.Equal(t, (MAX_AMOUNT - AMOUNT_ONE_XDAG).String(), "4294967295.000000000")
+}
+
+func TestParseAmount(t *testing.T) {
+	for _, s := range []string{"0.000000000", "0.000000001", "12.500000000", "0.123456789", "4294967295.999999999", "1024.000000000"} {
+		a, err := ParseAmount(s)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, a.String(), s)
+	}
+	a, err := ParseAmount("12.5")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, a, 12*AMOUNT_ONE_XDAG+AMOUNT_ONE_XDAG/2)
+	a, _ = ParseAmount("7")
+	assert.Equal(t, a, 7*AMOUNT_ONE_XDAG)
+	a, _ = ParseAmount("0.000000001")
+	assert.Equal(t, a, Amount(4))
+
+	for _, s := range []string{"", ".", ".5", "5.", "-1", "+1", "1e3", "1,5", " 1", "0x10", "1.2.3", "1.0000000001"} {
+		_, err = ParseAmount(s)
+		assert.Equal(t, errors.Is(err, ErrInvalidAmount), true, s)
+	}
+	for _, s := range []string{"4294967296", "99999999999999999999999", "4294967296.5"} {
+		_, err = ParseAmount(s)
+		assert.Equal(t, errors.Is(err, ErrAmountOverflow), true, s)
+	}
+
+	// the printed amounts parse back to the same print, but for the last ones
+	// rounding up out of the range
+	for _, a := range []Amount{1, 3, AMOUNT_ONE_XDAG - 1, 0x123456789abcdef, MAX_AMOUNT - 3<<31} {
+		b, err := ParseAmount(a.String())
+		assert.Equal(t, err, nil)
+		assert.Equal(t, b.String(), a.String())
+	}
+	b, _ := ParseAmount(Amount(1).String())
+	assert.Equal(t, b, Amount(0))
+	_, err = ParseAmount(MAX_AMOUNT.String())
+	assert.Equal(t, errors.Is(err, ErrAmountOverflow), true)
+}
+
+func TestAmountMath(t *testing.T) {
+	a, err := AMOUNT_ONE_XDAG.Add(2 * AMOUNT_ONE_XDAG)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, a, 3*AMOUNT_ONE_XDAG)
+	_, err = MAX_AMOUNT.Add(1)
+	assert.Equal(t, err, ErrAmountOverflow)
+
+	a, err = a.Sub(AMOUNT_ONE_XDAG)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, a, 2*AMOUNT_ONE_XDAG)
+	_, err = a.Sub(3 * AMOUNT_ONE_XDAG)
+	assert.Equal(t, err, ErrAmountUnderflow)
+
+	// the product does not overflow 64 bits
+	a, err = MAX_AMOUNT.MulRatio(6, 11)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, a, Amount(math.MaxUint64/11*6+(math.MaxUint64%11)*6/11))
+	a, _ = Amount(10).MulRatio(1, 3)
+	assert.Equal(t, a, Amount(3))
+	_, err = MAX_AMOUNT.MulRatio(2, 1)
+	assert.Equal(t, err, ErrAmountOverflow)
+	_, err = a.MulRatio(1, 0)
+	assert.Equal(t, errors.Is(err, ErrInvalidAmount), true)
+
+	total := 1024 * AMOUNT_ONE_XDAG
+	assert.Equal(t, total.Percent(5), total/20)
+	assert.Equal(t, total.Percent(0), Amount(0))
+	assert.Equal(t, total.Percent(-1), Amount(0))
+	assert.Equal(t, total.Percent(100), total)
+	assert.Equal(t, total.Percent(150), total)
+	assert.Equal(t, Amount(1000).Percent(0.5), Amount(5))
+	assert.Equal(t, MAX_AMOUNT.Percent(50), MAX_AMOUNT/2)
+}
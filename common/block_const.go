@@ -40,6 +40,26 @@ const (
 	XDAG_FIELD_RESERVE6
 )
 
+var fieldTypeNames = [...]string{"nonce", "head", "in", "out", "sign_in", "sign_out", "public_key_0",
+	"public_key_1", "head_test", "remark", "reserve1", "reserve2", "reserve3", "reserve4", "reserve5", "reserve6"}
+
+func (t FieldType) String() string {
+	if int(t) < len(fieldTypeNames) {
+		return fieldTypeNames[t]
+	}
+	return "unknown"
+}
+
+// ParseFieldType is the inverse of FieldType.String
+func ParseFieldType(s string) (FieldType, bool) {
+	for i, name := range fieldTypeNames {
+		if name == s {
+			return FieldType(i), true
+		}
+	}
+	return 0, false
+}
+
 var EmptyField Field
 var EmptyHash Hash
 var EmptyXdagSignature Signature
@@ -41,6 +41,12 @@ const (
 
 	//同步问题 分叉高度
 	SYNC_FIX_HEIGHT uint64 = 0
+
+	//矿池收益分配方式 按轮次比例或者最近N个share
+	PAYOUT_MODE_PROP  string = "prop"
+	PAYOUT_MODE_PPLNS string = "pplns"
+	PPLNS_WINDOW      int    = 10000
+	MAX_POOL_TAG      int    = 32
 )
 
 type MessageType int
@@ -10,3 +10,22 @@ const (
 	IMPORTED_NOT_BEST
 	IMPORTED_BEST
 )
+
+func (s ImportStatus) String() string {
+	switch s {
+	case IMPORT_ERROR:
+		return "error"
+	case IMPORT_EXIST:
+		return "exist"
+	case NO_PARENT:
+		return "no_parent"
+	case INVALID_BLOCK:
+		return "invalid"
+	case IMPORTED_NOT_BEST:
+		return "imported_not_best"
+	case IMPORTED_BEST:
+		return "imported_best"
+	default:
+		return "unknown"
+	}
+}
@@ -12,8 +12,33 @@ const (
 	DB_ORPHANIND // Orphan block index
 
 	DB_SNAPSHOT
+
+	DB_POOL // Pool payouts and awards
+
+	DB_MINERS // Miner share statistics
 )
 
+func (n DatabaseName) String() string {
+	switch n {
+	case DB_INDEX:
+		return "index"
+	case DB_BLOCK:
+		return "block"
+	case DB_TIME:
+		return "time"
+	case DB_ORPHANIND:
+		return "orphan"
+	case DB_SNAPSHOT:
+		return "snapshot"
+	case DB_POOL:
+		return "pool"
+	case DB_MINERS:
+		return "miners"
+	default:
+		return "unknown"
+	}
+}
+
 const (
 	SETTING_STATS      byte = 0x10
 	TIME_HASH_INFO     byte = 0x20
@@ -25,5 +50,16 @@ const (
 	BLOCK_HEIGHT       byte = 0x80
 	SNAPSHOT_PRESEED   byte = 0x90
 	TX_HISTORY         byte = 0xa0
+	POOL_PAYOUT        byte = 0xb0
+	POOL_AWARD         byte = 0xb1
+	MINER_STATS        byte = 0xb2
+	PPLNS_SHARE        byte = 0xb3
+	POOL_PAYMENT       byte = 0xb4
 	SUM_FILE_NAME           = "sums.dat"
 )
+
+const (
+	// 存储目录中记录存储格式版本的文件，版本 1 的键按字节序排列
+	STORE_FORMAT_FILE    = "XDAGO_FORMAT"
+	STORE_FORMAT_VERSION = 1
+)
@@ -0,0 +1,59 @@
+package common
+
+const (
+	// 单个节点累计的违规分数达到该值后被封禁
+	PEER_BAN_SCORE int32 = 100
+	// 封禁时长（秒）
+	PEER_BAN_DURATION int64 = 24 * 60 * 60
+
+	// 发送无法解析或校验失败的区块
+	MISBEHAVE_INVALID_BLOCK int32 = 20
+	// 发送无法识别的消息
+	MISBEHAVE_INVALID_MESSAGE int32 = 10
+	// 短时间内重复发送已存在的区块
+	MISBEHAVE_DUPLICATE_BLOCK int32 = 1
+	// 检查重复区块时记住的每个节点最近发送的区块数
+	PEER_SEEN_BLOCKS = 4096
+
+	NET_BAN_FILE_NAME string = "netban.txt"
+
+	// 开启 enableRefresh 时从 whitelistUrl 刷新白名单的间隔（秒）
+	WHITELIST_REFRESH_INTERVAL int64 = 60 * 60
+)
+
+type NetworkType byte
+
+const (
+	MAINNET NetworkType = iota
+	TESTNET
+	DEVNET
+	REGTEST // local private network of one node
+)
+
+func (n NetworkType) String() string {
+	switch n {
+	case MAINNET:
+		return "mainnet"
+	case TESTNET:
+		return "testnet"
+	case DEVNET:
+		return "devnet"
+	case REGTEST:
+		return "regtest"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// 握手协议版本，不兼容的改动需要增加
+	P2P_PROTOCOL_VERSION uint32 = 1
+	P2P_HANDSHAKE_WORD   uint32 = 0x58444147 // "XDAG"
+)
+
+const (
+	// 矿工协议中矿工名字段的首个字
+	WORKERNAME_HEADER_WORD uint32 = 0xf46b9853
+	// 矿池与矿工之间加密数据使用的密码
+	MINERS_PWD string = "minersgonnamine"
+)
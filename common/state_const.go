@@ -50,4 +50,19 @@ const (
 	XFER
 )
 
+// String returns the short name of the state, e.g. SYNC
+func (s StateType) String() string {
+	names := [...]string{"INIT", "KEYS", "REST", "LOAD", "STOP", "WDST", "WTST", "WAIT",
+		"CDST", "CTST", "CONN", "SDST", "STST", "SYNC", "XFER"}
+	if int(s) < len(names) {
+		return names[s]
+	}
+	return "UNKNOWN"
+}
+
+// IsSynced tells if the node is synchronized with its network
+func (s StateType) IsSynced() bool {
+	return s == SYNC || s == STST || s == SDST
+}
+
 const HASH_RATE_LAST_MAX_TIME = 64 * 4
@@ -5,3 +5,11 @@ type Hash160 [20]byte
 const XDAG_BIP44_CION_TYPE = 586
 
 const WALLET_PASSWORD_PROMPT = "Please Enter Wallet Password: "
+
+// version bytes of the base58check account addresses per network
+const (
+	ADDRESS_VERSION_MAINNET byte = 0x00
+	ADDRESS_VERSION_TESTNET byte = 0x6f
+	ADDRESS_VERSION_DEVNET  byte = 0x70
+	ADDRESS_VERSION_REGTEST byte = 0x71
+)
@@ -3,50 +3,114 @@ package config
 import (
 	"fmt"
 	"github.com/spf13/viper"
-	"net"
+	"math"
 	"strconv"
 	"strings"
 	"xdago/common"
 	"xdago/log"
 )
 
-var defaultConfig = Config{
-	telnetIp:                   "127.0.0.1",
-	telnetPort:                 7001,
-	maxShareCountPerChannel:    20,
-	awardEpoch:                 0xf,
-	waitEpoch:                  10,
-	maxConnections:             1024,
-	maxInboundConnectionsPerIp: 8,
-	connectionTimeout:          10000,
-	connectionReadTimeout:      10000,
-	storeMaxOpenFiles:          1024,
-	storeMaxThreads:            1,
-	storeFromBackup:            false,
-	storeBackupDir:             "./testdate",
-	enableRefresh:              false,
-	ttl:                        5,
-	rpcEnabled:                 false,
-	snapshotEnabled:            false,
+const ENV_PREFIX = "XDAGO"
+
+// defaults of the regtest section of the config file
+const (
+	REGTEST_XDAG_ERA     uint64 = 0x16900000000
+	REGTEST_REWARD              = "1024"
+	REGTEST_FORK_HEIGHT  uint64 = 1000
+	REGTEST_FORK_REWARD         = "128"
+	REGTEST_RANDOMX_FORK uint64 = math.MaxUint64 // never, the blocks are hashed with sha256
+	REGTEST_DIFFICULTY   uint64 = 1              // of every block, whatever its hash
+)
+
+// defaultConfig returns a new config with the defaults of the values missing
+// in the config file
+func defaultConfig() *Config {
+	return &Config{
+		telnetIp:                   "127.0.0.1",
+		telnetPort:                 7001,
+		maxShareCountPerChannel:    20,
+		awardEpoch:                 0xf,
+		waitEpoch:                  10,
+		maxConnections:             1024,
+		maxInboundConnectionsPerIp: 8,
+		connectionTimeout:          10000,
+		connectionReadTimeout:      10000,
+		storeMaxOpenFiles:          1024,
+		storeMaxThreads:            1,
+		storeFromBackup:            false,
+		storeBackupDir:             "./testdate",
+		enableRefresh:              false,
+		ttl:                        5,
+		rpcEnabled:                 false,
+		snapshotEnabled:            false,
+	}
+}
+
+// Load reads the config file of the network and validates it. Every call
+// returns a new Config. The environment variables XDAGO_<KEY> override the
+// keys of the file, the dots of the key replaced by underscores, like
+// XDAGO_NODE_PORT for node.port. The invalid fields are listed by a
+// *ValidationError.
+func Load(network common.NetworkType) (*Config, error) {
+	var c *Config
+	var err error
+	switch network {
+	case common.MAINNET:
+		c, err = initConfig("mainnet", "mainnet-config.json")
+	case common.TESTNET:
+		c, err = initConfig("testnet", "testnet-config.json")
+	case common.DEVNET:
+		c, err = initConfig("devnet", "devnet-config.json")
+	case common.REGTEST:
+		c, err = initConfig("regtest", "regtest-config.json")
+	default:
+		return nil, fmt.Errorf("unknown network %d", network)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch network {
+	case common.MAINNET:
+		c.setMainNet()
+	case common.TESTNET:
+		c.setTestNet()
+	case common.DEVNET:
+		c.setDevNet()
+	case common.REGTEST:
+		c.setRegTest()
+	}
+	return c, nil
 }
 
-func initConfig(rootDir, configName string) *Config {
-	c := &defaultConfig
+func initConfig(rootDir, configName string) (*Config, error) {
+	c := defaultConfig()
 	c.SetRootDir(rootDir)
 	c.SetConfigName(configName)
-	c.getSetting()
+	verr := &ValidationError{}
+	if err := c.getSetting(verr); err != nil {
+		return nil, err
+	}
+	c.validate(verr)
+	if err := verr.err(); err != nil {
+		return nil, err
+	}
 	c.SetDir()
-	return c
+	return c, nil
 }
 
-func (c *Config) getSetting() {
+// getSetting reads the config file, the values that can not be parsed are
+// added to verr
+func (c *Config) getSetting(verr *ValidationError) error {
 	v := viper.New()
 	v.AddConfigPath(c.rootDir)
 	v.SetConfigName(c.configName)
 	v.SetConfigType("json")
+	v.SetEnvPrefix(ENV_PREFIX)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
 
 	if err := v.ReadInConfig(); err != nil {
-		panic(err)
+		return fmt.Errorf("read config %s: %w", c.configName, err)
 	}
 
 	v.SetDefault("admin.telnet.ip", "127.0.0.1")
@@ -54,11 +118,18 @@ func (c *Config) getSetting() {
 	v.SetDefault("admin.telnet.port", 6001)
 	c.telnetPort = v.GetInt("admin.telnet.port")
 	c.telnetPassword = v.GetString("admin.telnet.password")
+	c.telnetTlsCert = v.GetString("admin.telnet.tls.cert")
+	c.telnetTlsKey = v.GetString("admin.telnet.tls.key")
+	if err := v.UnmarshalKey("admin.telnet.credentials", &c.telnetCredentials); err != nil {
+		verr.add("admin.telnet.credentials", "", err.Error())
+	}
 
 	v.SetDefault("pool.ip", "127.0.0.1")
 	c.poolIp = v.GetString("pool.ip")
 	v.SetDefault("pool.port", 7001)
 	c.poolPort = v.GetInt("pool.port")
+	v.SetDefault("pool.stratumPort", 7002)
+	c.stratumPort = v.GetInt("pool.stratumPort")
 	v.SetDefault("pool.tag", "xdago")
 	c.poolTag = v.GetString("pool.tag")
 
@@ -66,6 +137,10 @@ func (c *Config) getSetting() {
 	c.rewardRation = v.GetFloat64("pool.rewardRation")
 	c.fundRation = v.GetFloat64("pool.fundRation")
 	c.directRation = v.GetFloat64("pool.directRation")
+	v.SetDefault("pool.payoutMode", common.PAYOUT_MODE_PROP)
+	c.payoutMode = v.GetString("pool.payoutMode")
+	v.SetDefault("pool.pplnsWindow", common.PPLNS_WINDOW)
+	c.pplnsWindow = v.GetInt("pool.pplnsWindow")
 
 	v.SetDefault("node.ip", "127.0.0.1")
 	c.nodeIp = v.GetString("node.ip")
@@ -76,16 +151,12 @@ func (c *Config) getSetting() {
 	whiteIpArray := v.GetStringSlice("node.whiteIPs")
 	if len(whiteIpArray) > 0 {
 		log.Debug("Accessing IP count", len(whiteIpArray))
-		for _, address := range whiteIpArray {
-			_, err := net.ResolveTCPAddr("tcp4", address)
-			if err != nil {
-				log.Crit("Parse config white IPs error", log.Ctx{"address": address})
-			} else {
-				c.whiteIPList = append(c.whiteIPList, address)
-			}
-		}
+		c.whiteIPList = append([]string{}, whiteIpArray...)
 	}
 
+	c.enableRefresh = v.GetBool("node.enableRefresh")
+	c.enableWhitelist = v.GetBool("node.enableWhitelist")
+
 	c.libp2pPort = v.GetInt("node.libp2p.port")
 	c.libp2pPrivkey = v.GetString("node.libp2p.privkey")
 	c.isBootNode = v.GetBool("node.libp2p.isbootnode")
@@ -99,6 +170,8 @@ func (c *Config) getSetting() {
 	c.globalMinerChannelLimit = v.GetInt("miner.globalMinerChannelLimit")
 	c.maxConnectPerIp = v.GetInt("miner.maxConnectPerIp")
 	c.maxMinerPerAccount = v.GetInt("miner.maxMinerPerAccount")
+	c.minerThreads = v.GetInt("miner.threads")
+	c.randomxLargePages = v.GetBool("randomx.largePages")
 
 	// rpc
 	v.SetDefault("rpc.enabled", false)
@@ -111,179 +184,292 @@ func (c *Config) getSetting() {
 		c.rpcPortHttp = v.GetInt("rpc.http.port")
 		c.rpcPortWs = v.GetInt("rpc.ws.port")
 	}
+	c.rpcTlsCert = v.GetString("rpc.tls.cert")
+	c.rpcTlsKey = v.GetString("rpc.tls.key")
+	if err := v.UnmarshalKey("rpc.credentials", &c.rpcCredentials); err != nil {
+		verr.add("rpc.credentials", "", err.Error())
+	}
+	// without credentials only the read-only methods are open
+	v.SetDefault("rpc.anonymous.methods", []string{"read"})
+	c.rpcAnonymous = Credential{
+		Name:      "anonymous",
+		Methods:   v.GetStringSlice("rpc.anonymous.methods"),
+		RateLimit: v.GetFloat64("rpc.anonymous.rateLimit"),
+		Burst:     v.GetInt("rpc.anonymous.burst"),
+	}
 
+	v.SetDefault("log.verbosity", int(log.LvlInfo))
+	c.logVerbosity = v.GetInt("log.verbosity")
+
+	// metrics
+	v.SetDefault("metrics.enabled", false)
+	v.SetDefault("metrics.host", "127.0.0.1")
+	v.SetDefault("metrics.port", 10003)
+	c.metricsEnabled = v.GetBool("metrics.enabled")
+	c.metricsHost = v.GetString("metrics.host")
+	c.metricsPort = v.GetInt("metrics.port")
+
+	// regtest, the rewards are in xdag and the era may be a hex string
+	v.SetDefault("regtest.xdagEra", REGTEST_XDAG_ERA)
+	c.regtestEra = v.GetUint64("regtest.xdagEra")
+	v.SetDefault("regtest.reward", REGTEST_REWARD)
+	c.regtestReward = parseReward(verr, "regtest.reward", v.GetString("regtest.reward"))
+	v.SetDefault("regtest.apolloForkHeight", REGTEST_FORK_HEIGHT)
+	c.regtestForkHeight = v.GetUint64("regtest.apolloForkHeight")
+	v.SetDefault("regtest.apolloForkReward", REGTEST_FORK_REWARD)
+	c.regtestForkReward = parseReward(verr, "regtest.apolloForkReward", v.GetString("regtest.apolloForkReward"))
+	v.SetDefault("regtest.randomxForkHeight", REGTEST_RANDOMX_FORK)
+	c.regtestRandomxFork = v.GetUint64("regtest.randomxForkHeight")
+	v.SetDefault("regtest.difficulty", REGTEST_DIFFICULTY)
+	c.regtestDifficulty = v.GetUint64("regtest.difficulty")
+	return nil
 }
 
-func (c *Config) ChangePara(args []string) {
+func parseReward(verr *ValidationError, field, s string) uint64 {
+	amount, err := common.ParseAmount(s)
+	if err != nil {
+		verr.add(field, s, err.Error())
+	}
+	return uint64(amount)
+}
+
+// ChangePara applies the command line options of the node to the config
+func (c *Config) ChangePara(args []string) error {
 	if args == nil || len(args) == 0 {
 		fmt.Println("Use default configuration")
-		return
+		return nil
 	}
 
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
-		case "-a":
-		case "-c":
-		case "-m":
-		case "-s":
+		case "-a", "-c", "-r", "-d", "-t":
+			// -r only loads the blocks, -d and -t select the network
+			continue
+		case "-m", "-s", "-f", "-p", "-P", "-tag":
+			if i+1 >= len(args) {
+				return fmt.Errorf("missing value of %s", args[i])
+			}
+		}
+		switch args[i] {
+		case "-m", "-s":
 			i++
-			// TODO: set miner threads count
-			break
+			threads, err := strconv.Atoi(args[i])
+			if err != nil || threads < 0 {
+				return fmt.Errorf("illegal miner threads count %q", args[i])
+			}
+			c.minerThreads = threads
 		case "-f":
 			i++
 			c.rootDir = args[i]
-			break
 		case "-p":
 			i++
-			c.changeNode(args[i])
-			break
+			if err := c.changeNode(args[i]); err != nil {
+				return err
+			}
 		case "-P":
 			i++
-			c.changePoolPara(args[i])
-			break
-		case "-r":
-			// TODO: only load block but not run
-			break
+			if err := c.changePoolPara(args[i]); err != nil {
+				return err
+			}
 		case "-tag":
 			i++
-			if len(args[i]) > 32 {
-				c.poolTag = args[i][0:32]
+			if len(args[i]) > common.MAX_POOL_TAG {
+				c.poolTag = args[i][0:common.MAX_POOL_TAG]
 			} else {
 				c.poolTag = args[i]
 			}
-			break
-		case "-d":
-		case "-t":
-			//only devnet or testnet
-			break
 		default:
-			log.Crit("Illegal instruction", log.Ctx{"para": args[i]})
+			return fmt.Errorf("illegal instruction %q", args[i])
 		}
 	}
+	return nil
 }
 
-func (c *Config) changeNode(host string) {
+func (c *Config) changeNode(host string) error {
 	args := strings.Split(host, ":")
-	if len(args) == 2 {
-		var err error
-		c.nodeIp = args[0]
-		c.nodePort, err = strconv.Atoi(args[1])
-		if err != nil {
-			log.Crit("Illegal node port instruction")
-		}
-	} else {
-		log.Crit("Illegal node host instruction")
+	if len(args) != 2 {
+		return fmt.Errorf("illegal node host %q", host)
 	}
+	port, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("illegal node port %q", args[1])
+	}
+	c.nodeIp = args[0]
+	c.nodePort = port
+	return nil
 }
 
-func (c *Config) changePoolPara(para string) {
+// changePoolPara parses ip:port:CONNECTIONS_PER_POOL:CONNECTIONS_PER_IP:
+// CONNECTIONS_PER_ACCOUNT:FEE:REWARD:DIRECT:FUND
+func (c *Config) changePoolPara(para string) error {
 	args := strings.Split(para, ":")
 	if len(args) != 9 {
-		log.Crit("Illegal pool instruction")
+		return fmt.Errorf("illegal pool instruction %q", para)
 	}
-	var err error
-	c.poolIp = args[0]
-	c.poolPort, err = strconv.Atoi(args[1])
-	if err != nil {
-		log.Crit("Illegal pool port instruction")
+	verr := &ValidationError{}
+	atoi := func(field, s string) int {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			verr.add(field, s, "not an integer")
+		}
+		return n
 	}
-
-	c.globalMinerChannelLimit, err = strconv.Atoi(args[2])
-	if err != nil {
-		log.Crit("Illegal pool miner limit instruction")
+	ration := func(field, s string) float64 {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			verr.add(field, s, "not a number")
+		}
+		return f
 	}
-
-	c.maxConnectPerIp, err = strconv.Atoi(args[3])
-	if err != nil {
-		log.Crit("Illegal pool connect limit instruction")
+	poolPort := atoi("pool.port", args[1])
+	channelLimit := atoi("miner.globalMinerChannelLimit", args[2])
+	connectLimit := atoi("miner.maxConnectPerIp", args[3])
+	accountLimit := atoi("miner.maxMinerPerAccount", args[4])
+	poolRation := ration("pool.poolRation", args[5])
+	rewardRation := ration("pool.rewardRation", args[6])
+	directRation := ration("pool.directRation", args[7])
+	fundRation := ration("pool.fundRation", args[8])
+	if err := verr.err(); err != nil {
+		return err
 	}
-
-	c.maxMinerPerAccount, err = strconv.Atoi(args[4])
-	if err != nil {
-		log.Crit("Illegal miner account limit instruction")
+	if c.commandLine == nil {
+		c.commandLine = make(map[string]bool)
 	}
-
-	c.poolRation, err = strconv.ParseFloat(args[5], 64)
-	if err != nil {
-		log.Crit("Illegal pool ration instruction")
+	for _, field := range []string{"miner.maxConnectPerIp", "miner.maxMinerPerAccount",
+		"pool.poolRation", "pool.rewardRation", "pool.directRation", "pool.fundRation"} {
+		c.commandLine[field] = true
 	}
+	c.poolIp = args[0]
+	c.poolPort = poolPort
+	c.globalMinerChannelLimit = channelLimit
+	c.maxConnectPerIp = connectLimit
+	c.maxMinerPerAccount = accountLimit
+	c.poolRation = poolRation
+	c.rewardRation = rewardRation
+	c.directRation = directRation
+	c.fundRation = fundRation
+	return nil
+}
 
-	c.rewardRation, err = strconv.ParseFloat(args[6], 64)
+// mustLoad loads the config of the network and panics on an error
+func mustLoad(network common.NetworkType) *Config {
+	c, err := Load(network)
 	if err != nil {
-		log.Crit("Illegal reward ration instruction")
+		panic(err)
 	}
+	return c
+}
 
-	c.directRation, err = strconv.ParseFloat(args[7], 64)
-	if err != nil {
-		log.Crit("Illegal direct ration instruction")
-	}
+func MainNetConfig() *Config {
+	return mustLoad(common.MAINNET)
+}
 
-	c.fundRation, err = strconv.ParseFloat(args[8], 64)
-	if err != nil {
-		log.Crit("Illegal fund ration instruction")
-	}
+func TestNetConfig() *Config {
+	return mustLoad(common.TESTNET)
 }
 
-func MainNetConfig() *Config {
-	c := initConfig("mainnet", "mainnet-config.json")
+func DevNetConfig() *Config {
+	return mustLoad(common.DEVNET)
+}
 
+func (c *Config) setMainNet() {
 	c.whitelistUrl = "https://raw.githubusercontent.com/XDagger/xdag/master/client/netdb-white.txt"
 
+	c.network = common.MAINNET
 	c.xdagEra = 0x16940000000
 	c.mainStartAmount = 1 << 42
 
 	c.apolloForkHeight = 1017323
 	c.apolloForkAmount = 1 << 39
+
+	c.randomxForkHeight = 1540096
+	c.seedEpochBlocks = 4096
+	c.seedEpochLag = 128
 	c.xdagFieldHeader = common.XDAG_FIELD_HEAD
 
 	c.dnetKeyFile = c.rootDir + "/dnet_keys.bin"
 	c.walletKeyFile = c.rootDir + "/wallet.dat"
 
 	c.walletFilePath = c.rootDir + "/wallet/" + common.WALLET_FILE_NAME
-
-	return c
 }
 
-func DevNetConfig() *Config {
-	c := initConfig("devnet", "devnet-config.json")
+func (c *Config) setDevNet() {
 	c.whitelistUrl = ""
 
 	c.waitEpoch = 1
 
+	c.network = common.DEVNET
 	c.xdagEra = 0x16900000000
 	c.mainStartAmount = 1 << 42
 
 	c.apolloForkHeight = 1000
 	c.apolloForkAmount = 1 << 39
+
+	c.randomxForkHeight = 1000
+	c.seedEpochBlocks = 64
+	c.seedEpochLag = 16
 	c.xdagFieldHeader = common.XDAG_FIELD_HEAD_TEST
 
 	c.dnetKeyFile = c.rootDir + "/dnet_keys.bin"
 	c.walletKeyFile = c.rootDir + "/wallet-testnet.dat"
 
 	c.walletFilePath = c.rootDir + "/wallet/" + common.WALLET_FILE_NAME
-
-	return c
 }
 
-func TestNetConfig() *Config {
-	c := initConfig("testnet", "testnet-config.json")
+func (c *Config) setTestNet() {
 	c.whitelistUrl = "https://raw.githubusercontent.com/XDagger/xdag/master/client/netdb-white-testnet.txt"
 
 	// testnet wait 1 epoch
 	c.waitEpoch = 1
 
+	c.network = common.TESTNET
 	c.xdagEra = 0x16900000000
 	c.mainStartAmount = 1 << 42
 
 	c.apolloForkHeight = 196250
 
 	c.apolloForkAmount = 1 << 39
+
+	c.randomxForkHeight = 196288
+	c.seedEpochBlocks = 2048
+	c.seedEpochLag = 128
 	c.xdagFieldHeader = common.XDAG_FIELD_HEAD_TEST
 
 	c.dnetKeyFile = c.rootDir + "/dnet_keys.bin"
 	c.walletKeyFile = c.rootDir + "/wallet-testnet.dat"
 
 	c.walletFilePath = c.rootDir + "/wallet/" + common.WALLET_FILE_NAME
+}
 
-	return c
+// setRegTest sets the regtest network, a local chain of one node with the
+// era, rewards and forks of the regtest section. Every block has the fixed
+// difficulty of the section, so no nonce is searched.
+func (c *Config) setRegTest() {
+	c.whitelistUrl = ""
+	c.enableRefresh = false
+
+	c.waitEpoch = 1
+
+	c.network = common.REGTEST
+	c.xdagEra = c.regtestEra
+	c.mainStartAmount = c.regtestReward
+
+	c.apolloForkHeight = c.regtestForkHeight
+	c.apolloForkAmount = c.regtestForkReward
+
+	c.randomxForkHeight = c.regtestRandomxFork
+	c.blockDifficulty = c.regtestDifficulty
+	c.seedEpochBlocks = 64
+	c.seedEpochLag = 16
+	c.xdagFieldHeader = common.XDAG_FIELD_HEAD_TEST
+
+	c.dnetKeyFile = c.rootDir + "/dnet_keys.bin"
+	c.walletKeyFile = c.rootDir + "/wallet-regtest.dat"
+
+	c.walletFilePath = c.rootDir + "/wallet/" + common.WALLET_FILE_NAME
+}
+
+// IsRegtest tells if blocks may be generated at once, without pow
+func (c *Config) IsRegtest() bool {
+	return c.network == common.REGTEST
 }
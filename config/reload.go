@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"reflect"
+	"xdago/log"
+)
+
+// sources of a reload written in the audit log
+const (
+	RELOAD_SIGHUP = "sighup"
+	RELOAD_FILE   = "file"
+	RELOAD_ADMIN  = "admin"
+)
+
+// Change is a value of the config key Field changed by a reload
+type Change struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+func (ch Change) String() string {
+	return fmt.Sprintf("%s: %v -> %v", ch.Field, ch.Old, ch.New)
+}
+
+// OnReload registers f to run after a reload applied its changes
+func (c *Config) OnReload(f func(c *Config)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reloadHooks = append(c.reloadHooks, f)
+}
+
+// Reload reads the config file again and applies the values that can change
+// at runtime: the white ips, the miner limits, the pool rations, the log
+// verbosity and the rpc credentials with their rate limits. The whole file
+// is validated first and nothing is applied when it is invalid, the changes
+// are applied at once and logged with the source of the reload. The values
+// set on the command line with -P win over the file, they are kept and the
+// kept value is logged.
+func (c *Config) Reload(source string) ([]Change, error) {
+	c.reloading.Lock()
+	defer c.reloading.Unlock()
+	n, err := initConfig(c.rootDir, c.configName)
+	if err != nil {
+		log.Warn("config reload rejected", log.Ctx{"source": source, "err": err.Error()})
+		return nil, err
+	}
+
+	c.mu.Lock()
+	var changes []Change
+	apply := func(field string, old, new interface{}, set func()) {
+		if reflect.DeepEqual(old, new) {
+			return
+		}
+		if c.commandLine[field] {
+			log.Info("config reload keeps the command line value", log.Ctx{"source": source, "field": field, "value": old, "file": new})
+			return
+		}
+		changes = append(changes, Change{Field: field, Old: old, New: new})
+		set()
+	}
+	apply("node.whiteIPs", c.whiteIPList, n.whiteIPList, func() { c.whiteIPList = n.whiteIPList })
+	apply("miner.globalMinerLimit", c.globalMinerLimit, n.globalMinerLimit, func() { c.globalMinerLimit = n.globalMinerLimit })
+	apply("miner.maxConnectPerIp", c.maxConnectPerIp, n.maxConnectPerIp, func() { c.maxConnectPerIp = n.maxConnectPerIp })
+	apply("miner.maxMinerPerAccount", c.maxMinerPerAccount, n.maxMinerPerAccount, func() { c.maxMinerPerAccount = n.maxMinerPerAccount })
+	apply("pool.poolRation", c.poolRation, n.poolRation, func() { c.poolRation = n.poolRation })
+	apply("pool.rewardRation", c.rewardRation, n.rewardRation, func() { c.rewardRation = n.rewardRation })
+	apply("pool.fundRation", c.fundRation, n.fundRation, func() { c.fundRation = n.fundRation })
+	apply("pool.directRation", c.directRation, n.directRation, func() { c.directRation = n.directRation })
+	apply("log.verbosity", c.logVerbosity, n.logVerbosity, func() { c.logVerbosity = n.logVerbosity })
+	// the secrets of the credentials are not logged, only their names
+	if !reflect.DeepEqual(c.rpcCredentials, n.rpcCredentials) {
+		changes = append(changes, Change{Field: "rpc.credentials", Old: credentialNames(c.rpcCredentials), New: credentialNames(n.rpcCredentials)})
+		c.rpcCredentials = n.rpcCredentials
+	}
+	apply("rpc.anonymous", c.rpcAnonymous, n.rpcAnonymous, func() { c.rpcAnonymous = n.rpcAnonymous })
+	hooks := append([]func(c *Config){}, c.reloadHooks...)
+	c.mu.Unlock()
+
+	if len(changes) == 0 {
+		log.Info("config reloaded without changes", log.Ctx{"source": source})
+		return nil, nil
+	}
+	for _, ch := range changes {
+		log.Info("config reloaded", log.Ctx{"source": source, "field": ch.Field, "old": ch.Old, "new": ch.New})
+	}
+	for _, f := range hooks {
+		f(c)
+	}
+	return changes, nil
+}
+
+func credentialNames(creds []Credential) []string {
+	names := make([]string, 0, len(creds))
+	for _, cred := range creds {
+		names = append(names, cred.Name)
+	}
+	return names
+}
+
+// Watch reloads the config when its file changes, until the node stops
+func (c *Config) Watch() error {
+	v := viper.New()
+	v.AddConfigPath(c.rootDir)
+	v.SetConfigName(c.configName)
+	v.SetConfigType("json")
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	v.OnConfigChange(func(e fsnotify.Event) {
+		c.Reload(RELOAD_FILE)
+	})
+	v.WatchConfig()
+	return nil
+}
@@ -0,0 +1,105 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package config
+
+import (
+	"errors"
+	"github.com/magiconair/properties/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"xdago/common"
+)
+
+func TestReload(t *testing.T) {
+	inConfigDir(t, testConfigJson, func() {
+		c, err := Load(common.DEVNET)
+		assert.Equal(t, err, nil)
+		c.SetMinerThreads(4)
+		var reloaded int
+		c.OnReload(func(*Config) { reloaded++ })
+
+		changes, err := c.Reload(RELOAD_ADMIN)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(changes), 0)
+		assert.Equal(t, reloaded, 0)
+
+		json := strings.Replace(testConfigJson, `"poolRation": 5`, `"poolRation": 10`, 1)
+		json = strings.Replace(json, `"port": 8001`, `"port": 8002`, 1)
+		json = strings.Replace(json, `"threads": 0`, `"threads": 0, "maxConnectPerIp": 3`, 1)
+		json = strings.Replace(json, `"miner"`, `"log": {"verbosity": 5}, "rpc": {"credentials": [{"name": "admin", "token": "t", "methods": ["*"]}]}, "miner"`, 1)
+		assert.Equal(t, os.WriteFile(filepath.Join("devnet", "devnet-config.json"), []byte(json), 0644), nil)
+		changes, err = c.Reload(RELOAD_SIGHUP)
+		assert.Equal(t, err, nil)
+		var fields []string
+		for _, ch := range changes {
+			fields = append(fields, ch.Field)
+		}
+		assert.Equal(t, fields, []string{"miner.maxConnectPerIp", "pool.poolRation", "log.verbosity", "rpc.credentials"})
+		assert.Equal(t, changes[1].String(), "pool.poolRation: 5 -> 10")
+		assert.Equal(t, changes[3].String(), "rpc.credentials: [] -> [admin]")
+		assert.Equal(t, reloaded, 1)
+		assert.Equal(t, c.PoolRation(), float64(10))
+		assert.Equal(t, c.MaxConnectPerIp(), 3)
+		assert.Equal(t, c.LogVerbosity(), 5)
+		assert.Equal(t, c.RpcCredentials()[0].Token, "t")
+		// the other values are read at start only
+		assert.Equal(t, c.NodePort(), 8001)
+		assert.Equal(t, c.MinerThreads(), 4)
+
+		// an invalid file changes nothing
+		json = strings.Replace(json, `"poolRation": 10`, `"poolRation": 90`, 1)
+		json = strings.Replace(json, `"maxConnectPerIp": 3`, `"maxConnectPerIp": 1`, 1)
+		assert.Equal(t, os.WriteFile(filepath.Join("devnet", "devnet-config.json"), []byte(json), 0644), nil)
+		_, err = c.Reload(RELOAD_ADMIN)
+		var verr *ValidationError
+		assert.Equal(t, errors.As(err, &verr), true)
+		assert.Equal(t, c.PoolRation(), float64(10))
+		assert.Equal(t, c.MaxConnectPerIp(), 3)
+		assert.Equal(t, reloaded, 1)
+	})
+}
+
+func TestReloadCommandLine(t *testing.T) {
+	inConfigDir(t, testConfigJson, func() {
+		c, err := Load(common.DEVNET)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c.ChangePara([]string{"-P", "0.0.0.0:7001:8:2:4:7:5:5:5"}), nil)
+
+		// the rations and limits of -P win over the file
+		json := strings.Replace(testConfigJson, `"poolRation": 5`, `"poolRation": 10`, 1)
+		json = strings.Replace(json, `"threads": 0`, `"threads": 0, "maxConnectPerIp": 3, "globalMinerLimit": 100`, 1)
+		assert.Equal(t, os.WriteFile(filepath.Join("devnet", "devnet-config.json"), []byte(json), 0644), nil)
+		changes, err := c.Reload(RELOAD_ADMIN)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(changes), 1)
+		assert.Equal(t, changes[0].Field, "miner.globalMinerLimit")
+		assert.Equal(t, c.PoolRation(), float64(7))
+		assert.Equal(t, c.MaxConnectPerIp(), 2)
+		assert.Equal(t, c.GlobalMinerLimit(), 100)
+	})
+}
+
+func TestWatch(t *testing.T) {
+	inConfigDir(t, testConfigJson, func() {
+		c, err := Load(common.DEVNET)
+		assert.Equal(t, err, nil)
+		done := make(chan struct{}, 1)
+		c.OnReload(func(*Config) { done <- struct{}{} })
+		assert.Equal(t, c.Watch(), nil)
+
+		json := strings.Replace(testConfigJson, `"threads": 0`, `"threads": 0, "globalMinerLimit": 100`, 1)
+		assert.Equal(t, os.WriteFile(filepath.Join("devnet", "devnet-config.json"), []byte(json), 0644), nil)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("config not reloaded")
+		}
+		assert.Equal(t, c.GlobalMinerLimit(), 100)
+	})
+}
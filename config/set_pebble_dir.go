@@ -4,9 +4,12 @@
 
 package config
 
+import "xdago/common"
+
 func (c *Config) SetDir() {
 	c.storeDir = c.rootDir + "/pebble/xdagdb"
 	c.storeBackupDir = c.rootDir + "/pebble/xdagdb/backupdata"
 	c.whiteListDir = c.rootDir + "/netdb-white.txt"
 	c.netDBDir = c.rootDir + "/netdb.txt"
+	c.netBanDir = c.rootDir + "/" + common.NET_BAN_FILE_NAME
 }
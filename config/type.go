@@ -1,25 +1,39 @@
 package config
 
 import (
+	"sync"
 	"xdago/common"
 )
 
 type Config struct {
+	// mu guards the values applied by Reload
+	mu          sync.RWMutex
+	reloading   sync.Mutex
+	reloadHooks []func(c *Config)
+	commandLine map[string]bool // fields set on the command line, a reload keeps them
+
 	configName string
 
 	// Admin spec
 	telnetIp       string
 	telnetPort     int
 	telnetPassword string
+	telnetTlsCert  string
+	telnetTlsKey   string
+	// logins of the console, the telnet password alone is used when empty
+	telnetCredentials []Credential
 
 	// Mining Pool spec
 	poolIp       string
 	poolPort     int
+	stratumPort  int
 	poolTag      string
 	poolRation   float64
 	rewardRation float64
 	fundRation   float64
 	directRation float64
+	payoutMode   string // common.PAYOUT_MODE_PROP or common.PAYOUT_MODE_PPLNS
+	pplnsWindow  int    // shares paid by the pplns mode
 
 	globalMinerLimit        int
 	globalMinerChannelLimit int
@@ -29,6 +43,7 @@ type Config struct {
 	maxShareCountPerChannel int
 	awardEpoch              int
 	waitEpoch               int
+	minerThreads            int // local cpu miner threads, 0 disables it
 
 	// Node spec
 	nodeIp                     string
@@ -43,16 +58,18 @@ type Config struct {
 	storeBackupDir string
 	whiteListDir   string
 	netDBDir       string
+	netBanDir      string
 
 	storeMaxOpenFiles int
 	storeMaxThreads   int
 	storeFromBackup   bool
 	originStoreDir    string
 
-	whitelistUrl  string
-	enableRefresh bool
-	dnetKeyFile   string
-	walletKeyFile string
+	whitelistUrl    string
+	enableRefresh   bool
+	enableWhitelist bool
+	dnetKeyFile     string
+	walletKeyFile   string
 
 	ttl          int
 	dnetKeyBytes [2048]byte
@@ -69,19 +86,49 @@ type Config struct {
 	walletFilePath string
 
 	// Xdag spec
+	network          common.NetworkType
 	xdagEra          uint64
 	xdagFieldHeader  common.FieldType
 	mainStartAmount  uint64
 	apolloForkHeight uint64
 	apolloForkAmount uint64
 
+	// RandomX spec
+	randomxForkHeight uint64
+	seedEpochBlocks   uint64
+	seedEpochLag      uint64
+	randomxLargePages bool
+	blockDifficulty   uint64 // fixed difficulty of every block, 0 to take it from the hash
+
+	// Regtest spec, the regtest section of the config file, the other
+	// networks have fixed values
+	regtestEra         uint64
+	regtestReward      uint64
+	regtestForkHeight  uint64
+	regtestForkReward  uint64
+	regtestRandomxFork uint64
+	regtestDifficulty  uint64
+
 	// Xdag RPC modules
 	rpcEnabled  bool
 	rpcHost     string
 	rpcPortHttp int
 	rpcPortWs   int
+	rpcTlsCert  string
+	rpcTlsKey   string
+	// callers of the api, rpcAnonymous is used by requests without credentials
+	rpcCredentials []Credential
+	rpcAnonymous   Credential
 	//moduleDescriptions []ModuleDescription
 
+	// log verbosity of the node, log.LvlCrit to log.LvlTrace
+	logVerbosity int
+
+	// Metrics and health endpoint
+	metricsEnabled bool
+	metricsHost    string
+	metricsPort    int
+
 	// Xdag Snapshot
 	snapshotEnabled bool
 	snapshotHeight  uint64
@@ -89,6 +136,19 @@ type Config struct {
 	isSnapshotJ     bool
 }
 
+// Credential is a login of the rpc or admin interfaces: a bearer token or a
+// user and password. Methods lists the methods or method groups it may call,
+// "*" is all of them. RateLimit is in calls per second, 0 is unlimited.
+type Credential struct {
+	Name      string
+	Token     string
+	User      string
+	Password  string
+	Methods   []string
+	RateLimit float64
+	Burst     int
+}
+
 // getter and setter
 
 func (c *Config) ConfigName() string {
@@ -123,6 +183,30 @@ func (c *Config) SetTelnetPassword(telnetPassword string) {
 	c.telnetPassword = telnetPassword
 }
 
+func (c *Config) TelnetTlsCert() string {
+	return c.telnetTlsCert
+}
+
+func (c *Config) SetTelnetTlsCert(telnetTlsCert string) {
+	c.telnetTlsCert = telnetTlsCert
+}
+
+func (c *Config) TelnetTlsKey() string {
+	return c.telnetTlsKey
+}
+
+func (c *Config) SetTelnetTlsKey(telnetTlsKey string) {
+	c.telnetTlsKey = telnetTlsKey
+}
+
+func (c *Config) TelnetCredentials() []Credential {
+	return c.telnetCredentials
+}
+
+func (c *Config) SetTelnetCredentials(telnetCredentials []Credential) {
+	c.telnetCredentials = telnetCredentials
+}
+
 func (c *Config) PoolIp() string {
 	return c.poolIp
 }
@@ -139,6 +223,14 @@ func (c *Config) SetPoolPort(poolPort int) {
 	c.poolPort = poolPort
 }
 
+func (c *Config) StratumPort() int {
+	return c.stratumPort
+}
+
+func (c *Config) SetStratumPort(stratumPort int) {
+	c.stratumPort = stratumPort
+}
+
 func (c *Config) PoolTag() string {
 	return c.poolTag
 }
@@ -148,42 +240,85 @@ func (c *Config) SetPoolTag(tag string) {
 }
 
 func (c *Config) PoolRation() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.poolRation
 }
 
 func (c *Config) SetPoolRation(poolRation float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.poolRation = poolRation
 }
 
 func (c *Config) RewardRation() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.rewardRation
 }
 
 func (c *Config) SetRewardRation(rewardRation float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.rewardRation = rewardRation
 }
 
 func (c *Config) FundRation() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.fundRation
 }
 
 func (c *Config) SetFundRation(fundRation float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.fundRation = fundRation
 }
 
+// Rations returns the pool rations read at once
+func (c *Config) Rations() (pool, reward, fund, direct float64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.poolRation, c.rewardRation, c.fundRation, c.directRation
+}
+
 func (c *Config) DirectRation() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.directRation
 }
 
 func (c *Config) SetDirectRation(directRation float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.directRation = directRation
 }
 
+func (c *Config) PayoutMode() string {
+	return c.payoutMode
+}
+
+func (c *Config) SetPayoutMode(payoutMode string) {
+	c.payoutMode = payoutMode
+}
+
+func (c *Config) PplnsWindow() int {
+	return c.pplnsWindow
+}
+
+func (c *Config) SetPplnsWindow(pplnsWindow int) {
+	c.pplnsWindow = pplnsWindow
+}
+
 func (c *Config) GlobalMinerLimit() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.globalMinerLimit
 }
 
 func (c *Config) SetGlobalMinerLimit(globalMinerLimit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.globalMinerLimit = globalMinerLimit
 }
 
@@ -196,18 +331,26 @@ func (c *Config) SetGlobalMinerChannelLimit(globalMinerChannelLimit int) {
 }
 
 func (c *Config) MaxConnectPerIp() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.maxConnectPerIp
 }
 
 func (c *Config) SetMaxConnectPerIp(maxConnectPerIp int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.maxConnectPerIp = maxConnectPerIp
 }
 
 func (c *Config) MaxMinerPerAccount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.maxMinerPerAccount
 }
 
 func (c *Config) SetMaxMinerPerAccount(maxMinerPerAccount int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.maxMinerPerAccount = maxMinerPerAccount
 }
 
@@ -235,6 +378,14 @@ func (c *Config) SetWaitEpoch(waitEpoch int) {
 	c.waitEpoch = waitEpoch
 }
 
+func (c *Config) MinerThreads() int {
+	return c.minerThreads
+}
+
+func (c *Config) SetMinerThreads(minerThreads int) {
+	c.minerThreads = minerThreads
+}
+
 func (c *Config) NodeIp() string {
 	return c.nodeIp
 }
@@ -323,6 +474,14 @@ func (c *Config) SetNetDBDir(netDBDir string) {
 	c.netDBDir = netDBDir
 }
 
+func (c *Config) NetBanDir() string {
+	return c.netBanDir
+}
+
+func (c *Config) SetNetBanDir(netBanDir string) {
+	c.netBanDir = netBanDir
+}
+
 func (c *Config) StoreMaxOpenFiles() int {
 	return c.storeMaxOpenFiles
 }
@@ -371,6 +530,14 @@ func (c *Config) SetEnableRefresh(enableRefresh bool) {
 	c.enableRefresh = enableRefresh
 }
 
+func (c *Config) EnableWhitelist() bool {
+	return c.enableWhitelist
+}
+
+func (c *Config) SetEnableWhitelist(enableWhitelist bool) {
+	c.enableWhitelist = enableWhitelist
+}
+
 func (c *Config) DnetKeyFile() string {
 	return c.dnetKeyFile
 }
@@ -404,10 +571,14 @@ func (c *Config) SetDnetKeyBytes(dnetKeyBytes [2048]byte) {
 }
 
 func (c *Config) WhiteIPList() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.whiteIPList
 }
 
 func (c *Config) SetWhiteIPList(whiteIPList []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.whiteIPList = whiteIPList
 }
 
@@ -451,6 +622,14 @@ func (c *Config) SetWalletFilePath(walletFilePath string) {
 	c.walletFilePath = walletFilePath
 }
 
+func (c *Config) Network() common.NetworkType {
+	return c.network
+}
+
+func (c *Config) SetNetwork(network common.NetworkType) {
+	c.network = network
+}
+
 func (c *Config) XdagEra() uint64 {
 	return c.xdagEra
 }
@@ -491,6 +670,46 @@ func (c *Config) SetApolloForkAmount(apolloForkAmount uint64) {
 	c.apolloForkAmount = apolloForkAmount
 }
 
+func (c *Config) RandomxForkHeight() uint64 {
+	return c.randomxForkHeight
+}
+
+func (c *Config) SetRandomxForkHeight(randomxForkHeight uint64) {
+	c.randomxForkHeight = randomxForkHeight
+}
+
+func (c *Config) SeedEpochBlocks() uint64 {
+	return c.seedEpochBlocks
+}
+
+func (c *Config) SetSeedEpochBlocks(seedEpochBlocks uint64) {
+	c.seedEpochBlocks = seedEpochBlocks
+}
+
+func (c *Config) SeedEpochLag() uint64 {
+	return c.seedEpochLag
+}
+
+func (c *Config) SetSeedEpochLag(seedEpochLag uint64) {
+	c.seedEpochLag = seedEpochLag
+}
+
+func (c *Config) BlockDifficulty() uint64 {
+	return c.blockDifficulty
+}
+
+func (c *Config) SetBlockDifficulty(blockDifficulty uint64) {
+	c.blockDifficulty = blockDifficulty
+}
+
+func (c *Config) RandomxLargePages() bool {
+	return c.randomxLargePages
+}
+
+func (c *Config) SetRandomxLargePages(randomxLargePages bool) {
+	c.randomxLargePages = randomxLargePages
+}
+
 func (c *Config) RpcEnabled() bool {
 	return c.rpcEnabled
 }
@@ -523,6 +742,46 @@ func (c *Config) SetRpcPortWs(rpcPortWs int) {
 	c.rpcPortWs = rpcPortWs
 }
 
+func (c *Config) RpcTlsCert() string {
+	return c.rpcTlsCert
+}
+
+func (c *Config) SetRpcTlsCert(rpcTlsCert string) {
+	c.rpcTlsCert = rpcTlsCert
+}
+
+func (c *Config) RpcTlsKey() string {
+	return c.rpcTlsKey
+}
+
+func (c *Config) SetRpcTlsKey(rpcTlsKey string) {
+	c.rpcTlsKey = rpcTlsKey
+}
+
+func (c *Config) RpcCredentials() []Credential {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rpcCredentials
+}
+
+func (c *Config) SetRpcCredentials(rpcCredentials []Credential) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rpcCredentials = rpcCredentials
+}
+
+func (c *Config) RpcAnonymous() Credential {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rpcAnonymous
+}
+
+func (c *Config) SetRpcAnonymous(rpcAnonymous Credential) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rpcAnonymous = rpcAnonymous
+}
+
 func (c *Config) SnapshotEnabled() bool {
 	return c.snapshotEnabled
 }
@@ -554,3 +813,39 @@ func (c *Config) IsSnapshotJ() bool {
 func (c *Config) SetIsSnapshotJ(isSnapshotJ bool) {
 	c.isSnapshotJ = isSnapshotJ
 }
+
+func (c *Config) MetricsEnabled() bool {
+	return c.metricsEnabled
+}
+
+func (c *Config) SetMetricsEnabled(metricsEnabled bool) {
+	c.metricsEnabled = metricsEnabled
+}
+
+func (c *Config) MetricsHost() string {
+	return c.metricsHost
+}
+
+func (c *Config) SetMetricsHost(metricsHost string) {
+	c.metricsHost = metricsHost
+}
+
+func (c *Config) MetricsPort() int {
+	return c.metricsPort
+}
+
+func (c *Config) SetMetricsPort(metricsPort int) {
+	c.metricsPort = metricsPort
+}
+
+func (c *Config) LogVerbosity() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.logVerbosity
+}
+
+func (c *Config) SetLogVerbosity(logVerbosity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logVerbosity = logVerbosity
+}
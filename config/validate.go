@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"xdago/common"
+	"xdago/log"
+)
+
+const MAX_RATIONS = 100 // the pool rations are percents of the block reward
+
+// FieldError is an invalid value of the config key Field
+type FieldError struct {
+	Field  string
+	Value  interface{}
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %v: %s", e.Field, e.Value, e.Reason)
+}
+
+// ValidationError lists every invalid field of a config
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Error())
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field string, value interface{}, reason string) {
+	e.Fields = append(e.Fields, &FieldError{Field: field, Value: value, Reason: reason})
+}
+
+// err returns nil when no field is invalid
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the values of the config, the error is a *ValidationError
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+	c.validate(verr)
+	return verr.err()
+}
+
+func (c *Config) validate(verr *ValidationError) {
+	port := func(field string, p int, optional bool) {
+		if p < 0 || p > 65535 || (p == 0 && !optional) {
+			verr.add(field, p, "port must be 1 to 65535")
+		}
+	}
+	host := func(field, h string) {
+		if h == "" {
+			verr.add(field, `""`, "host is empty")
+		}
+	}
+	notNegative := func(field string, n int) {
+		if n < 0 {
+			verr.add(field, n, "must not be negative")
+		}
+	}
+
+	host("admin.telnet.ip", c.telnetIp)
+	port("admin.telnet.port", c.telnetPort, false)
+	if (c.telnetTlsCert == "") != (c.telnetTlsKey == "") {
+		verr.add("admin.telnet.tls", c.telnetTlsCert+","+c.telnetTlsKey, "cert and key must be set together")
+	}
+
+	host("pool.ip", c.poolIp)
+	port("pool.port", c.poolPort, false)
+	port("pool.stratumPort", c.stratumPort, false)
+	if len(c.poolTag) > common.MAX_POOL_TAG {
+		verr.add("pool.tag", c.poolTag, fmt.Sprintf("longer than %d chars", common.MAX_POOL_TAG))
+	}
+	rations := []struct {
+		field string
+		value float64
+	}{
+		{"pool.poolRation", c.poolRation},
+		{"pool.rewardRation", c.rewardRation},
+		{"pool.fundRation", c.fundRation},
+		{"pool.directRation", c.directRation},
+	}
+	var sum float64
+	for _, r := range rations {
+		if r.value < 0 || r.value > MAX_RATIONS {
+			verr.add(r.field, r.value, fmt.Sprintf("must be 0 to %d", MAX_RATIONS))
+		}
+		sum += r.value
+	}
+	if sum > MAX_RATIONS {
+		verr.add("pool rations", sum, fmt.Sprintf("sum is over %d", MAX_RATIONS))
+	}
+	if c.payoutMode != common.PAYOUT_MODE_PROP && c.payoutMode != common.PAYOUT_MODE_PPLNS {
+		verr.add("pool.payoutMode", c.payoutMode, "must be "+common.PAYOUT_MODE_PROP+" or "+common.PAYOUT_MODE_PPLNS)
+	}
+	if c.pplnsWindow <= 0 {
+		verr.add("pool.pplnsWindow", c.pplnsWindow, "must be positive")
+	}
+	if c.regtestDifficulty == 0 {
+		verr.add("regtest.difficulty", c.regtestDifficulty, "must be positive")
+	}
+
+	host("node.ip", c.nodeIp)
+	port("node.port", c.nodePort, false)
+	port("node.libp2p.port", c.libp2pPort, true)
+	notNegative("node.maxInboundConnectionsPerIp", c.maxInboundConnectionsPerIp)
+	for _, address := range c.whiteIPList {
+		if _, err := net.ResolveTCPAddr("tcp4", address); err != nil {
+			verr.add("node.whiteIPs", address, "not an ip:port address")
+		}
+	}
+
+	notNegative("miner.globalMinerLimit", c.globalMinerLimit)
+	notNegative("miner.globalMinerChannelLimit", c.globalMinerChannelLimit)
+	notNegative("miner.maxConnectPerIp", c.maxConnectPerIp)
+	notNegative("miner.maxMinerPerAccount", c.maxMinerPerAccount)
+	notNegative("miner.threads", c.minerThreads)
+
+	if c.rpcEnabled {
+		host("rpc.http.host", c.rpcHost)
+		port("rpc.http.port", c.rpcPortHttp, false)
+		port("rpc.ws.port", c.rpcPortWs, false)
+	}
+	if (c.rpcTlsCert == "") != (c.rpcTlsKey == "") {
+		verr.add("rpc.tls", c.rpcTlsCert+","+c.rpcTlsKey, "cert and key must be set together")
+	}
+	if c.logVerbosity < int(log.LvlCrit) || c.logVerbosity > int(log.LvlTrace) {
+		verr.add("log.verbosity", c.logVerbosity, fmt.Sprintf("must be %d to %d", log.LvlCrit, log.LvlTrace))
+	}
+	if c.metricsEnabled {
+		host("metrics.host", c.metricsHost)
+		port("metrics.port", c.metricsPort, false)
+	}
+}
@@ -0,0 +1,172 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package config
+
+import (
+	"errors"
+	"github.com/magiconair/properties/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xdago/common"
+)
+
+const testConfigJson = `{
+	"admin": {"telnet": {"ip": "127.0.0.1", "port": 6001}},
+	"pool": {"ip": "127.0.0.1", "port": 7001, "tag": "xdago",
+		"poolRation": 5, "rewardRation": 5, "fundRation": 5, "directRation": 5},
+	"node": {"ip": "127.0.0.1", "port": 8001, "whiteIPs": ["127.0.0.1:8001"]},
+	"miner": {"threads": 0}
+}`
+
+// inConfigDir runs f in a temporary working dir with the devnet config json
+func inConfigDir(t *testing.T, json string, f func()) {
+	inNetworkDir(t, common.DEVNET, json, f)
+}
+
+// inNetworkDir runs f in a temporary working dir with the config json of
+// network
+func inNetworkDir(t *testing.T, network common.NetworkType, json string, f func()) {
+	dir := t.TempDir()
+	assert.Equal(t, os.Mkdir(filepath.Join(dir, network.String()), 0755), nil)
+	assert.Equal(t, os.WriteFile(filepath.Join(dir, network.String(), network.String()+"-config.json"), []byte(json), 0644), nil)
+	wd, _ := os.Getwd()
+	assert.Equal(t, os.Chdir(dir), nil)
+	defer os.Chdir(wd)
+	f()
+}
+
+func TestLoad(t *testing.T) {
+	inConfigDir(t, testConfigJson, func() {
+		c, err := Load(common.DEVNET)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c.Network(), common.DEVNET)
+		assert.Equal(t, c.NodePort(), 8001)
+		assert.Equal(t, c.PoolRation(), float64(5))
+		assert.Equal(t, c.PayoutMode(), common.PAYOUT_MODE_PROP)
+		assert.Equal(t, c.StoreDir(), "devnet/pebble/xdagdb")
+
+		// every load returns a new config
+		c.SetNodePort(9000)
+		c2, _ := Load(common.DEVNET)
+		assert.Equal(t, c2.NodePort(), 8001)
+
+		t.Setenv("XDAGO_NODE_PORT", "8500")
+		t.Setenv("XDAGO_POOL_PAYOUTMODE", common.PAYOUT_MODE_PPLNS)
+		c3, err := Load(common.DEVNET)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c3.NodePort(), 8500)
+		assert.Equal(t, c3.PayoutMode(), common.PAYOUT_MODE_PPLNS)
+
+		t.Setenv("XDAGO_NODE_PORT", "70000")
+		_, err = Load(common.DEVNET)
+		var verr *ValidationError
+		assert.Equal(t, errors.As(err, &verr), true)
+		assert.Equal(t, verr.Fields[0].Field, "node.port")
+
+		_, err = Load(common.MAINNET)
+		assert.Matches(t, err.Error(), "read config mainnet-config.json")
+		_, err = Load(common.NetworkType(9))
+		assert.Matches(t, err.Error(), "unknown network")
+	})
+}
+
+func TestLoadRegtest(t *testing.T) {
+	inNetworkDir(t, common.REGTEST, testConfigJson, func() {
+		c, err := Load(common.REGTEST)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c.IsRegtest(), true)
+		assert.Equal(t, c.RootDir(), "regtest")
+		assert.Equal(t, c.StoreDir(), "regtest/pebble/xdagdb")
+		assert.Equal(t, c.XdagEra(), REGTEST_XDAG_ERA)
+		assert.Equal(t, c.MainStartAmount(), uint64(1024<<32))
+		assert.Equal(t, c.ApolloForkHeight(), REGTEST_FORK_HEIGHT)
+		assert.Equal(t, c.ApolloForkAmount(), uint64(128<<32))
+		assert.Equal(t, c.RandomxForkHeight(), REGTEST_RANDOMX_FORK)
+		assert.Equal(t, c.BlockDifficulty(), REGTEST_DIFFICULTY)
+	})
+
+	json := strings.Replace(testConfigJson, `"miner"`, `"regtest": {"xdagEra": "0x17000000000", "reward": "64.5",
+		"apolloForkHeight": 10, "apolloForkReward": "1", "randomxForkHeight": 20, "difficulty": 5}, "miner"`, 1)
+	inNetworkDir(t, common.REGTEST, json, func() {
+		c, err := Load(common.REGTEST)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c.XdagEra(), uint64(0x17000000000))
+		assert.Equal(t, c.MainStartAmount(), uint64(64<<32+1<<31))
+		assert.Equal(t, c.ApolloForkHeight(), uint64(10))
+		assert.Equal(t, c.ApolloForkAmount(), uint64(1<<32))
+		assert.Equal(t, c.RandomxForkHeight(), uint64(20))
+		assert.Equal(t, c.BlockDifficulty(), uint64(5))
+
+		bad := strings.Replace(json, `"reward": "64.5"`, `"reward": "-1"`, 1)
+		assert.Equal(t, os.WriteFile(filepath.Join("regtest", "regtest-config.json"), []byte(bad), 0644), nil)
+		_, err = Load(common.REGTEST)
+		assert.Matches(t, err.Error(), "regtest.reward")
+
+		bad = strings.Replace(json, `"difficulty": 5`, `"difficulty": 0`, 1)
+		assert.Equal(t, os.WriteFile(filepath.Join("regtest", "regtest-config.json"), []byte(bad), 0644), nil)
+		_, err = Load(common.REGTEST)
+		assert.Matches(t, err.Error(), "regtest.difficulty")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	bad := `{
+		"admin": {"telnet": {"port": 0}},
+		"pool": {"poolRation": 50, "rewardRation": 40, "fundRation": 20, "directRation": -1, "payoutMode": "x"},
+		"node": {"whiteIPs": ["nowhere"]},
+		"miner": {"threads": -2},
+		"rpc": {"enabled": true, "http": {"port": 100000}, "credentials": "none"}
+	}`
+	inConfigDir(t, bad, func() {
+		_, err := Load(common.DEVNET)
+		var verr *ValidationError
+		assert.Equal(t, errors.As(err, &verr), true)
+		var fields []string
+		for _, f := range verr.Fields {
+			fields = append(fields, f.Field)
+		}
+		assert.Equal(t, fields, []string{"rpc.credentials", "admin.telnet.port", "pool.directRation", "pool rations",
+			"pool.payoutMode", "node.whiteIPs", "miner.threads", "rpc.http.port"})
+		assert.Matches(t, err.Error(), "^invalid config: rpc.credentials")
+	})
+
+	c := &Config{}
+	c.SetTelnetIp("127.0.0.1")
+	c.SetTelnetPort(6001)
+	c.SetPoolIp("127.0.0.1")
+	c.SetPoolPort(7001)
+	c.SetStratumPort(7002)
+	c.SetPayoutMode(common.PAYOUT_MODE_PROP)
+	c.SetPplnsWindow(common.PPLNS_WINDOW)
+	c.SetNodeIp("127.0.0.1")
+	c.SetNodePort(8001)
+	assert.Equal(t, c.Validate(), nil)
+	c.SetPoolTag("a tag that is much longer than the 32 chars")
+	assert.Matches(t, c.Validate().Error(), "pool.tag")
+}
+
+func TestChangePara(t *testing.T) {
+	c := &Config{}
+	assert.Equal(t, c.ChangePara([]string{"-m", "4", "-p", "1.2.3.4:8002", "-tag", "mine", "-d"}), nil)
+	assert.Equal(t, c.MinerThreads(), 4)
+	assert.Equal(t, c.NodeIp(), "1.2.3.4")
+	assert.Equal(t, c.NodePort(), 8002)
+	assert.Equal(t, c.PoolTag(), "mine")
+
+	assert.Matches(t, c.ChangePara([]string{"-m", "-1"}).Error(), "miner threads")
+	assert.Matches(t, c.ChangePara([]string{"-p", "1.2.3.4"}).Error(), "node host")
+	assert.Matches(t, c.ChangePara([]string{"-x"}).Error(), "illegal instruction")
+	assert.Matches(t, c.ChangePara([]string{"-m"}).Error(), "missing value")
+	assert.Equal(t, c.ChangePara([]string{"-P", "0.0.0.0:7001:8:2:4:5:5:5:5"}), nil)
+	assert.Equal(t, c.FundRation(), float64(5))
+	err := c.ChangePara([]string{"-P", "0.0.0.0:x:8:2:4:y:5:5:5"})
+	var verr *ValidationError
+	assert.Equal(t, errors.As(err, &verr), true)
+	assert.Equal(t, len(verr.Fields), 2)
+	assert.Equal(t, c.PoolPort(), 7001)
+}
@@ -0,0 +1,315 @@
+package consensus
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/log"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+// MAX_PAYMENTS_PER_BLOCK is the number of outputs of a payment block: the
+// fields left after the header, the input, the public key and the signature
+const MAX_PAYMENTS_PER_BLOCK = common.XDAG_BLOCK_FIELDS - 5
+
+var (
+	ErrAwardRation = errors.New("award: the sum of the pool rations is over 100")
+	ErrNoReward    = errors.New("award: main block has no reward")
+)
+
+// AwardChain is the part of core.IBlockchain the award manager depends on
+type AwardChain interface {
+	GetBlockByHeight(height uint64) *core.Block
+	TryToConnect(block *core.Block) core.ImportResult
+}
+
+// round collects the share difficulty of every account for one task, the
+// finder has the share of the highest actual difficulty
+type round struct {
+	diffs      map[string]*big.Int
+	finder     string
+	finderDiff *big.Int
+}
+
+func newRound() *round {
+	return &round{diffs: make(map[string]*big.Int), finderDiff: new(big.Int)}
+}
+
+func (r *round) add(share Share) {
+	sum, ok := r.diffs[share.Account]
+	if !ok {
+		sum = new(big.Int)
+		r.diffs[share.Account] = sum
+	}
+	sum.Add(sum, share.Difficulty)
+	if share.ActualDifficulty.Cmp(r.finderDiff) > 0 {
+		r.finder = share.Account
+		r.finderDiff = new(big.Int).Set(share.ActualDifficulty)
+	}
+}
+
+// AwardManager pays the reward of our main blocks once they are confirmed,
+// see DueEpoch. The reward is split in
+//   - poolRation% pool fee, left in the mined block
+//   - fundRation% to the community fund
+//   - rewardRation% to the miner of the best share
+//   - directRation% to the miners of the round by share difficulty
+//   - the rest to the miners of the round by share difficulty, or of the last
+//     pplnsWindow shares in the pplns payout mode
+type AwardManager struct {
+	sync.Mutex
+	config *config.Config
+	chain  AwardChain
+	ledger *Ledger
+	key    *secp256k1.PrivateKey // key of the pool, it signed the mined blocks
+	fund   common.Hash
+
+	rounds map[uint64]*round // by task index
+	window *ShareWindow      // nil unless the pool pays pplns
+	mined  map[common.Hash]*AwardRecord
+	now    func() uint64
+}
+
+func NewAwardManager(config *config.Config, chain AwardChain, ledger *Ledger,
+	key *secp256k1.PrivateKey) (*AwardManager, error) {
+	pool, reward, fundRation, direct := config.Rations()
+	if pool+reward+fundRation+direct > 100 || pool < 0 || reward < 0 || fundRation < 0 || direct < 0 {
+		return nil, ErrAwardRation
+	}
+	fund, err := codec.Address2Hash(common.FUND_ADDRESS)
+	if err != nil {
+		return nil, err
+	}
+	a := &AwardManager{
+		config: config,
+		chain:  chain,
+		ledger: ledger,
+		key:    key,
+		fund:   fund,
+		rounds: make(map[uint64]*round),
+		window: NewShareWindow(config, ledger.source),
+		mined:  make(map[common.Hash]*AwardRecord),
+		now:    utils.GetCurrentTimestamp,
+	}
+	// the blocks mined before a restart
+	for _, r := range ledger.Awards() {
+		a.mined[r.Hash] = r
+	}
+	return a, nil
+}
+
+// AddShare counts an accepted share, it is the share handler of the pool servers
+func (a *AwardManager) AddShare(share Share) {
+	a.Lock()
+	r, ok := a.rounds[share.TaskIndex]
+	if !ok {
+		r = newRound()
+		a.rounds[share.TaskIndex] = r
+	}
+	r.add(share)
+	a.Unlock()
+	// the window has its own lock, its writes don't hold up the rounds
+	if a.window != nil {
+		a.window.Add(share)
+	}
+}
+
+// Close writes the shares of the pplns window that are not saved yet
+func (a *AwardManager) Close() {
+	if a.window != nil {
+		a.window.Flush()
+	}
+}
+
+// split shares amount among the accounts by their difficulty, the result is
+// sorted by account and the rounding dust is returned
+func split(amount common.Amount, diffs map[string]*big.Int) ([]LedgerEntry, common.Amount) {
+	total := new(big.Int)
+	accounts := make([]string, 0, len(diffs))
+	for account, diff := range diffs {
+		total.Add(total, diff)
+		accounts = append(accounts, account)
+	}
+	if total.Sign() == 0 {
+		return nil, amount
+	}
+	sort.Strings(accounts)
+	var entries []LedgerEntry
+	left := amount
+	for _, account := range accounts {
+		part := new(big.Int).Mul(new(big.Int).SetUint64(uint64(amount)), diffs[account])
+		part.Quo(part, total)
+		if part.Sign() == 0 {
+			continue
+		}
+		entries = append(entries, LedgerEntry{Account: account, Amount: common.Amount(part.Uint64())})
+		left -= common.Amount(part.Uint64())
+	}
+	return entries, left
+}
+
+// Split computes the payout entries of the reward of a main block. diffs are
+// the shares of the round, window the shares of the pplns window, the rest
+// is split by the round when window is nil. The parts of the accounts that
+// are not addresses go to the fee.
+func (a *AwardManager) Split(block *core.Block, height uint64, diffs, window map[string]*big.Int, finder string) []LedgerEntry {
+	// the rations are read at once, a reload may change them
+	poolRation, rewardRation, fundRation, directRation := a.config.Rations()
+	total := common.Amount(block.Info().Amount)
+	fund := total.Percent(fundRation)
+	finderPart := total.Percent(rewardRation)
+	direct := total.Percent(directRation)
+	fee := total.Percent(poolRation)
+	if finder == "" {
+		fee += finderPart
+		finderPart = 0
+	}
+	rest := total - fund - finderPart - direct - fee
+
+	var entries []LedgerEntry
+	add := func(kind PayoutKind, parts []LedgerEntry) {
+		for _, e := range parts {
+			e.Kind = kind
+			entries = append(entries, e)
+		}
+	}
+	directParts, dust := split(direct, diffs)
+	fee += dust
+	add(PAYOUT_DIRECT, directParts)
+	if window == nil {
+		window = diffs
+	}
+	minerParts, dust := split(rest, window)
+	fee += dust
+	add(PAYOUT_MINER, minerParts)
+	if finderPart > 0 {
+		add(PAYOUT_FINDER, []LedgerEntry{{Account: finder, Amount: finderPart}})
+	}
+	if fund > 0 {
+		add(PAYOUT_FUND, []LedgerEntry{{Account: common.FUND_ADDRESS, Amount: fund}})
+	}
+	// a miner account that is not an address can't be paid, its part stays
+	// in the block with the fee
+	valid := entries[:0]
+	for _, e := range entries {
+		if _, err := codec.ParseHash(e.Account); err != nil {
+			log.Warn("payout to an invalid account kept as fee", log.Ctx{"account": e.Account, "amount": e.Amount.String()})
+			fee += e.Amount
+			continue
+		}
+		valid = append(valid, e)
+	}
+	entries = valid
+	add(PAYOUT_FEE, []LedgerEntry{{Account: codec.Hash2Address(block.GetHashLow()), Amount: fee}})
+
+	now := a.now()
+	for i := range entries {
+		entries[i].Block = block.GetHashLow()
+		entries[i].Height = height
+		entries[i].Time = now
+	}
+	return entries
+}
+
+// Pay splits the reward of block, sends the payment blocks and writes the
+// ledger. A block already in the ledger is not paid again. Every payment
+// block is saved before it is sent, a retry after a failure or a restart
+// sends the saved blocks again, which the chain finds existing, so no miner
+// is paid twice.
+func (a *AwardManager) Pay(block *core.Block, height uint64, diffs, window map[string]*big.Int, finder string) ([]LedgerEntry, error) {
+	if a.ledger.IsPaid(height, block.GetHashLow()) {
+		return nil, nil
+	}
+	if block.Info().Amount == 0 {
+		return nil, ErrNoReward
+	}
+	entries := a.Split(block, height, diffs, window, finder)
+
+	// merge the payments to the same address, the fee stays in the block
+	amounts := make(map[common.Hash]common.Amount)
+	var order []common.Hash
+	for _, e := range entries {
+		if e.Kind == PAYOUT_FEE {
+			continue
+		}
+		h, err := codec.ParseHash(e.Account)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := amounts[h]; !ok {
+			order = append(order, h)
+		}
+		amounts[h] += e.Amount
+	}
+	chunks := a.ledger.Chunks(height, block.GetHashLow())
+	txs := make(map[common.Hash]common.Hash)
+	for index, start := 0, 0; start < len(order); index, start = index+1, start+MAX_PAYMENTS_PER_BLOCK {
+		end := start + MAX_PAYMENTS_PER_BLOCK
+		if end > len(order) {
+			end = len(order)
+		}
+		c, ok := chunks[index]
+		if !ok {
+			tx := a.paymentBlock(block, order[start:end], amounts)
+			c = &PaymentChunk{
+				Block:  block.GetHashLow(),
+				Height: height,
+				Index:  index,
+				To:     order[start:end],
+				Tx:     tx.GetHashLow(),
+				Raw:    tx.ToBytes(),
+			}
+			a.ledger.SaveChunk(c)
+		}
+		if err := a.sendPayment(c); err != nil {
+			return nil, err
+		}
+		for _, h := range c.To {
+			txs[h] = c.Tx
+		}
+	}
+	for i, e := range entries {
+		if e.Kind == PAYOUT_FEE {
+			continue
+		}
+		h, _ := codec.ParseHash(e.Account)
+		entries[i].Tx = txs[h]
+	}
+	a.ledger.Add(entries)
+	a.ledger.DeleteChunks(height, block.GetHashLow())
+	log.Info("mined block paid", log.Ctx{"height": height, "payments": len(order), "amount": common.Amount(block.Info().Amount).String()})
+	return entries, nil
+}
+
+// paymentBlock creates a block moving the amounts of to from block
+func (a *AwardManager) paymentBlock(block *core.Block, to []common.Hash, amounts map[common.Hash]common.Amount) *core.Block {
+	var sum common.Amount
+	links := make([]core.Address, 0, len(to)+1)
+	links = append(links, core.Address{})
+	for _, h := range to {
+		links = append(links, core.AddressFromAmount(h, common.XDAG_FIELD_OUT, uint64(amounts[h])))
+		sum += amounts[h]
+	}
+	links[0] = core.AddressFromAmount(block.GetHashLow(), common.XDAG_FIELD_IN, uint64(sum))
+
+	tx := core.NewBlock(a.config, a.now(), links, nil, false, []*secp256k1.PublicKey{a.key.PubKey()}, "", 0)
+	tx.SignOut(a.key)
+	return tx
+}
+
+// sendPayment imports the payment block of c, a block imported before is
+// reported existing
+func (a *AwardManager) sendPayment(c *PaymentChunk) error {
+	tx := core.NewBlockFromXdag(core.NewXdagBlock(c.Raw))
+	res := a.chain.TryToConnect(tx)
+	if !res.IsNormal() {
+		return errors.New("award: payment block rejected: " + res.ErrorInfo)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package consensus
+
+import (
+	"math/big"
+	"sort"
+	"xdago/common"
+	"xdago/core"
+	"xdago/log"
+	"xdago/utils"
+)
+
+// AwardRecord is one of our mined blocks waiting for its payout, it is saved
+// in the ledger so that a restart does not lose the round
+type AwardRecord struct {
+	Hash   common.Hash
+	Height uint64 // main chain height, 0 until the block wins its epoch
+	Epoch  uint64
+	Diffs  map[string]*big.Int
+	Window map[string]*big.Int // shares of the pplns window when the block was mined
+	Finder string
+}
+
+// DueEpoch is the first epoch the block of r can be paid at: waitEpoch epochs
+// after its own epoch, rounded up to the next multiple of awardEpoch+1 so the
+// payouts are made in batches. The block also needs CONFIRMATIONS_COUNT main
+// blocks on top of it.
+func (a *AwardManager) DueEpoch(r *AwardRecord) uint64 {
+	mask := uint64(a.config.AwardEpoch())
+	due := r.Epoch + uint64(a.config.WaitEpoch())
+	return (due + mask) &^ mask
+}
+
+// expired reports whether a block that never won its epoch can be forgotten
+func (a *AwardManager) expired(r *AwardRecord, epoch uint64) bool {
+	return r.Height == 0 && epoch > a.DueEpoch(r)+uint64(common.CONFIRMATIONS_COUNT)
+}
+
+// OnBlockMined registers a block mined for the task of taskIndex, its round
+// is paid when the block is confirmed as a main block
+func (a *AwardManager) OnBlockMined(block *core.Block, taskIndex uint64) {
+	a.Lock()
+	defer a.Unlock()
+	r, ok := a.rounds[taskIndex]
+	if !ok {
+		r = newRound()
+	}
+	record := &AwardRecord{
+		Hash:   block.GetHash(),
+		Epoch:  utils.GetEpoch(block.GetTimestamp()),
+		Diffs:  r.diffs,
+		Finder: r.finder,
+	}
+	if a.window != nil {
+		record.Window = a.window.Weights()
+	}
+	a.mined[record.Hash] = record
+	a.ledger.SaveAward(record)
+	// older rounds can't be mined anymore
+	for index := range a.rounds {
+		if index < taskIndex {
+			delete(a.rounds, index)
+		}
+	}
+}
+
+// OnNewMainBlock is called when the main chain reaches height. It records
+// whether the main block is ours and pays the blocks that are due.
+func (a *AwardManager) OnNewMainBlock(height uint64) {
+	main := a.chain.GetBlockByHeight(height)
+	if main == nil {
+		return
+	}
+	epoch := utils.GetEpoch(main.GetTimestamp())
+
+	a.Lock()
+	if r, ok := a.mined[main.GetHash()]; ok && r.Height != height {
+		r.Height = height
+		a.ledger.SaveAward(r)
+		log.Info("mined block won its epoch", log.Ctx{"height": height, "dueEpoch": a.DueEpoch(r)})
+	}
+	var due []*AwardRecord
+	for hash, r := range a.mined {
+		if a.expired(r, epoch) {
+			delete(a.mined, hash)
+			a.ledger.DeleteAward(hash)
+			continue
+		}
+		if r.Height == 0 || epoch < a.DueEpoch(r) || r.Height+uint64(common.CONFIRMATIONS_COUNT) > height {
+			continue
+		}
+		due = append(due, r)
+	}
+	a.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].Height < due[j].Height })
+	for _, r := range due {
+		block := a.chain.GetBlockByHeight(r.Height)
+		if block == nil || block.GetHash() != r.Hash {
+			log.Info("mined block is not main anymore", log.Ctx{"hash": utils.Hash2String(r.Hash), "height": r.Height})
+			a.forget(r)
+			continue
+		}
+		if _, err := a.Pay(block, r.Height, r.Diffs, r.Window, r.Finder); err != nil {
+			// kept for the next main block
+			log.Error("pay mined block failed", log.Ctx{"height": r.Height, "err": err.Error()})
+			continue
+		}
+		a.forget(r)
+	}
+}
+
+func (a *AwardManager) forget(r *AwardRecord) {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.mined, r.Hash)
+	a.ledger.DeleteAward(r.Hash)
+}
+
+// Pending returns the mined blocks waiting for their payout ordered by epoch
+func (a *AwardManager) Pending() []AwardRecord {
+	a.Lock()
+	defer a.Unlock()
+	res := make([]AwardRecord, 0, len(a.mined))
+	for _, r := range a.mined {
+		res = append(res, *r)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Epoch < res[j].Epoch })
+	return res
+}
@@ -0,0 +1,334 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package consensus
+
+import (
+	"github.com/magiconair/properties/assert"
+	"math/big"
+	"sync"
+	"testing"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/db/factory"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+type fakeAwardChain struct {
+	sync.Mutex
+	main  map[uint64]*core.Block
+	txs   []*core.Block
+	fails int // the imports rejected after len(txs) reaches it, 0 for none
+}
+
+func (c *fakeAwardChain) GetBlockByHeight(height uint64) *core.Block {
+	c.Lock()
+	defer c.Unlock()
+	return c.main[height]
+}
+
+func (c *fakeAwardChain) TryToConnect(block *core.Block) core.ImportResult {
+	c.Lock()
+	defer c.Unlock()
+	for _, tx := range c.txs {
+		if tx.GetHashLow() == block.GetHashLow() {
+			return core.ImportResult{Status: common.IMPORT_EXIST, HashLow: block.GetHashLow()}
+		}
+	}
+	if c.fails > 0 && len(c.txs) >= c.fails {
+		return core.ImportResult{Status: common.INVALID_BLOCK, ErrorInfo: "rejected"}
+	}
+	c.txs = append(c.txs, block)
+	return core.ImportResult{Status: common.IMPORTED_NOT_BEST, HashLow: block.GetHashLow()}
+}
+
+// grow adds the main blocks from..to, one per epoch
+func (c *fakeAwardChain) grow(cfg *config.Config, from, to uint64, a *AwardManager) {
+	for h := from; h <= to; h++ {
+		c.Lock()
+		c.main[h] = mainBlock(cfg, h)
+		c.Unlock()
+		a.OnNewMainBlock(h)
+	}
+}
+
+func awardConfig(t *testing.T) *config.Config {
+	c := seedConfig()
+	c.SetStoreDir(t.TempDir())
+	c.SetPoolRation(5)
+	c.SetRewardRation(5)
+	c.SetFundRation(5)
+	c.SetDirectRation(10)
+	return c
+}
+
+func newTestLedger(t *testing.T, c *config.Config) *Ledger {
+	kvFactory := factory.NewKvStoreFactory(c)
+	source := kvFactory.GetDB(common.DB_POOL)
+	source.Init()
+	t.Cleanup(kvFactory.Close)
+	return NewLedger(source)
+}
+
+func testAccount(i byte) string {
+	var h common.Hash
+	h[8] = i
+	h[31] = 0x5a
+	return codec.Hash2Address(h)
+}
+
+func TestAwardRations(t *testing.T) {
+	c := &config.Config{}
+	c.SetPoolRation(50)
+	c.SetFundRation(60)
+	_, err := NewAwardManager(c, &fakeAwardChain{}, nil, nil)
+	assert.Equal(t, err, ErrAwardRation)
+}
+
+func TestAwardPay(t *testing.T) {
+	c := awardConfig(t)
+	chain := &fakeAwardChain{main: make(map[uint64]*core.Block)}
+	key, _ := secp256k1.GeneratePrivateKey()
+	ledger := newTestLedger(t, c)
+	a, err := NewAwardManager(c, chain, ledger, key)
+	assert.Equal(t, err, nil)
+
+	// three miners, the last one is given as a hashlow string
+	var hexHash common.Hash
+	hexHash[8] = 3
+	accounts := []string{testAccount(1), testAccount(2), utils.Hash2String(hexHash)}
+	for i, account := range accounts {
+		for n := 0; n <= i; n++ {
+			a.AddShare(Share{Account: account, TaskIndex: 7, Difficulty: big.NewInt(1000), ActualDifficulty: big.NewInt(1000)})
+		}
+	}
+	a.AddShare(Share{Account: accounts[0], TaskIndex: 7, Difficulty: big.NewInt(1000), ActualDifficulty: big.NewInt(5000)})
+
+	block := mainBlock(c, 100)
+	block.Info().Amount = 1024 << 32
+	a.OnBlockMined(block, 7)
+	chain.main[100] = block
+	a.OnNewMainBlock(100)
+	chain.grow(c, 101, 100+uint64(common.CONFIRMATIONS_COUNT)-1, a)
+	assert.Equal(t, len(chain.txs), 0)
+	chain.grow(c, 100+uint64(common.CONFIRMATIONS_COUNT), 100+uint64(common.CONFIRMATIONS_COUNT), a)
+	assert.Equal(t, len(chain.txs), 1)
+
+	entries := ledger.EntriesAt(100)
+	var total common.Amount
+	kinds := make(map[PayoutKind]common.Amount)
+	for _, e := range entries {
+		assert.Equal(t, e.Block, block.GetHashLow())
+		total += e.Amount
+		kinds[e.Kind] += e.Amount
+		if e.Kind != PAYOUT_FEE {
+			assert.Equal(t, e.Tx, chain.txs[0].GetHashLow())
+		}
+	}
+	assert.Equal(t, total, common.Amount(1024<<32))
+	assert.Equal(t, kinds[PAYOUT_FUND], common.Amount(1024<<32)/20)
+	assert.Equal(t, kinds[PAYOUT_FINDER], common.Amount(1024<<32)/20)
+	assert.Equal(t, kinds[PAYOUT_DIRECT] <= common.Amount(1024<<32)/10, true)
+	assert.Equal(t, ledger.IsPaid(100, block.GetHashLow()), true)
+
+	// miner 0 has 2000 of 7000 share weight and found the best share, its
+	// lucky hash counts for the finder reward only
+	var miner0 common.Amount
+	for _, e := range entries {
+		if e.Account == accounts[0] && (e.Kind == PAYOUT_DIRECT || e.Kind == PAYOUT_MINER) {
+			miner0 += e.Amount
+		}
+		if e.Kind == PAYOUT_FINDER {
+			assert.Equal(t, e.Account, accounts[0])
+		}
+	}
+	assert.Equal(t, miner0 >= common.Amount(1024<<32)*85/100*2/7-2, true)
+	assert.Equal(t, miner0 <= common.Amount(1024<<32)*85/100*2/7, true)
+
+	// the payment block spends the mined block
+	tx := core.NewBlockFromXdag(core.NewXdagBlock(chain.txs[0].ToBytes()))
+	assert.Equal(t, len(tx.Inputs), 1)
+	assert.Equal(t, tx.Inputs[0].HashLow, block.GetHashLow())
+	var out uint64
+	for _, o := range tx.Outputs {
+		out += o.Amount
+	}
+	assert.Equal(t, len(tx.Outputs), 4)
+	assert.Equal(t, out, tx.Inputs[0].Amount)
+	assert.Equal(t, out+uint64(kinds[PAYOUT_FEE]), uint64(1024<<32))
+
+	// paid once only
+	_, err = a.Pay(block, 100, nil, nil, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(chain.txs), 1)
+}
+
+func TestAwardPayBadAccount(t *testing.T) {
+	c := awardConfig(t)
+	chain := &fakeAwardChain{main: make(map[uint64]*core.Block)}
+	key, _ := secp256k1.GeneratePrivateKey()
+	ledger := newTestLedger(t, c)
+	a, _ := NewAwardManager(c, chain, ledger, key)
+
+	block := mainBlock(c, 100)
+	block.Info().Amount = 1024 << 32
+	diffs := map[string]*big.Int{testAccount(1): big.NewInt(1000), "not an address": big.NewInt(1000), "": big.NewInt(1000)}
+	entries, err := a.Pay(block, 100, diffs, nil, "not an address")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(chain.txs), 1)
+
+	// the good miner is paid its third, the other parts stay in the block
+	kinds := make(map[PayoutKind]common.Amount)
+	var total common.Amount
+	for _, e := range entries {
+		assert.Equal(t, e.Account != "not an address" && e.Account != "", true)
+		kinds[e.Kind] += e.Amount
+		total += e.Amount
+	}
+	assert.Equal(t, total, common.Amount(1024<<32))
+	assert.Equal(t, kinds[PAYOUT_FINDER], common.Amount(0))
+	good := kinds[PAYOUT_MINER] + kinds[PAYOUT_DIRECT]
+	assert.Equal(t, good >= common.Amount(1024<<32)*85/100/3-2 && good <= common.Amount(1024<<32)*85/100/3, true)
+	tx := core.NewBlockFromXdag(core.NewXdagBlock(chain.txs[0].ToBytes()))
+	assert.Equal(t, len(tx.Outputs), 2)
+	assert.Equal(t, tx.Inputs[0].Amount+uint64(kinds[PAYOUT_FEE]), uint64(1024<<32))
+}
+
+func TestAwardOrphanedBlock(t *testing.T) {
+	c := awardConfig(t)
+	chain := &fakeAwardChain{main: make(map[uint64]*core.Block)}
+	key, _ := secp256k1.GeneratePrivateKey()
+	ledger := newTestLedger(t, c)
+	a, _ := NewAwardManager(c, chain, ledger, key)
+
+	block := mainBlock(c, 200)
+	block.Info().Amount = 64 << 32
+	a.OnBlockMined(block, 1)
+	chain.main[200] = block
+	a.OnNewMainBlock(200)
+
+	// another block takes the height before it is confirmed
+	chain.main[200] = mainBlock(c, 200)
+	chain.grow(c, 201, 200+uint64(common.CONFIRMATIONS_COUNT), a)
+	assert.Equal(t, len(chain.txs), 0)
+	assert.Equal(t, len(ledger.Entries()), 0)
+}
+
+func TestAwardManyPayments(t *testing.T) {
+	c := awardConfig(t)
+	chain := &fakeAwardChain{main: make(map[uint64]*core.Block)}
+	key, _ := secp256k1.GeneratePrivateKey()
+	a, _ := NewAwardManager(c, chain, newTestLedger(t, c), key)
+
+	diffs := make(map[string]*big.Int)
+	for i := 1; i <= 2*MAX_PAYMENTS_PER_BLOCK; i++ {
+		diffs[testAccount(byte(i))] = big.NewInt(int64(i))
+	}
+	block := mainBlock(c, 300)
+	block.Info().Amount = 1024 << 32
+	entries, err := a.Pay(block, 300, diffs, nil, testAccount(1))
+	assert.Equal(t, err, nil)
+	// 22 miners and the fund in 3 payment blocks
+	assert.Equal(t, len(chain.txs), 3)
+	var total common.Amount
+	for _, e := range entries {
+		total += e.Amount
+	}
+	assert.Equal(t, total, common.Amount(1024<<32))
+}
+
+func TestAwardPayRetry(t *testing.T) {
+	c := awardConfig(t)
+	chain := &fakeAwardChain{main: make(map[uint64]*core.Block), fails: 1}
+	key, _ := secp256k1.GeneratePrivateKey()
+	ledger := newTestLedger(t, c)
+	a, _ := NewAwardManager(c, chain, ledger, key)
+
+	diffs := make(map[string]*big.Int)
+	for i := 1; i <= 2*MAX_PAYMENTS_PER_BLOCK; i++ {
+		diffs[testAccount(byte(i))] = big.NewInt(int64(i))
+	}
+	block := mainBlock(c, 300)
+	block.Info().Amount = 1024 << 32
+
+	// the second payment block is rejected, the first one is sent
+	_, err := a.Pay(block, 300, diffs, nil, testAccount(1))
+	assert.Matches(t, err.Error(), "payment block rejected")
+	assert.Equal(t, len(chain.txs), 1)
+	assert.Equal(t, ledger.IsPaid(300, block.GetHashLow()), false)
+	chunks := ledger.Chunks(300, block.GetHashLow())
+	assert.Equal(t, len(chunks), 2)
+	assert.Equal(t, chunks[0].Tx, chain.txs[0].GetHashLow())
+
+	// a retry after a restart sends the saved blocks and pays the rest once
+	chain.fails = 0
+	a, _ = NewAwardManager(c, chain, ledger, key)
+	a.now = func() uint64 { return 0x2000 << 16 }
+	entries, err := a.Pay(block, 300, diffs, nil, testAccount(1))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(chain.txs), 3)
+	assert.Equal(t, chain.txs[1].GetHashLow(), chunks[1].Tx)
+	var total common.Amount
+	for _, tx := range chain.txs {
+		for _, o := range core.NewBlockFromXdag(core.NewXdagBlock(tx.ToBytes())).Outputs {
+			total += common.Amount(o.Amount)
+		}
+	}
+	for _, e := range entries {
+		if e.Kind == PAYOUT_FEE {
+			total += e.Amount
+		}
+	}
+	assert.Equal(t, total, common.Amount(1024<<32))
+	assert.Equal(t, len(ledger.Chunks(300, block.GetHashLow())), 0)
+	assert.Equal(t, ledger.IsPaid(300, block.GetHashLow()), true)
+}
+
+func TestAwardEpochSchedule(t *testing.T) {
+	c := awardConfig(t)
+	c.SetWaitEpoch(20)
+	c.SetAwardEpoch(7)
+	chain := &fakeAwardChain{main: make(map[uint64]*core.Block)}
+	key, _ := secp256k1.GeneratePrivateKey()
+	ledger := newTestLedger(t, c)
+	a, _ := NewAwardManager(c, chain, ledger, key)
+
+	a.AddShare(Share{Account: testAccount(1), TaskIndex: 1, Difficulty: big.NewInt(1000), ActualDifficulty: big.NewInt(1000)})
+	block := mainBlock(c, 100)
+	block.Info().Amount = 64 << 32
+	a.OnBlockMined(block, 1)
+	lost := mainBlock(c, 101)
+	a.OnBlockMined(lost, 2)
+	chain.main[100] = block
+	a.OnNewMainBlock(100)
+
+	// epoch 0x1064 + 20 waited epochs, rounded up to a multiple of 8
+	pending := a.Pending()
+	assert.Equal(t, len(pending), 2)
+	assert.Equal(t, pending[0].Height, uint64(100))
+	assert.Equal(t, a.DueEpoch(&pending[0]), uint64(0x1078))
+	chain.grow(c, 101, 110, a)
+
+	// restart in the middle of the wait
+	a, _ = NewAwardManager(c, chain, ledger, key)
+	assert.Equal(t, len(a.Pending()), 2)
+	chain.grow(c, 111, 119, a)
+	assert.Equal(t, len(chain.txs), 0)
+	chain.grow(c, 120, 120, a)
+	assert.Equal(t, len(chain.txs), 1)
+	assert.Equal(t, ledger.IsPaid(100, block.GetHashLow()), true)
+	assert.Equal(t, len(a.Pending()), 1)
+
+	// the block that never won its epoch is dropped at last
+	chain.grow(c, 121, 0x80+uint64(common.CONFIRMATIONS_COUNT), a)
+	assert.Equal(t, len(a.Pending()), 1)
+	chain.grow(c, 0x81+uint64(common.CONFIRMATIONS_COUNT), 0x81+uint64(common.CONFIRMATIONS_COUNT), a)
+	assert.Equal(t, len(a.Pending()), 0)
+	assert.Equal(t, len(ledger.Awards()), 0)
+	assert.Equal(t, len(chain.txs), 1)
+}
@@ -0,0 +1,27 @@
+package consensus
+
+import (
+	"math/big"
+	"xdago/common"
+)
+
+var maxDifficulty = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+// HashDifficulty 由哈希计算难度，与xdag一致：取哈希高128位右移32位后除最大值
+func HashDifficulty(hash common.Hash) *big.Int {
+	var be [16]byte
+	for i := 0; i < 16; i++ {
+		be[i] = hash[31-i]
+	}
+	res := new(big.Int).SetBytes(be[:])
+	res.Rsh(res, 32)
+	if res.Sign() == 0 {
+		return new(big.Int).Set(maxDifficulty)
+	}
+	return res.Div(maxDifficulty, res)
+}
+
+// MeetsDifficulty reports whether hash reaches difficulty target
+func MeetsDifficulty(hash common.Hash, target *big.Int) bool {
+	return HashDifficulty(hash).Cmp(target) >= 0
+}
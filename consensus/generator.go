@@ -0,0 +1,99 @@
+package consensus
+
+import (
+	"errors"
+	"sync"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/log"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+const MAX_GENERATE_BLOCKS = 1000 // main blocks of one generate call
+
+var (
+	ErrNotRegtest       = errors.New("blocks are generated on the regtest network only")
+	ErrGenerateCount    = errors.New("count of blocks must be 1 to 1000")
+	ErrGenerateRejected = errors.New("generated block rejected")
+)
+
+// GeneratorChain is the part of core.IBlockchain used to generate blocks
+type GeneratorChain interface {
+	TryToConnect(block *core.Block) core.ImportResult
+	CheckNewMain()
+	GetXDAGTopStatus() *core.XDAGTopStatus
+}
+
+// Generator makes main blocks at once on the regtest network. The blocks of
+// one call are in consecutive epochs from the current one, each linking the
+// previous main block, and their reward goes to the address of key. The
+// clock of the node then moves past the last epoch, see utils.AdvanceClock,
+// so the time checks of the chain take the blocks as past main blocks. The
+// regtest blocks have the fixed difficulty of config.BlockDifficulty, see
+// SeedManager.BlockDifficulty, so the nonce is not searched.
+type Generator struct {
+	sync.Mutex
+	config  *config.Config
+	chain   GeneratorChain
+	key     *secp256k1.PrivateKey
+	last    uint64 // epoch of the last generated block
+	now     func() uint64
+	advance func(uint64) // moves the clock of the node
+}
+
+func NewGenerator(config *config.Config, chain GeneratorChain, key *secp256k1.PrivateKey) (*Generator, error) {
+	if !config.IsRegtest() {
+		return nil, ErrNotRegtest
+	}
+	return &Generator{
+		config:  config,
+		chain:   chain,
+		key:     key,
+		now:     utils.GetCurrentTimestamp,
+		advance: utils.AdvanceClock,
+	}, nil
+}
+
+// Generate makes count main blocks and returns their hashlow
+func (g *Generator) Generate(count int) ([]common.Hash, error) {
+	if count < 1 || count > MAX_GENERATE_BLOCKS {
+		return nil, ErrGenerateCount
+	}
+	g.Lock()
+	defer g.Unlock()
+
+	var prev common.Hash
+	if top := g.chain.GetXDAGTopStatus(); top != nil && len(top.Top) == common.XDAG_HASH_SIZE {
+		copy(prev[:], top.Top)
+	}
+	epoch := utils.GetEpoch(g.now())
+	if epoch <= g.last {
+		epoch = g.last + 1
+	}
+	res := make([]common.Hash, 0, count)
+	for i := 0; i < count; i++ {
+		var links []core.Address
+		if prev != common.EmptyHash {
+			links = append(links, core.AddressFromAmount(prev, common.XDAG_FIELD_OUT, 0))
+		}
+		// a main block has the time of the end of its epoch
+		timestamp := utils.GetEndOfEpoch(epoch << 16)
+		block := core.NewBlock(g.config, timestamp, links, nil, true, []*secp256k1.PublicKey{g.key.PubKey()}, g.config.PoolTag(), 0)
+		block.SignOut(g.key)
+		if r := g.chain.TryToConnect(block); !r.IsNormal() {
+			log.Warn("generated block rejected", log.Ctx{"status": r.Status, "err": r.ErrorInfo})
+			return res, ErrGenerateRejected
+		}
+		// the epoch of the block is over before the chain looks for the main block
+		g.advance((epoch + 1) << 16)
+		g.chain.CheckNewMain()
+		g.last = epoch
+		prev = block.GetHashLow()
+		res = append(res, prev)
+		epoch++
+	}
+	log.Info("generated main blocks", log.Ctx{"count": count, "epoch": g.last})
+	return res, nil
+}
@@ -0,0 +1,103 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package consensus
+
+import (
+	"github.com/magiconair/properties/assert"
+	"testing"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+type fakeGeneratorChain struct {
+	top    common.Hash
+	blocks []*core.Block
+	checks int
+	reject bool
+}
+
+func (c *fakeGeneratorChain) TryToConnect(block *core.Block) core.ImportResult {
+	if c.reject {
+		return core.ImportResult{Status: common.INVALID_BLOCK, ErrorInfo: "rejected"}
+	}
+	c.blocks = append(c.blocks, block)
+	return core.ImportResult{Status: common.IMPORTED_BEST, HashLow: block.GetHashLow()}
+}
+
+func (c *fakeGeneratorChain) CheckNewMain() {
+	c.checks++
+}
+
+func (c *fakeGeneratorChain) GetXDAGTopStatus() *core.XDAGTopStatus {
+	top := core.NewXDAGTopStatus()
+	top.Top = c.top[:]
+	return &top
+}
+
+func regtestConfig() *config.Config {
+	c := &config.Config{}
+	c.SetNetwork(common.REGTEST)
+	c.SetXdagFieldHeader(common.XDAG_FIELD_HEAD_TEST)
+	c.SetPoolTag("regtest")
+	return c
+}
+
+func TestGenerator(t *testing.T) {
+	key, _ := secp256k1.GeneratePrivateKey()
+	chain := &fakeGeneratorChain{}
+	chain.top[8] = 7
+
+	_, err := NewGenerator(&config.Config{}, chain, key)
+	assert.Equal(t, err, ErrNotRegtest)
+	g, err := NewGenerator(regtestConfig(), chain, key)
+	assert.Equal(t, err, nil)
+	start := uint64(0x16b3a7f1234)
+	now := start
+	g.now = func() uint64 { return now }
+	// the chain looks for the main block once the clock is past its epoch
+	g.advance = func(ts uint64) {
+		assert.Equal(t, ts, chain.blocks[len(chain.blocks)-1].GetTimestamp()+1)
+		assert.Equal(t, chain.checks, len(chain.blocks)-1)
+		now = ts
+	}
+
+	_, err = g.Generate(0)
+	assert.Equal(t, err, ErrGenerateCount)
+	_, err = g.Generate(MAX_GENERATE_BLOCKS + 1)
+	assert.Equal(t, err, ErrGenerateCount)
+
+	hashes, err := g.Generate(3)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(hashes), 3)
+	assert.Equal(t, chain.checks, 3)
+	prev := chain.top
+	for i, b := range chain.blocks {
+		assert.Equal(t, b.GetHashLow(), hashes[i])
+		assert.Equal(t, b.GetTimestamp(), utils.GetEndOfEpoch(start)+uint64(i)<<16)
+		bj, err := core.DecodeBlock(b.ToBytes())
+		assert.Equal(t, err, nil)
+		assert.Equal(t, bj.Fields[1].Type, "out")
+		assert.Equal(t, bj.Fields[1].Hash, utils.Hash2String(prev))
+		assert.Equal(t, bj.Fields[2].Remark, "regtest")
+		assert.Equal(t, bj.Signatures[0].Verified, true)
+		prev = b.GetHashLow()
+	}
+
+	// the next call goes on after the last epoch, from the new top
+	chain.top = hashes[2]
+	hashes, _ = g.Generate(1)
+	assert.Equal(t, chain.blocks[3].GetTimestamp(), utils.GetEndOfEpoch(start)+3<<16)
+	bj, _ := core.DecodeBlock(chain.blocks[3].ToBytes())
+	assert.Equal(t, bj.Fields[1].Hash, utils.Hash2String(chain.blocks[2].GetHashLow()))
+
+	chain.reject = true
+	hashes, err = g.Generate(2)
+	assert.Equal(t, err, ErrGenerateRejected)
+	assert.Equal(t, len(hashes), 0)
+}
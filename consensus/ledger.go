@@ -0,0 +1,195 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"sync"
+	"xdago/common"
+	"xdago/db"
+	"xdago/log"
+	"xdago/utils"
+)
+
+type PayoutKind byte
+
+const (
+	PAYOUT_FEE    PayoutKind = iota // pool fee, kept in the mined block
+	PAYOUT_FUND                     // community fund
+	PAYOUT_FINDER                   // reward of the miner who found the block
+	PAYOUT_DIRECT                   // direct payment to the miners of the round
+	PAYOUT_MINER                    // the rest shared by the miners
+)
+
+func (k PayoutKind) String() string {
+	switch k {
+	case PAYOUT_FEE:
+		return "fee"
+	case PAYOUT_FUND:
+		return "fund"
+	case PAYOUT_FINDER:
+		return "finder"
+	case PAYOUT_DIRECT:
+		return "direct"
+	case PAYOUT_MINER:
+		return "miner"
+	}
+	return "unknown"
+}
+
+// LedgerEntry is one part of the reward of a mined main block
+type LedgerEntry struct {
+	Block   common.Hash // hashlow of the mined block
+	Height  uint64
+	Kind    PayoutKind
+	Account string
+	Amount  common.Amount
+	Tx      common.Hash // hashlow of the payment block, empty when kept in the block
+	Time    uint64
+}
+
+// Ledger keeps the payout entries in the pool database, the key is
+// POOL_PAYOUT + height + block hashlow + index
+type Ledger struct {
+	sync.Mutex
+	source db.IKVSource
+}
+
+func NewLedger(source db.IKVSource) *Ledger {
+	return &Ledger{source: source}
+}
+
+func payoutKey(height uint64, block common.Hash, index int) []byte {
+	var h [8]byte
+	var i [4]byte
+	binary.BigEndian.PutUint64(h[:], height)
+	binary.BigEndian.PutUint32(i[:], uint32(index))
+	return utils.MergeBytes([]byte{common.POOL_PAYOUT}, h[:], block[:], i[:])
+}
+
+// Add writes the entries of one mined block
+func (l *Ledger) Add(entries []LedgerEntry) {
+	l.Lock()
+	defer l.Unlock()
+	for i, e := range entries {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+			log.Crit("encode ledger entry error", log.Ctx{"err": err.Error()})
+		}
+		l.source.Put(payoutKey(e.Height, e.Block, i), buf.Bytes())
+	}
+}
+
+func (l *Ledger) fetch(prefix []byte) []LedgerEntry {
+	var entries []LedgerEntry
+	l.source.FetchRange(prefix, utils.KeyPrefixEnd(prefix), func(k, v []byte) bool {
+		var e LedgerEntry
+		if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&e); err != nil {
+			log.Error("decode ledger entry error", log.Ctx{"key": k, "err": err.Error()})
+			return false
+		}
+		entries = append(entries, e)
+		return false
+	})
+	return entries
+}
+
+// Entries returns all the entries ordered by height
+func (l *Ledger) Entries() []LedgerEntry {
+	return l.fetch([]byte{common.POOL_PAYOUT})
+}
+
+// EntriesAt returns the entries of the mined blocks at height
+func (l *Ledger) EntriesAt(height uint64) []LedgerEntry {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], height)
+	return l.fetch(utils.MergeBytes([]byte{common.POOL_PAYOUT}, h[:]))
+}
+
+// IsPaid reports whether the reward of block at height was distributed
+func (l *Ledger) IsPaid(height uint64, block common.Hash) bool {
+	return len(l.fetch(payoutKey(height, block, 0)[:1+8+common.XDAG_HASH_SIZE])) > 0
+}
+
+// PaymentChunk is a payment block of the reward of a mined block, it is saved
+// before the block is sent so that a retry sends the same block again
+type PaymentChunk struct {
+	Block  common.Hash // hashlow of the mined block
+	Height uint64
+	Index  int
+	To     []common.Hash // the paid addresses
+	Tx     common.Hash   // hashlow of the payment block
+	Raw    []byte
+}
+
+func paymentKey(height uint64, block common.Hash, index int) []byte {
+	key := payoutKey(height, block, index)
+	key[0] = common.POOL_PAYMENT
+	return key
+}
+
+// SaveChunk writes a payment block before it is sent
+func (l *Ledger) SaveChunk(c *PaymentChunk) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		log.Crit("encode payment chunk error", log.Ctx{"err": err.Error()})
+	}
+	l.source.Put(paymentKey(c.Height, c.Block, c.Index), buf.Bytes())
+}
+
+// Chunks returns the saved payment blocks of the mined block by index
+func (l *Ledger) Chunks(height uint64, block common.Hash) map[int]*PaymentChunk {
+	chunks := make(map[int]*PaymentChunk)
+	prefix := paymentKey(height, block, 0)[:1+8+common.XDAG_HASH_SIZE]
+	l.source.FetchRange(prefix, utils.KeyPrefixEnd(prefix), func(k, v []byte) bool {
+		var c PaymentChunk
+		if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&c); err != nil {
+			log.Error("decode payment chunk error", log.Ctx{"key": k, "err": err.Error()})
+			return false
+		}
+		chunks[c.Index] = &c
+		return false
+	})
+	return chunks
+}
+
+// DeleteChunks removes the payment blocks of a mined block written to the
+// ledger
+func (l *Ledger) DeleteChunks(height uint64, block common.Hash) {
+	for index := range l.Chunks(height, block) {
+		l.source.Delete(paymentKey(height, block, index))
+	}
+}
+
+func awardKey(hash common.Hash) []byte {
+	return utils.MergeBytes([]byte{common.POOL_AWARD}, hash[:])
+}
+
+// SaveAward writes a mined block waiting for its payout
+func (l *Ledger) SaveAward(r *AwardRecord) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		log.Crit("encode award record error", log.Ctx{"err": err.Error()})
+	}
+	l.source.Put(awardKey(r.Hash), buf.Bytes())
+}
+
+func (l *Ledger) DeleteAward(hash common.Hash) {
+	l.source.Delete(awardKey(hash))
+}
+
+// Awards returns the mined blocks waiting for their payout
+func (l *Ledger) Awards() []*AwardRecord {
+	var records []*AwardRecord
+	prefix := []byte{common.POOL_AWARD}
+	l.source.FetchRange(prefix, utils.KeyPrefixEnd(prefix), func(k, v []byte) bool {
+		var r AwardRecord
+		if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&r); err != nil {
+			log.Error("decode award record error", log.Ctx{"key": k, "err": err.Error()})
+			return false
+		}
+		records = append(records, &r)
+		return false
+	})
+	return records
+}
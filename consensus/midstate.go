@@ -0,0 +1,77 @@
+package consensus
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"math/big"
+	"xdago/common"
+)
+
+const (
+	midstateSize = (common.XDAG_BLOCK_FIELDS - 2) * common.XDAG_FIELD_SIZE // the first 14 fields
+	// sha256 marshaled state: magic, 8 words, 64 bytes buffer, length
+	shaMagic     = "sha\x03"
+	shaStateSize = len(shaMagic) + 32 + sha256.BlockSize + 8
+)
+
+// ShareVerifier finishes the double SHA-256 of a candidate block from the
+// state after its first 14 fields, hashing a share costs three SHA-256
+// compressions instead of ten.
+type ShareVerifier struct {
+	midstate  common.Field
+	lastField common.Field
+	state     [shaStateSize]byte
+}
+
+// NewShareVerifier creates a verifier from the midstate given to miners, as
+// the 8 little endian words of xdag_hash_get_state, and the 15th field
+func NewShareVerifier(midstate, lastField common.Field) *ShareVerifier {
+	v := &ShareVerifier{
+		midstate:  midstate,
+		lastField: lastField,
+	}
+	copy(v.state[:], shaMagic)
+	for i := 0; i < 8; i++ {
+		binary.BigEndian.PutUint32(v.state[len(shaMagic)+i*4:], binary.LittleEndian.Uint32(midstate[i*4:]))
+	}
+	binary.BigEndian.PutUint64(v.state[shaStateSize-8:], midstateSize)
+	return v
+}
+
+// NewShareVerifierFromBlock computes the midstate of a candidate block
+func NewShareVerifierFromBlock(block common.RawBlock) *ShareVerifier {
+	h := sha256.New()
+	h.Write(block[:midstateSize])
+	state, _ := h.(encoding.BinaryMarshaler).MarshalBinary()
+	var midstate, lastField common.Field
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(midstate[i*4:], binary.BigEndian.Uint32(state[len(shaMagic)+i*4:]))
+	}
+	copy(lastField[:], block[midstateSize:])
+	return NewShareVerifier(midstate, lastField)
+}
+
+func (v *ShareVerifier) Midstate() common.Field {
+	return v.midstate
+}
+
+func (v *ShareVerifier) LastField() common.Field {
+	return v.lastField
+}
+
+// Hash returns the block hash with nonce as the last field
+func (v *ShareVerifier) Hash(nonce common.Field) common.Hash {
+	h := sha256.New()
+	h.(encoding.BinaryUnmarshaler).UnmarshalBinary(v.state[:])
+	h.Write(v.lastField[:])
+	h.Write(nonce[:])
+	var first common.Hash
+	h.Sum(first[:0])
+	return sha256.Sum256(first[:])
+}
+
+// Difficulty returns the difficulty of the block hash with nonce
+func (v *ShareVerifier) Difficulty(nonce common.Field) *big.Int {
+	return HashDifficulty(v.Hash(nonce))
+}
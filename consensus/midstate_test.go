@@ -0,0 +1,98 @@
+package consensus
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/magiconair/properties/assert"
+	"math/rand"
+	"testing"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/crypto"
+	"xdago/secp256k1"
+)
+
+func fullHash(block common.RawBlock, nonce common.Field) common.Hash {
+	copy(block[midstateSize:][common.XDAG_FIELD_SIZE:], nonce[:])
+	return crypto.HashTwice(block[:])
+}
+
+func TestShareVerifierVectors(t *testing.T) {
+	var block common.RawBlock
+	var nonce common.Field
+	h := NewShareVerifierFromBlock(block).Hash(nonce)
+	assert.Equal(t, hex.EncodeToString(h[:]), "0c35a1d4c8835b3a53f503a6bbe33dc219794ddceda6e6846bc3ff760ff43b9f")
+
+	for i := range block {
+		block[i] = byte(i)
+	}
+	binary.LittleEndian.PutUint64(nonce[24:], 42)
+	h = NewShareVerifierFromBlock(block).Hash(nonce)
+	assert.Equal(t, hex.EncodeToString(h[:]), "da3ac53b191bf5e018dd471c45576eec9e7218c9919fd9e4f03c5c499fa83afa")
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 32; i++ {
+		var block common.RawBlock
+		r.Read(block[:])
+		v := NewShareVerifierFromBlock(block)
+		for j := 0; j < 4; j++ {
+			r.Read(nonce[:])
+			assert.Equal(t, v.Hash(nonce), fullHash(block, nonce))
+		}
+	}
+}
+
+// a verifier built from the midstate sent to miners gives the same hash
+func TestShareVerifierFromMidstate(t *testing.T) {
+	var block common.RawBlock
+	for i := range block {
+		block[i] = byte(i * 13)
+	}
+	v := NewShareVerifierFromBlock(block)
+	w := NewShareVerifier(v.Midstate(), v.LastField())
+	var nonce common.Field
+	binary.LittleEndian.PutUint64(nonce[24:], 42)
+	assert.Equal(t, w.Hash(nonce), fullHash(block, nonce))
+	assert.Equal(t, w.Difficulty(nonce), HashDifficulty(fullHash(block, nonce)))
+}
+
+func TestTaskFromBlock(t *testing.T) {
+	c := &config.Config{}
+	c.SetXdagFieldHeader(common.XDAG_FIELD_HEAD_TEST)
+	block := core.NewBlock(c, 0x16900000000, nil, nil, true, nil, "", -1)
+	key, _ := secp256k1.GeneratePrivateKey()
+	block.SignOut(key)
+	block.Nonce[31] = 7
+
+	task := NewTaskFromBlock(block, 3)
+	assert.Equal(t, task.TaskIndex(), uint64(3))
+	assert.Equal(t, task.TaskTime(), block.GetTimestamp())
+	assert.Equal(t, task.Task().Data, block.Nonce)
+	assert.Equal(t, task.ShareHash(block.Nonce), block.RecalcHash())
+
+	var nonce common.Field
+	nonce[0] = 1
+	block.Nonce = nonce
+	assert.Equal(t, task.ShareHash(nonce), block.RecalcHash())
+}
+
+func BenchmarkShareVerifier(b *testing.B) {
+	var block common.RawBlock
+	v := NewShareVerifierFromBlock(block)
+	var nonce common.Field
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(nonce[24:], uint64(i))
+		v.Hash(nonce)
+	}
+}
+
+func BenchmarkFullHash(b *testing.B) {
+	var block common.RawBlock
+	var nonce common.Field
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(nonce[24:], uint64(i))
+		fullHash(block, nonce)
+	}
+}
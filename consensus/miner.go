@@ -0,0 +1,258 @@
+package consensus
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/log"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+const (
+	MINER_SUBMIT_INTERVAL = 4 * time.Second // improving solutions are submitted at most this often
+	MINER_SUBMIT_MARGIN   = time.Second     // the last solution is submitted this long before the epoch ends
+	minerBatch            = 256             // nonces hashed between checks for a stop
+)
+
+// MinerChain is the part of core.IBlockchain the miner depends on
+type MinerChain interface {
+	CreateNewBlock(pairs map[core.Address]*secp256k1.PrivateKey, to []core.Address, mining bool, remark string) *core.Block
+	TryToConnect(block *core.Block) core.ImportResult
+}
+
+type solution struct {
+	nonce common.Field
+	hash  common.Hash
+}
+
+// Miner is the built-in cpu solo miner, it mines the main block candidate of
+// every epoch with config.MinerThreads goroutines and submits improving
+// solutions to the chain
+type Miner struct {
+	sync.Mutex
+	config  *config.Config
+	chain   MinerChain
+	seeds   *SeedManager // nil mines with SHA-256 only
+	threads int
+	handler func(block *core.Block)
+
+	task      *Task
+	best      solution
+	submitted common.Hash // best hash sent to the chain for the task
+	hashes    uint64
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewMiner(config *config.Config, chain MinerChain, seeds *SeedManager) *Miner {
+	return &Miner{
+		config:  config,
+		chain:   chain,
+		seeds:   seeds,
+		threads: config.MinerThreads(),
+		quit:    make(chan struct{}),
+	}
+}
+
+// SetBlockHandler sets the handler called for every mined block accepted by
+// the chain, e.g. to broadcast it
+func (m *Miner) SetBlockHandler(handler func(block *core.Block)) {
+	m.handler = handler
+}
+
+// Start runs the miner, it does nothing when no thread is configured
+func (m *Miner) Start() {
+	if m.threads <= 0 {
+		return
+	}
+	log.Info("cpu miner started", log.Ctx{"threads": m.threads})
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for {
+			task := m.newTask()
+			end := time.UnixMilli(int64(utils.XdagTimestamp2Ms(utils.GetEndOfEpoch(task.TaskTime()))))
+			timeout := time.Until(end.Add(-MINER_SUBMIT_MARGIN))
+			if timeout <= 0 {
+				// too late for this epoch, wait for the next one
+				select {
+				case <-time.After(time.Until(end)):
+					continue
+				case <-m.quit:
+					return
+				}
+			}
+			if !m.mine(task, timeout) {
+				return
+			}
+		}
+	}()
+}
+
+func (m *Miner) Stop() {
+	close(m.quit)
+	m.wg.Wait()
+	log.Info("cpu miner stopped")
+}
+
+// newTask creates the task of the candidate main block of the current epoch
+func (m *Miner) newTask() *Task {
+	block := m.chain.CreateNewBlock(nil, nil, true, m.config.PoolTag())
+	var raw common.RawBlock
+	copy(raw[:], block.ToBytes())
+	if m.seeds != nil {
+		return m.seeds.NewTask(raw, block.GetTimestamp(), 0)
+	}
+	return NewTask(raw, block.GetTimestamp(), 0)
+}
+
+// mine searches nonces of task until timeout and submits the improving
+// solutions, it returns false when the miner is stopped
+func (m *Miner) mine(task *Task, timeout time.Duration) bool {
+	m.Lock()
+	m.task = task
+	m.best = solution{hash: maxHash}
+	m.submitted = maxHash
+	m.Unlock()
+
+	stop := make(chan struct{})
+	found := make(chan solution, m.threads)
+	var wg sync.WaitGroup
+	start := rand.Uint64()
+	for i := 0; i < m.threads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.work(task, start+uint64(i), uint64(m.threads), stop, found)
+		}(i)
+	}
+
+	timer := time.NewTimer(timeout)
+	ticker := time.NewTicker(MINER_SUBMIT_INTERVAL)
+	defer timer.Stop()
+	defer ticker.Stop()
+	running := true
+	for running {
+		select {
+		case s := <-found:
+			m.Lock()
+			if lessHash(s.hash, m.best.hash) {
+				m.best = s
+			}
+			m.Unlock()
+		case <-ticker.C:
+			m.submit(task)
+		case <-timer.C:
+			running = false
+		case <-m.quit:
+			close(stop)
+			wg.Wait()
+			return false
+		}
+	}
+	close(stop)
+	wg.Wait()
+	// drain the solutions found while stopping
+	for {
+		select {
+		case s := <-found:
+			m.Lock()
+			if lessHash(s.hash, m.best.hash) {
+				m.best = s
+			}
+			m.Unlock()
+		default:
+			m.submit(task)
+			return true
+		}
+	}
+}
+
+// work hashes the nonces start, start+step, ... and reports improvements
+func (m *Miner) work(task *Task, start, step uint64, stop chan struct{}, found chan solution) {
+	nonce := task.Task().Data
+	best := maxHash
+	n := start
+	for {
+		for i := 0; i < minerBatch; i++ {
+			binary.LittleEndian.PutUint64(nonce[24:], n)
+			hash := task.ShareHash(nonce)
+			if lessHash(hash, best) {
+				best = hash
+				select {
+				case found <- solution{nonce: nonce, hash: hash}:
+				case <-stop:
+					return
+				}
+			}
+			n += step
+		}
+		atomic.AddUint64(&m.hashes, minerBatch)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// submit sends the best solution of task to the chain if it improved
+func (m *Miner) submit(task *Task) {
+	m.Lock()
+	best := m.best
+	if !lessHash(best.hash, m.submitted) {
+		m.Unlock()
+		return
+	}
+	m.submitted = best.hash
+	m.Unlock()
+
+	raw := task.Block()
+	copy(raw[(common.XDAG_BLOCK_FIELDS-1)*common.XDAG_FIELD_SIZE:], best.nonce[:])
+	block := core.NewBlockFromXdag(core.NewXdagBlock(raw[:]))
+	res := m.chain.TryToConnect(block)
+	if !res.IsNormal() {
+		log.Warn("mined block rejected", log.Ctx{"status": res.Status, "err": res.ErrorInfo})
+		return
+	}
+	log.Info("mined block", log.Ctx{"hash": utils.Hash2String(best.hash), "status": res.Status})
+	if m.handler != nil {
+		m.handler(block)
+	}
+}
+
+// Task returns the task being mined
+func (m *Miner) Task() *Task {
+	m.Lock()
+	defer m.Unlock()
+	return m.task
+}
+
+// Best returns the best hash and nonce found for the current task
+func (m *Miner) Best() (common.Hash, common.Field) {
+	m.Lock()
+	defer m.Unlock()
+	return m.best.hash, m.best.nonce
+}
+
+// Hashes returns the number of nonces hashed since the start
+func (m *Miner) Hashes() uint64 {
+	return atomic.LoadUint64(&m.hashes)
+}
+
+// lessHash reports whether a is a better pow hash than b, hashes are little
+// endian numbers and the smaller has the higher difficulty
+func lessHash(a, b common.Hash) bool {
+	for i := len(a) - 1; i >= 0; i-- {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
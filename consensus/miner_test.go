@@ -0,0 +1,111 @@
+package consensus
+
+import (
+	"github.com/magiconair/properties/assert"
+	"sync"
+	"testing"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+type fakeMinerChain struct {
+	sync.Mutex
+	config *config.Config
+	height uint64
+	mined  []*core.Block
+}
+
+func (c *fakeMinerChain) CreateNewBlock(pairs map[core.Address]*secp256k1.PrivateKey, to []core.Address,
+	mining bool, remark string) *core.Block {
+	if c.height == 0 {
+		// a candidate of the current epoch
+		return core.NewBlock(c.config, utils.GetCurrentTimestamp(), nil, nil, true, nil, remark, -1)
+	}
+	return mainBlock(c.config, c.height)
+}
+
+func (c *fakeMinerChain) TryToConnect(block *core.Block) core.ImportResult {
+	c.Lock()
+	defer c.Unlock()
+	c.mined = append(c.mined, block)
+	return core.ImportResult{Status: common.IMPORTED_BEST, HashLow: block.GetHashLow()}
+}
+
+func (c *fakeMinerChain) blocks() []*core.Block {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*core.Block{}, c.mined...)
+}
+
+func TestMinerSha256(t *testing.T) {
+	c := seedConfig()
+	c.SetMinerThreads(2)
+	chain := &fakeMinerChain{config: c, height: 10}
+	m := NewMiner(c, chain, nil)
+	var handled []*core.Block
+	m.SetBlockHandler(func(block *core.Block) {
+		handled = append(handled, block)
+	})
+
+	task := m.newTask()
+	assert.Equal(t, task.IsRandomx(), false)
+	assert.Equal(t, m.mine(task, 200*time.Millisecond), true)
+	assert.Equal(t, m.Hashes() > 0, true)
+
+	// the last submitted block carries the best nonce and hash
+	mined := chain.blocks()
+	assert.Equal(t, len(mined) > 0, true)
+	assert.Equal(t, len(handled), len(mined))
+	best, nonce := m.Best()
+	last := mined[len(mined)-1]
+	assert.Equal(t, last.GetHash(), best)
+	raw := last.GetXdagBlock().GetData()
+	assert.Equal(t, raw[midstateSize+common.XDAG_FIELD_SIZE:], nonce[:])
+	assert.Equal(t, last.GetTimestamp(), task.TaskTime())
+	assert.Equal(t, lessHash(best, maxHash), true)
+
+	// nothing better, nothing submitted
+	m.submit(task)
+	assert.Equal(t, len(chain.blocks()), len(mined))
+}
+
+func TestMinerRandomx(t *testing.T) {
+	c := seedConfig()
+	c.SetMinerThreads(2)
+	chain := &fakeMinerChain{config: c, height: 110}
+	seedChain := &fakeSeedChain{blocks: map[uint64]*core.Block{100: mainBlock(c, 100)}}
+	seeds, _ := NewSeedManager(c, seedChain, &fakeHasher{prepared: make(map[string]bool)})
+	seeds.OnNewMainBlock(100, seedChain.blocks[100])
+	m := NewMiner(c, chain, seeds)
+
+	task := m.newTask()
+	assert.Equal(t, task.IsRandomx(), true)
+	assert.Equal(t, m.mine(task, 200*time.Millisecond), true)
+	mined := chain.blocks()
+	assert.Equal(t, len(mined) > 0, true)
+	best, _ := m.Best()
+	diff, err := seeds.BlockDifficulty(mined[len(mined)-1])
+	assert.Equal(t, err, nil)
+	assert.Equal(t, diff, HashDifficulty(best))
+}
+
+func TestMinerStop(t *testing.T) {
+	c := seedConfig()
+	c.SetMinerThreads(1)
+	chain := &fakeMinerChain{config: c}
+	m := NewMiner(c, chain, nil)
+	m.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Hashes() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	m.Stop()
+	assert.Equal(t, m.Hashes() > 0, true)
+
+	c.ChangePara([]string{"-m", "4"})
+	assert.Equal(t, c.MinerThreads(), 4)
+}
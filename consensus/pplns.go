@@ -0,0 +1,170 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"math/big"
+	"sync"
+	"xdago/common"
+	"xdago/config"
+	"xdago/db"
+	"xdago/log"
+	"xdago/utils"
+)
+
+// PPLNS_FLUSH_SHARES is the number of shares the window writes in one batch
+const PPLNS_FLUSH_SHARES = 64
+
+// windowShare is a share of the pplns window
+type windowShare struct {
+	Account    string
+	Difficulty *big.Int
+}
+
+// ShareWindow keeps the last accepted shares for the pplns payout mode. The
+// shares are saved in the pool database next to the ledger, the key is
+// PPLNS_SHARE + sequence number, so the window goes on across rounds and
+// restarts. The shares are written in batches of PPLNS_FLUSH_SHARES, a crash
+// loses the shares of the last unwritten batch.
+type ShareWindow struct {
+	sync.Mutex
+	source db.IKVSource
+	size   int
+	seq    uint64        // sequence number of the next share
+	shares []windowShare // ring of the last size shares
+	start  int           // index of the oldest share once the ring is full
+
+	flushMu sync.Mutex // keeps the batches in order
+	keys    [][]byte   // writes waiting for the next batch, a nil value deletes
+	values  [][]byte
+	pending int // shares waiting for the next batch
+}
+
+// NewShareWindow returns the share window of the pplns mode, or nil when the
+// pool pays per round
+func NewShareWindow(config *config.Config, source db.IKVSource) *ShareWindow {
+	if config.PayoutMode() != common.PAYOUT_MODE_PPLNS {
+		return nil
+	}
+	size := config.PplnsWindow()
+	if size <= 0 {
+		size = common.PPLNS_WINDOW
+	}
+	w := &ShareWindow{
+		source: source,
+		size:   size,
+		shares: make([]windowShare, 0, size),
+	}
+	w.load()
+	return w
+}
+
+func windowKey(seq uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	return utils.MergeBytes([]byte{common.PPLNS_SHARE}, b[:])
+}
+
+// load reads the saved window and drops the shares that fell out of it
+func (w *ShareWindow) load() {
+	var keys [][]byte
+	var shares []windowShare
+	prefix := []byte{common.PPLNS_SHARE}
+	w.source.FetchRange(prefix, utils.KeyPrefixEnd(prefix), func(k, v []byte) bool {
+		if len(k) != 9 {
+			return false
+		}
+		var s windowShare
+		if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&s); err != nil {
+			log.Error("decode pplns share error", log.Ctx{"err": err.Error()})
+			return false
+		}
+		keys = append(keys, k)
+		shares = append(shares, s)
+		return false
+	})
+	if len(keys) == 0 {
+		return
+	}
+	for len(shares) > w.size {
+		w.source.Delete(keys[0])
+		keys, shares = keys[1:], shares[1:]
+	}
+	w.shares = append(w.shares, shares...)
+	w.seq = binary.BigEndian.Uint64(keys[len(keys)-1][1:]) + 1
+}
+
+// Add appends an accepted share, the oldest share leaves a full window
+func (w *ShareWindow) Add(share Share) {
+	s := windowShare{Account: share.Account, Difficulty: new(big.Int).Set(share.Difficulty)}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		log.Crit("encode pplns share error", log.Ctx{"err": err.Error()})
+	}
+
+	w.Lock()
+	w.keys = append(w.keys, windowKey(w.seq))
+	w.values = append(w.values, buf.Bytes())
+	if w.seq >= uint64(w.size) {
+		w.keys = append(w.keys, windowKey(w.seq-uint64(w.size)))
+		w.values = append(w.values, nil)
+	}
+	w.seq++
+	w.pending++
+	if len(w.shares) < w.size {
+		w.shares = append(w.shares, s)
+	} else {
+		w.shares[w.start] = s
+		w.start = (w.start + 1) % w.size
+	}
+	full := w.pending >= PPLNS_FLUSH_SHARES
+	w.Unlock()
+
+	if full {
+		w.Flush()
+	}
+}
+
+// Flush writes the shares added since the last batch
+func (w *ShareWindow) Flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.Lock()
+	keys, values := w.keys, w.values
+	w.keys, w.values, w.pending = nil, nil, 0
+	w.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+	if b, ok := w.source.(db.IKVBatcher); ok {
+		b.WriteBatch(keys, values)
+		return
+	}
+	for i, k := range keys {
+		w.source.Put(k, values[i])
+	}
+}
+
+// Len returns the number of shares in the window
+func (w *ShareWindow) Len() int {
+	w.Lock()
+	defer w.Unlock()
+	return len(w.shares)
+}
+
+// Weights returns the share difficulty of every account in the window
+func (w *ShareWindow) Weights() map[string]*big.Int {
+	w.Lock()
+	defer w.Unlock()
+	weights := make(map[string]*big.Int)
+	for _, s := range w.shares {
+		sum, ok := weights[s.Account]
+		if !ok {
+			sum = new(big.Int)
+			weights[s.Account] = sum
+		}
+		sum.Add(sum, s.Difficulty)
+	}
+	return weights
+}
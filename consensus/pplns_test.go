@@ -0,0 +1,150 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package consensus
+
+import (
+	"github.com/magiconair/properties/assert"
+	"math/big"
+	"testing"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/secp256k1"
+)
+
+func pplnsConfig(t *testing.T, window int) *config.Config {
+	c := awardConfig(t)
+	c.SetPayoutMode(common.PAYOUT_MODE_PPLNS)
+	c.SetPplnsWindow(window)
+	return c
+}
+
+func TestShareWindow(t *testing.T) {
+	c := pplnsConfig(t, 4)
+	source, kvFactory := newMinerSource(t, c)
+	w := NewShareWindow(c, source)
+	for i := 1; i <= 6; i++ {
+		w.Add(Share{Account: testAccount(byte(i % 2)), Difficulty: big.NewInt(int64(i))})
+	}
+	// shares 3..6 are left
+	assert.Equal(t, w.Len(), 4)
+	weights := w.Weights()
+	assert.Equal(t, weights[testAccount(0)], big.NewInt(4+6))
+	assert.Equal(t, weights[testAccount(1)], big.NewInt(3+5))
+
+	// a restart keeps the written window, a smaller window drops the oldest
+	w.Flush()
+	w = NewShareWindow(c, source)
+	assert.Equal(t, w.Weights(), weights)
+	c.SetPplnsWindow(2)
+	w = NewShareWindow(c, source)
+	assert.Equal(t, w.Len(), 2)
+	assert.Equal(t, w.Weights()[testAccount(1)], big.NewInt(5))
+	w.Add(Share{Account: testAccount(1), Difficulty: big.NewInt(7)})
+	assert.Equal(t, w.Weights()[testAccount(0)], big.NewInt(6))
+	assert.Equal(t, w.Weights()[testAccount(1)], big.NewInt(7))
+	kvFactory.Close()
+
+	c.SetPayoutMode(common.PAYOUT_MODE_PROP)
+	assert.Equal(t, NewShareWindow(c, nil) == nil, true)
+}
+
+func TestShareWindowBatch(t *testing.T) {
+	c := pplnsConfig(t, 100)
+	source, kvFactory := newMinerSource(t, c)
+	defer kvFactory.Close()
+	w := NewShareWindow(c, source)
+	saved := func() int {
+		return len(source.PrefixKeyLookup([]byte{common.PPLNS_SHARE}))
+	}
+	for i := 0; i < PPLNS_FLUSH_SHARES-1; i++ {
+		w.Add(Share{Account: testAccount(1), Difficulty: big.NewInt(1)})
+	}
+	assert.Equal(t, saved(), 0)
+	w.Add(Share{Account: testAccount(1), Difficulty: big.NewInt(1)})
+	assert.Equal(t, saved(), PPLNS_FLUSH_SHARES)
+
+	// the batches delete the shares leaving the window
+	for i := 0; i < PPLNS_FLUSH_SHARES; i++ {
+		w.Add(Share{Account: testAccount(2), Difficulty: big.NewInt(1)})
+	}
+	assert.Equal(t, saved(), 100)
+	w.Add(Share{Account: testAccount(2), Difficulty: big.NewInt(1)})
+	w.Flush()
+	assert.Equal(t, saved(), 100)
+	w = NewShareWindow(c, source)
+	assert.Equal(t, w.Weights()[testAccount(1)], big.NewInt(100-PPLNS_FLUSH_SHARES-1))
+}
+
+// pplnsRun feeds a share log to a new pool and pays two blocks
+func pplnsRun(t *testing.T, key *secp256k1.PrivateKey, shares []Share) []LedgerEntry {
+	c := pplnsConfig(t, 10)
+	chain := &fakeAwardChain{main: make(map[uint64]*core.Block)}
+	ledger := newTestLedger(t, c)
+	a, _ := NewAwardManager(c, chain, ledger, key)
+	a.now = func() uint64 { return 0x1000 << 16 }
+
+	for i, s := range shares {
+		a.AddShare(s)
+		if i == 7 || i == len(shares)-1 {
+			block := mainBlock(c, 100+s.TaskIndex)
+			block.Info().Amount = 1024 << 32
+			a.OnBlockMined(block, s.TaskIndex)
+			chain.main[100+s.TaskIndex] = block
+			a.OnNewMainBlock(100 + s.TaskIndex)
+		}
+	}
+	chain.grow(c, 100+shares[len(shares)-1].TaskIndex+1, 100+shares[len(shares)-1].TaskIndex+uint64(common.CONFIRMATIONS_COUNT), a)
+	return ledger.Entries()
+}
+
+func TestAwardPplns(t *testing.T) {
+	// 8 shares in round 1, 6 in round 2
+	var shares []Share
+	for i := 0; i < 14; i++ {
+		task := uint64(1)
+		if i >= 8 {
+			task = 2
+		}
+		shares = append(shares, Share{Account: testAccount(byte(i%3 + 1)), TaskIndex: task, Difficulty: big.NewInt(int64(1000 + i)),
+			ActualDifficulty: big.NewInt(int64(1000 + i))})
+	}
+	key, _ := secp256k1.GeneratePrivateKey()
+	entries := pplnsRun(t, key, shares)
+
+	// the same share log gives the same payouts, the test blocks differ
+	payouts := func(entries []LedgerEntry) []LedgerEntry {
+		res := make([]LedgerEntry, len(entries))
+		for i, e := range entries {
+			res[i] = LedgerEntry{Height: e.Height, Kind: e.Kind, Account: e.Account, Amount: e.Amount}
+			if e.Kind == PAYOUT_FEE {
+				res[i].Account = ""
+			}
+		}
+		return res
+	}
+	assert.Equal(t, payouts(pplnsRun(t, key, shares)), payouts(entries))
+
+	// the second block pays the last 10 shares, across the two rounds
+	window := make(map[string]*big.Int)
+	for _, s := range shares[4:] {
+		if window[s.Account] == nil {
+			window[s.Account] = new(big.Int)
+		}
+		window[s.Account].Add(window[s.Account], s.Difficulty)
+	}
+	var miners int
+	for _, e := range entries {
+		if e.Height != 102 || e.Kind != PAYOUT_MINER {
+			continue
+		}
+		miners++
+		part := new(big.Int).Mul(big.NewInt(1024<<32*75/100), window[e.Account])
+		part.Quo(part, big.NewInt(10085))
+		assert.Equal(t, new(big.Int).Sub(part, new(big.Int).SetUint64(uint64(e.Amount))).CmpAbs(big.NewInt(1<<20)) < 0, true)
+	}
+	assert.Equal(t, miners, 3)
+}
@@ -0,0 +1,317 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"sync"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/log"
+	"xdago/utils"
+)
+
+var (
+	ErrNotForked    = errors.New("randomx: block is before the fork")
+	ErrNoSeed       = errors.New("randomx: seed of the block is not kept")
+	ErrNoSeedBlock  = errors.New("randomx: seed block not found")
+	ErrSeedSchedule = errors.New("randomx: seed epoch lag must be less than seed epoch blocks")
+)
+
+// maxHash has the lowest difficulty, it rejects shares that could not be hashed
+var maxHash = func() (h common.Hash) {
+	for i := range h {
+		h[i] = 0xff
+	}
+	return
+}()
+
+// PowHasher computes RandomX hashes, randomx.Pool implements it
+type PowHasher interface {
+	// Prepare initializes the cache and dataset of a seed, it may take a while
+	Prepare(seed []byte) error
+	Hash(seed, data []byte) (common.Hash, error)
+	Release(seed []byte)
+}
+
+// SeedChain is the part of core.IBlockchain the seed manager depends on
+type SeedChain interface {
+	GetPreSeed() common.Hash
+	GetBlockByHeight(height uint64) *core.Block
+}
+
+type seedEpoch struct {
+	seed       []byte
+	height     uint64 // main chain height the seed epoch starts at
+	switchTime uint64 // first xdag epoch hashed with the seed
+	ready      chan struct{}
+	err        error
+}
+
+// SeedManager follows the main chain and decides the RandomX seed of every
+// block. A seed epoch starts every seedEpochBlocks main blocks from the fork
+// height, its seed is the pre-seed for the first epoch and the reversed hash
+// of the main block seedEpochLag blocks earlier for the next ones. The seed is
+// used seedEpochLag epochs after the block starting it, which leaves time to
+// build its dataset in the background.
+//
+// The node does not build a SeedManager yet, the block import has no
+// difficulty hook, so only the miner and the tasks given to it hash with
+// RandomX.
+type SeedManager struct {
+	sync.RWMutex
+	config   *config.Config
+	chain    SeedChain
+	hasher   PowHasher
+	epochs   []*seedEpoch // current and next seed, oldest first
+	forkTime uint64       // first xdag epoch hashed with RandomX, 0 until the fork block is known
+}
+
+func NewSeedManager(config *config.Config, chain SeedChain, hasher PowHasher) (*SeedManager, error) {
+	if config.SeedEpochLag() >= config.SeedEpochBlocks() {
+		return nil, ErrSeedSchedule
+	}
+	return &SeedManager{
+		config: config,
+		chain:  chain,
+		hasher: hasher,
+	}, nil
+}
+
+// IsSeedHeight reports whether the main block at height starts a seed epoch
+func (m *SeedManager) IsSeedHeight(height uint64) bool {
+	fork := m.config.RandomxForkHeight()
+	return height >= fork && (height-fork)%m.config.SeedEpochBlocks() == 0
+}
+
+// switchTime is the first xdag epoch hashed with the seed started by block
+func (m *SeedManager) switchTime(block *core.Block) uint64 {
+	return utils.GetEpoch(block.GetTimestamp()) + m.config.SeedEpochLag() + 1
+}
+
+func reverse(hash common.Hash) []byte {
+	res := make([]byte, len(hash))
+	for i := range hash {
+		res[i] = hash[len(hash)-1-i]
+	}
+	return res
+}
+
+func (m *SeedManager) seedOf(height uint64) ([]byte, error) {
+	if height == m.config.RandomxForkHeight() {
+		seed := m.chain.GetPreSeed()
+		return seed[:], nil
+	}
+	block := m.chain.GetBlockByHeight(height - m.config.SeedEpochLag())
+	if block == nil {
+		return nil, ErrNoSeedBlock
+	}
+	return reverse(block.GetHash()), nil
+}
+
+// OnNewMainBlock is called when block becomes the main block at height. At
+// the start of a seed epoch it derives the next seed and prepares it.
+func (m *SeedManager) OnNewMainBlock(height uint64, block *core.Block) error {
+	if !m.IsSeedHeight(height) {
+		return nil
+	}
+	seed, err := m.seedOf(height)
+	if err != nil {
+		return err
+	}
+	e := &seedEpoch{
+		seed:       seed,
+		height:     height,
+		switchTime: m.switchTime(block),
+		ready:      make(chan struct{}),
+	}
+
+	m.Lock()
+	if height == m.config.RandomxForkHeight() {
+		m.forkTime = e.switchTime
+	}
+	for len(m.epochs) > 0 && m.epochs[len(m.epochs)-1].height >= height {
+		m.release(m.epochs[len(m.epochs)-1])
+		m.epochs = m.epochs[:len(m.epochs)-1]
+	}
+	m.epochs = append(m.epochs, e)
+	if len(m.epochs) > 2 {
+		m.release(m.epochs[0])
+		m.epochs = m.epochs[1:]
+	}
+	m.Unlock()
+
+	log.Info("randomx seed epoch", log.Ctx{"height": height, "switchTime": e.switchTime})
+	go func() {
+		e.err = m.hasher.Prepare(e.seed)
+		if e.err != nil {
+			log.Error("randomx prepare seed failed", log.Ctx{"height": height, "err": e.err.Error()})
+		}
+		close(e.ready)
+	}()
+	return nil
+}
+
+// Rollback forgets the seed epochs started above height when the main chain
+// is unwound
+func (m *SeedManager) Rollback(height uint64) {
+	m.Lock()
+	defer m.Unlock()
+	if height < m.config.RandomxForkHeight() {
+		m.forkTime = 0
+	}
+	for len(m.epochs) > 0 && m.epochs[len(m.epochs)-1].height > height {
+		m.release(m.epochs[len(m.epochs)-1])
+		m.epochs = m.epochs[:len(m.epochs)-1]
+	}
+}
+
+// release frees the seed of a dropped epoch once it is prepared. A reorg may
+// bring the same seed back meanwhile, so the seed is kept while an epoch uses
+// it and the check and release are done under the lock.
+func (m *SeedManager) release(e *seedEpoch) {
+	go func() {
+		<-e.ready
+		m.Lock()
+		defer m.Unlock()
+		for _, o := range m.epochs {
+			if bytes.Equal(o.seed, e.seed) {
+				return
+			}
+		}
+		m.hasher.Release(e.seed)
+	}()
+}
+
+// Init rebuilds the fork time and the seed epochs after a restart by
+// replaying the fork block and the last two seed heights of the main chain
+// up to height
+func (m *SeedManager) Init(height uint64) error {
+	fork := m.config.RandomxForkHeight()
+	if height < fork {
+		return nil
+	}
+	forkBlock := m.chain.GetBlockByHeight(fork)
+	if forkBlock == nil {
+		return ErrNoSeedBlock
+	}
+	m.Lock()
+	m.forkTime = m.switchTime(forkBlock)
+	m.Unlock()
+	blocks := m.config.SeedEpochBlocks()
+	last := height - (height-fork)%blocks
+	heights := []uint64{last}
+	if last-fork >= blocks {
+		heights = []uint64{last - blocks, last}
+	}
+	for _, h := range heights {
+		block := m.chain.GetBlockByHeight(h)
+		if block == nil {
+			return ErrNoSeedBlock
+		}
+		if err := m.OnNewMainBlock(h, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SeedManager) epochFor(timestamp uint64) *seedEpoch {
+	m.RLock()
+	defer m.RUnlock()
+	epoch := utils.GetEpoch(timestamp)
+	for i := len(m.epochs) - 1; i >= 0; i-- {
+		if epoch >= m.epochs[i].switchTime {
+			return m.epochs[i]
+		}
+	}
+	return nil
+}
+
+// IsFork reports whether a block at timestamp is hashed with RandomX, that
+// is from the switch time of the seed started at the fork height on
+func (m *SeedManager) IsFork(timestamp uint64) bool {
+	m.RLock()
+	defer m.RUnlock()
+	return m.forkTime != 0 && utils.GetEpoch(timestamp) >= m.forkTime
+}
+
+// seedEpochFor returns the seed epoch of a block at timestamp, ErrNoSeed
+// for a block after the fork whose seed is older than the kept ones
+func (m *SeedManager) seedEpochFor(timestamp uint64) (*seedEpoch, error) {
+	if !m.IsFork(timestamp) {
+		return nil, ErrNotForked
+	}
+	e := m.epochFor(timestamp)
+	if e == nil {
+		return nil, ErrNoSeed
+	}
+	return e, nil
+}
+
+// Seed returns the seed of a block at timestamp
+func (m *SeedManager) Seed(timestamp uint64) ([]byte, error) {
+	e, err := m.seedEpochFor(timestamp)
+	if err != nil {
+		return nil, err
+	}
+	return e.seed, nil
+}
+
+// Hash returns the RandomX hash of data for a block at timestamp, it waits
+// for the dataset of the seed if it is still being built
+func (m *SeedManager) Hash(timestamp uint64, data []byte) (common.Hash, error) {
+	e, err := m.seedEpochFor(timestamp)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	<-e.ready
+	if e.err != nil {
+		return common.Hash{}, e.err
+	}
+	return m.hasher.Hash(e.seed, data)
+}
+
+// PowInput is the RandomX input of a block: the SHA-256 of its first 15
+// fields followed by the nonce field
+func PowInput(block common.RawBlock) []byte {
+	pre := sha256.Sum256(block[:midstateSize+common.XDAG_FIELD_SIZE])
+	return append(pre[:], block[midstateSize+common.XDAG_FIELD_SIZE:]...)
+}
+
+// BlockDifficulty returns the difficulty of a block, from its RandomX hash
+// after the fork and from its SHA-256 hash before. It fails with ErrNoSeed
+// for a block after the fork whose seed is no longer kept. A network of fixed
+// block difficulty, i.e. regtest, ignores the hash.
+func (m *SeedManager) BlockDifficulty(block *core.Block) (*big.Int, error) {
+	if d := m.config.BlockDifficulty(); d != 0 {
+		return new(big.Int).SetUint64(d), nil
+	}
+	if !m.IsFork(block.GetTimestamp()) {
+		return HashDifficulty(block.GetHash()), nil
+	}
+	hash, err := m.Hash(block.GetTimestamp(), PowInput(block.GetXdagBlock().GetData()))
+	if err != nil {
+		return nil, err
+	}
+	return HashDifficulty(hash), nil
+}
+
+// NewTask creates a mining task, shares of tasks after the fork are hashed
+// with RandomX
+func (m *SeedManager) NewTask(block common.RawBlock, taskTime, taskIndex uint64) *Task {
+	t := NewTask(block, taskTime, taskIndex)
+	if m.IsFork(taskTime) {
+		t.SetPow(func(data []byte) common.Hash {
+			hash, err := m.Hash(taskTime, data)
+			if err != nil {
+				log.Warn("randomx share hash failed", log.Ctx{"err": err.Error()})
+				return maxHash
+			}
+			return hash
+		})
+	}
+	return t
+}
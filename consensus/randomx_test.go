@@ -0,0 +1,237 @@
+package consensus
+
+import (
+	"crypto/sha256"
+	"github.com/magiconair/properties/assert"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/secp256k1"
+)
+
+// fakeHasher stands in for randomx.Pool, the hash is sha256(seed || data)
+type fakeHasher struct {
+	sync.Mutex
+	prepared map[string]bool
+	released []string
+	gate     chan struct{} // holds the prepares until closed
+}
+
+func (h *fakeHasher) Prepare(seed []byte) error {
+	if h.gate != nil {
+		<-h.gate
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.prepared[string(seed)] = true
+	return nil
+}
+
+func (h *fakeHasher) Hash(seed, data []byte) (common.Hash, error) {
+	return sha256.Sum256(append(append([]byte{}, seed...), data...)), nil
+}
+
+func (h *fakeHasher) Release(seed []byte) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.prepared, string(seed))
+	h.released = append(h.released, string(seed))
+}
+
+func (h *fakeHasher) count() (int, int) {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.prepared), len(h.released)
+}
+
+type fakeSeedChain struct {
+	preSeed common.Hash
+	blocks  map[uint64]*core.Block
+}
+
+func (c *fakeSeedChain) GetPreSeed() common.Hash {
+	return c.preSeed
+}
+
+func (c *fakeSeedChain) GetBlockByHeight(height uint64) *core.Block {
+	return c.blocks[height]
+}
+
+func seedConfig() *config.Config {
+	c := &config.Config{}
+	c.SetXdagFieldHeader(common.XDAG_FIELD_HEAD_TEST)
+	c.SetRandomxForkHeight(100)
+	c.SetSeedEpochBlocks(16)
+	c.SetSeedEpochLag(4)
+	return c
+}
+
+// mainBlock creates the main block of height, one per epoch
+func mainBlock(c *config.Config, height uint64) *core.Block {
+	block := core.NewBlock(c, (0x1000+height)<<16|0xffff, nil, nil, true, nil, "", -1)
+	key, _ := secp256k1.GeneratePrivateKey()
+	block.SignOut(key)
+	return block
+}
+
+func TestSeedManager(t *testing.T) {
+	c := seedConfig()
+	chain := &fakeSeedChain{preSeed: common.Hash{1, 2, 3}, blocks: make(map[uint64]*core.Block)}
+	hasher := &fakeHasher{prepared: make(map[string]bool)}
+	_, err := NewSeedManager(&config.Config{}, chain, hasher)
+	assert.Equal(t, err, ErrSeedSchedule)
+	m, err := NewSeedManager(c, chain, hasher)
+	assert.Equal(t, err, nil)
+
+	epochOf := func(height uint64) uint64 { return 0x1000 + height }
+	addBlocks := func(from, to uint64) {
+		for h := from; h <= to; h++ {
+			chain.blocks[h] = mainBlock(c, h)
+			assert.Equal(t, m.OnNewMainBlock(h, chain.blocks[h]), nil)
+		}
+	}
+	addBlocks(90, 120)
+	assert.Equal(t, m.IsSeedHeight(99), false)
+	assert.Equal(t, m.IsSeedHeight(116), true)
+
+	// the pre-seed is used lag+1 epochs after the fork block
+	assert.Equal(t, m.IsFork(epochOf(104)<<16), false)
+	assert.Equal(t, m.IsFork(epochOf(105)<<16), true)
+	seed, err := m.Seed(epochOf(105) << 16)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, seed, chain.preSeed[:])
+
+	// the next seeds come from the block lag heights before the seed height
+	seed, _ = m.Seed(epochOf(121) << 16)
+	assert.Equal(t, seed, reverse(chain.blocks[112].GetHash()))
+	addBlocks(121, 140)
+	seed, _ = m.Seed(epochOf(137) << 16)
+	assert.Equal(t, seed, reverse(chain.blocks[128].GetHash()))
+
+	// only the current and next seed are kept
+	waitPrepared := func(prepared, released int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			p, r := hasher.count()
+			if p == prepared && r == released {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatal(hasher.count())
+	}
+	waitPrepared(2, 1)
+	_, err = m.Seed(epochOf(105) << 16)
+	assert.Equal(t, err, ErrNoSeed)
+
+	// an older block after the fork is still hashed with RandomX, its
+	// difficulty can't be computed without the seed
+	assert.Equal(t, m.IsFork(epochOf(105)<<16), true)
+	_, err = m.BlockDifficulty(chain.blocks[110])
+	assert.Equal(t, err, ErrNoSeed)
+	_, err = m.Seed(epochOf(104) << 16)
+	assert.Equal(t, err, ErrNotForked)
+
+	// a reorg below the last seed height drops its seed
+	m.Rollback(130)
+	waitPrepared(1, 2)
+	seed, _ = m.Seed(epochOf(137) << 16)
+	assert.Equal(t, seed, reverse(chain.blocks[112].GetHash()))
+}
+
+func TestSeedManagerReorg(t *testing.T) {
+	c := seedConfig()
+	chain := &fakeSeedChain{preSeed: common.Hash{1, 2, 3}, blocks: make(map[uint64]*core.Block)}
+	hasher := &fakeHasher{prepared: make(map[string]bool), gate: make(chan struct{})}
+	m, _ := NewSeedManager(c, chain, hasher)
+	chain.blocks[100] = mainBlock(c, 100)
+
+	// the fork block is unwound and connected again while its seed is
+	// being prepared, the pending release must not drop it
+	m.OnNewMainBlock(100, chain.blocks[100])
+	m.Rollback(99)
+	assert.Equal(t, m.IsFork((0x1000+105)<<16), false)
+	m.OnNewMainBlock(100, chain.blocks[100])
+	close(hasher.gate)
+	_, err := m.Hash((0x1000+105)<<16, []byte{1})
+	assert.Equal(t, err, nil)
+	time.Sleep(50 * time.Millisecond)
+	p, r := hasher.count()
+	assert.Equal(t, p, 1)
+	assert.Equal(t, r, 0)
+}
+
+func TestSeedManagerInit(t *testing.T) {
+	c := seedConfig()
+	chain := &fakeSeedChain{preSeed: common.Hash{1, 2, 3}, blocks: make(map[uint64]*core.Block)}
+	for h := uint64(90); h <= 140; h++ {
+		chain.blocks[h] = mainBlock(c, h)
+	}
+	hasher := &fakeHasher{prepared: make(map[string]bool)}
+	m, _ := NewSeedManager(c, chain, hasher)
+
+	// before the fork there is nothing to replay
+	assert.Equal(t, m.Init(99), nil)
+	assert.Equal(t, m.IsFork((0x1000+140)<<16), false)
+
+	// a restart at height 140 replays the seed heights 116 and 132
+	assert.Equal(t, m.Init(140), nil)
+	seed, err := m.Seed((0x1000 + 121) << 16)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, seed, reverse(chain.blocks[112].GetHash()))
+	seed, _ = m.Seed((0x1000 + 137) << 16)
+	assert.Equal(t, seed, reverse(chain.blocks[128].GetHash()))
+	assert.Equal(t, m.IsFork((0x1000+120)<<16), true)
+	assert.Equal(t, m.IsFork((0x1000+104)<<16), false)
+	_, err = m.Seed((0x1000 + 120) << 16)
+	assert.Equal(t, err, ErrNoSeed)
+
+	// only the fork seed exists at height 110
+	m, _ = NewSeedManager(c, chain, hasher)
+	assert.Equal(t, m.Init(110), nil)
+	seed, _ = m.Seed((0x1000 + 110) << 16)
+	assert.Equal(t, seed, chain.preSeed[:])
+
+	delete(chain.blocks, 132)
+	m, _ = NewSeedManager(c, chain, hasher)
+	assert.Equal(t, m.Init(140), ErrNoSeedBlock)
+}
+
+func TestRandomxDifficulty(t *testing.T) {
+	c := seedConfig()
+	chain := &fakeSeedChain{blocks: make(map[uint64]*core.Block)}
+	hasher := &fakeHasher{prepared: make(map[string]bool)}
+	m, _ := NewSeedManager(c, chain, hasher)
+
+	before := mainBlock(c, 99)
+	diff, err := m.BlockDifficulty(before)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, diff, HashDifficulty(before.GetHash()))
+
+	chain.blocks[100] = mainBlock(c, 100)
+	m.OnNewMainBlock(100, chain.blocks[100])
+	after := mainBlock(c, 110)
+	raw := after.GetXdagBlock().GetData()
+	hash, _ := hasher.Hash(chain.preSeed[:], PowInput(raw))
+	diff, err = m.BlockDifficulty(after)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, diff, HashDifficulty(hash))
+
+	// the regtest blocks have a fixed difficulty
+	c.SetBlockDifficulty(3)
+	diff, err = m.BlockDifficulty(after)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, diff, big.NewInt(3))
+	c.SetBlockDifficulty(0)
+
+	// shares of a post-fork task are hashed over sha256(15 fields) || nonce
+	task := m.NewTask(raw, after.GetTimestamp(), 1)
+	assert.Equal(t, task.IsRandomx(), true)
+	nonce := after.Nonce
+	assert.Equal(t, task.ShareHash(nonce), hash)
+	assert.Equal(t, m.NewTask(raw, before.GetTimestamp(), 1).IsRandomx(), false)
+}
@@ -0,0 +1,303 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/db"
+	"xdago/log"
+	"xdago/metrics"
+	"xdago/utils"
+)
+
+const (
+	MINER_HISTORY_EPOCHS = 1024 // epochs of worker stats kept in the miner database, about 18 hours
+	HASHRATE_EPOCHS      = 4    // epochs the hash rate is averaged over
+	EPOCH_SECONDS        = 64
+)
+
+// WorkerStats are the shares of one worker in one epoch
+type WorkerStats struct {
+	Address    string
+	Worker     string
+	Epoch      uint64
+	Accepted   uint64
+	Stale      uint64
+	Invalid    uint64
+	Difficulty *big.Int // sum of the accepted share difficulty
+	LastShare  time.Time
+}
+
+// Hashes estimates the hashes done for the accepted shares, a share of
+// difficulty d takes d/2^32 hashes on average
+func (w *WorkerStats) Hashes() float64 {
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(w.Difficulty), big.NewFloat(1<<32)).Float64()
+	return f
+}
+
+// MinerInfo is the summary of a miner address
+type MinerInfo struct {
+	Address  string
+	Workers  []WorkerStats // stats of the current epoch
+	Hashrate float64       // hashes per second
+}
+
+var sharesTotal = metrics.NewCounterVec("xdag_pool_shares_total", "shares of the pool miners by status", "status")
+
+func init() {
+	metrics.Register(sharesTotal)
+}
+
+type workerKey struct {
+	address string
+	worker  string
+}
+
+// MinerRegistry counts the shares of every miner address and worker per
+// epoch. The last HASHRATE_EPOCHS epochs are kept in memory for the hash
+// rate, finished epochs are written to the miner database and kept there for
+// MINER_HISTORY_EPOCHS epochs.
+type MinerRegistry struct {
+	sync.Mutex
+	source db.IKVSource
+	epochs map[uint64]map[workerKey]*WorkerStats
+	last   uint64 // newest epoch seen
+}
+
+// NewMinerRegistry creates a registry, the recent epochs are loaded from
+// source so the hash rate survives a restart
+func NewMinerRegistry(source db.IKVSource) *MinerRegistry {
+	r := &MinerRegistry{
+		source: source,
+		epochs: make(map[uint64]map[workerKey]*WorkerStats),
+		last:   utils.GetCurrentEpoch(),
+	}
+	for _, w := range r.fetch(r.last - HASHRATE_EPOCHS + 1) {
+		w := w
+		r.stats(w.Epoch)[workerKey{w.Address, w.Worker}] = &w
+	}
+	return r
+}
+
+func statsKey(epoch uint64, address, worker string) []byte {
+	var e [8]byte
+	binary.BigEndian.PutUint64(e[:], epoch)
+	return utils.MergeBytes([]byte{common.MINER_STATS}, e[:], []byte(address), []byte{0}, []byte(worker))
+}
+
+// fetch reads the stats stored from epoch on
+func (r *MinerRegistry) fetch(from uint64) []WorkerStats {
+	var res []WorkerStats
+	r.source.FetchRange(statsKey(from, "", "")[:9], []byte{common.MINER_STATS + 1}, func(k, v []byte) bool {
+		var w WorkerStats
+		if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&w); err != nil {
+			log.Error("decode worker stats error", log.Ctx{"err": err.Error()})
+			return false
+		}
+		res = append(res, w)
+		return false
+	})
+	return res
+}
+
+func (r *MinerRegistry) stats(epoch uint64) map[workerKey]*WorkerStats {
+	m, ok := r.epochs[epoch]
+	if !ok {
+		m = make(map[workerKey]*WorkerStats)
+		r.epochs[epoch] = m
+	}
+	return m
+}
+
+// minerAddress returns the xdag address of an account, accounts that are not
+// addresses are kept as they are
+func minerAddress(account string) string {
+	if h, err := codec.ParseHash(account); err == nil {
+		return codec.Hash2Address(h)
+	}
+	return account
+}
+
+func shareEpoch(share Share) uint64 {
+	return utils.GetEpoch(utils.Ms2XdagTimestamp(uint64(share.Time.UnixMilli())))
+}
+
+// AddShare counts an accepted share, the registry is a share handler of the
+// pool servers
+func (r *MinerRegistry) AddShare(share Share) {
+	r.Add(share, SHARE_ACCEPTED)
+}
+
+// Add counts a share with its status
+func (r *MinerRegistry) Add(share Share, status ShareStatus) {
+	sharesTotal.With(status.String()).Inc()
+	epoch := shareEpoch(share)
+	r.Lock()
+	defer r.Unlock()
+	if epoch > r.last {
+		r.rollover(epoch)
+	}
+	if epoch+HASHRATE_EPOCHS <= r.last {
+		return
+	}
+	key := workerKey{minerAddress(share.Account), share.Worker}
+	m := r.stats(epoch)
+	w, ok := m[key]
+	if !ok {
+		w = &WorkerStats{Address: key.address, Worker: key.worker, Epoch: epoch, Difficulty: new(big.Int)}
+		m[key] = w
+	}
+	switch status {
+	case SHARE_ACCEPTED:
+		w.Accepted++
+		w.Difficulty.Add(w.Difficulty, share.Difficulty)
+		w.LastShare = share.Time
+	case SHARE_STALE:
+		w.Stale++
+	default:
+		w.Invalid++
+	}
+}
+
+// rollover saves the finished epochs and drops the old ones
+func (r *MinerRegistry) rollover(epoch uint64) {
+	for e, m := range r.epochs {
+		if e < epoch {
+			r.save(m)
+		}
+		if e+HASHRATE_EPOCHS <= epoch {
+			delete(r.epochs, e)
+		}
+	}
+	r.last = epoch
+	if epoch < MINER_HISTORY_EPOCHS {
+		return
+	}
+	// the keys are ordered by epoch, only the expired ones are read
+	var expired [][]byte
+	r.source.FetchRange([]byte{common.MINER_STATS}, statsKey(epoch-MINER_HISTORY_EPOCHS+1, "", "")[:9], func(k, v []byte) bool {
+		expired = append(expired, k)
+		return false
+	})
+	for _, k := range expired {
+		r.source.Delete(k)
+	}
+}
+
+func (r *MinerRegistry) save(m map[workerKey]*WorkerStats) {
+	for _, w := range m {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(w); err != nil {
+			log.Crit("encode worker stats error", log.Ctx{"err": err.Error()})
+		}
+		r.source.Put(statsKey(w.Epoch, w.Address, w.Worker), buf.Bytes())
+	}
+}
+
+// Flush writes the epochs in memory, it is called before closing the database
+func (r *MinerRegistry) Flush() {
+	r.Lock()
+	defer r.Unlock()
+	for _, m := range r.epochs {
+		r.save(m)
+	}
+}
+
+// Workers returns the stats of the current epoch of the workers of address
+func (r *MinerRegistry) Workers(address string) []WorkerStats {
+	address = minerAddress(address)
+	r.Lock()
+	defer r.Unlock()
+	var res []WorkerStats
+	for key, w := range r.epochs[r.last] {
+		if key.address == address {
+			res = append(res, copyStats(w))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Worker < res[j].Worker })
+	return res
+}
+
+func copyStats(w *WorkerStats) WorkerStats {
+	c := *w
+	c.Difficulty = new(big.Int).Set(w.Difficulty)
+	return c
+}
+
+// hashrate returns the hashes per second of the workers matching f
+func (r *MinerRegistry) hashrate(f func(key workerKey) bool) float64 {
+	var hashes float64
+	for _, m := range r.epochs {
+		for key, w := range m {
+			if f(key) {
+				hashes += w.Hashes()
+			}
+		}
+	}
+	return hashes / (HASHRATE_EPOCHS * EPOCH_SECONDS)
+}
+
+// Hashrate estimates the hashes per second of a worker, all the workers of
+// address when worker is empty
+func (r *MinerRegistry) Hashrate(address, worker string) float64 {
+	address = minerAddress(address)
+	r.Lock()
+	defer r.Unlock()
+	return r.hashrate(func(key workerKey) bool {
+		return key.address == address && (worker == "" || key.worker == worker)
+	})
+}
+
+// PoolHashrate estimates the hashes per second of all the miners
+func (r *MinerRegistry) PoolHashrate() float64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.hashrate(func(key workerKey) bool { return true })
+}
+
+// Miners returns the summary of the miners seen in the hash rate window
+func (r *MinerRegistry) Miners() []MinerInfo {
+	r.Lock()
+	defer r.Unlock()
+	infos := make(map[string]*MinerInfo)
+	for _, m := range r.epochs {
+		for key, w := range m {
+			info, ok := infos[key.address]
+			if !ok {
+				info = &MinerInfo{Address: key.address}
+				infos[key.address] = info
+			}
+			info.Hashrate += w.Hashes() / (HASHRATE_EPOCHS * EPOCH_SECONDS)
+			if w.Epoch == r.last {
+				info.Workers = append(info.Workers, copyStats(w))
+			}
+		}
+	}
+	res := make([]MinerInfo, 0, len(infos))
+	for _, info := range infos {
+		sort.Slice(info.Workers, func(i, j int) bool { return info.Workers[i].Worker < info.Workers[j].Worker })
+		res = append(res, *info)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Address < res[j].Address })
+	return res
+}
+
+// History returns the saved stats of address from epoch on, ordered by epoch
+func (r *MinerRegistry) History(address string, from uint64) []WorkerStats {
+	address = minerAddress(address)
+	r.Lock()
+	defer r.Unlock()
+	var res []WorkerStats
+	for _, w := range r.fetch(from) {
+		if w.Address == address {
+			res = append(res, w)
+		}
+	}
+	return res
+}
@@ -0,0 +1,101 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package consensus
+
+import (
+	"github.com/magiconair/properties/assert"
+	"math/big"
+	"testing"
+	"time"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/db"
+	"xdago/db/factory"
+	"xdago/utils"
+)
+
+func newMinerSource(t *testing.T, c *config.Config) (db.IKVSource, *factory.KvStoreFactory) {
+	kvFactory := factory.NewKvStoreFactory(c)
+	source := kvFactory.GetDB(common.DB_MINERS)
+	source.Init()
+	return source, &kvFactory
+}
+
+// epochTime returns a time in the xdag epoch
+func epochTime(epoch uint64) time.Time {
+	return time.UnixMilli(int64(utils.XdagTimestamp2Ms(epoch<<16 | 0x8000)))
+}
+
+func TestMinerRegistry(t *testing.T) {
+	c := &config.Config{}
+	c.SetStoreDir(t.TempDir())
+	source, kvFactory := newMinerSource(t, c)
+	r := NewMinerRegistry(source)
+	epoch := utils.GetCurrentEpoch() + 1
+
+	var h common.Hash
+	h[8] = 1
+	address := codec.Hash2Address(h)
+	diff := new(big.Int).Lsh(big.NewInt(1000), 32) // 1000 hashes each
+	for i := 0; i < 4; i++ {
+		r.AddShare(Share{Account: address, Worker: "rig1", Difficulty: diff, Time: epochTime(epoch)})
+	}
+	// the legacy pool names accounts by hashlow string
+	r.AddShare(Share{Account: utils.Hash2String(h), Worker: "rig2", Difficulty: diff, Time: epochTime(epoch)})
+	r.Add(Share{Account: address, Worker: "rig1", Time: epochTime(epoch)}, SHARE_STALE)
+	r.Add(Share{Account: address, Worker: "rig1", Time: epochTime(epoch)}, SHARE_INVALID)
+	r.Add(Share{Account: address, Worker: "rig1", Time: epochTime(epoch)}, SHARE_INVALID)
+
+	workers := r.Workers(address)
+	assert.Equal(t, len(workers), 2)
+	assert.Equal(t, workers[0].Worker, "rig1")
+	assert.Equal(t, workers[0].Accepted, uint64(4))
+	assert.Equal(t, workers[0].Stale, uint64(1))
+	assert.Equal(t, workers[0].Invalid, uint64(2))
+	assert.Equal(t, workers[1].Accepted, uint64(1))
+	assert.Equal(t, r.Hashrate(address, "rig1"), float64(4000)/(HASHRATE_EPOCHS*EPOCH_SECONDS))
+	assert.Equal(t, r.Hashrate(address, ""), float64(5000)/(HASHRATE_EPOCHS*EPOCH_SECONDS))
+	assert.Equal(t, r.PoolHashrate(), r.Hashrate(address, ""))
+	miners := r.Miners()
+	assert.Equal(t, len(miners), 1)
+	assert.Equal(t, miners[0].Address, address)
+	assert.Equal(t, len(miners[0].Workers), 2)
+
+	// the next epoch saves the finished one
+	r.AddShare(Share{Account: address, Worker: "rig1", Difficulty: diff, Time: epochTime(epoch + 1)})
+	assert.Equal(t, len(r.History(address, 0)), 2)
+	assert.Equal(t, len(r.Workers(address)), 1)
+	assert.Equal(t, r.Hashrate(address, ""), float64(6000)/(HASHRATE_EPOCHS*EPOCH_SECONDS))
+
+	// shares older than the hash rate window are ignored
+	r.AddShare(Share{Account: address, Worker: "rig1", Difficulty: diff, Time: epochTime(epoch + HASHRATE_EPOCHS + 1)})
+	r.AddShare(Share{Account: address, Worker: "old", Difficulty: diff, Time: epochTime(epoch)})
+	assert.Equal(t, r.Hashrate(address, "old"), float64(0))
+
+	// a restart keeps the saved epochs of the window
+	r.Flush()
+	r2 := NewMinerRegistry(source)
+	assert.Equal(t, len(r2.History(address, epoch)), 4)
+	kvFactory.Close()
+}
+
+func TestMinerRegistryRestart(t *testing.T) {
+	c := &config.Config{}
+	c.SetStoreDir(t.TempDir())
+	source, kvFactory := newMinerSource(t, c)
+	defer kvFactory.Close()
+	epoch := utils.GetCurrentEpoch()
+
+	r := NewMinerRegistry(source)
+	diff := new(big.Int).Lsh(big.NewInt(64), 32)
+	r.AddShare(Share{Account: "acc", Worker: "w", Difficulty: diff, Time: epochTime(epoch)})
+	r.Flush()
+
+	r = NewMinerRegistry(source)
+	assert.Equal(t, r.Hashrate("acc", "w"), float64(64)/(HASHRATE_EPOCHS*EPOCH_SECONDS))
+	assert.Equal(t, r.Workers("acc")[0].Accepted, uint64(1))
+}
@@ -0,0 +1,41 @@
+package consensus
+
+import (
+	"math/big"
+	"time"
+	"xdago/common"
+)
+
+type ShareStatus byte
+
+const (
+	SHARE_ACCEPTED ShareStatus = iota
+	SHARE_STALE                // for a task that is not mined anymore
+	SHARE_INVALID              // duplicate, over the limit or too low difficulty
+)
+
+func (s ShareStatus) String() string {
+	switch s {
+	case SHARE_ACCEPTED:
+		return "accepted"
+	case SHARE_STALE:
+		return "stale"
+	default:
+		return "invalid"
+	}
+}
+
+// Share is a nonce accepted from a miner for a task. Difficulty is the
+// target the share met, its weight in the rounds and the hash rate, while the
+// difficulty of the hash itself varies too much with luck and only picks the
+// best share.
+type Share struct {
+	Account          string
+	Worker           string
+	TaskIndex        uint64
+	Nonce            common.Field
+	Hash             common.Hash
+	Difficulty       *big.Int
+	ActualDifficulty *big.Int // difficulty of Hash
+	Time             time.Time
+}
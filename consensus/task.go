@@ -1,7 +1,9 @@
 package consensus
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"xdago/common"
 	"xdago/core"
 )
 
@@ -9,6 +11,32 @@ type Task struct {
 	task      core.XdagField
 	taskTime  uint64
 	taskIndex uint64
+	block     common.RawBlock // candidate block, the last field is the nonce
+	verifier  *ShareVerifier
+	pow       func(data []byte) common.Hash // RandomX hash after the fork
+	preHash   [sha256.Size]byte             // SHA-256 of the first 15 fields, the pow input prefix
+}
+
+// NewTask creates a mining task for a candidate block, the task field is the
+// nonce field of the block that miners fill in
+func NewTask(block common.RawBlock, taskTime, taskIndex uint64) *Task {
+	t := &Task{
+		taskTime:  taskTime,
+		taskIndex: taskIndex,
+		block:     block,
+	}
+	copy(t.task.Data[:], block[(common.XDAG_BLOCK_FIELDS-1)*common.XDAG_FIELD_SIZE:])
+	t.task.Type = common.XDAG_FIELD_SIGN_IN
+	t.verifier = NewShareVerifierFromBlock(block)
+	return t
+}
+
+// NewTaskFromBlock creates the mining task of a candidate main block, the
+// current Nonce of the block is the starting nonce
+func NewTaskFromBlock(block *core.Block, taskIndex uint64) *Task {
+	var raw common.RawBlock
+	copy(raw[:], block.ToBytes())
+	return NewTask(raw, block.GetTimestamp(), taskIndex)
 }
 
 func (t *Task) Task() core.XdagField {
@@ -35,6 +63,46 @@ func (t *Task) SetTaskIndex(taskIndex uint64) {
 	t.taskIndex = taskIndex
 }
 
+func (t *Task) Block() common.RawBlock {
+	return t.block
+}
+
+func (t *Task) SetBlock(block common.RawBlock) {
+	t.block = block
+	t.verifier = NewShareVerifierFromBlock(block)
+	t.preHash = sha256.Sum256(block[:midstateSize+common.XDAG_FIELD_SIZE])
+}
+
+// SetPow makes shares hashed by pow over PowInput instead of SHA-256
+func (t *Task) SetPow(pow func(data []byte) common.Hash) {
+	t.pow = pow
+	t.preHash = sha256.Sum256(t.block[:midstateSize+common.XDAG_FIELD_SIZE])
+}
+
+func (t *Task) IsRandomx() bool {
+	return t.pow != nil
+}
+
+// Midstate is the SHA-256 state after the first 14 fields of the block
+func (t *Task) Midstate() common.Field {
+	return t.verifier.Midstate()
+}
+
+// LastField is the field hashed after the midstate and before the nonce
+func (t *Task) LastField() common.Field {
+	return t.verifier.LastField()
+}
+
+// ShareHash 计算把nonce填入区块最后一个字段后的区块哈希
+func (t *Task) ShareHash(nonce common.Field) common.Hash {
+	if t.pow != nil {
+		data := make([]byte, 0, len(t.preHash)+len(nonce))
+		data = append(append(data, t.preHash[:]...), nonce[:]...)
+		return t.pow(data)
+	}
+	return t.verifier.Hash(nonce)
+}
+
 func (t *Task) ToString() string {
 	return fmt.Sprintf("Task: { taskTime: %d, %016x }", t.taskTime, t.taskTime)
 }
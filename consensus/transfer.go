@@ -0,0 +1,114 @@
+package consensus
+
+import (
+	"errors"
+	"sort"
+	"xdago/common"
+	"xdago/core"
+	"xdago/secp256k1"
+	"xdago/utils"
+	"xdago/wallet"
+)
+
+const MAX_XFER_INPUTS = 5 // inputs of one transfer block, each is signed
+
+var (
+	ErrWalletLocked     = errors.New("wallet is locked")
+	ErrBalanceNotEnough = errors.New("balance not enough")
+	ErrRemark           = errors.New("remark is too long or not printable")
+	ErrKeyNotFound      = errors.New("key of our address not found")
+	ErrCreateTransfer   = errors.New("create transfer block failed")
+	ErrTransferRejected = errors.New("transfer block rejected")
+)
+
+// TransferChain is the part of core.IBlockchain used to send transfers
+type TransferChain interface {
+	GetBlockByHash(hash common.Hash, isRaw bool) *core.Block
+	GetMemOurBlocks() map[common.Hash]int
+	CreateNewBlock(pairs map[core.Address]*secp256k1.PrivateKey, to []core.Address, mining bool, remark string) *core.Block
+	TryToConnect(block *core.Block) core.ImportResult
+}
+
+// OurAddress is an address block of ours and the wallet key that signed it
+type OurAddress struct {
+	Hash    common.Hash
+	Key     int
+	Balance common.Amount
+}
+
+// OurAddresses returns our addresses ordered by balance, the richest first
+func OurAddresses(chain TransferChain) []OurAddress {
+	var res []OurAddress
+	for hash, key := range chain.GetMemOurBlocks() {
+		a := OurAddress{Hash: hash, Key: key}
+		if block := chain.GetBlockByHash(hash, false); block != nil && block.Info() != nil {
+			a.Balance = common.Amount(block.Info().Amount)
+		}
+		res = append(res, a)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Balance != res[j].Balance {
+			return res[i].Balance > res[j].Balance
+		}
+		return string(res[i].Hash[:]) < string(res[j].Hash[:])
+	})
+	return res
+}
+
+// Transfer sends amount from our addresses to the address to, the inputs are
+// taken from the richest addresses. It returns the hashlow of the transfer
+// blocks, one per MAX_XFER_INPUTS inputs.
+func Transfer(chain TransferChain, w *wallet.Wallet, to common.Hash, amount common.Amount, remark string) ([]common.Hash, error) {
+	if len(remark) > common.XDAG_FIELD_SIZE || !utils.IsAsciiPrintable(remark) {
+		return nil, ErrRemark
+	}
+	if w == nil || w.IsLocked() {
+		return nil, ErrWalletLocked
+	}
+	var inputs []OurAddress
+	left := amount
+	for _, a := range OurAddresses(chain) {
+		if left == 0 {
+			break
+		}
+		if a.Balance == 0 {
+			continue
+		}
+		if a.Balance > left {
+			a.Balance = left
+		}
+		inputs = append(inputs, a)
+		left -= a.Balance
+	}
+	if left > 0 || amount == 0 {
+		return nil, ErrBalanceNotEnough
+	}
+
+	var txs []common.Hash
+	for start := 0; start < len(inputs); start += MAX_XFER_INPUTS {
+		end := start + MAX_XFER_INPUTS
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		pairs := make(map[core.Address]*secp256k1.PrivateKey)
+		var sum common.Amount
+		for _, in := range inputs[start:end] {
+			key := w.GetAccount(in.Key)
+			if key == nil {
+				return txs, ErrKeyNotFound
+			}
+			pairs[core.AddressFromAmount(in.Hash, common.XDAG_FIELD_IN, uint64(in.Balance))] = key
+			sum += in.Balance
+		}
+		out := []core.Address{core.AddressFromAmount(to, common.XDAG_FIELD_OUT, uint64(sum))}
+		block := chain.CreateNewBlock(pairs, out, false, remark)
+		if block == nil {
+			return txs, ErrCreateTransfer
+		}
+		if res := chain.TryToConnect(block); !res.IsNormal() {
+			return txs, ErrTransferRejected
+		}
+		txs = append(txs, block.GetHashLow())
+	}
+	return txs, nil
+}
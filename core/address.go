@@ -2,7 +2,7 @@ package core
 
 import (
 	"encoding/binary"
-	"encoding/hex"
+	"xdago/codec"
 	"xdago/common"
 	"xdago/log"
 )
@@ -96,5 +96,5 @@ func (adr *Address) GetHashLow() []byte {
 }
 
 func (adr Address) ToString() string {
-	return "Block(A) Hash[" + hex.EncodeToString(adr.HashLow[:]) + "]"
+	return "Block(A) Address[" + codec.Hash2Address(adr.HashLow) + "]"
 }
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/crypto"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+var ErrBlockSize = fmt.Errorf("raw block is not %d bytes", common.XDAG_BLOCK_SIZE)
+
+// BlockJson describes every field of a raw block, EncodeBlock builds the
+// exact raw block back from it. The hashes, the type, the time, the xdag
+// amounts and the signatures are only informational, the type is derived
+// from the field types.
+type BlockJson struct {
+	Hash       string          `json:"hash"`
+	Address    string          `json:"address"`
+	Transport  uint64          `json:"transport"`
+	Type       string          `json:"type"`
+	Timestamp  uint64          `json:"timestamp"` // xdag time, 1/1024 seconds
+	Time       string          `json:"time"`
+	Fee        uint64          `json:"fee"`
+	Fields     []FieldJson     `json:"fields"`
+	Signatures []SignatureJson `json:"signatures"`
+}
+
+// FieldJson is a field of the block, the header is field 0 and its values
+// are the ones of the block. Links have an address and an amount, remarks a
+// text, public keys the compressed key and the other fields their hex data,
+// left out when zero.
+type FieldJson struct {
+	Index     int    `json:"index"`
+	Type      string `json:"type"`
+	Address   string `json:"address,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Amount    uint64 `json:"amount,omitempty"`
+	Xdag      string `json:"xdag,omitempty"`
+	Remark    string `json:"remark,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+// SignatureJson is a signature stored in the fields Field and Field+1. It is
+// only checked against the public keys of the block, Key is the index of the
+// field of the verifying key or -1.
+type SignatureJson struct {
+	Field    int    `json:"field"`
+	Type     string `json:"type"`
+	R        string `json:"r"`
+	S        string `json:"s"`
+	Key      int    `json:"key"`
+	Verified bool   `json:"verified"`
+}
+
+// DecodeBlock describes the raw block
+func DecodeBlock(raw []byte) (*BlockJson, error) {
+	if len(raw) != common.XDAG_BLOCK_SIZE {
+		return nil, ErrBlockSize
+	}
+	xb := NewXdagBlock(raw)
+	hash := crypto.HashTwice(raw)
+	var hashLow common.Hash
+	copy(hashLow[8:], hash[8:])
+	header := xb.Fields[0].Data
+	bj := &BlockJson{
+		Hash:      utils.Hash2String(hash),
+		Address:   codec.Hash2Address(hashLow),
+		Transport: binary.LittleEndian.Uint64(header[:8]),
+		Type:      utils.Type2String(binary.LittleEndian.Uint64(header[8:16])),
+		Timestamp: binary.LittleEndian.Uint64(header[16:24]),
+		Fee:       binary.LittleEndian.Uint64(header[24:]),
+	}
+	bj.Time = time.UnixMilli(int64(utils.XdagTimestamp2Ms(bj.Timestamp))).UTC().Format(time.RFC3339Nano)
+
+	var keys []int
+	for i, field := range xb.Fields {
+		fj := FieldJson{Index: i, Type: field.Type.String()}
+		switch {
+		case i == 0:
+		case field.Type == common.XDAG_FIELD_IN || field.Type == common.XDAG_FIELD_OUT:
+			adr := AddressFromField(field)
+			fj.Address = codec.Hash2Address(adr.HashLow)
+			fj.Hash = utils.Hash2String(adr.HashLow)
+			fj.Amount = adr.Amount
+			fj.Xdag = common.Amount(adr.Amount).String()
+		case field.Type == common.XDAG_FIELD_REMARK:
+			if remark, ok := remarkText(field.Data); ok {
+				fj.Remark = remark
+			} else {
+				fj.Data = hex.EncodeToString(field.Data[:])
+			}
+		case field.Type == common.XDAG_FIELD_PUBLIC_KEY_0 || field.Type == common.XDAG_FIELD_PUBLIC_KEY_1:
+			fj.PublicKey = hex.EncodeToString(compressedKey(field))
+			keys = append(keys, i)
+		case field.Data != common.EmptyField:
+			fj.Data = hex.EncodeToString(field.Data[:])
+		}
+		bj.Fields = append(bj.Fields, fj)
+	}
+	bj.Signatures = verifySignatures(xb, keys)
+	return bj, nil
+}
+
+// remarkText returns the printable text of a remark padded with zeros
+func remarkText(data common.Field) (string, bool) {
+	s := strings.TrimRight(string(data[:]), "\x00")
+	if s == "" || strings.IndexByte(s, 0) >= 0 || !utils.IsAsciiPrintable(s) {
+		return "", false
+	}
+	return s, true
+}
+
+func compressedKey(field XdagField) []byte {
+	key := make([]byte, 33)
+	key[0] = 0x02
+	if field.Type == common.XDAG_FIELD_PUBLIC_KEY_1 {
+		key[0] = 0x03
+	}
+	copy(key[1:], field.Data[:])
+	return key
+}
+
+// verifySignatures finds the signature pairs like Block.Parse does and checks
+// them with the public keys in the fields keys
+func verifySignatures(xb *XdagBlock, keys []int) []SignatureJson {
+	b := Block{info: &BlockInfo{}, xdagBlock: xb}
+	res := []SignatureJson{}
+	first := 0
+	for i, field := range xb.Fields {
+		if field.Type != common.XDAG_FIELD_SIGN_IN && field.Type != common.XDAG_FIELD_SIGN_OUT {
+			continue
+		}
+		if first == 0 {
+			first = i
+		}
+		if (i-first)%2 != 0 || i+1 >= common.XDAG_BLOCK_FIELDS {
+			continue
+		}
+		r, s := field.Data[:], xb.Fields[i+1].Data[:]
+		sig := SignatureJson{Field: i, Type: field.Type.String(), R: hex.EncodeToString(r), S: hex.EncodeToString(s), Key: -1}
+		digest := b.GetSubRawData(i)
+		for _, k := range keys {
+			compressed := compressedKey(xb.Fields[k])
+			pubKey, err := secp256k1.ParsePubKey(compressed)
+			if err != nil {
+				continue
+			}
+			h := crypto.HashTwice(utils.MergeBytes(digest[:], compressed))
+			if crypto.EcdsaVerify(pubKey, h[:], r, s) {
+				sig.Key, sig.Verified = k, true
+				break
+			}
+		}
+		res = append(res, sig)
+	}
+	return res
+}
+
+// EncodeBlock builds the raw block described by bj
+func EncodeBlock(bj *BlockJson) ([]byte, error) {
+	if len(bj.Fields) != common.XDAG_BLOCK_FIELDS {
+		return nil, fmt.Errorf("block has %d fields, not %d", len(bj.Fields), common.XDAG_BLOCK_FIELDS)
+	}
+	raw := make([]byte, common.XDAG_BLOCK_SIZE)
+	var typ uint64
+	for i, fj := range bj.Fields {
+		if fj.Index != i {
+			return nil, fmt.Errorf("field %d has index %d", i, fj.Index)
+		}
+		t, ok := common.ParseFieldType(fj.Type)
+		if !ok {
+			return nil, fmt.Errorf("field %d: unknown type %q", i, fj.Type)
+		}
+		typ |= uint64(t) << (i << 2)
+		data := raw[i*common.XDAG_FIELD_SIZE : (i+1)*common.XDAG_FIELD_SIZE]
+		if err := encodeField(fj, t, data); err != nil {
+			return nil, fmt.Errorf("field %d: %w", i, err)
+		}
+	}
+	binary.LittleEndian.PutUint64(raw[:8], bj.Transport)
+	binary.LittleEndian.PutUint64(raw[8:16], typ)
+	binary.LittleEndian.PutUint64(raw[16:24], bj.Timestamp)
+	binary.LittleEndian.PutUint64(raw[24:32], bj.Fee)
+	return raw, nil
+}
+
+func encodeField(fj FieldJson, t common.FieldType, data []byte) error {
+	switch {
+	case fj.Index == 0:
+	case t == common.XDAG_FIELD_IN || t == common.XDAG_FIELD_OUT:
+		h, err := codec.Address2Hash(fj.Address)
+		if err != nil {
+			return err
+		}
+		copy(data[:24], h[8:])
+		binary.LittleEndian.PutUint64(data[24:], fj.Amount)
+	case t == common.XDAG_FIELD_REMARK && fj.Remark != "":
+		if len(fj.Remark) > common.XDAG_FIELD_SIZE {
+			return errors.New("remark is longer than 32 bytes")
+		}
+		copy(data, fj.Remark)
+	case t == common.XDAG_FIELD_PUBLIC_KEY_0 || t == common.XDAG_FIELD_PUBLIC_KEY_1:
+		key, err := hex.DecodeString(fj.PublicKey)
+		if err != nil || len(key) != 33 || key[0] != 0x02+byte(t-common.XDAG_FIELD_PUBLIC_KEY_0) {
+			return errors.New("public key must be the compressed key matching the field type")
+		}
+		copy(data, key[1:])
+	case fj.Data != "":
+		b, err := hex.DecodeString(fj.Data)
+		if err != nil || len(b) != common.XDAG_FIELD_SIZE {
+			return errors.New("data must be 32 hex bytes")
+		}
+		copy(data, b)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/magiconair/properties/assert"
+	"testing"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+func codecBlock(t *testing.T) (*Block, []byte) {
+	c := &config.Config{}
+	c.SetXdagFieldHeader(common.XDAG_FIELD_HEAD)
+	key, _ := secp256k1.GeneratePrivateKey()
+	var in, out common.Hash
+	in[8], out[31] = 1, 2
+	links := []Address{
+		AddressFromAmount(in, common.XDAG_FIELD_IN, 10<<32),
+		AddressFromAmount(out, common.XDAG_FIELD_OUT, 10<<32+1),
+	}
+	b := NewBlock(c, 0x16b3a7f0000, links, nil, false, []*secp256k1.PublicKey{key.PubKey()}, "hello", 0)
+	b.SignOut(key)
+	raw := b.ToBytes()
+	assert.Equal(t, len(raw), common.XDAG_BLOCK_SIZE)
+	return b, raw
+}
+
+func TestDecodeBlock(t *testing.T) {
+	b, raw := codecBlock(t)
+	bj, err := DecodeBlock(raw)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, bj.Hash, utils.Hash2String(b.GetHash()))
+	assert.Equal(t, bj.Address, codec.Hash2Address(b.GetHashLow()))
+	assert.Equal(t, bj.Timestamp, uint64(0x16b3a7f0000))
+	assert.Equal(t, bj.Type, utils.Type2String(b.GetType()))
+	assert.Equal(t, bj.Fields[0].Type, "head")
+	assert.Equal(t, bj.Fields[1].Type, "in")
+	assert.Equal(t, bj.Fields[1].Amount, uint64(10<<32))
+	assert.Equal(t, bj.Fields[1].Xdag, "10.000000000")
+	assert.Equal(t, bj.Fields[2].Type, "out")
+	assert.Equal(t, bj.Fields[3].Remark, "hello")
+	assert.Equal(t, bj.Fields[4].PublicKey, hex.EncodeToString(b.PubKeys[0].SerializeCompressed()))
+	assert.Equal(t, bj.Fields[5].Type, "sign_out")
+	assert.Equal(t, bj.Fields[15].Data, "")
+	assert.Equal(t, len(bj.Signatures), 1)
+	assert.Equal(t, bj.Signatures[0].Field, 5)
+	assert.Equal(t, bj.Signatures[0].Key, 4)
+	assert.Equal(t, bj.Signatures[0].Verified, true)
+
+	// a changed amount breaks the signature
+	tampered := append([]byte{}, raw...)
+	tampered[2*common.XDAG_FIELD_SIZE+24]++
+	bj, _ = DecodeBlock(tampered)
+	assert.Equal(t, bj.Signatures[0].Verified, false)
+	assert.Equal(t, bj.Signatures[0].Key, -1)
+
+	_, err = DecodeBlock(raw[1:])
+	assert.Equal(t, err, ErrBlockSize)
+}
+
+func TestEncodeBlock(t *testing.T) {
+	_, raw := codecBlock(t)
+	bj, _ := DecodeBlock(raw)
+	data, err := json.Marshal(bj)
+	assert.Equal(t, err, nil)
+	var back BlockJson
+	assert.Equal(t, json.Unmarshal(data, &back), nil)
+	encoded, err := EncodeBlock(&back)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, bytes.Equal(encoded, raw), true)
+
+	// a remark that is no text and a nonce are kept as data
+	raw[3*common.XDAG_FIELD_SIZE+10] = 0xff
+	raw[15*common.XDAG_FIELD_SIZE] = 7
+	bj, _ = DecodeBlock(raw)
+	assert.Equal(t, bj.Fields[3].Remark, "")
+	assert.Equal(t, len(bj.Fields[15].Data), 64)
+	encoded, _ = EncodeBlock(bj)
+	assert.Equal(t, bytes.Equal(encoded, raw), true)
+
+	back.Fields[1].Amount = 5 << 32
+	encoded, _ = EncodeBlock(&back)
+	bj, _ = DecodeBlock(encoded)
+	assert.Equal(t, bj.Fields[1].Xdag, "5.000000000")
+
+	back.Fields[4].PublicKey = "04" + back.Fields[4].PublicKey[2:]
+	_, err = EncodeBlock(&back)
+	assert.Matches(t, err.Error(), "field 4: public key")
+	back.Fields[4].Type = "bogus"
+	_, err = EncodeBlock(&back)
+	assert.Matches(t, err.Error(), "unknown type")
+	back.Fields = back.Fields[:15]
+	_, err = EncodeBlock(&back)
+	assert.Matches(t, err.Error(), "15 fields")
+}
@@ -26,4 +26,5 @@ type IBlockchain interface {
 	StopCheckMain()    // 关闭检查主块链线程
 	RegisterListener() // 注册监听器
 	GetXdagExtStats() XdagExtStats
+	Events() *EventBus // 区块导入、主块变化和回滚事件
 }
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"sync"
+	"xdago/log"
+)
+
+type EventType byte
+
+const (
+	EVENT_NEW_BLOCK      EventType = iota // a block was imported
+	EVENT_NEW_MAIN_BLOCK                  // a block became the main block of Height
+	EVENT_REORG                           // the main block of Height is not main anymore
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EVENT_NEW_BLOCK:
+		return "newBlock"
+	case EVENT_NEW_MAIN_BLOCK:
+		return "newMainBlock"
+	case EVENT_REORG:
+		return "reorg"
+	default:
+		return "unknown"
+	}
+}
+
+type ChainEvent struct {
+	Type   EventType
+	Block  *Block
+	Height uint64
+}
+
+// EventBus hands the chain events to the subscribers. Publish never blocks
+// the chain: a subscriber whose channel is full has missed an event, it is
+// unsubscribed and its channel is closed, so it knows to subscribe again and
+// resync.
+type EventBus struct {
+	sync.RWMutex
+	subs   map[int]*subscriber
+	nextId int
+}
+
+type subscriber struct {
+	ch   chan ChainEvent
+	once sync.Once
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[int]*subscriber)}
+}
+
+// Subscribe returns a channel receiving the events and the function ending
+// the subscription, the channel is closed then
+func (b *EventBus) Subscribe(size int) (<-chan ChainEvent, func()) {
+	b.Lock()
+	defer b.Unlock()
+	id := b.nextId
+	b.nextId++
+	sub := &subscriber{ch: make(chan ChainEvent, size)}
+	b.subs[id] = sub
+	return sub.ch, func() {
+		b.Lock()
+		defer b.Unlock()
+		b.unsubscribe(id, sub)
+	}
+}
+
+// unsubscribe closes the channel of a subscriber, b is locked
+func (b *EventBus) unsubscribe(id int, sub *subscriber) {
+	delete(b.subs, id)
+	sub.once.Do(func() {
+		close(sub.ch)
+	})
+}
+
+func (b *EventBus) Publish(ev ChainEvent) {
+	var full []int
+	b.RLock()
+	for id, sub := range b.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			full = append(full, id)
+		}
+	}
+	b.RUnlock()
+	if len(full) == 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	for _, id := range full {
+		if sub, ok := b.subs[id]; ok {
+			log.Warn("chain event subscriber too slow, unsubscribed", log.Ctx{"subscriber": id, "event": ev.Type.String()})
+			b.unsubscribe(id, sub)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package core
+
+import (
+	"github.com/magiconair/properties/assert"
+	"testing"
+)
+
+func TestEventBusOverflow(t *testing.T) {
+	b := NewEventBus()
+	slow, cancelSlow := b.Subscribe(2)
+	fast, cancelFast := b.Subscribe(8)
+	defer cancelFast()
+
+	for h := uint64(1); h <= 3; h++ {
+		b.Publish(ChainEvent{Type: EVENT_NEW_MAIN_BLOCK, Height: h})
+	}
+	b.Publish(ChainEvent{Type: EVENT_REORG, Height: 3})
+
+	// the full queue keeps what it got and is closed, nothing is missed silently
+	var heights []uint64
+	for ev := range slow {
+		heights = append(heights, ev.Height)
+	}
+	assert.Equal(t, heights, []uint64{1, 2})
+	cancelSlow()
+
+	for h := uint64(1); h <= 3; h++ {
+		assert.Equal(t, (<-fast).Height, h)
+	}
+	assert.Equal(t, (<-fast).Type, EVENT_REORG)
+
+	// a new subscription gets the next events
+	again, cancel := b.Subscribe(2)
+	defer cancel()
+	b.Publish(ChainEvent{Type: EVENT_NEW_MAIN_BLOCK, Height: 4})
+	assert.Equal(t, (<-again).Height, uint64(4))
+	assert.Equal(t, (<-fast).Height, uint64(4))
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"time"
+	"xdago/metrics"
+)
+
+var importSeconds = metrics.NewHistogramVec("xdag_block_import_seconds",
+	"time of the block imports by result", "status", metrics.DefBuckets)
+
+func init() {
+	metrics.Register(importSeconds)
+}
+
+// ObserveImport counts an import of the result res which began at start
+func ObserveImport(start time.Time, res ImportResult) {
+	importSeconds.With(res.Status.String()).Observe(time.Since(start).Seconds())
+}
+
+// meteredChain times the imports of the chain
+type meteredChain struct {
+	IBlockchain
+}
+
+// NewMeteredChain returns chain with its imports counted in the metrics, the
+// network, the pool and the api should get this one
+func NewMeteredChain(chain IBlockchain) IBlockchain {
+	return meteredChain{chain}
+}
+
+func (c meteredChain) TryToConnect(block *Block) ImportResult {
+	start := time.Now()
+	res := c.IBlockchain.TryToConnect(block)
+	ObserveImport(start, res)
+	return res
+}
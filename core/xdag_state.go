@@ -7,6 +7,10 @@ type XdagState struct {
 	temp int
 }
 
+func (x XdagState) State() common.StateType {
+	return x.cmd
+}
+
 func (x *XdagState) SetState(s common.StateType) {
 	x.cmd = s
 }
@@ -0,0 +1,188 @@
+// Package dfslib is a Go port of the dfslib cryptography engine found in
+// clib/dfstools, used by the original XDAG pool and miner protocol.
+package dfslib
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+const (
+	magic0 uint32 = 572035291
+	magic1 uint32 = 2626708081
+	magic2 uint32 = 2471573851
+	magic3 uint32 = 3569250857
+	magic4 uint64 = 1971772241
+	magic5 uint32 = 1615037507
+	magic6 uint32 = 43385317
+	magic7 uint64 = 1229426917
+	magic8 uint64 = 3433359571
+
+	SECTOR_WORDS = 128 // a sector is 512 bytes
+)
+
+var (
+	ErrInvalidPassword = errors.New("dfslib: invalid password")
+	ErrNoPassword      = errors.New("dfslib: password not set")
+	ErrOddSize         = errors.New("dfslib: array size must be even")
+)
+
+// Crypt holds the state of dfslib_crypt
+type Crypt struct {
+	regs  [0x10000]uint32
+	pwd   [4]uint32
+	isPwd bool
+}
+
+// SetPassword sets the password, only code points of up to 3 utf8 bytes are
+// accepted as in dfslib_utf8_to_unicode
+func (c *Crypt) SetPassword(password string) error {
+	c.isPwd = false
+	c.pwd = [4]uint32{magic0, magic1, magic2, magic3}
+	for _, r := range password {
+		if r == utf8.RuneError || r > 0xffff {
+			return ErrInvalidPassword
+		}
+		res := uint64(r)
+		for i := 0; i < len(c.pwd); i++ {
+			res += uint64(c.pwd[i]) * magic4
+			c.pwd[i] = uint32(res)
+			res >>= 32
+		}
+	}
+	c.isPwd = len(password) > 0
+	return nil
+}
+
+func (c *Crypt) IsPassword() bool {
+	return c.isPwd
+}
+
+func (c *Crypt) f(x, y, z, t uint32) uint32 {
+	return uint32((uint64(y)*uint64(z+c.regs[x>>16]))>>16) ^ c.regs[uint16(t)]
+}
+
+func (c *Crypt) prepare(sectorNo uint64) (x, y, z, t uint32) {
+	sectorNo *= magic7<<32 | magic8
+	x = c.pwd[0] ^ c.regs[sectorNo%65479+31]
+	y = c.pwd[1] ^ c.regs[sectorNo%65497+11]
+	z = c.pwd[2] ^ c.regs[sectorNo%65519+5]
+	t = c.pwd[3] ^ c.regs[sectorNo%65521+3]
+	for i := 0; i < 8; i++ {
+		a := c.f(x, y, z, t)
+		b := c.f(y, z, t, x)
+		cc := c.f(z, t, x, y)
+		d := c.f(t, x, y, z)
+		x = c.f(a, b, cc, d)
+		y = c.f(b, cc, d, a)
+		z = c.f(cc, d, a, b)
+		t = c.f(d, a, b, cc)
+	}
+	return
+}
+
+func enmix(data []uint32) {
+	c := magic5
+	for i := len(data) - 1; i >= 0; i-- {
+		data[i] ^= c * magic6
+		c = data[i]
+	}
+}
+
+func unmix(data []uint32) {
+	c := magic5
+	for i := len(data) - 1; i >= 0; i-- {
+		c *= magic6
+		data[i] ^= c
+		c ^= data[i]
+	}
+}
+
+// encrypt runs dfs_encrypt3 over pairs of words, data may alias c.regs
+func (c *Crypt) encrypt(data []uint32, x, y, z, t uint32) {
+	for i := 0; i < len(data); i += 2 {
+		a := c.f(x, y, z, t) ^ ^data[i]
+		b := c.f(y, z, t, x)
+		cc := c.f(z, t, x, y)
+		d := c.f(t, x, y, z)
+		data[i] -= d
+		x = c.f(a, b, cc, d) ^ ^data[i+1]
+		y = c.f(b, cc, d, a)
+		z = c.f(cc, d, a, b)
+		t = c.f(d, a, b, cc)
+		data[i+1] -= t
+	}
+}
+
+func (c *Crypt) uncrypt(data []uint32, x, y, z, t uint32) {
+	for i := 0; i < len(data); i += 2 {
+		cc := c.f(z, t, x, y)
+		d := c.f(t, x, y, z)
+		data[i] += d
+		a := c.f(x, y, z, t) ^ ^data[i]
+		b := c.f(y, z, t, x)
+		z = c.f(cc, d, a, b)
+		t = c.f(d, a, b, cc)
+		data[i+1] += t
+		x = c.f(a, b, cc, d) ^ ^data[i+1]
+		y = c.f(b, cc, d, a)
+	}
+}
+
+// EncryptArray encrypts data in place, it is dfslib_encrypt_array
+func (c *Crypt) EncryptArray(data []uint32, sectorNo uint64) error {
+	if !c.isPwd {
+		return ErrNoPassword
+	}
+	if len(data)&1 != 0 {
+		return ErrOddSize
+	}
+	x, y, z, t := c.prepare(sectorNo)
+	enmix(data)
+	c.encrypt(data, x, y, z, t)
+	return nil
+}
+
+// UncryptArray decrypts data in place, it is dfslib_uncrypt_array
+func (c *Crypt) UncryptArray(data []uint32, sectorNo uint64) error {
+	if !c.isPwd {
+		return ErrNoPassword
+	}
+	if len(data)&1 != 0 {
+		return ErrOddSize
+	}
+	x, y, z, t := c.prepare(sectorNo)
+	c.uncrypt(data, x, y, z, t)
+	unmix(data)
+	return nil
+}
+
+// EncryptSector encrypts a 512 bytes sector, sector must hold SECTOR_WORDS words
+func (c *Crypt) EncryptSector(sector []uint32, sectorNo uint64) error {
+	return c.EncryptArray(sector[:SECTOR_WORDS], sectorNo)
+}
+
+func (c *Crypt) UncryptSector(sector []uint32, sectorNo uint64) error {
+	return c.UncryptArray(sector[:SECTOR_WORDS], sectorNo)
+}
+
+// SetSector0 fills the registers with rotations of sector and encrypts them
+func (c *Crypt) SetSector0(sector []byte) error {
+	if !c.isPwd {
+		return ErrNoPassword
+	}
+	var row [512]byte
+	for i := 0; i < 512; i++ {
+		copy(row[:], sector[i:512])
+		copy(row[512-i:], sector[:i])
+		words := c.regs[i*SECTOR_WORDS : (i+1)*SECTOR_WORDS]
+		BytesToWords(words, row[:])
+	}
+	for i := 0; i < 512; i++ {
+		words := c.regs[i*SECTOR_WORDS : (i+1)*SECTOR_WORDS]
+		x, y, z, t := c.prepare(uint64(i))
+		enmix(words)
+		c.encrypt(words, x, y, z, t)
+	}
+	return nil
+}
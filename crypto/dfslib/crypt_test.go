@@ -0,0 +1,53 @@
+package dfslib
+
+import (
+	"fmt"
+	"github.com/magiconair/properties/assert"
+	"testing"
+	"xdago/common"
+)
+
+func wordsHex(words []uint32) string {
+	var s string
+	for _, w := range words {
+		s += fmt.Sprintf("%08x", w)
+	}
+	return s
+}
+
+// expected values are produced by cryptStart and dfslibEncryptArray of
+// clib/dfstools
+func TestMinerCrypt(t *testing.T) {
+	c := MinerCrypt(common.MINERS_PWD)
+
+	var field [FIELD_WORDS]uint32
+	for i := range field {
+		field[i] = 0x01020304 * uint32(i+1)
+	}
+	assert.Equal(t, c.EncryptArray(field[:], 5), nil)
+	assert.Equal(t, wordsHex(field[:]), "bbe005fa5b37a234194d658a2392f22d35b4c77936eeebdae745b7df85c679bc")
+
+	var zero [FIELD_WORDS]uint32
+	assert.Equal(t, c.EncryptArray(zero[:], 123456789), nil)
+	assert.Equal(t, wordsHex(zero[:]), "ba39dac7a7579eaf8fcd0d6d0e95f1d06e3f418b903a1f15166cdcd29b383214")
+	assert.Equal(t, c.UncryptArray(zero[:], 123456789), nil)
+	assert.Equal(t, zero, [FIELD_WORDS]uint32{})
+}
+
+func TestEncryptFields(t *testing.T) {
+	c := MinerCrypt(common.MINERS_PWD)
+	data := make([]byte, 512)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	enc := append([]byte{}, data...)
+	assert.Equal(t, c.EncryptFields(enc, 16), uint64(32))
+	assert.Equal(t, string(enc) != string(data), true)
+	assert.Equal(t, c.DecryptFields(enc, 16), uint64(32))
+	assert.Equal(t, enc, data)
+
+	var empty Crypt
+	assert.Equal(t, empty.SetPassword(""), nil)
+	assert.Equal(t, empty.EncryptArray(make([]uint32, 8), 0), ErrNoPassword)
+	assert.Equal(t, c.EncryptArray(make([]uint32, 7), 0), ErrOddSize)
+}
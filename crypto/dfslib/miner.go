@@ -0,0 +1,88 @@
+package dfslib
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	SECTOR0_BASE   uint32 = 0x1947f3ac
+	SECTOR0_OFFSET uint32 = 0x82e9d1b5
+
+	FIELD_WORDS = 8 // a 32 bytes field
+)
+
+var (
+	minerCrypt     *Crypt
+	minerCryptOnce sync.Once
+)
+
+// NewCrypt creates the crypt used between pool and miners, the same as
+// cryptStart in clib/dfstools/wrapper.cpp
+func NewCrypt(password string) (*Crypt, error) {
+	c := new(Crypt)
+	if err := c.SetPassword(password); err != nil {
+		return nil, err
+	}
+	var sector0 [SECTOR_WORDS]uint32
+	for i := range sector0 {
+		sector0[i] = SECTOR0_BASE + uint32(i)*SECTOR0_OFFSET
+	}
+	var data [SECTOR_WORDS * 4]byte
+	for i := 0; i < SECTOR_WORDS; i++ {
+		WordsToBytes(data[:], sector0[:])
+		c.SetSector0(data[:])
+		c.EncryptSector(sector0[:], uint64(SECTOR0_BASE+uint32(i)*SECTOR0_OFFSET))
+	}
+	return c, nil
+}
+
+// MinerCrypt returns the shared crypt keyed with password, it is built once
+// since the setup takes a while
+func MinerCrypt(password string) *Crypt {
+	minerCryptOnce.Do(func() {
+		c, err := NewCrypt(password)
+		if err != nil {
+			panic(err)
+		}
+		minerCrypt = c
+	})
+	return minerCrypt
+}
+
+func BytesToWords(words []uint32, data []byte) {
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+}
+
+func WordsToBytes(data []byte, words []uint32) {
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(data[i*4:], w)
+	}
+}
+
+// EncryptFields encrypts data field by field, field i uses sector number
+// sectorNo+i. It returns the next sector number.
+func (c *Crypt) EncryptFields(data []byte, sectorNo uint64) uint64 {
+	var words [FIELD_WORDS]uint32
+	for pos := 0; pos+FIELD_WORDS*4 <= len(data); pos += FIELD_WORDS * 4 {
+		BytesToWords(words[:], data[pos:])
+		c.EncryptArray(words[:], sectorNo)
+		WordsToBytes(data[pos:], words[:])
+		sectorNo++
+	}
+	return sectorNo
+}
+
+// DecryptFields is the reverse of EncryptFields
+func (c *Crypt) DecryptFields(data []byte, sectorNo uint64) uint64 {
+	var words [FIELD_WORDS]uint32
+	for pos := 0; pos+FIELD_WORDS*4 <= len(data); pos += FIELD_WORDS * 4 {
+		BytesToWords(words[:], data[pos:])
+		c.UncryptArray(words[:], sectorNo)
+		WordsToBytes(data[pos:], words[:])
+		sectorNo++
+	}
+	return sectorNo
+}
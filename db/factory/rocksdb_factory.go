@@ -49,3 +49,12 @@ func (r *KvStoreFactory) Close() {
 		delete(r.databases, key)
 	}
 }
+
+// Sources returns the opened stores by name
+func (r *KvStoreFactory) Sources() map[common.DatabaseName]db.IKVSource {
+	res := make(map[common.DatabaseName]db.IKVSource, len(r.databases))
+	for name, kv := range r.databases {
+		res[name] = kv.(db.IKVSource)
+	}
+	return res
+}
@@ -17,9 +17,29 @@ type IKVSource interface {
 	Keys() [][]byte
 	PrefixKeyLookup(key []byte) [][]byte
 	FetchPrefix(key []byte, f FetchFunc)
+	// FetchRange calls f with the keys from start to before end in order, a
+	// nil end has no bound
+	FetchRange(start, end []byte, f FetchFunc)
 	PrefixValueLookup(key []byte) [][]byte
 }
 
+// IKVMetrics is a store reporting the internal metrics of its engine, e.g.
+// the memtable size, by name
+type IKVMetrics interface {
+	Metrics() map[string]float64
+}
+
+// IKVCompactor is a store compacting its whole key range on demand
+type IKVCompactor interface {
+	Compact() error
+}
+
+// IKVBatcher is a store writing many keys in one batch, a nil value deletes
+// its key
+type IKVBatcher interface {
+	WriteBatch(keys, values [][]byte)
+}
+
 type IDataFactory interface {
 	GetDB(name common.DatabaseName) *IKVSource
 	Close()
@@ -0,0 +1,158 @@
+//go:build pebble && !rocksdb
+
+package pebbledb
+
+import (
+	"fmt"
+	"github.com/cockroachdb/pebble"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"xdago/common"
+	"xdago/log"
+)
+
+// stores created before the format marker used the mvcc comparer
+const mvccFormatVersion = 0
+
+// keys copied per batch commit when a store is migrated
+const migrateBatchKeys = 10000
+
+func formatPath(dbPath string) string {
+	return filepath.Join(dbPath, common.STORE_FORMAT_FILE)
+}
+
+// readFormat returns the format version of the store at dbPath, a new store
+// gets the current version
+func readFormat(dbPath string) (int, error) {
+	b, err := os.ReadFile(formatPath(dbPath))
+	if err == nil {
+		version, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return 0, fmt.Errorf("illegal store format %q", strings.TrimSpace(string(b)))
+		}
+		return version, nil
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+	if _, err = os.Stat(filepath.Join(dbPath, "CURRENT")); os.IsNotExist(err) {
+		return common.STORE_FORMAT_VERSION, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return mvccFormatVersion, nil
+}
+
+// writeFormat marks the store at dbPath with the current format version
+func writeFormat(dbPath string) error {
+	if _, err := os.Stat(formatPath(dbPath)); err == nil {
+		return nil
+	}
+	return os.WriteFile(formatPath(dbPath), []byte(strconv.Itoa(common.STORE_FORMAT_VERSION)+"\n"), 0644)
+}
+
+// checkFormat brings the store at dbPath to the current format before it is
+// opened, a store of the mvcc comparer is copied once into a store of
+// bytewise ordered keys the prefix iterators assume.
+func checkFormat(dbPath string) error {
+	old := dbPath + ".mvcc"
+	if _, err := os.Stat(old); err == nil {
+		// a migration stopped before the old store was removed
+		if _, err = os.Stat(dbPath); os.IsNotExist(err) {
+			if err = os.Rename(old, dbPath); err != nil {
+				return err
+			}
+		} else if err = os.RemoveAll(old); err != nil {
+			return err
+		}
+	}
+
+	version, err := readFormat(dbPath)
+	if err != nil {
+		return err
+	}
+	switch version {
+	case common.STORE_FORMAT_VERSION:
+		return nil
+	case mvccFormatVersion:
+		return migrateMvcc(dbPath, old)
+	default:
+		return fmt.Errorf("store format %d is not supported, expect %d", version, common.STORE_FORMAT_VERSION)
+	}
+}
+
+// migrateMvcc copies the keys of the mvcc store at dbPath into a new store
+// and swaps it in, the old store is kept at old until the swap is done
+func migrateMvcc(dbPath, old string) error {
+	log.Info("migrate store to bytewise keys", log.Ctx{"path": dbPath})
+	tmp := dbPath + ".migrate"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	src, err := pebble.Open(dbPath, &pebble.Options{
+		Comparer: mvccComparer,
+		Merger:   &pebble.Merger{Name: "cockroach_merge_operator"},
+	})
+	if err != nil {
+		return err
+	}
+	count, err := copyStore(src, tmp)
+	if cerr := src.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err = writeFormat(tmp); err != nil {
+		return err
+	}
+	if err = os.Rename(dbPath, old); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, dbPath); err != nil {
+		return err
+	}
+	log.Info("store migrated", log.Ctx{"path": dbPath, "keys": count})
+	return os.RemoveAll(old)
+}
+
+func copyStore(src *pebble.DB, dstPath string) (int, error) {
+	dst, err := pebble.Open(dstPath, &pebble.Options{
+		Comparer: pebble.DefaultComparer,
+		Merger:   &pebble.Merger{Name: "cockroach_merge_operator"},
+	})
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	iter := src.NewIter(nil)
+	batch := dst.NewBatch()
+	for iter.First(); iter.Valid(); iter.Next() {
+		if err = batch.Set(iter.Key(), iter.Value(), nil); err != nil {
+			break
+		}
+		count++
+		if count%migrateBatchKeys == 0 {
+			if err = batch.Commit(pebble.Sync); err != nil {
+				break
+			}
+			batch = dst.NewBatch()
+		}
+	}
+	if err == nil {
+		err = iter.Error()
+	}
+	if err == nil {
+		err = batch.Commit(pebble.Sync)
+	}
+	if cerr := iter.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return count, err
+}
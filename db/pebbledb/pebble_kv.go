@@ -74,11 +74,12 @@ func (p *PebbleKv) Init() {
 		log.Crit("no name set to db")
 	}
 
+	dbPath := p.getPath()
 	cache := pebble.NewCache(128 << 20)
 	defer cache.Unref()
 	opts := &pebble.Options{
 		Cache:                       cache,
-		Comparer:                    mvccComparer,
+		Comparer:                    pebble.DefaultComparer,
 		DisableWAL:                  false,
 		FormatMajorVersion:          pebble.FormatNewest,
 		L0CompactionThreshold:       2,
@@ -119,18 +120,23 @@ func (p *PebbleKv) Init() {
 	//}
 	var err error
 	log.Info("Opening db", log.Ctx{"dbname": p.name})
-	dbPath := p.getPath()
 	dir := path.Dir(dbPath)
 	if _, err = os.Stat(dir); os.IsNotExist(err) {
 		if err = os.MkdirAll(dir, 0666); err != nil {
 			panic(err)
 		}
 	}
+	if err = checkFormat(dbPath); err != nil {
+		log.Crit("Failed to migrate db", log.Ctx{"dbname": p.name, "err": err.Error()})
+	}
 	log.Debug("Open existing db or create new db ", log.Ctx{"dbname": p.name})
 	p.db, err = pebble.Open(dbPath, opts)
 	if err != nil {
 		log.Crit("Failed to open db", log.Ctx{"dbname": p.name, "err": err.Error()})
 	}
+	if err = writeFormat(dbPath); err != nil {
+		log.Crit("Failed to mark db format", log.Ctx{"dbname": p.name, "err": err.Error()})
+	}
 	p.alive = true
 
 	log.Debug("<~ PebbleKVSource.init()", log.Ctx{"dbname": p.name})
@@ -145,6 +151,56 @@ func (p *PebbleKv) Reset() {
 	p.Init()
 }
 
+// Metrics returns the pebble metrics, nil when the db is closed
+func (p *PebbleKv) Metrics() map[string]float64 {
+	p.RLock()
+	defer p.RUnlock()
+	if !p.alive {
+		return nil
+	}
+	m := p.db.Metrics()
+	return map[string]float64{
+		"disk_usage_bytes":      float64(m.DiskSpaceUsage()),
+		"memtable_bytes":        float64(m.MemTable.Size),
+		"memtables":             float64(m.MemTable.Count),
+		"read_amplification":    float64(m.ReadAmp()),
+		"tables":                float64(m.Total().NumFiles),
+		"compactions":           float64(m.Compact.Count),
+		"compaction_debt_bytes": float64(m.Compact.EstimatedDebt),
+		"flushes":               float64(m.Flush.Count),
+		"wal_files":             float64(m.WAL.Files),
+		"wal_bytes":             float64(m.WAL.Size),
+		"block_cache_bytes":     float64(m.BlockCache.Size),
+		"block_cache_hits":      float64(m.BlockCache.Hits),
+		"block_cache_misses":    float64(m.BlockCache.Misses),
+		"snapshots":             float64(m.Snapshots.Count),
+	}
+}
+
+// Compact compacts the keys from the first to the last one
+func (p *PebbleKv) Compact() error {
+	p.RLock()
+	defer p.RUnlock()
+	if !p.alive {
+		return errors.New("db " + p.name + " is closed")
+	}
+	iter := p.db.NewIter(nil)
+	var first, last []byte
+	if iter.First() {
+		first = utils.Copy2(iter.Key())
+	}
+	if iter.Last() {
+		last = append(utils.Copy2(iter.Key()), 0)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	if first == nil {
+		return nil
+	}
+	return p.db.Compact(first, last, true)
+}
+
 func (p *PebbleKv) IsAlive() bool {
 	return p.alive
 }
@@ -172,6 +228,33 @@ func (p *PebbleKv) Put(key, val []byte) {
 		log.Ctx{"dbname": p.name, "key": hex.EncodeToString(key), "val": len(val)})
 }
 
+// WriteBatch writes the keys in one synced batch, a nil value deletes its key
+func (p *PebbleKv) WriteBatch(keys, values [][]byte) {
+	p.RLock()
+	defer p.RUnlock()
+
+	log.Trace("~> PebbleKVSource.writeBatch():", log.Ctx{"dbname": p.name, "keys": len(keys)})
+	b := p.db.NewBatch()
+	var err error
+	for i, key := range keys {
+		if values[i] != nil {
+			err = b.Set(key, values[i], nil)
+		} else {
+			err = b.Delete(key, nil)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = b.Commit(p.writeOpt)
+	}
+	if err != nil {
+		log.Crit("Failed to write batch into db", log.Ctx{"dbname": p.name, "err": err.Error()})
+	}
+	b.Close()
+}
+
 func (p *PebbleKv) Get(key []byte) []byte {
 	p.RLock()
 	defer p.RUnlock()
@@ -247,6 +330,21 @@ func (p *PebbleKv) FetchPrefix(key []byte, f db.FetchFunc) {
 	}
 }
 
+func (p *PebbleKv) FetchRange(start, end []byte, f db.FetchFunc) {
+	p.RLock()
+	defer p.RUnlock()
+
+	iter := p.db.NewIter(&pebble.IterOptions{LowerBound: start, UpperBound: end})
+	for iter.First(); iter.Valid(); iter.Next() {
+		if f(utils.Copy2(iter.Key()), utils.Copy2(iter.Value())) {
+			break
+		}
+	}
+	if err := iter.Close(); err != nil {
+		log.Crit("Failed to close range iterator", log.Ctx{"dbname": p.name, "err": err.Error()})
+	}
+}
+
 func (p *PebbleKv) PrefixKeyLookup(key []byte) [][]byte {
 	var keyList [][]byte
 	p.FetchPrefix(key, func(k, v []byte) bool {
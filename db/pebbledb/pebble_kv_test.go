@@ -0,0 +1,103 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package pebbledb
+
+import (
+	"github.com/cockroachdb/pebble"
+	"github.com/magiconair/properties/assert"
+	"os"
+	"path"
+	"testing"
+	"xdago/common"
+	"xdago/config"
+	"xdago/utils"
+)
+
+func TestStoreMigration(t *testing.T) {
+	c := &config.Config{}
+	c.SetStoreDir(t.TempDir())
+
+	// a store of the mvcc comparer without format marker is migrated once
+	keys := [][]byte{{7, 0, 3}, {7, 0, 1}, {7, 1, 0}, {7, 0, 2}, {1, 2, 0}}
+	old, err := pebble.Open(path.Join(c.StoreDir(), "old"), &pebble.Options{
+		Comparer: mvccComparer,
+		Merger:   &pebble.Merger{Name: "cockroach_merge_operator"},
+	})
+	assert.Equal(t, err, nil)
+	for _, k := range keys {
+		assert.Equal(t, old.Set(k, k, pebble.Sync), nil)
+	}
+	assert.Equal(t, old.Close(), nil)
+
+	for i := 0; i < 2; i++ {
+		kv := NewPebbleKv("old", 1)
+		kv.SetConfig(c)
+		kv.Init()
+		for _, k := range keys {
+			assert.Equal(t, kv.Get(k), k)
+		}
+		assert.Equal(t, kv.PrefixKeyLookup([]byte{7}), [][]byte{{7, 0, 1}, {7, 0, 2}, {7, 0, 3}, {7, 1, 0}})
+		kv.Close()
+		version, err := readFormat(kv.getPath())
+		assert.Equal(t, err, nil)
+		assert.Equal(t, version, common.STORE_FORMAT_VERSION)
+	}
+	_, err = os.Stat(path.Join(c.StoreDir(), "old.mvcc"))
+	assert.Equal(t, os.IsNotExist(err), true)
+
+	// a new store is marked with the current format
+	kv := NewPebbleKv("new", 1)
+	kv.SetConfig(c)
+	kv.Init()
+	kv.Put([]byte{1}, []byte{1})
+	kv.Close()
+	b, err := os.ReadFile(path.Join(c.StoreDir(), "new", common.STORE_FORMAT_FILE))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(b), "1\n")
+
+	// a format of a later version is refused
+	assert.Equal(t, os.WriteFile(path.Join(c.StoreDir(), "new", common.STORE_FORMAT_FILE), []byte("2\n"), 0644), nil)
+	assert.Equal(t, checkFormat(path.Join(c.StoreDir(), "new")) != nil, true)
+}
+
+func TestFetchRange(t *testing.T) {
+	c := &config.Config{}
+	c.SetStoreDir(t.TempDir())
+	kv := NewPebbleKv("range", 0)
+	kv.SetConfig(c)
+	kv.Init()
+	defer kv.Close()
+	for _, k := range [][]byte{{1}, {2, 1}, {2, 2, 5}, {2, 3}, {3}} {
+		kv.Put(k, k)
+	}
+	fetch := func(start, end []byte) [][]byte {
+		var keys [][]byte
+		kv.FetchRange(start, end, func(k, v []byte) bool {
+			keys = append(keys, k)
+			return false
+		})
+		return keys
+	}
+	assert.Equal(t, fetch([]byte{2, 2}, []byte{2, 3}), [][]byte{{2, 2, 5}})
+	assert.Equal(t, fetch([]byte{2}, utils.KeyPrefixEnd([]byte{2})), [][]byte{{2, 1}, {2, 2, 5}, {2, 3}})
+	assert.Equal(t, fetch([]byte{2, 3}, nil), [][]byte{{2, 3}, {3}})
+	assert.Equal(t, utils.KeyPrefixEnd([]byte{2, 0xff}), []byte{3})
+	assert.Equal(t, utils.KeyPrefixEnd([]byte{0xff}) == nil, true)
+}
+
+func TestWriteBatch(t *testing.T) {
+	c := &config.Config{}
+	c.SetStoreDir(t.TempDir())
+	kv := NewPebbleKv("batch", 0)
+	kv.SetConfig(c)
+	kv.Init()
+	defer kv.Close()
+	kv.Put([]byte{1}, []byte{1})
+	kv.WriteBatch([][]byte{{2}, {1}, {3}}, [][]byte{{2}, nil, {3}})
+	assert.Equal(t, kv.Get([]byte{1}) == nil, true)
+	assert.Equal(t, kv.Get([]byte{2}), []byte{2})
+	assert.Equal(t, kv.Get([]byte{3}), []byte{3})
+}
@@ -3,7 +3,9 @@
 package rocksdb
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"github.com/linxGnu/grocksdb"
 	"os"
 	"path"
@@ -129,6 +131,46 @@ func (p *RocksKv) Reset() {
 	p.Init()
 }
 
+// rocksdb properties reported by Metrics
+var rocksProperties = map[string]string{
+	"estimate_keys":            "rocksdb.estimate-num-keys",
+	"live_data_bytes":          "rocksdb.estimate-live-data-size",
+	"sst_bytes":                "rocksdb.total-sst-files-size",
+	"memtable_bytes":           "rocksdb.cur-size-all-mem-tables",
+	"running_compactions":      "rocksdb.num-running-compactions",
+	"running_flushes":          "rocksdb.num-running-flushes",
+	"pending_compaction_bytes": "rocksdb.estimate-pending-compaction-bytes",
+	"block_cache_bytes":        "rocksdb.block-cache-usage",
+	"snapshots":                "rocksdb.num-snapshots",
+}
+
+// Metrics returns the rocksdb properties, nil when the db is closed
+func (p *RocksKv) Metrics() map[string]float64 {
+	p.RLock()
+	defer p.RUnlock()
+	if !p.alive {
+		return nil
+	}
+	res := make(map[string]float64, len(rocksProperties))
+	for name, property := range rocksProperties {
+		if v, ok := p.db.GetIntProperty(property); ok {
+			res[name] = float64(v)
+		}
+	}
+	return res
+}
+
+// Compact compacts the whole key range
+func (p *RocksKv) Compact() error {
+	p.RLock()
+	defer p.RUnlock()
+	if !p.alive {
+		return errors.New("db " + p.name + " is closed")
+	}
+	p.db.CompactRange(grocksdb.Range{})
+	return nil
+}
+
 func (p *RocksKv) IsAlive() bool {
 	return p.alive
 }
@@ -157,6 +199,26 @@ func (p *RocksKv) Put(key, val []byte) {
 
 }
 
+// WriteBatch writes the keys in one batch, a nil value deletes its key
+func (p *RocksKv) WriteBatch(keys, values [][]byte) {
+	p.RLock()
+	defer p.RUnlock()
+
+	log.Trace("~> RocksdbKVSource.writeBatch():", log.Ctx{"dbname": p.name, "keys": len(keys)})
+	b := grocksdb.NewWriteBatch()
+	defer b.Destroy()
+	for i, key := range keys {
+		if values[i] != nil {
+			b.Put(key, values[i])
+		} else {
+			b.Delete(key)
+		}
+	}
+	if err := p.db.Write(p.writeOpt, b); err != nil {
+		log.Crit("Failed to write batch into db", log.Ctx{"dbname": p.name, "err": err.Error()})
+	}
+}
+
 func (p *RocksKv) Get(key []byte) []byte {
 	p.RLock()
 	defer p.RUnlock()
@@ -221,6 +283,22 @@ func (p *RocksKv) FetchPrefix(key []byte, f db.FetchFunc) {
 	}
 }
 
+func (p *RocksKv) FetchRange(start, end []byte, f db.FetchFunc) {
+	p.RLock()
+	defer p.RUnlock()
+
+	iter := p.db.NewIterator(p.readOpt)
+	defer iter.Close()
+	for iter.Seek(start); iter.Valid(); iter.Next() {
+		if end != nil && bytes.Compare(iter.Key().Data(), end) >= 0 {
+			return
+		}
+		if f(iter.Key().Data(), iter.Value().Data()) {
+			return
+		}
+	}
+}
+
 func (p *RocksKv) PrefixKeyLookup(key []byte) [][]byte {
 	var keyList [][]byte
 	p.FetchPrefix(key, func(k, v []byte) bool {
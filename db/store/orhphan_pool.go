@@ -84,6 +84,14 @@ func (p *OrphanPool) AddOrphan(block *core.Block) {
 	p.orphanSource.Put(ORPHAN_SIZE, utils.U64ToBytes(curSize+1, binary.BigEndian))
 }
 
+// Size returns the number of the orphan blocks
+func (p *OrphanPool) Size() uint64 {
+	if p.orphanSource.Get(ORPHAN_SIZE) == nil {
+		return 0
+	}
+	return p.getOrphanSize()
+}
+
 func (p *OrphanPool) getOrphanSize() uint64 {
 	curSize := binary.BigEndian.Uint64(p.orphanSource.Get(ORPHAN_SIZE))
 	log.Debug("current orphan size",
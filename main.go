@@ -1,5 +1,10 @@
 package main
 
-func main() {
+import (
+	"os"
+	"xdago/cli"
+)
 
+func main() {
+	os.Exit(cli.NewApp().Run(os.Args[1:]))
 }
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Histogram counts the observations per bucket
+type Histogram struct {
+	sync.Mutex
+	buckets []float64 // upper bounds, the +Inf one is implicit
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.Lock()
+	defer h.Unlock()
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer, name, label, value string) {
+	h.Lock()
+	defer h.Unlock()
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(label, value, "le", formatFloat(le)), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(label, value, "le", "+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels(label, value), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels(label, value), h.count)
+}
+
+// HistogramVec is a histogram per value of a label
+type HistogramVec struct {
+	sync.RWMutex
+	name       string
+	help       string
+	label      string
+	buckets    []float64
+	histograms map[string]*Histogram
+}
+
+// NewHistogramVec returns the histograms of the sorted buckets
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	return &HistogramVec{name: name, help: help, label: label, buckets: buckets,
+		histograms: make(map[string]*Histogram)}
+}
+
+func (v *HistogramVec) Name() string {
+	return v.name
+}
+
+// With returns the histogram of the label value
+func (v *HistogramVec) With(value string) *Histogram {
+	v.RLock()
+	h, ok := v.histograms[value]
+	v.RUnlock()
+	if ok {
+		return h
+	}
+	v.Lock()
+	defer v.Unlock()
+	if h, ok = v.histograms[value]; !ok {
+		h = newHistogram(v.buckets)
+		v.histograms[value] = h
+	}
+	return h
+}
+
+func (v *HistogramVec) Write(w io.Writer) {
+	writeHeader(w, v.name, v.help, "histogram")
+	v.RLock()
+	defer v.RUnlock()
+	keys := make([]string, 0, len(v.histograms))
+	for value := range v.histograms {
+		keys = append(keys, value)
+	}
+	sort.Strings(keys)
+	for _, value := range keys {
+		v.histograms[value].write(w, v.name, v.label, value)
+	}
+}
@@ -0,0 +1,233 @@
+// Package metrics keeps the counters, gauges and histograms of the node and
+// writes them in the prometheus text format. The packages register their
+// metrics in DefaultRegistry, net/monitor serves it on /metrics.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the upper bounds in seconds of the latency histograms
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Metric is a family of samples sharing a name
+type Metric interface {
+	Name() string
+	Write(w io.Writer)
+}
+
+type Registry struct {
+	sync.RWMutex
+	metrics map[string]Metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]Metric)}
+}
+
+var DefaultRegistry = NewRegistry()
+
+// Register adds m to DefaultRegistry
+func Register(m Metric) {
+	DefaultRegistry.Register(m)
+}
+
+// Register adds m, a metric of the same name is replaced
+func (r *Registry) Register(m Metric) {
+	r.Lock()
+	defer r.Unlock()
+	r.metrics[m.Name()] = m
+}
+
+func (r *Registry) Unregister(name string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.metrics, name)
+}
+
+// Write writes the metrics ordered by name
+func (r *Registry) Write(w io.Writer) {
+	r.RLock()
+	list := make([]Metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		list = append(list, m)
+	}
+	r.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	for _, m := range list {
+		m.Write(w)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats the label pairs, names and values alternate
+func labels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i] + `="` + labelEscaper.Replace(pairs[i+1]) + `"`)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "{" + b.String() + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter only goes up
+type Counter struct {
+	name  string
+	help  string
+	value uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	return &Counter{name: name, help: help}
+}
+
+func (c *Counter) Name() string {
+	return c.name
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+func (c *Counter) Write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.name, c.Value())
+}
+
+// CounterVec is a counter per value of a label
+type CounterVec struct {
+	sync.RWMutex
+	name     string
+	help     string
+	label    string
+	counters map[string]*Counter
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	return &CounterVec{name: name, help: help, label: label, counters: make(map[string]*Counter)}
+}
+
+func (v *CounterVec) Name() string {
+	return v.name
+}
+
+// With returns the counter of the label value
+func (v *CounterVec) With(value string) *Counter {
+	v.RLock()
+	c, ok := v.counters[value]
+	v.RUnlock()
+	if ok {
+		return c
+	}
+	v.Lock()
+	defer v.Unlock()
+	if c, ok = v.counters[value]; !ok {
+		c = &Counter{}
+		v.counters[value] = c
+	}
+	return c
+}
+
+func (v *CounterVec) Write(w io.Writer) {
+	writeHeader(w, v.name, v.help, "counter")
+	v.RLock()
+	defer v.RUnlock()
+	keys := make([]string, 0, len(v.counters))
+	for value := range v.counters {
+		keys = append(keys, value)
+	}
+	sort.Strings(keys)
+	for _, value := range keys {
+		fmt.Fprintf(w, "%s%s %d\n", v.name, labels(v.label, value), v.counters[value].Value())
+	}
+}
+
+// GaugeFunc is a gauge read when the metrics are written
+type GaugeFunc struct {
+	name string
+	help string
+	f    func() float64
+}
+
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	return &GaugeFunc{name: name, help: help, f: f}
+}
+
+func (g *GaugeFunc) Name() string {
+	return g.name
+}
+
+func (g *GaugeFunc) Write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+}
+
+// GaugeVecFunc is a gauge per value of a label, read when the metrics are
+// written
+type GaugeVecFunc struct {
+	name  string
+	help  string
+	label string
+	f     func() map[string]float64
+}
+
+func NewGaugeVecFunc(name, help, label string, f func() map[string]float64) *GaugeVecFunc {
+	return &GaugeVecFunc{name: name, help: help, label: label, f: f}
+}
+
+func (g *GaugeVecFunc) Name() string {
+	return g.name
+}
+
+func (g *GaugeVecFunc) Write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	values := g.f()
+	keys := make([]string, 0, len(values))
+	for value := range values {
+		keys = append(keys, value)
+	}
+	sort.Strings(keys)
+	for _, value := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labels(g.label, value), formatFloat(values[value]))
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/magiconair/properties/assert"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter("b_total", "a counter")
+	c.Inc()
+	c.Add(2)
+	v := NewCounterVec("a_total", "a counter\nvec", "kind")
+	v.With(`x"y`).Inc()
+	v.With("b").Add(5)
+	r.Register(c)
+	r.Register(v)
+	r.Register(NewGaugeFunc("c", "a gauge", func() float64 { return 1.5 }))
+	r.Register(NewGaugeVecFunc("d", "a gauge vec", "state", func() map[string]float64 {
+		return map[string]float64{"SYNC": 1}
+	}))
+	r.Register(NewGaugeFunc("e", "replaced", func() float64 { return 0 }))
+	r.Unregister("e")
+
+	var b bytes.Buffer
+	r.Write(&b)
+	assert.Equal(t, b.String(), `# HELP a_total a counter vec
+# TYPE a_total counter
+a_total{kind="b"} 5
+a_total{kind="x\"y"} 1
+# HELP b_total a counter
+# TYPE b_total counter
+b_total 3
+# HELP c a gauge
+# TYPE c gauge
+c 1.5
+# HELP d a gauge vec
+# TYPE d gauge
+d{state="SYNC"} 1
+`)
+}
+
+func TestHistogram(t *testing.T) {
+	v := NewHistogramVec("import_seconds", "import time", "status", []float64{0.1, 1})
+	h := v.With("best")
+	for _, x := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(x)
+	}
+	assert.Equal(t, v.With("best").Count(), uint64(4))
+
+	var b bytes.Buffer
+	v.Write(&b)
+	assert.Equal(t, b.String(), `# HELP import_seconds import time
+# TYPE import_seconds histogram
+import_seconds_bucket{status="best",le="0.1"} 2
+import_seconds_bucket{status="best",le="1"} 3
+import_seconds_bucket{status="best",le="+Inf"} 4
+import_seconds_sum{status="best"} 3.65
+import_seconds_count{status="best"} 4
+`)
+}
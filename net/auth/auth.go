@@ -0,0 +1,179 @@
+// Package auth is the access control of the rpc and admin listeners. A
+// caller is identified by a bearer token or a user and password of the
+// config credentials, it may call the methods of its allowlist at its rate
+// limit. The listeners are wrapped in tls when a cert and key are set.
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"xdago/config"
+)
+
+const ALL = "*" // all the methods
+
+var (
+	ErrUnauthorized = errors.New("auth: unauthorized")
+	ErrTlsConfig    = errors.New("auth: tls cert and key must be set together")
+)
+
+// Groups maps a group name of the credential methods to its methods
+type Groups map[string][]string
+
+// Identity is an authenticated caller
+type Identity struct {
+	Name    string
+	all     bool
+	methods map[string]bool
+	limiter *Limiter
+}
+
+func newIdentity(cred config.Credential, groups Groups) *Identity {
+	id := &Identity{Name: cred.Name, methods: make(map[string]bool)}
+	for _, m := range cred.Methods {
+		if m == ALL {
+			id.all = true
+		} else if list, ok := groups[m]; ok {
+			for _, g := range list {
+				id.methods[g] = true
+			}
+		} else {
+			id.methods[m] = true
+		}
+	}
+	if cred.RateLimit > 0 {
+		id.limiter = NewLimiter(cred.RateLimit, cred.Burst)
+	}
+	return id
+}
+
+// Allowed tells if the caller may call method
+func (id *Identity) Allowed(method string) bool {
+	return id.all || id.methods[method]
+}
+
+// Allow takes a call from the rate limit of the caller
+func (id *Identity) Allow() bool {
+	return id.limiter == nil || id.limiter.Allow()
+}
+
+type entry struct {
+	token    [32]byte
+	user     [32]byte
+	password [32]byte
+	hasToken bool
+	hasUser  bool
+	id       *Identity
+}
+
+// Authenticator finds the identity of the credentials
+type Authenticator struct {
+	entries   []entry
+	anonymous *Identity
+}
+
+// NewAuthenticator builds the identities of creds. The callers without
+// credentials get anonymous, they are refused when it allows no method.
+func NewAuthenticator(creds []config.Credential, anonymous config.Credential, groups Groups) *Authenticator {
+	a := &Authenticator{}
+	for _, cred := range creds {
+		e := entry{id: newIdentity(cred, groups)}
+		if cred.Token != "" {
+			e.token = sha256.Sum256([]byte(cred.Token))
+			e.hasToken = true
+		}
+		if cred.User != "" {
+			e.user = sha256.Sum256([]byte(cred.User))
+			e.password = sha256.Sum256([]byte(cred.Password))
+			e.hasUser = true
+		}
+		a.entries = append(a.entries, e)
+	}
+	if len(anonymous.Methods) > 0 {
+		a.anonymous = newIdentity(anonymous, groups)
+	}
+	return a
+}
+
+// Len returns the number of credentials
+func (a *Authenticator) Len() int {
+	return len(a.entries)
+}
+
+// Anonymous returns the identity of the callers without credentials, nil
+// when they are refused
+func (a *Authenticator) Anonymous() *Identity {
+	return a.anonymous
+}
+
+// Token returns the identity of a bearer token, nil when it is unknown. All
+// the entries are compared so the time doesn't tell which one matched.
+func (a *Authenticator) Token(token string) *Identity {
+	h := sha256.Sum256([]byte(token))
+	var found *Identity
+	for i := range a.entries {
+		e := &a.entries[i]
+		if e.hasToken && subtle.ConstantTimeCompare(h[:], e.token[:]) == 1 {
+			found = e.id
+		}
+	}
+	return found
+}
+
+// Basic returns the identity of a user and password, nil when they are wrong
+func (a *Authenticator) Basic(user, password string) *Identity {
+	u := sha256.Sum256([]byte(user))
+	p := sha256.Sum256([]byte(password))
+	var found *Identity
+	for i := range a.entries {
+		e := &a.entries[i]
+		if e.hasUser && subtle.ConstantTimeCompare(u[:], e.user[:])&subtle.ConstantTimeCompare(p[:], e.password[:]) == 1 {
+			found = e.id
+		}
+	}
+	return found
+}
+
+// Request returns the identity of the Authorization header of r, the
+// anonymous one without the header
+func (a *Authenticator) Request(r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	var id *Identity
+	switch {
+	case header == "":
+		id = a.anonymous
+	case len(header) > 7 && strings.EqualFold(header[:7], "Bearer "):
+		id = a.Token(strings.TrimSpace(header[7:]))
+	default:
+		if user, password, ok := r.BasicAuth(); ok {
+			id = a.Basic(user, password)
+		}
+	}
+	if id == nil {
+		return nil, ErrUnauthorized
+	}
+	return id, nil
+}
+
+// Listen listens on address, in tls when cert and key are set
+func Listen(address, cert, key string) (net.Listener, error) {
+	if cert == "" && key == "" {
+		return net.Listen("tcp", address)
+	}
+	if cert == "" || key == "" {
+		return nil, ErrTlsConfig
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", address, &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   tls.VersionTLS12,
+	})
+}
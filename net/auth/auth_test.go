@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/magiconair/properties/assert"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"xdago/config"
+)
+
+var testGroups = Groups{
+	"read":   {"get", "list"},
+	"wallet": {"send"},
+}
+
+func TestAuthenticator(t *testing.T) {
+	a := NewAuthenticator([]config.Credential{
+		{Name: "reader", Token: "t1", Methods: []string{"read"}},
+		{Name: "payer", User: "alice", Password: "pw", Methods: []string{"read", "wallet", "status"}},
+		{Name: "root", Token: "t2", Methods: []string{ALL}},
+	}, config.Credential{}, testGroups)
+	assert.Equal(t, a.Len(), 3)
+	assert.Equal(t, a.Anonymous() == nil, true)
+
+	reader := a.Token("t1")
+	assert.Equal(t, reader.Name, "reader")
+	assert.Equal(t, reader.Allowed("list"), true)
+	assert.Equal(t, reader.Allowed("send"), false)
+	assert.Equal(t, a.Token("t3") == nil, true)
+	assert.Equal(t, a.Token("") == nil, true)
+
+	payer := a.Basic("alice", "pw")
+	assert.Equal(t, payer.Name, "payer")
+	assert.Equal(t, payer.Allowed("send"), true)
+	assert.Equal(t, payer.Allowed("status"), true)
+	assert.Equal(t, payer.Allowed("stop"), false)
+	assert.Equal(t, a.Basic("alice", "") == nil, true)
+	assert.Equal(t, a.Basic("", "") == nil, true)
+	assert.Equal(t, a.Token("t2").Allowed("stop"), true)
+
+	r, _ := http.NewRequest(http.MethodPost, "/", nil)
+	_, err := a.Request(r)
+	assert.Equal(t, err, ErrUnauthorized)
+	r.Header.Set("Authorization", "bearer t1")
+	id, err := a.Request(r)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, id, reader)
+	r.SetBasicAuth("alice", "pw")
+	id, _ = a.Request(r)
+	assert.Equal(t, id, payer)
+	r.SetBasicAuth("alice", "t1")
+	_, err = a.Request(r)
+	assert.Equal(t, err, ErrUnauthorized)
+
+	a = NewAuthenticator(nil, config.Credential{Name: "anonymous", Methods: []string{"read"}}, testGroups)
+	r.Header.Del("Authorization")
+	id, err = a.Request(r)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, id.Name, "anonymous")
+	assert.Equal(t, id.Allowed("get"), true)
+	assert.Equal(t, id.Allowed("send"), false)
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(2, 3)
+	l.now = func() time.Time { return now }
+	l.last = now
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, l.Allow(), true)
+	}
+	assert.Equal(t, l.Allow(), false)
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, l.Allow(), true)
+	assert.Equal(t, l.Allow(), false)
+	// the bucket holds the burst at most
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, l.Allow(), true)
+	}
+	assert.Equal(t, l.Allow(), false)
+
+	assert.Equal(t, NewLimiter(0.5, 0).burst, float64(1))
+	assert.Equal(t, NewLimiter(2.5, 0).burst, float64(3))
+}
+
+// writeCert writes a self signed cert of 127.0.0.1 and its key
+func writeCert(t *testing.T) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Equal(t, err, nil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "xdag"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Equal(t, err, nil)
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Equal(t, err, nil)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile, cert
+}
+
+func TestListen(t *testing.T) {
+	certFile, keyFile, cert := writeCert(t)
+	_, err := Listen("127.0.0.1:0", certFile, "")
+	assert.Equal(t, err, ErrTlsConfig)
+
+	l, err := Listen("127.0.0.1:0", certFile, keyFile)
+	assert.Equal(t, err, nil)
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.Write([]byte("hi"))
+		c.Close()
+	}()
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	c, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots})
+	assert.Equal(t, err, nil)
+	defer c.Close()
+	buf := make([]byte, 2)
+	_, err = c.Read(buf)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(buf), "hi")
+
+	l, err = Listen("127.0.0.1:0", "", "")
+	assert.Equal(t, err, nil)
+	l.Close()
+}
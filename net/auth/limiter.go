@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket refilled at rate tokens per second up to burst
+type Limiter struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewLimiter returns a full bucket, burst defaults to the rate rounded up
+func NewLimiter(rate float64, burst int) *Limiter {
+	b := float64(burst)
+	if burst <= 0 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &Limiter{rate: rate, burst: b, tokens: b, last: time.Now(), now: time.Now}
+}
+
+// Allow takes a token, it returns false when the bucket is empty
+func (l *Limiter) Allow() bool {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,232 @@
+// Package monitor serves the metrics of the node in the prometheus text
+// format on /metrics and the sync state on /healthz, it listens on
+// metrics.host:port. The chain, the peers, the pool and the stores are
+// read when the metrics are scraped.
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/core"
+	"xdago/db"
+	"xdago/log"
+	"xdago/metrics"
+	"xdago/net/node"
+)
+
+const HTTP_TIMEOUT = 10 * time.Second
+
+var ErrMetricsDisabled = errors.New("monitor: metrics.enabled is false")
+
+// Chain is the part of core.IBlockchain shown by the metrics
+type Chain interface {
+	GetXDAGStats() *core.XDAGStats
+}
+
+// OrphanPool is the orphan block pool of the store
+type OrphanPool interface {
+	Size() uint64
+}
+
+// Health is the body of /healthz
+type Health struct {
+	State   string `json:"state"`
+	Synced  bool   `json:"synced"`
+	Message string `json:"message"`
+}
+
+type Server struct {
+	sync.Mutex
+	config   *config.Config
+	registry *metrics.Registry
+	state    *core.XdagState
+	http     *http.Server
+}
+
+// NewServer serves the metrics of registry, usually metrics.DefaultRegistry
+// holding the import and share counters
+func NewServer(config *config.Config, registry *metrics.Registry) *Server {
+	s := &Server{config: config, registry: registry}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	mux.HandleFunc("/healthz", s.serveHealth)
+	s.http = &http.Server{Handler: mux, ReadTimeout: HTTP_TIMEOUT, WriteTimeout: HTTP_TIMEOUT}
+	return s
+}
+
+func difficulty(d *big.Int) float64 {
+	if d == nil {
+		return 0
+	}
+	f, _ := new(big.Float).SetInt(d).Float64()
+	return f
+}
+
+// SetChain adds the chain statistics
+func (s *Server) SetChain(chain Chain) {
+	stat := func(name, help string, f func(st *core.XDAGStats) float64) {
+		s.registry.Register(metrics.NewGaugeFunc(name, help, func() float64 { return f(chain.GetXDAGStats()) }))
+	}
+	stat("xdag_blocks", "blocks known by the node", func(st *core.XDAGStats) float64 { return float64(st.NBlocks) })
+	stat("xdag_main_blocks", "main blocks known by the node", func(st *core.XDAGStats) float64 { return float64(st.NMain) })
+	stat("xdag_extra_blocks", "extra blocks waiting to be linked", func(st *core.XDAGStats) float64 { return float64(st.NExtra) })
+	stat("xdag_noref_blocks", "blocks not referenced by other blocks", func(st *core.XDAGStats) float64 { return float64(st.NnoRef) })
+	stat("xdag_network_blocks", "blocks of the network", func(st *core.XDAGStats) float64 { return float64(st.TotalNBlocks) })
+	stat("xdag_network_main_blocks", "main blocks of the network", func(st *core.XDAGStats) float64 { return float64(st.TotalNMain) })
+	stat("xdag_difficulty", "difficulty of the top main chain", func(st *core.XDAGStats) float64 { return difficulty(st.Difficulty) })
+}
+
+// SetState adds the node state and answers /healthz with it
+func (s *Server) SetState(state *core.XdagState) {
+	s.Lock()
+	s.state = state
+	s.Unlock()
+	s.registry.Register(metrics.NewGaugeVecFunc("xdag_state", "the state of the node is 1", "state", func() map[string]float64 {
+		return map[string]float64{state.State().String(): 1}
+	}))
+	s.registry.Register(metrics.NewGaugeFunc("xdag_synced", "1 when the node is synchronized", func() float64 {
+		if state.State().IsSynced() {
+			return 1
+		}
+		return 0
+	}))
+}
+
+// SetPeers adds the peer counts
+func (s *Server) SetPeers(peers *node.PeerManager) {
+	s.registry.Register(metrics.NewGaugeVecFunc("xdag_peers", "connected peers", "direction", func() map[string]float64 {
+		inbound, outbound := peers.PeerCount()
+		return map[string]float64{"inbound": float64(inbound), "outbound": float64(outbound)}
+	}))
+}
+
+// SetOrphanPool adds the orphan pool size
+func (s *Server) SetOrphanPool(pool OrphanPool) {
+	s.registry.Register(metrics.NewGaugeFunc("xdag_orphan_blocks", "blocks in the orphan pool", func() float64 {
+		return float64(pool.Size())
+	}))
+}
+
+// SetMinerRegistry adds the pool miners, the shares are counted by the registry
+func (s *Server) SetMinerRegistry(registry *consensus.MinerRegistry) {
+	miners := func(f func(m consensus.MinerInfo) float64) func() float64 {
+		return func() float64 {
+			var sum float64
+			for _, m := range registry.Miners() {
+				sum += f(m)
+			}
+			return sum
+		}
+	}
+	s.registry.Register(metrics.NewGaugeFunc("xdag_pool_miners", "miner addresses with recent shares",
+		miners(func(consensus.MinerInfo) float64 { return 1 })))
+	s.registry.Register(metrics.NewGaugeFunc("xdag_pool_workers", "workers with shares in the current epoch",
+		miners(func(m consensus.MinerInfo) float64 { return float64(len(m.Workers)) })))
+	s.registry.Register(metrics.NewGaugeFunc("xdag_pool_hashrate", "hashes per second of the pool miners",
+		miners(func(m consensus.MinerInfo) float64 { return m.Hashrate })))
+}
+
+// SetStores adds the engine metrics of the stores implementing db.IKVMetrics
+func (s *Server) SetStores(sources map[common.DatabaseName]db.IKVSource) {
+	s.registry.Register(&kvMetrics{sources: sources})
+}
+
+// kvMetrics writes a gauge xdag_kv_<name>{db=...} per engine metric
+type kvMetrics struct {
+	sources map[common.DatabaseName]db.IKVSource
+}
+
+func (k *kvMetrics) Name() string {
+	return "xdag_kv"
+}
+
+func (k *kvMetrics) Write(w io.Writer) {
+	values := make(map[string]map[string]float64) // metric -> db -> value
+	for name, source := range k.sources {
+		m, ok := source.(db.IKVMetrics)
+		if !ok {
+			continue
+		}
+		for metric, v := range m.Metrics() {
+			if values[metric] == nil {
+				values[metric] = make(map[string]float64)
+			}
+			values[metric][name.String()] = v
+		}
+	}
+	names := make([]string, 0, len(values))
+	for metric := range values {
+		names = append(names, metric)
+	}
+	sort.Strings(names)
+	for _, metric := range names {
+		dbs := values[metric]
+		metrics.NewGaugeVecFunc("xdag_kv_"+metric, "kv store engine metric "+metric, "db",
+			func() map[string]float64 { return dbs }).Write(w)
+	}
+}
+
+// Start listens on metrics.host:port
+func (s *Server) Start() error {
+	if !s.config.MetricsEnabled() {
+		return ErrMetricsDisabled
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(s.config.MetricsHost(), strconv.Itoa(s.config.MetricsPort())))
+	if err != nil {
+		return err
+	}
+	s.Serve(l)
+	return nil
+}
+
+// Serve answers the scrapes on l in the background
+func (s *Server) Serve(l net.Listener) {
+	log.Info("metrics server started", log.Ctx{"address": l.Addr().String()})
+	go func() {
+		if err := s.http.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Error("metrics server failed", log.Ctx{"err": err.Error()})
+		}
+	}()
+}
+
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.http.Shutdown(ctx)
+	log.Info("metrics server stopped")
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.registry.Write(w)
+}
+
+// serveHealth answers 200 when the node is synchronized, 503 otherwise
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	state := s.state
+	s.Unlock()
+	h := Health{State: common.INIT.String(), Message: "no state"}
+	if state != nil {
+		h = Health{State: state.State().String(), Synced: state.State().IsSynced(), Message: state.ToString()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !h.Synced {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(&h); err != nil {
+		log.Debug("write health failed", log.Ctx{"err": err.Error()})
+	}
+}
@@ -0,0 +1,135 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package monitor
+
+import (
+	"encoding/json"
+	"github.com/magiconair/properties/assert"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/core"
+	"xdago/db/factory"
+	"xdago/metrics"
+	"xdago/net/node"
+)
+
+type fakeChain struct {
+	core.IBlockchain
+	status common.ImportStatus
+}
+
+func (c *fakeChain) GetXDAGStats() *core.XDAGStats {
+	return &core.XDAGStats{Difficulty: big.NewInt(0x10000), NBlocks: 12, NMain: 5, NExtra: 3, NnoRef: 2, TotalNMain: 7}
+}
+
+func (c *fakeChain) TryToConnect(block *core.Block) core.ImportResult {
+	return core.ImportResult{Status: c.status}
+}
+
+type fakeOrphans uint64
+
+func (o fakeOrphans) Size() uint64 {
+	return uint64(o)
+}
+
+func get(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	assert.Equal(t, err, nil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.Equal(t, err, nil)
+	return resp.StatusCode, string(body)
+}
+
+func TestMonitor(t *testing.T) {
+	c := &config.Config{}
+	c.SetStoreDir(t.TempDir())
+	s := NewServer(c, metrics.DefaultRegistry)
+	assert.Equal(t, s.Start(), ErrMetricsDisabled)
+
+	chain := &fakeChain{}
+	s.SetChain(chain)
+	state := &core.XdagState{}
+	state.SetState(common.CONN)
+	s.SetState(state)
+	peers := node.NewPeerManager(c)
+	peers.AddPeer(node.NewNode("10.0.0.1", 8001), true)
+	peers.AddPeer(node.NewNode("10.0.0.2", 8001), false)
+	peers.AddPeer(node.NewNode("10.0.0.3", 8001), false)
+	s.SetPeers(peers)
+	s.SetOrphanPool(fakeOrphans(4))
+
+	kvFactory := factory.NewKvStoreFactory(c)
+	source := kvFactory.GetDB(common.DB_MINERS)
+	source.Init()
+	defer kvFactory.Close()
+	registry := consensus.NewMinerRegistry(source)
+	registry.AddShare(consensus.Share{Account: "miner", Worker: "rig1", Difficulty: big.NewInt(1 << 40), Time: time.Now()})
+	registry.Add(consensus.Share{Account: "miner", Worker: "rig1", Time: time.Now()}, consensus.SHARE_STALE)
+	s.SetMinerRegistry(registry)
+	s.SetStores(kvFactory.Sources())
+
+	metered := core.NewMeteredChain(chain)
+	chain.status = common.IMPORTED_BEST
+	metered.TryToConnect(nil)
+	metered.TryToConnect(nil)
+	chain.status = common.NO_PARENT
+	metered.TryToConnect(nil)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	s.Serve(l)
+	defer s.Stop()
+	url := "http://" + l.Addr().String()
+
+	code, body := get(t, url+"/metrics")
+	assert.Equal(t, code, http.StatusOK)
+	for _, line := range []string{
+		"xdag_blocks 12",
+		"xdag_main_blocks 5",
+		"xdag_extra_blocks 3",
+		"xdag_noref_blocks 2",
+		"xdag_network_main_blocks 7",
+		"xdag_difficulty 65536",
+		`xdag_state{state="CONN"} 1`,
+		"xdag_synced 0",
+		`xdag_peers{direction="inbound"} 1`,
+		`xdag_peers{direction="outbound"} 2`,
+		"xdag_orphan_blocks 4",
+		"xdag_pool_miners 1",
+		"xdag_pool_workers 1",
+		`xdag_pool_shares_total{status="accepted"} 1`,
+		`xdag_pool_shares_total{status="stale"} 1`,
+		`xdag_block_import_seconds_count{status="imported_best"} 2`,
+		`xdag_block_import_seconds_count{status="no_parent"} 1`,
+		"# TYPE xdag_block_import_seconds histogram",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("%q not in the metrics", line)
+		}
+	}
+	assert.Equal(t, strings.Contains(body, `xdag_kv_disk_usage_bytes{db="miners"}`), true)
+
+	var h Health
+	code, body = get(t, url+"/healthz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, json.Unmarshal([]byte(body), &h), nil)
+	assert.Equal(t, h, Health{State: "CONN", Message: "Connected to the main network. Synchronizing."})
+	state.SetState(common.SYNC)
+	code, body = get(t, url+"/healthz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, json.Unmarshal([]byte(body), &h), nil)
+	assert.Equal(t, h.Synced, true)
+	assert.Equal(t, h.State, "SYNC")
+}
@@ -0,0 +1,304 @@
+package node
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xdago/config"
+	"xdago/log"
+)
+
+var ErrNotWhitelisted = errors.New("peer is not in white list")
+
+// NetDB is the address book of known nodes. Seeds come from config
+// whiteIPs and netdb-white.txt, nodes we connected to are saved in netdb.txt.
+type NetDB struct {
+	sync.RWMutex
+	config *config.Config
+	nodes  map[string]Node // host:port -> node
+	white  map[string]Node // host:port -> node, config whiteIPs and listed
+	listed map[string]Node // white list of netdb-white.txt or whitelistUrl
+	client *http.Client
+	quit   chan struct{}
+}
+
+func NewNetDB(config *config.Config) *NetDB {
+	return &NetDB{
+		config: config,
+		nodes:  make(map[string]Node),
+		white:  make(map[string]Node),
+		listed: make(map[string]Node),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// parseNodes reads one host:port per line, blank lines and # comments are skipped
+func parseNodes(r io.Reader) []Node {
+	var res []Node
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		n, err := parseNode(line)
+		if err != nil {
+			log.Warn("skip illegal node address", log.Ctx{"address": line})
+			continue
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+func parseNode(address string) (Node, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return Node{}, err
+	}
+	if net.ParseIP(host) == nil {
+		return Node{}, errors.New("illegal ip " + host)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return Node{}, errors.New("illegal port " + port)
+	}
+	return NewNode(host, p), nil
+}
+
+func readNodes(file string) []Node {
+	if file == "" {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("open node list failed", log.Ctx{"file": file, "err": err.Error()})
+		}
+		return nil
+	}
+	defer f.Close()
+	return parseNodes(f)
+}
+
+func writeNodes(file string, nodes []Node) error {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for _, n := range nodes {
+		sb.WriteString(peerKey(n))
+		sb.WriteString("\n")
+	}
+	return os.WriteFile(file, []byte(sb.String()), 0644)
+}
+
+func sortedNodes(m map[string]Node) []Node {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	res := make([]Node, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, m[key])
+	}
+	return res
+}
+
+// Init loads config whiteIPs, the white list file and netdb.txt. A config
+// reload changing whiteIPs rebuilds the white list.
+func (db *NetDB) Init() {
+	db.Lock()
+	defer db.Unlock()
+
+	for _, n := range readNodes(db.config.WhiteListDir()) {
+		db.listed[peerKey(n)] = n
+	}
+	db.buildWhite()
+	for _, n := range readNodes(db.config.NetDBDir()) {
+		db.nodes[peerKey(n)] = n
+	}
+	db.config.OnReload(func(*config.Config) {
+		db.Lock()
+		defer db.Unlock()
+		db.buildWhite()
+		log.Info("white list reloaded", log.Ctx{"white": len(db.white)})
+	})
+	log.Info("net db loaded", log.Ctx{"white": len(db.white), "nodes": len(db.nodes)})
+}
+
+// buildWhite sets the white list to the config whiteIPs and the listed
+// nodes, db is locked
+func (db *NetDB) buildWhite() {
+	db.white = make(map[string]Node)
+	for _, address := range db.config.WhiteIPList() {
+		if n, err := parseNode(address); err == nil {
+			db.white[peerKey(n)] = n
+		}
+	}
+	for key, n := range db.listed {
+		db.white[key] = n
+	}
+}
+
+// AddNode records a node we successfully connected to and persists netdb.txt
+func (db *NetDB) AddNode(n Node) {
+	db.Lock()
+	defer db.Unlock()
+	key := peerKey(n)
+	if _, ok := db.nodes[key]; ok {
+		return
+	}
+	db.nodes[key] = NewNodeWithID(n.Host, n.Port, n.Id)
+	db.save()
+}
+
+func (db *NetDB) RemoveNode(n Node) {
+	db.Lock()
+	defer db.Unlock()
+	key := peerKey(n)
+	if _, ok := db.nodes[key]; !ok {
+		return
+	}
+	delete(db.nodes, key)
+	db.save()
+}
+
+// save must be called with the lock held
+func (db *NetDB) save() {
+	file := db.config.NetDBDir()
+	if file == "" {
+		return
+	}
+	if err := writeNodes(file, sortedNodes(db.nodes)); err != nil {
+		log.Error("write net db failed", log.Ctx{"file": file, "err": err.Error()})
+	}
+}
+
+// Nodes returns the nodes to connect to, white list first
+func (db *NetDB) Nodes() []Node {
+	db.RLock()
+	defer db.RUnlock()
+	res := sortedNodes(db.white)
+	for _, n := range sortedNodes(db.nodes) {
+		if _, ok := db.white[peerKey(n)]; !ok {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+func (db *NetDB) WhiteNodes() []Node {
+	db.RLock()
+	defer db.RUnlock()
+	return sortedNodes(db.white)
+}
+
+// IsWhite reports whether host appears in the white list, the port is ignored
+// since inbound connections come from ephemeral ports
+func (db *NetDB) IsWhite(host string) bool {
+	db.RLock()
+	defer db.RUnlock()
+	for _, n := range db.white {
+		if n.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckInbound rejects hosts outside the white list when whitelisting is enabled
+func (db *NetDB) CheckInbound(host string) error {
+	if !db.config.EnableWhitelist() || db.IsWhite(host) {
+		return nil
+	}
+	return ErrNotWhitelisted
+}
+
+// RefreshWhitelist downloads the white list from whitelistUrl, replaces the
+// in memory list and saves it to netdb-white.txt
+func (db *NetDB) RefreshWhitelist() error {
+	url := db.config.WhitelistUrl()
+	if url == "" {
+		return errors.New("white list url not set")
+	}
+	resp, err := db.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("fetch white list: " + resp.Status)
+	}
+	nodes := parseNodes(resp.Body)
+	if len(nodes) == 0 {
+		return errors.New("fetch white list: empty list")
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	db.listed = make(map[string]Node)
+	for _, n := range nodes {
+		db.listed[peerKey(n)] = n
+	}
+	db.buildWhite()
+	if file := db.config.WhiteListDir(); file != "" {
+		if err := writeNodes(file, nodes); err != nil {
+			log.Error("write white list failed", log.Ctx{"file": file, "err": err.Error()})
+		}
+	}
+	log.Info("white list refreshed", log.Ctx{"url": url, "count": len(nodes)})
+	return nil
+}
+
+// StartRefresh refreshes the white list periodically if enableRefresh is set
+func (db *NetDB) StartRefresh(interval time.Duration) {
+	if !db.config.EnableRefresh() || db.config.WhitelistUrl() == "" {
+		return
+	}
+	db.Lock()
+	if db.quit != nil {
+		db.Unlock()
+		return
+	}
+	db.quit = make(chan struct{})
+	quit := db.quit
+	db.Unlock()
+
+	go func() {
+		if err := db.RefreshWhitelist(); err != nil {
+			log.Warn("refresh white list failed", log.Ctx{"err": err.Error()})
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := db.RefreshWhitelist(); err != nil {
+					log.Warn("refresh white list failed", log.Ctx{"err": err.Error()})
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+func (db *NetDB) StopRefresh() {
+	db.Lock()
+	defer db.Unlock()
+	if db.quit != nil {
+		close(db.quit)
+		db.quit = nil
+	}
+}
@@ -0,0 +1,105 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package node
+
+import (
+	"github.com/magiconair/properties/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"xdago/common"
+	"xdago/config"
+)
+
+func TestNetDBPersist(t *testing.T) {
+	c := testConfig(t)
+	dir := t.TempDir()
+	c.SetNetDBDir(path.Join(dir, "netdb.txt"))
+	c.SetWhiteListDir(path.Join(dir, "netdb-white.txt"))
+	c.SetWhiteIPList([]string{"127.0.0.1:8001"})
+	err := os.WriteFile(c.WhiteListDir(), []byte("# seeds\n10.0.0.9:8001\nbad line\n"), 0644)
+	assert.Equal(t, err, nil)
+
+	db := NewNetDB(c)
+	db.Init()
+	assert.Equal(t, len(db.WhiteNodes()), 2)
+
+	pm := NewPeerManager(c)
+	pm.SetNetDB(db)
+	_, err = pm.AddPeer(NewNode("10.0.0.5", 8001), false)
+	assert.Equal(t, err, nil)
+	_, err = pm.AddPeer(NewNode("10.0.0.6", 8001), true)
+	assert.Equal(t, err, nil)
+
+	reloaded := NewNetDB(c)
+	reloaded.Init()
+	nodes := reloaded.Nodes()
+	assert.Equal(t, len(nodes), 3)
+	assert.Equal(t, nodes[2].Host, "10.0.0.5")
+}
+
+func TestNetDBWhitelist(t *testing.T) {
+	c := testConfig(t)
+	dir := t.TempDir()
+	c.SetWhiteListDir(path.Join(dir, "netdb-white.txt"))
+	c.SetEnableWhitelist(true)
+	c.SetEnableRefresh(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("10.1.1.1:8001\n10.1.1.2:8001\n"))
+	}))
+	defer server.Close()
+	c.SetWhitelistUrl(server.URL)
+
+	db := NewNetDB(c)
+	db.Init()
+	pm := NewPeerManager(c)
+	pm.SetNetDB(db)
+
+	assert.Equal(t, pm.CheckInbound("10.1.1.1"), ErrNotWhitelisted)
+	assert.Equal(t, db.RefreshWhitelist(), nil)
+	assert.Equal(t, pm.CheckInbound("10.1.1.1"), nil)
+	assert.Equal(t, pm.CheckInbound("10.1.1.3"), ErrNotWhitelisted)
+
+	// outbound connections are not restricted by the white list
+	assert.Equal(t, pm.CheckOutbound("10.1.1.3"), nil)
+
+	// refreshed list is saved for the next start
+	reloaded := NewNetDB(c)
+	reloaded.Init()
+	assert.Equal(t, reloaded.IsWhite("10.1.1.2"), true)
+
+	c.SetEnableWhitelist(false)
+	assert.Equal(t, pm.CheckInbound("10.1.1.3"), nil)
+}
+
+func TestNetDBReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(whiteIPs string) {
+		json := `{"node": {"ip": "127.0.0.1", "port": 8001, "whiteIPs": [` + whiteIPs + `]}}`
+		assert.Equal(t, os.WriteFile(path.Join(dir, "devnet", "devnet-config.json"), []byte(json), 0644), nil)
+	}
+	assert.Equal(t, os.Mkdir(path.Join(dir, "devnet"), 0755), nil)
+	write(`"10.2.2.1:8001"`)
+	wd, _ := os.Getwd()
+	assert.Equal(t, os.Chdir(dir), nil)
+	defer os.Chdir(wd)
+
+	c, err := config.Load(common.DEVNET)
+	assert.Equal(t, err, nil)
+	db := NewNetDB(c)
+	db.Init()
+	assert.Equal(t, db.IsWhite("10.2.2.1"), true)
+
+	// a reload of whiteIPs takes effect at once
+	write(`"10.2.2.2:8001"`)
+	_, err = c.Reload(config.RELOAD_ADMIN)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, db.IsWhite("10.2.2.1"), false)
+	assert.Equal(t, db.IsWhite("10.2.2.2"), true)
+}
@@ -18,3 +18,7 @@ func (h *StatHandler) Add(delta uint64) {
 func (h *StatHandler) AddOne() {
 	atomic.AddUint64(&h.Count, 1)
 }
+
+func (h *StatHandler) Get() uint64 {
+	return atomic.LoadUint64(&h.Count)
+}
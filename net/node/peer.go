@@ -0,0 +1,61 @@
+package node
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Peer is a connected remote node and the statistics collected for it
+type Peer struct {
+	Node          Node
+	Inbound       bool
+	Connected     time.Time
+	Messages      NodeStat
+	BytesIn       StatHandler
+	BytesOut      StatHandler
+	InvalidBlocks StatHandler
+	latency       int64 // nanoseconds
+	score         int32 // misbehavior score
+}
+
+func NewPeer(node Node, inbound bool) *Peer {
+	return &Peer{
+		Node:      node,
+		Inbound:   inbound,
+		Connected: time.Now(),
+	}
+}
+
+func (p *Peer) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.latency))
+}
+
+func (p *Peer) SetLatency(latency time.Duration) {
+	atomic.StoreInt64(&p.latency, int64(latency))
+}
+
+func (p *Peer) Score() int32 {
+	return atomic.LoadInt32(&p.score)
+}
+
+func (p *Peer) addScore(delta int32) int32 {
+	return atomic.AddInt32(&p.score, delta)
+}
+
+func (p *Peer) ToString() string {
+	direction := "outbound"
+	if p.Inbound {
+		direction = "inbound"
+	}
+	return "Peer{" + p.Node.Host + ":" + strconv.Itoa(p.Node.Port) +
+		", " + direction +
+		", msgIn=" + strconv.FormatUint(p.Messages.Inbound.Get(), 10) +
+		", msgOut=" + strconv.FormatUint(p.Messages.Outbound.Get(), 10) +
+		", in=" + strconv.FormatUint(p.BytesIn.Get(), 10) +
+		", out=" + strconv.FormatUint(p.BytesOut.Get(), 10) +
+		", latency=" + p.Latency().String() +
+		", invalid=" + strconv.FormatUint(p.InvalidBlocks.Get(), 10) +
+		", score=" + strconv.Itoa(int(p.Score())) +
+		"}"
+}
@@ -0,0 +1,335 @@
+package node
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/log"
+)
+
+var (
+	ErrPeerBanned      = errors.New("peer is banned")
+	ErrPeerExists      = errors.New("peer already connected")
+	ErrMaxConnections  = errors.New("max connections reached")
+	ErrMaxInboundPerIp = errors.New("max inbound connections per ip reached")
+)
+
+// PeerManager keeps track of connected peers, enforces the connection limits
+// from config and bans peers whose misbehavior score gets too high.
+// Bans are written to config.NetBanDir so they survive restarts.
+type PeerManager struct {
+	sync.RWMutex
+	config    *config.Config
+	peers     map[string]*Peer // host:port -> peer
+	inboundIp map[string]int   // host -> inbound connection count
+	banned    map[string]int64 // host -> ban expiry, unix seconds
+	scores    map[string]int32 // host -> misbehavior score, kept across reconnects
+	netDB     *NetDB
+	now       func() time.Time
+}
+
+func NewPeerManager(config *config.Config) *PeerManager {
+	return &PeerManager{
+		config:    config,
+		peers:     make(map[string]*Peer),
+		inboundIp: make(map[string]int),
+		banned:    make(map[string]int64),
+		scores:    make(map[string]int32),
+		now:       time.Now,
+	}
+}
+
+// SetNetDB enables white list checks for inbound peers and records
+// outbound peers in the address book
+func (pm *PeerManager) SetNetDB(netDB *NetDB) {
+	pm.Lock()
+	defer pm.Unlock()
+	pm.netDB = netDB
+}
+
+func peerKey(node Node) string {
+	return net.JoinHostPort(node.Host, strconv.Itoa(node.Port))
+}
+
+// Init loads the persisted ban list, expired entries are dropped
+func (pm *PeerManager) Init() {
+	pm.Lock()
+	defer pm.Unlock()
+
+	file := pm.config.NetBanDir()
+	if file == "" {
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("open ban list failed", log.Ctx{"file": file, "err": err.Error()})
+		}
+		return
+	}
+	defer f.Close()
+
+	now := pm.now().Unix()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || expiry <= now {
+			continue
+		}
+		pm.banned[fields[0]] = expiry
+	}
+	log.Debug("ban list loaded", log.Ctx{"count": len(pm.banned)})
+}
+
+// saveBans must be called with the lock held
+func (pm *PeerManager) saveBans() {
+	file := pm.config.NetBanDir()
+	if file == "" {
+		return
+	}
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		log.Error("create ban list dir failed", log.Ctx{"file": file, "err": err.Error()})
+		return
+	}
+
+	hosts := make([]string, 0, len(pm.banned))
+	for host := range pm.banned {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var sb strings.Builder
+	for _, host := range hosts {
+		sb.WriteString(fmt.Sprintf("%s %d\n", host, pm.banned[host]))
+	}
+	if err := os.WriteFile(file, []byte(sb.String()), 0644); err != nil {
+		log.Error("write ban list failed", log.Ctx{"file": file, "err": err.Error()})
+	}
+}
+
+// isBanned must be called with the lock held
+func (pm *PeerManager) isBanned(host string) bool {
+	expiry, ok := pm.banned[host]
+	return ok && expiry > pm.now().Unix()
+}
+
+func (pm *PeerManager) IsBanned(host string) bool {
+	pm.RLock()
+	defer pm.RUnlock()
+	return pm.isBanned(host)
+}
+
+// Ban disconnects every peer from host and refuses it until duration passes
+func (pm *PeerManager) Ban(host string, duration time.Duration) {
+	pm.Lock()
+	defer pm.Unlock()
+	pm.ban(host, duration)
+}
+
+func (pm *PeerManager) ban(host string, duration time.Duration) {
+	pm.banned[host] = pm.now().Add(duration).Unix()
+	for key, peer := range pm.peers {
+		if peer.Node.Host == host {
+			pm.remove(key, peer)
+		}
+	}
+	pm.saveBans()
+	log.Warn("peer banned", log.Ctx{"host": host, "duration": duration.String()})
+}
+
+func (pm *PeerManager) Unban(host string) {
+	pm.Lock()
+	defer pm.Unlock()
+	delete(pm.scores, host)
+	if _, ok := pm.banned[host]; ok {
+		delete(pm.banned, host)
+		pm.saveBans()
+	}
+}
+
+// BannedHosts returns the hosts that are currently banned
+func (pm *PeerManager) BannedHosts() []string {
+	pm.RLock()
+	defer pm.RUnlock()
+	var hosts []string
+	for host := range pm.banned {
+		if pm.isBanned(host) {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// CheckInbound reports whether a new inbound connection from host may be accepted
+func (pm *PeerManager) CheckInbound(host string) error {
+	pm.RLock()
+	defer pm.RUnlock()
+	return pm.check(host, true)
+}
+
+// CheckOutbound reports whether a new outbound connection to host may be opened
+func (pm *PeerManager) CheckOutbound(host string) error {
+	pm.RLock()
+	defer pm.RUnlock()
+	return pm.check(host, false)
+}
+
+func (pm *PeerManager) check(host string, inbound bool) error {
+	if pm.isBanned(host) {
+		return ErrPeerBanned
+	}
+	if max := pm.config.MaxConnections(); max > 0 && len(pm.peers) >= max {
+		return ErrMaxConnections
+	}
+	if inbound {
+		if pm.netDB != nil {
+			if err := pm.netDB.CheckInbound(host); err != nil {
+				return err
+			}
+		}
+		if max := pm.config.MaxInboundConnectionsPerIp(); max > 0 && pm.inboundIp[host] >= max {
+			return ErrMaxInboundPerIp
+		}
+	}
+	return nil
+}
+
+// AddPeer registers a connected node after checking bans and connection limits
+func (pm *PeerManager) AddPeer(node Node, inbound bool) (*Peer, error) {
+	pm.Lock()
+	defer pm.Unlock()
+
+	key := peerKey(node)
+	if _, ok := pm.peers[key]; ok {
+		return nil, ErrPeerExists
+	}
+	if err := pm.check(node.Host, inbound); err != nil {
+		return nil, err
+	}
+	peer := NewPeer(node, inbound)
+	pm.peers[key] = peer
+	if inbound {
+		pm.inboundIp[node.Host]++
+	} else if pm.netDB != nil {
+		pm.netDB.AddNode(node)
+	}
+	log.Debug("peer added", log.Ctx{"peer": key, "inbound": inbound})
+	return peer, nil
+}
+
+func (pm *PeerManager) RemovePeer(node Node) {
+	pm.Lock()
+	defer pm.Unlock()
+	key := peerKey(node)
+	if peer, ok := pm.peers[key]; ok {
+		pm.remove(key, peer)
+	}
+}
+
+func (pm *PeerManager) remove(key string, peer *Peer) {
+	delete(pm.peers, key)
+	if peer.Inbound {
+		pm.inboundIp[peer.Node.Host]--
+		if pm.inboundIp[peer.Node.Host] <= 0 {
+			delete(pm.inboundIp, peer.Node.Host)
+		}
+	}
+	log.Debug("peer removed", log.Ctx{"peer": key})
+}
+
+func (pm *PeerManager) GetPeer(node Node) *Peer {
+	pm.RLock()
+	defer pm.RUnlock()
+	return pm.peers[peerKey(node)]
+}
+
+func (pm *PeerManager) Peers() []*Peer {
+	pm.RLock()
+	defer pm.RUnlock()
+	res := make([]*Peer, 0, len(pm.peers))
+	for _, peer := range pm.peers {
+		res = append(res, peer)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return peerKey(res[i].Node) < peerKey(res[j].Node)
+	})
+	return res
+}
+
+// PeerCount returns the number of inbound and outbound peers
+func (pm *PeerManager) PeerCount() (inbound, outbound int) {
+	pm.RLock()
+	defer pm.RUnlock()
+	for _, peer := range pm.peers {
+		if peer.Inbound {
+			inbound++
+		} else {
+			outbound++
+		}
+	}
+	return
+}
+
+// Misbehave adds score to the misbehavior score of the peer's host, so a
+// reconnect does not reset it. The host is banned once the score reaches
+// common.PEER_BAN_SCORE. Returns true if banned.
+func (pm *PeerManager) Misbehave(node Node, score int32, reason string) bool {
+	pm.Lock()
+	defer pm.Unlock()
+
+	if peer, ok := pm.peers[peerKey(node)]; ok {
+		if score == common.MISBEHAVE_INVALID_BLOCK {
+			peer.InvalidBlocks.AddOne()
+		}
+		peer.addScore(score)
+	}
+	pm.scores[node.Host] += score
+	total := pm.scores[node.Host]
+	log.Debug("peer misbehaved", log.Ctx{"peer": peerKey(node), "reason": reason, "score": total})
+	if total < common.PEER_BAN_SCORE {
+		return false
+	}
+	delete(pm.scores, node.Host)
+	pm.ban(node.Host, time.Duration(common.PEER_BAN_DURATION)*time.Second)
+	return true
+}
+
+func (pm *PeerManager) DialTimeout() time.Duration {
+	return time.Duration(pm.config.ConnectionTimeout()) * time.Millisecond
+}
+
+func (pm *PeerManager) ReadTimeout() time.Duration {
+	return time.Duration(pm.config.ConnectionReadTimeout()) * time.Millisecond
+}
+
+// Dial opens an outbound connection to node honoring connectionTimeout
+func (pm *PeerManager) Dial(node Node) (net.Conn, error) {
+	if err := pm.CheckOutbound(node.Host); err != nil {
+		return nil, err
+	}
+	return net.DialTimeout("tcp", peerKey(node), pm.DialTimeout())
+}
+
+// SetReadDeadline applies connectionReadTimeout to the next read on conn
+func (pm *PeerManager) SetReadDeadline(conn net.Conn) error {
+	timeout := pm.ReadTimeout()
+	if timeout <= 0 {
+		return conn.SetReadDeadline(time.Time{})
+	}
+	return conn.SetReadDeadline(time.Now().Add(timeout))
+}
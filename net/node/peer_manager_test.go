@@ -0,0 +1,98 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package node
+
+import (
+	"github.com/magiconair/properties/assert"
+	"path"
+	"testing"
+	"time"
+	"xdago/common"
+	"xdago/config"
+)
+
+func testConfig(t *testing.T) *config.Config {
+	c := &config.Config{}
+	c.SetMaxConnections(3)
+	c.SetMaxInboundConnectionsPerIp(2)
+	c.SetConnectionTimeout(1000)
+	c.SetConnectionReadTimeout(2000)
+	c.SetNetBanDir(path.Join(t.TempDir(), common.NET_BAN_FILE_NAME))
+	return c
+}
+
+func TestPeerManagerLimits(t *testing.T) {
+	pm := NewPeerManager(testConfig(t))
+	pm.Init()
+
+	_, err := pm.AddPeer(NewNode("10.0.0.1", 1001), true)
+	assert.Equal(t, err, nil)
+	_, err = pm.AddPeer(NewNode("10.0.0.1", 1001), true)
+	assert.Equal(t, err, ErrPeerExists)
+	_, err = pm.AddPeer(NewNode("10.0.0.1", 1002), true)
+	assert.Equal(t, err, nil)
+	_, err = pm.AddPeer(NewNode("10.0.0.1", 1003), true)
+	assert.Equal(t, err, ErrMaxInboundPerIp)
+
+	// outbound connections are not limited per ip
+	_, err = pm.AddPeer(NewNode("10.0.0.1", 1004), false)
+	assert.Equal(t, err, nil)
+	_, err = pm.AddPeer(NewNode("10.0.0.2", 1001), false)
+	assert.Equal(t, err, ErrMaxConnections)
+
+	in, out := pm.PeerCount()
+	assert.Equal(t, in, 2)
+	assert.Equal(t, out, 1)
+
+	pm.RemovePeer(NewNode("10.0.0.1", 1001))
+	assert.Equal(t, pm.CheckInbound("10.0.0.1"), nil)
+	assert.Equal(t, pm.DialTimeout(), time.Second)
+	assert.Equal(t, pm.ReadTimeout(), 2*time.Second)
+}
+
+func TestPeerManagerBan(t *testing.T) {
+	c := testConfig(t)
+	pm := NewPeerManager(c)
+	pm.Init()
+
+	n := NewNode("10.0.0.3", 1001)
+	peer, err := pm.AddPeer(n, true)
+	assert.Equal(t, err, nil)
+
+	for i := int32(0); i < common.PEER_BAN_SCORE/common.MISBEHAVE_INVALID_BLOCK-1; i++ {
+		assert.Equal(t, pm.Misbehave(n, common.MISBEHAVE_INVALID_BLOCK, "invalid block"), false)
+	}
+	assert.Equal(t, peer.InvalidBlocks.Get(), uint64(common.PEER_BAN_SCORE/common.MISBEHAVE_INVALID_BLOCK-1))
+
+	// the score belongs to the host, a reconnect from another port keeps it
+	pm.RemovePeer(n)
+	n = NewNode("10.0.0.3", 1005)
+	_, err = pm.AddPeer(n, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pm.Misbehave(n, common.MISBEHAVE_INVALID_BLOCK, "invalid block"), true)
+	assert.Equal(t, pm.GetPeer(n) == nil, true)
+	assert.Equal(t, pm.IsBanned("10.0.0.3"), true)
+
+	_, err = pm.AddPeer(NewNode("10.0.0.3", 1002), false)
+	assert.Equal(t, err, ErrPeerBanned)
+
+	// bans survive a restart
+	restarted := NewPeerManager(c)
+	restarted.Init()
+	assert.Equal(t, restarted.IsBanned("10.0.0.3"), true)
+	assert.Equal(t, restarted.BannedHosts(), []string{"10.0.0.3"})
+
+	// expired bans are dropped
+	restarted.now = func() time.Time {
+		return time.Now().Add(time.Duration(common.PEER_BAN_DURATION+1) * time.Second)
+	}
+	assert.Equal(t, restarted.IsBanned("10.0.0.3"), false)
+
+	restarted.Unban("10.0.0.3")
+	again := NewPeerManager(c)
+	again.Init()
+	assert.Equal(t, again.IsBanned("10.0.0.3"), false)
+}
@@ -0,0 +1,196 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/net/node"
+	"xdago/utils"
+)
+
+const clientVersionSize = 16
+
+var (
+	ErrHandshakeWord     = errors.New("handshake: illegal header word")
+	ErrProtocolVersion   = errors.New("handshake: incompatible protocol version")
+	ErrNetworkMismatch   = errors.New("handshake: peer is on another network")
+	ErrSelfConnection    = errors.New("handshake: connected to self")
+	ErrHandshakeTooShort = errors.New("handshake: message too short")
+)
+
+// Handshake is the first message exchanged on a new connection. It is padded
+// to a full 512 bytes block so it can travel on the same framing as blocks.
+type Handshake struct {
+	ProtocolVersion uint32
+	ClientVersion   string
+	Network         common.NetworkType
+	XdagEra         uint64
+	FieldHeader     common.FieldType
+	NodeId          [8]byte
+	Port            uint32
+	Timestamp       uint64
+	Stats           *core.XDAGStats
+}
+
+func NewHandshake(config *config.Config, local node.Node, stats *core.XDAGStats) Handshake {
+	if stats == nil {
+		stats = core.NewEmptyXDAGStats()
+	}
+	return Handshake{
+		ProtocolVersion: common.P2P_PROTOCOL_VERSION,
+		ClientVersion:   common.CLIENT_VERSION,
+		Network:         config.Network(),
+		XdagEra:         config.XdagEra(),
+		FieldHeader:     config.XdagFieldHeader(),
+		NodeId:          local.Id,
+		Port:            uint32(local.Port),
+		Timestamp:       utils.GetCurrentTimestamp(),
+		Stats:           stats,
+	}
+}
+
+func writeBigInt(w *utils.SimpleWriter, i *big.Int) {
+	var b [32]byte
+	if i != nil {
+		i.FillBytes(b[:])
+	}
+	w.WriteBytes(b[:])
+}
+
+func readBigInt(r *utils.SimpleReader) *big.Int {
+	var b [32]byte
+	r.ReadBytes(b[:])
+	return new(big.Int).SetBytes(b[:])
+}
+
+func (h Handshake) Encode() []byte {
+	w := utils.NewSimpleWriter(common.XDAG_BLOCK_SIZE)
+	w.WriteInt(binary.LittleEndian, common.P2P_HANDSHAKE_WORD)
+	w.WriteInt(binary.LittleEndian, h.ProtocolVersion)
+	version := h.ClientVersion
+	if len(version) > clientVersionSize {
+		version = version[:clientVersionSize]
+	}
+	w.WriteFixedSizeString(version, clientVersionSize)
+	w.WriteOneByte(byte(h.Network))
+	w.WriteOneByte(byte(h.FieldHeader))
+	w.WriteInt(binary.LittleEndian, h.XdagEra)
+	w.WriteBytes(h.NodeId[:])
+	w.WriteInt(binary.LittleEndian, h.Port)
+	w.WriteInt(binary.LittleEndian, h.Timestamp)
+
+	s := h.Stats
+	if s == nil {
+		s = core.NewEmptyXDAGStats()
+	}
+	writeBigInt(w, s.Difficulty)
+	writeBigInt(w, s.MaxDifficulty())
+	w.WriteInt(binary.LittleEndian, s.NBlocks)
+	w.WriteInt(binary.LittleEndian, s.TotalNBlocks)
+	w.WriteInt(binary.LittleEndian, s.NMain)
+	w.WriteInt(binary.LittleEndian, s.TotalNMain)
+	w.WriteInt(binary.LittleEndian, uint32(s.NHosts))
+	w.WriteInt(binary.LittleEndian, uint32(s.TotalNHosts))
+	w.WriteInt(binary.LittleEndian, s.MainTime)
+
+	res := make([]byte, common.XDAG_BLOCK_SIZE)
+	copy(res, w.BytesUncheck())
+	return res
+}
+
+func DecodeHandshake(data []byte) (Handshake, error) {
+	var h Handshake
+	if len(data) < common.XDAG_BLOCK_SIZE {
+		return h, ErrHandshakeTooShort
+	}
+	r := utils.NewSimpleReader(data)
+	var word uint32
+	r.ReadInt(binary.LittleEndian, &word)
+	if word != common.P2P_HANDSHAKE_WORD {
+		return h, ErrHandshakeWord
+	}
+	r.ReadInt(binary.LittleEndian, &h.ProtocolVersion)
+	h.ClientVersion = string(r.ReadCString(clientVersionSize))
+	h.Network = common.NetworkType(r.ReadOneByte())
+	h.FieldHeader = common.FieldType(r.ReadOneByte())
+	r.ReadInt(binary.LittleEndian, &h.XdagEra)
+	r.ReadBytes(h.NodeId[:])
+	r.ReadInt(binary.LittleEndian, &h.Port)
+	r.ReadInt(binary.LittleEndian, &h.Timestamp)
+
+	difficulty := readBigInt(r)
+	maxDifficulty := readBigInt(r)
+	var nBlocks, totalNBlocks, nMain, totalNMain, mainTime uint64
+	var nHosts, totalNHosts uint32
+	r.ReadInt(binary.LittleEndian, &nBlocks)
+	r.ReadInt(binary.LittleEndian, &totalNBlocks)
+	r.ReadInt(binary.LittleEndian, &nMain)
+	r.ReadInt(binary.LittleEndian, &totalNMain)
+	r.ReadInt(binary.LittleEndian, &nHosts)
+	r.ReadInt(binary.LittleEndian, &totalNHosts)
+	r.ReadInt(binary.LittleEndian, &mainTime)
+	if r.Error() != nil {
+		return h, r.Error()
+	}
+
+	h.Stats = core.NewXDAGStats(maxDifficulty, totalNBlocks, totalNMain, mainTime, int(totalNHosts))
+	h.Stats.Difficulty = difficulty
+	h.Stats.NBlocks = nBlocks
+	h.Stats.NMain = nMain
+	h.Stats.NHosts = int(nHosts)
+	return h, nil
+}
+
+// Validate checks the remote handshake against the local configuration
+func (h Handshake) Validate(config *config.Config, localId [8]byte) error {
+	if h.ProtocolVersion != common.P2P_PROTOCOL_VERSION {
+		return fmt.Errorf("%w: remote %d, local %d", ErrProtocolVersion, h.ProtocolVersion, common.P2P_PROTOCOL_VERSION)
+	}
+	if h.Network != config.Network() || h.XdagEra != config.XdagEra() || h.FieldHeader != config.XdagFieldHeader() {
+		return fmt.Errorf("%w: remote %s, local %s", ErrNetworkMismatch, h.Network, config.Network())
+	}
+	if h.NodeId == localId {
+		return ErrSelfConnection
+	}
+	return nil
+}
+
+// Node returns the remote node, its listening port and id come from the handshake
+func (h Handshake) Node(host string) node.Node {
+	return node.NewNodeWithID(host, int(h.Port), h.NodeId)
+}
+
+// ApplyStats merges the remote chain stats into the local totals
+func (h Handshake) ApplyStats(local *core.XDAGStats) {
+	if h.Stats == nil || local == nil {
+		return
+	}
+	local.Update(*h.Stats)
+}
+
+// DoHandshake sends the local handshake, reads the remote one and validates it
+func DoHandshake(rw io.ReadWriter, config *config.Config, local Handshake) (Handshake, error) {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(local.Encode())
+		errc <- err
+	}()
+
+	buf := make([]byte, common.XDAG_BLOCK_SIZE)
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		return Handshake{}, err
+	}
+	if err := <-errc; err != nil {
+		return Handshake{}, err
+	}
+	remote, err := DecodeHandshake(buf)
+	if err != nil {
+		return remote, err
+	}
+	return remote, remote.Validate(config, local.NodeId)
+}
@@ -0,0 +1,97 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package p2p
+
+import (
+	"errors"
+	"github.com/magiconair/properties/assert"
+	"math/big"
+	"net"
+	"testing"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/net/node"
+)
+
+func testConfig(network common.NetworkType) *config.Config {
+	c := &config.Config{}
+	c.SetNetwork(network)
+	if network == common.MAINNET {
+		c.SetXdagEra(0x16940000000)
+		c.SetXdagFieldHeader(common.XDAG_FIELD_HEAD)
+	} else {
+		c.SetXdagEra(0x16900000000)
+		c.SetXdagFieldHeader(common.XDAG_FIELD_HEAD_TEST)
+	}
+	return c
+}
+
+func TestHandshakeEncode(t *testing.T) {
+	c := testConfig(common.DEVNET)
+	stats := core.NewXDAGStats(big.NewInt(12345), 100, 10, 0x1234, 3)
+	stats.NBlocks = 50
+	stats.NMain = 5
+	stats.NHosts = 2
+	h := NewHandshake(c, node.NewNode("127.0.0.1", 8001), stats)
+
+	data := h.Encode()
+	assert.Equal(t, len(data), common.XDAG_BLOCK_SIZE)
+	d, err := DecodeHandshake(data)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, d.ClientVersion, common.CLIENT_VERSION)
+	assert.Equal(t, d.Network, common.DEVNET)
+	assert.Equal(t, d.NodeId, h.NodeId)
+	assert.Equal(t, d.Port, uint32(8001))
+	assert.Equal(t, d.Stats.MaxDifficulty().Int64(), int64(12345))
+	assert.Equal(t, d.Stats.TotalNBlocks, uint64(100))
+	assert.Equal(t, d.Stats.NBlocks, uint64(50))
+	assert.Equal(t, d.Stats.TotalNHosts, 3)
+
+	_, err = DecodeHandshake(make([]byte, common.XDAG_BLOCK_SIZE))
+	assert.Equal(t, err, ErrHandshakeWord)
+}
+
+func exchange(local, remote *config.Config, remoteStats *core.XDAGStats) (Handshake, error, error) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		h := NewHandshake(remote, node.NewNode("127.0.0.1", 8002), remoteStats)
+		_, err := DoHandshake(b, remote, h)
+		errc <- err
+	}()
+	h := NewHandshake(local, node.NewNode("127.0.0.1", 8001), nil)
+	res, err := DoHandshake(a, local, h)
+	return res, err, <-errc
+}
+
+func TestDoHandshake(t *testing.T) {
+	remoteStats := core.NewXDAGStats(big.NewInt(999), 2000, 200, 0, 5)
+	remoteStats.NBlocks = 2000
+
+	h, err, remoteErr := exchange(testConfig(common.TESTNET), testConfig(common.TESTNET), remoteStats)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, remoteErr, nil)
+	assert.Equal(t, h.Node("127.0.0.1").Port, 8002)
+
+	local := core.NewEmptyXDAGStats()
+	h.ApplyStats(local)
+	assert.Equal(t, local.TotalNBlocks, uint64(2000))
+	assert.Equal(t, local.TotalNMain, uint64(200))
+	assert.Equal(t, local.TotalNHosts, 5)
+	assert.Equal(t, local.MaxDifficulty().Int64(), int64(999))
+
+	// devnet and testnet share era and header type, the network id tells them apart
+	_, err, remoteErr = exchange(testConfig(common.TESTNET), testConfig(common.DEVNET), nil)
+	assert.Equal(t, errors.Is(err, ErrNetworkMismatch), true)
+	assert.Equal(t, errors.Is(remoteErr, ErrNetworkMismatch), true)
+
+	_, err, _ = exchange(testConfig(common.MAINNET), testConfig(common.DEVNET), nil)
+	assert.Equal(t, errors.Is(err, ErrNetworkMismatch), true)
+}
@@ -0,0 +1,138 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAddressInUse      = errors.New("loopback: address already in use")
+	ErrConnectionRefused = errors.New("loopback: connection refused")
+	ErrListenerClosed    = errors.New("loopback: listener closed")
+)
+
+// LoopbackHub is an in memory network, every node gets its own transport
+// bound to a host name and connections are net.Pipe pairs.
+type LoopbackHub struct {
+	sync.Mutex
+	listeners map[string]*loopbackListener
+	nextPort  int
+}
+
+func NewLoopbackHub() *LoopbackHub {
+	return &LoopbackHub{
+		listeners: make(map[string]*loopbackListener),
+		nextPort:  40000,
+	}
+}
+
+// Transport returns a transport whose outbound connections originate from host
+func (h *LoopbackHub) Transport(host string) Transport {
+	return &loopbackTransport{hub: h, host: host}
+}
+
+type loopbackTransport struct {
+	hub  *LoopbackHub
+	host string
+}
+
+func (t *loopbackTransport) Listen(address string) (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	t.hub.Lock()
+	defer t.hub.Unlock()
+	key := addr.String()
+	if _, ok := t.hub.listeners[key]; ok {
+		return nil, ErrAddressInUse
+	}
+	l := &loopbackListener{
+		hub:    t.hub,
+		addr:   addr,
+		accept: make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	t.hub.listeners[key] = l
+	return l, nil
+}
+
+func (t *loopbackTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	t.hub.Lock()
+	l, ok := t.hub.listeners[addr.String()]
+	t.hub.nextPort++
+	local, _ := net.ResolveTCPAddr("tcp", net.JoinHostPort(t.host, strconv.Itoa(t.hub.nextPort)))
+	t.hub.Unlock()
+	if !ok {
+		return nil, ErrConnectionRefused
+	}
+
+	client, server := net.Pipe()
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	select {
+	case l.accept <- &loopbackConn{Conn: server, local: addr, remote: local}:
+		return &loopbackConn{Conn: client, local: local, remote: addr}, nil
+	case <-l.closed:
+		return nil, ErrConnectionRefused
+	case <-deadline:
+		return nil, ErrConnectionRefused
+	}
+}
+
+type loopbackListener struct {
+	hub    *LoopbackHub
+	addr   *net.TCPAddr
+	accept chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *loopbackListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *loopbackListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.hub.Lock()
+		delete(l.hub.listeners, l.addr.String())
+		l.hub.Unlock()
+	})
+	return nil
+}
+
+func (l *loopbackListener) Addr() net.Addr {
+	return l.addr
+}
+
+// loopbackConn reports tcp addresses so peers can be told apart by host
+type loopbackConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *loopbackConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *loopbackConn) RemoteAddr() net.Addr {
+	return c.remote
+}
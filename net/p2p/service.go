@@ -0,0 +1,411 @@
+package p2p
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/log"
+	"xdago/net/node"
+	"xdago/utils"
+)
+
+var ErrServiceStopped = errors.New("p2p service stopped")
+
+// Chain is the part of core.IBlockchain the network layer depends on
+type Chain interface {
+	TryToConnect(block *core.Block) core.ImportResult
+	GetBlockByTime(startTime, endTime uint64) []*core.Block
+}
+
+// Service accepts and dials peers over a Transport, performs the handshake
+// and gossips 512 bytes blocks between the chain and its peers.
+type Service struct {
+	sync.RWMutex
+	config      *config.Config
+	transport   Transport
+	chain       Chain
+	stats       *core.XDAGStats
+	local       node.Node
+	peerManager *node.PeerManager
+	netDB       *node.NetDB
+	listener    net.Listener
+	conns       map[string]*peerConn // host:port -> connection
+	quit        chan struct{}
+	wg          sync.WaitGroup
+}
+
+type peerConn struct {
+	conn   net.Conn
+	peer   *node.Peer
+	send   chan []byte
+	closed chan struct{}
+	once   sync.Once
+	// hashlows of the last blocks received, used by the read loop only
+	seen      map[common.Hash]struct{}
+	seenOrder []common.Hash
+}
+
+// received records a block hashlow and reports whether the peer already
+// sent it among its last common.PEER_SEEN_BLOCKS blocks
+func (pc *peerConn) received(hashLow common.Hash) bool {
+	if _, ok := pc.seen[hashLow]; ok {
+		return true
+	}
+	if len(pc.seenOrder) >= common.PEER_SEEN_BLOCKS {
+		delete(pc.seen, pc.seenOrder[0])
+		pc.seenOrder = pc.seenOrder[1:]
+	}
+	pc.seen[hashLow] = struct{}{}
+	pc.seenOrder = append(pc.seenOrder, hashLow)
+	return false
+}
+
+func (pc *peerConn) close() {
+	pc.once.Do(func() {
+		close(pc.closed)
+		pc.conn.Close()
+	})
+}
+
+func NewService(config *config.Config, transport Transport, chain Chain, stats *core.XDAGStats) *Service {
+	if stats == nil {
+		stats = core.NewEmptyXDAGStats()
+	}
+	s := &Service{
+		config:      config,
+		transport:   transport,
+		chain:       chain,
+		stats:       stats,
+		local:       node.NewNode(config.NodeIp(), config.NodePort()),
+		peerManager: node.NewPeerManager(config),
+		netDB:       node.NewNetDB(config),
+		conns:       make(map[string]*peerConn),
+		quit:        make(chan struct{}),
+	}
+	// inbound peers are checked against the white list and the outbound
+	// ones are recorded in netdb.txt
+	s.peerManager.SetNetDB(s.netDB)
+	return s
+}
+
+func (s *Service) PeerManager() *node.PeerManager {
+	return s.peerManager
+}
+
+func (s *Service) NetDB() *node.NetDB {
+	return s.netDB
+}
+
+func (s *Service) Local() node.Node {
+	return s.local
+}
+
+func (s *Service) Stats() *core.XDAGStats {
+	return s.stats
+}
+
+// Start listens on node.ip:node.port and accepts peers in the background
+func (s *Service) Start() error {
+	s.peerManager.Init()
+	s.netDB.Init()
+	address := net.JoinHostPort(s.config.NodeIp(), strconv.Itoa(s.config.NodePort()))
+	l, err := s.transport.Listen(address)
+	if err != nil {
+		return err
+	}
+	if addr, ok := l.Addr().(*net.TCPAddr); ok {
+		s.local.Port = addr.Port
+	}
+	s.listener = l
+	s.netDB.StartRefresh(time.Duration(common.WHITELIST_REFRESH_INTERVAL) * time.Second)
+	log.Info("p2p service started", log.Ctx{"address": l.Addr().String()})
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return nil
+}
+
+func (s *Service) Stop() {
+	select {
+	case <-s.quit:
+		return
+	default:
+	}
+	close(s.quit)
+	s.netDB.StopRefresh()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.Lock()
+	for _, pc := range s.conns {
+		pc.close()
+	}
+	s.Unlock()
+	s.wg.Wait()
+	log.Info("p2p service stopped")
+}
+
+func (s *Service) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Warn("accept peer failed", log.Ctx{"err": err.Error()})
+			continue
+		}
+		host := hostOf(conn.RemoteAddr())
+		if err = s.peerManager.CheckInbound(host); err != nil {
+			log.Debug("reject inbound peer", log.Ctx{"host": host, "err": err.Error()})
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := s.setup(conn, true); err != nil {
+				log.Debug("inbound peer setup failed", log.Ctx{"host": host, "err": err.Error()})
+			}
+		}()
+	}
+}
+
+func hostOf(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Connect dials address and keeps the connection until it fails or Stop is called
+func (s *Service) Connect(address string) error {
+	select {
+	case <-s.quit:
+		return ErrServiceStopped
+	default:
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if err = s.peerManager.CheckOutbound(host); err != nil {
+		return err
+	}
+	conn, err := s.transport.Dial(address, s.peerManager.DialTimeout())
+	if err != nil {
+		return err
+	}
+	errc := make(chan error, 1)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.setupWithResult(conn, false, errc)
+	}()
+	return <-errc
+}
+
+func (s *Service) setup(conn net.Conn, inbound bool) error {
+	errc := make(chan error, 1)
+	s.setupWithResult(conn, inbound, errc)
+	return <-errc
+}
+
+// setupWithResult performs the handshake, reports its result on errc and then
+// serves the connection until it is closed
+func (s *Service) setupWithResult(conn net.Conn, inbound bool, errc chan<- error) {
+	host := hostOf(conn.RemoteAddr())
+
+	s.peerManager.SetReadDeadline(conn)
+	start := time.Now()
+	s.RLock()
+	local := NewHandshake(s.config, s.local, s.stats)
+	s.RUnlock()
+	remote, err := DoHandshake(conn, s.config, local)
+	if err != nil {
+		conn.Close()
+		errc <- err
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	peer, err := s.peerManager.AddPeer(remote.Node(host), inbound)
+	if err != nil {
+		conn.Close()
+		errc <- err
+		return
+	}
+	peer.SetLatency(time.Since(start))
+
+	s.Lock()
+	remote.ApplyStats(s.stats)
+	s.stats.NHosts = len(s.conns) + 1
+	pc := &peerConn{
+		conn:   conn,
+		peer:   peer,
+		send:   make(chan []byte, 256),
+		closed: make(chan struct{}),
+		seen:   make(map[common.Hash]struct{}),
+	}
+	key := net.JoinHostPort(peer.Node.Host, strconv.Itoa(peer.Node.Port))
+	select {
+	case <-s.quit:
+		s.Unlock()
+		pc.close()
+		s.peerManager.RemovePeer(peer.Node)
+		errc <- ErrServiceStopped
+		return
+	default:
+	}
+	s.conns[key] = pc
+	s.Unlock()
+	log.Info("peer connected", log.Ctx{"peer": key, "inbound": inbound, "version": remote.ClientVersion})
+	errc <- nil
+
+	s.wg.Add(2)
+	go s.writeLoop(pc)
+	go func() {
+		defer s.wg.Done()
+		s.syncTo(pc)
+	}()
+	s.readLoop(pc)
+
+	pc.close()
+	s.peerManager.RemovePeer(peer.Node)
+	s.Lock()
+	delete(s.conns, key)
+	s.stats.NHosts = len(s.conns)
+	s.Unlock()
+	log.Info("peer disconnected", log.Ctx{"peer": key})
+}
+
+// syncTo sends our recent blocks to a newly connected peer
+func (s *Service) syncTo(pc *peerConn) {
+	now := utils.GetCurrentTimestamp()
+	var start uint64
+	if now > common.REQUEST_BLOCKS_MAX_TIME {
+		start = now - common.REQUEST_BLOCKS_MAX_TIME
+	}
+	blocks := s.chain.GetBlockByTime(start, now+common.MAIN_CHAIN_PERIOD)
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].GetTimestamp() < blocks[j].GetTimestamp()
+	})
+	for _, block := range blocks {
+		data := block.GetXdagBlock().GetData()
+		s.sendTo(pc, data[:])
+	}
+}
+
+func (s *Service) sendTo(pc *peerConn, data []byte) {
+	select {
+	case pc.send <- data:
+	case <-pc.closed:
+	}
+}
+
+func (s *Service) writeLoop(pc *peerConn) {
+	defer s.wg.Done()
+	for {
+		select {
+		case data := <-pc.send:
+			n, err := pc.conn.Write(data)
+			pc.peer.BytesOut.Add(uint64(n))
+			if err != nil {
+				pc.close()
+				return
+			}
+			pc.peer.Messages.Outbound.AddOne()
+		case <-pc.closed:
+			return
+		}
+	}
+}
+
+// readLoop imports the blocks of a peer. An idle peer is kept, dead
+// connections are found by the tcp keep alive, but a block once started
+// must arrive within connectionReadTimeout.
+func (s *Service) readLoop(pc *peerConn) {
+	buf := make([]byte, common.XDAG_BLOCK_SIZE)
+	for {
+		n, err := io.ReadFull(pc.conn, buf[:1])
+		if err == nil {
+			s.peerManager.SetReadDeadline(pc.conn)
+			var m int
+			m, err = io.ReadFull(pc.conn, buf[1:])
+			n += m
+			pc.conn.SetReadDeadline(time.Time{})
+		}
+		pc.peer.BytesIn.Add(uint64(n))
+		if err != nil {
+			return
+		}
+		pc.peer.Messages.Inbound.AddOne()
+
+		xdagBlock := core.NewXdagBlock(buf)
+		if xdagBlock.Fields[0].Type != s.config.XdagFieldHeader() {
+			if s.peerManager.Misbehave(pc.peer.Node, common.MISBEHAVE_INVALID_MESSAGE, "not a block") {
+				return
+			}
+			continue
+		}
+		block := core.NewBlockFromXdag(xdagBlock)
+		if pc.received(block.GetHashLow()) {
+			if s.peerManager.Misbehave(pc.peer.Node, common.MISBEHAVE_DUPLICATE_BLOCK, "duplicate block") {
+				return
+			}
+			continue
+		}
+		res := s.chain.TryToConnect(block)
+		switch res.Status {
+		case common.IMPORTED_BEST, common.IMPORTED_NOT_BEST:
+			s.broadcast(block, pc)
+		case common.INVALID_BLOCK:
+			if s.peerManager.Misbehave(pc.peer.Node, common.MISBEHAVE_INVALID_BLOCK, res.ErrorInfo) {
+				return
+			}
+		}
+	}
+}
+
+// Broadcast sends a block to every connected peer
+func (s *Service) Broadcast(block *core.Block) {
+	s.broadcast(block, nil)
+}
+
+func (s *Service) broadcast(block *core.Block, except *peerConn) {
+	data := block.GetXdagBlock().GetData()
+	s.RLock()
+	conns := make([]*peerConn, 0, len(s.conns))
+	for _, pc := range s.conns {
+		if pc != except {
+			conns = append(conns, pc)
+		}
+	}
+	s.RUnlock()
+	for _, pc := range conns {
+		s.sendTo(pc, utils.Copy2(data[:]))
+	}
+}
+
+// PeerNodes returns the nodes currently connected
+func (s *Service) PeerNodes() []node.Node {
+	var res []node.Node
+	for _, peer := range s.peerManager.Peers() {
+		res = append(res, peer.Node)
+	}
+	return res
+}
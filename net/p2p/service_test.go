@@ -0,0 +1,302 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package p2p
+
+import (
+	"github.com/magiconair/properties/assert"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/net/node"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+// memChain keeps blocks in memory and picks the block with the smallest
+// hash of every epoch as main block
+type memChain struct {
+	sync.Mutex
+	blocks map[common.Hash]*core.Block // hashlow -> block
+	main   map[uint64]common.Hash
+}
+
+func newMemChain() *memChain {
+	return &memChain{
+		blocks: make(map[common.Hash]*core.Block),
+		main:   make(map[uint64]common.Hash),
+	}
+}
+
+func (c *memChain) TryToConnect(block *core.Block) core.ImportResult {
+	c.Lock()
+	defer c.Unlock()
+	hashLow := block.GetHashLow()
+	if _, ok := c.blocks[hashLow]; ok {
+		return core.ImportResult{Status: common.IMPORT_EXIST, HashLow: hashLow}
+	}
+	c.blocks[hashLow] = block
+
+	epoch := utils.GetEpoch(block.GetTimestamp())
+	hash := block.GetHash()
+	if cur, ok := c.main[epoch]; !ok || utils.Hash2String(hash) < utils.Hash2String(cur) {
+		c.main[epoch] = hash
+		return core.ImportResult{Status: common.IMPORTED_BEST, HashLow: hashLow}
+	}
+	return core.ImportResult{Status: common.IMPORTED_NOT_BEST, HashLow: hashLow}
+}
+
+// GetBlockByTime returns the blocks of the epochs from startTime up to endTime
+func (c *memChain) GetBlockByTime(startTime, endTime uint64) []*core.Block {
+	c.Lock()
+	defer c.Unlock()
+	var res []*core.Block
+	for _, block := range c.blocks {
+		ts := block.GetTimestamp()
+		if utils.GetEpoch(ts) >= utils.GetEpoch(startTime) && ts < endTime {
+			res = append(res, block)
+		}
+	}
+	return res
+}
+
+func (c *memChain) mainChain() map[uint64]common.Hash {
+	c.Lock()
+	defer c.Unlock()
+	res := make(map[uint64]common.Hash)
+	for k, v := range c.main {
+		res[k] = v
+	}
+	return res
+}
+
+type testNode struct {
+	config  *config.Config
+	chain   *memChain
+	service *Service
+}
+
+func newTestNode(t *testing.T, hub *LoopbackHub, host string) *testNode {
+	c := testConfig(common.DEVNET)
+	c.SetNodeIp(host)
+	c.SetNodePort(8001)
+	c.SetMaxConnections(16)
+	c.SetConnectionTimeout(1000)
+
+	chain := newMemChain()
+	n := &testNode{
+		config:  c,
+		chain:   chain,
+		service: NewService(c, hub.Transport(host), chain, nil),
+	}
+	assert.Equal(t, n.service.Start(), nil)
+	return n
+}
+
+func (n *testNode) stop() {
+	n.service.Stop()
+}
+
+// inject imports a locally created block and gossips it
+func (n *testNode) inject(block *core.Block) {
+	res := n.chain.TryToConnect(block)
+	if res.IsNormal() {
+		n.service.Broadcast(block)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not reached in time")
+}
+
+func TestLoopbackNetwork(t *testing.T) {
+	hub := NewLoopbackHub()
+	var nodes []*testNode
+	for i := 1; i <= 3; i++ {
+		nodes = append(nodes, newTestNode(t, hub, "10.0.0."+strconv.Itoa(i)))
+	}
+	defer func() {
+		for _, n := range nodes {
+			n.stop()
+		}
+	}()
+
+	// blocks made before the nodes are connected reach peers on connect
+	key, _ := secp256k1.GeneratePrivateKey()
+	now := utils.GetCurrentTimestamp()
+	nodes[0].inject(core.GenerateAddressBlock(nodes[0].config, key, now))
+
+	// a line topology: 1 <-> 2 <-> 3, blocks must be relayed by the middle node
+	assert.Equal(t, nodes[0].service.Connect("10.0.0.2:8001"), nil)
+	assert.Equal(t, nodes[2].service.Connect("10.0.0.2:8001"), nil)
+	waitFor(t, func() bool {
+		in, _ := nodes[1].service.PeerManager().PeerCount()
+		return in == 2
+	})
+
+	for i := 0; i < 10; i++ {
+		k, _ := secp256k1.GeneratePrivateKey()
+		n := nodes[i%len(nodes)]
+		n.inject(core.GenerateAddressBlock(n.config, k, now-uint64(i)*common.MAIN_CHAIN_PERIOD/4))
+	}
+
+	want := 11
+	waitFor(t, func() bool {
+		for _, n := range nodes {
+			if len(n.chain.GetBlockByTime(now-common.REQUEST_BLOCKS_MAX_TIME, now+common.MAIN_CHAIN_PERIOD)) != want {
+				return false
+			}
+		}
+		return true
+	})
+	main := nodes[0].chain.mainChain()
+	for _, n := range nodes[1:] {
+		assert.Equal(t, n.chain.mainChain(), main)
+	}
+
+	peer := nodes[1].service.PeerManager().Peers()[0]
+	assert.Equal(t, peer.BytesIn.Get() > 0, true)
+	assert.Equal(t, nodes[1].service.Stats().NHosts, 2)
+}
+
+// rawPeer completes the handshake with the node at address and returns the
+// connection to write raw messages on
+func rawPeer(t *testing.T, hub *LoopbackHub, host, address string) net.Conn {
+	c := testConfig(common.DEVNET)
+	conn, err := hub.Transport(host).Dial(address, time.Second)
+	assert.Equal(t, err, nil)
+	local := NewHandshake(c, node.NewNode(host, 8001), core.NewEmptyXDAGStats())
+	_, err = DoHandshake(conn, c, local)
+	assert.Equal(t, err, nil)
+	return conn
+}
+
+func TestLoopbackMisbehave(t *testing.T) {
+	hub := NewLoopbackHub()
+	n := newTestNode(t, hub, "10.0.3.1")
+	defer n.stop()
+	n.config.SetConnectionReadTimeout(100)
+	pm := n.service.PeerManager()
+
+	// a block sent twice costs the duplicate penalty
+	conn := rawPeer(t, hub, "10.0.3.2", "10.0.3.1:8001")
+	defer conn.Close()
+	waitFor(t, func() bool { return len(pm.Peers()) == 1 })
+	key, _ := secp256k1.GeneratePrivateKey()
+	block := core.GenerateAddressBlock(n.config, key, utils.GetCurrentTimestamp())
+	data := block.GetXdagBlock().GetData()
+	for i := 0; i < 2; i++ {
+		_, err := conn.Write(data[:])
+		assert.Equal(t, err, nil)
+	}
+	peer := pm.Peers()[0]
+	waitFor(t, func() bool { return peer.Score() == common.MISBEHAVE_DUPLICATE_BLOCK })
+
+	// messages which are no block get the host banned
+	var junk [common.XDAG_BLOCK_SIZE]byte
+	for i := int32(0); i < common.PEER_BAN_SCORE/common.MISBEHAVE_INVALID_MESSAGE; i++ {
+		conn.Write(junk[:])
+	}
+	waitFor(t, func() bool { return pm.IsBanned("10.0.3.2") })
+
+	// a block not completed within the read timeout drops the peer
+	idle := rawPeer(t, hub, "10.0.3.3", "10.0.3.1:8001")
+	defer idle.Close()
+	waitFor(t, func() bool { return len(pm.Peers()) == 1 })
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, len(pm.Peers()), 1)
+	_, err := idle.Write(data[:10])
+	assert.Equal(t, err, nil)
+	waitFor(t, func() bool { return len(pm.Peers()) == 0 })
+}
+
+func TestLoopbackRejectOtherNetwork(t *testing.T) {
+	hub := NewLoopbackHub()
+	dev := newTestNode(t, hub, "10.0.1.1")
+	defer dev.stop()
+
+	c := testConfig(common.TESTNET)
+	c.SetNodeIp("10.0.1.2")
+	c.SetNodePort(8001)
+	c.SetMaxConnections(16)
+	test := NewService(c, hub.Transport("10.0.1.2"), dev.chain, nil)
+	assert.Equal(t, test.Start(), nil)
+	defer test.Stop()
+
+	err := test.Connect("10.0.1.1:8001")
+	assert.Equal(t, err != nil, true)
+	assert.Equal(t, len(test.PeerNodes()), 0)
+}
+
+func TestLoopbackWhitelist(t *testing.T) {
+	hub := NewLoopbackHub()
+	white := newTestNode(t, hub, "10.0.2.2")
+	defer white.stop()
+	other := newTestNode(t, hub, "10.0.2.3")
+	defer other.stop()
+
+	c := testConfig(common.DEVNET)
+	c.SetNodeIp("10.0.2.1")
+	c.SetNodePort(8001)
+	c.SetMaxConnections(16)
+	c.SetEnableWhitelist(true)
+	c.SetWhiteIPList([]string{"10.0.2.2:8001"})
+	c.SetNetDBDir(filepath.Join(t.TempDir(), "netdb.txt"))
+	guarded := NewService(c, hub.Transport("10.0.2.1"), white.chain, nil)
+	assert.Equal(t, guarded.Start(), nil)
+	defer guarded.Stop()
+
+	assert.Equal(t, other.service.Connect("10.0.2.1:8001") != nil, true)
+	assert.Equal(t, len(guarded.PeerNodes()), 0)
+
+	assert.Equal(t, white.service.Connect("10.0.2.1:8001"), nil)
+	waitFor(t, func() bool { return len(guarded.PeerNodes()) == 1 })
+
+	// the guarded node dials out and keeps the peer in netdb.txt
+	assert.Equal(t, guarded.Connect("10.0.2.3:8001"), nil)
+	nodes := guarded.NetDB().Nodes()
+	assert.Equal(t, len(nodes), 2)
+	data, err := ioutil.ReadFile(c.NetDBDir())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.Contains(string(data), "10.0.2.3:8001"), true)
+}
+
+func TestTCPTransport(t *testing.T) {
+	tr := NewTCPTransport()
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Skip("tcp not available:", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Write([]byte("xdag"))
+			conn.Close()
+		}
+	}()
+	conn, err := tr.Dial(l.Addr().String(), time.Second)
+	assert.Equal(t, err, nil)
+	defer conn.Close()
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(buf), "xdag")
+}
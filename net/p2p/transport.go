@@ -0,0 +1,27 @@
+package p2p
+
+import (
+	"net"
+	"time"
+)
+
+// Transport opens connections between nodes. TCPTransport is used in
+// production, LoopbackHub connects nodes inside one process for tests.
+type Transport interface {
+	Listen(address string) (net.Listener, error)
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+}
+
+type TCPTransport struct{}
+
+func NewTCPTransport() *TCPTransport {
+	return &TCPTransport{}
+}
+
+func (t *TCPTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+func (t *TCPTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, timeout)
+}
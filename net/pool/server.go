@@ -0,0 +1,556 @@
+// Package pool serves miners speaking the original XDAG pool protocol: every
+// message is a 32 bytes field encrypted with dfslib, the miner first sends its
+// address block, then worker names and shares, the pool sends task fields.
+package pool
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/crypto"
+	"xdago/crypto/dfslib"
+	"xdago/log"
+	"xdago/utils"
+)
+
+const (
+	LOG_WEIGHT_SHARES   = 64 // shares the weight of a share is averaged over
+	MAX_CONN_WORKERS    = 8  // worker names a connection may register
+	MAX_MINER_WORKERS   = 64 // worker names kept in the stats of a miner
+	MAX_WORKER_NAME_LEN = 20 // longer worker names are refused
+
+	ACCEPT_MAX_DELAY = time.Second // longest wait after a temporary accept error
+)
+
+var (
+	ErrServerStopped = errors.New("pool server stopped")
+	ErrGlobalLimit   = errors.New("pool: global miner limit reached")
+	ErrIpLimit       = errors.New("pool: connection limit per ip reached")
+	ErrAccountLimit  = errors.New("pool: too many miners for account")
+	ErrBlockHeader   = errors.New("pool: illegal miner block header")
+	ErrBlockCrc      = errors.New("pool: miner block crc mismatch")
+	ErrWrongAddress  = errors.New("pool: share of another address")
+)
+
+// MinerStats is the share accounting of one miner address
+type MinerStats struct {
+	Address    common.Hash // hash low of the miner address block
+	Workers    []string
+	Shares     uint64
+	Rejected   uint64
+	Difficulty *big.Int // sum of accepted share weights
+	LastShare  time.Time
+}
+
+type miner struct {
+	stats MinerStats
+	conns int
+}
+
+type conn struct {
+	conn       net.Conn
+	host       string
+	miner      *miner
+	hash       common.Hash // hash of the miner address block
+	worker     string
+	workers    []string // worker names registered on the connection
+	nfieldIn   uint64
+	nfieldOut  uint64
+	writeLock  sync.Mutex
+	taskIndex  uint64
+	shareCount int
+	nonces     map[common.Field]struct{}
+	logShares  int     // shares in logMean
+	logMean    float64 // mean log of the share difficulty
+}
+
+// shareWeight returns the weight of a share of difficulty. Xdag miners have
+// no share target, so as in classic xdag the share is counted by the mean log
+// of the difficulty of the last LOG_WEIGHT_SHARES shares of the connection
+// and a lucky hash moves the weight by its log only.
+func (c *conn) shareWeight(difficulty *big.Int) *big.Int {
+	f, _ := new(big.Float).SetInt(difficulty).Float64()
+	if c.logShares < LOG_WEIGHT_SHARES {
+		c.logShares++
+	}
+	c.logMean += (math.Log(f) - c.logMean) / float64(c.logShares)
+	weight, _ := big.NewFloat(math.Exp(c.logMean)).Int(nil)
+	return weight
+}
+
+// Server hands out the current consensus.Task to xdag miners
+type Server struct {
+	sync.RWMutex
+	config   *config.Config
+	crypt    *dfslib.Crypt
+	listener net.Listener
+	task     *consensus.Task
+	conns    map[*conn]struct{}
+	ipCount  map[string]int
+	miners   map[common.Hash]*miner
+	best     map[uint64]consensus.Share
+	handler  func(consensus.Share)
+	// rejectHandler is called for refused shares
+	rejectHandler func(consensus.Share, consensus.ShareStatus)
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	now  func() time.Time
+}
+
+func NewServer(config *config.Config) *Server {
+	return &Server{
+		config:  config,
+		crypt:   dfslib.MinerCrypt(common.MINERS_PWD),
+		conns:   make(map[*conn]struct{}),
+		ipCount: make(map[string]int),
+		miners:  make(map[common.Hash]*miner),
+		best:    make(map[uint64]consensus.Share),
+		quit:    make(chan struct{}),
+		now:     utils.Now,
+	}
+}
+
+// SetShareHandler registers a callback called for every accepted share
+func (s *Server) SetShareHandler(handler func(consensus.Share)) {
+	s.Lock()
+	defer s.Unlock()
+	s.handler = handler
+}
+
+// SetRejectHandler registers a callback called for every refused share
+func (s *Server) SetRejectHandler(handler func(consensus.Share, consensus.ShareStatus)) {
+	s.Lock()
+	defer s.Unlock()
+	s.rejectHandler = handler
+}
+
+// Start listens on pool.ip:pool.port
+func (s *Server) Start() error {
+	address := net.JoinHostPort(s.config.PoolIp(), strconv.Itoa(s.config.PoolPort()))
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.Serve(l)
+	return nil
+}
+
+// Serve accepts miners on l in the background
+func (s *Server) Serve(l net.Listener) {
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.quit:
+		l.Close()
+		return
+	default:
+	}
+	s.listener = l
+	log.Info("pool server started", log.Ctx{"address": l.Addr().String()})
+	s.wg.Add(1)
+	go s.acceptLoop(l)
+}
+
+// Stop closes the listener and the connections and waits for their
+// goroutines. It may be called more than once and while miners connect,
+// quit is closed under the lock addConn checks it with.
+func (s *Server) Stop() {
+	s.Lock()
+	select {
+	case <-s.quit:
+		s.Unlock()
+		return
+	default:
+	}
+	close(s.quit)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.Unlock()
+	s.wg.Wait()
+	log.Info("pool server stopped")
+}
+
+// acceptLoop accepts miners until the listener fails, a temporary error such
+// as too many open files is retried after a growing delay
+func (s *Server) acceptLoop(l net.Listener) {
+	defer s.wg.Done()
+	var delay time.Duration
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); !ok || !ne.Temporary() {
+				log.Error("pool server stops accepting miners", log.Ctx{"err": err.Error()})
+				return
+			}
+			if delay *= 2; delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay > ACCEPT_MAX_DELAY {
+				delay = ACCEPT_MAX_DELAY
+			}
+			log.Warn("accept miner failed", log.Ctx{"err": err.Error(), "retry": delay.String()})
+			select {
+			case <-time.After(delay):
+			case <-s.quit:
+				return
+			}
+			continue
+		}
+		delay = 0
+		c, err := s.addConn(nc)
+		if err != nil {
+			log.Debug("reject miner", log.Ctx{"remote": nc.RemoteAddr().String(), "err": err.Error()})
+			nc.Close()
+			continue
+		}
+		go func() {
+			defer s.wg.Done()
+			if err := s.serveConn(c); err != nil && err != io.EOF {
+				log.Debug("miner disconnected", log.Ctx{"host": c.host, "err": err.Error()})
+			}
+		}()
+	}
+}
+
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (s *Server) addConn(nc net.Conn) (*conn, error) {
+	host := hostOf(nc.RemoteAddr())
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.quit:
+		return nil, ErrServerStopped
+	default:
+	}
+	if limit := s.config.GlobalMinerLimit(); limit > 0 && len(s.conns) >= limit {
+		return nil, ErrGlobalLimit
+	}
+	if limit := s.config.MaxConnectPerIp(); limit > 0 && s.ipCount[host] >= limit {
+		return nil, ErrIpLimit
+	}
+	c := &conn{
+		conn:   nc,
+		host:   host,
+		nonces: make(map[common.Field]struct{}),
+	}
+	s.conns[c] = struct{}{}
+	s.ipCount[host]++
+	// counted before the lock is released so Stop waits for it
+	s.wg.Add(1)
+	return c, nil
+}
+
+func (s *Server) removeConn(c *conn) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.conns, c)
+	if s.ipCount[c.host]--; s.ipCount[c.host] <= 0 {
+		delete(s.ipCount, c.host)
+	}
+	if c.miner != nil {
+		c.miner.conns--
+	}
+}
+
+func (s *Server) serveConn(c *conn) error {
+	defer func() {
+		c.conn.Close()
+		s.removeConn(c)
+	}()
+
+	var block common.RawBlock
+	if _, err := io.ReadFull(c.conn, block[:]); err != nil {
+		return err
+	}
+	c.nfieldIn = s.crypt.DecryptFields(block[:], c.nfieldIn)
+	hash, err := checkMinerBlock(&block)
+	if err != nil {
+		return err
+	}
+	if err = s.login(c, hash); err != nil {
+		return err
+	}
+	if err = s.sendTask(c); err != nil {
+		return err
+	}
+
+	var field common.Field
+	for {
+		if _, err = io.ReadFull(c.conn, field[:]); err != nil {
+			return err
+		}
+		c.nfieldIn = s.crypt.DecryptFields(field[:], c.nfieldIn)
+		if binary.LittleEndian.Uint32(field[:4]) == common.WORKERNAME_HEADER_WORD {
+			s.setWorker(c, workerName(field[4:]))
+			continue
+		}
+		if err = s.submit(c, field); err != nil {
+			return err
+		}
+	}
+}
+
+// checkMinerBlock verifies the transport header of the address block sent by
+// a miner: the low word is BLOCK_HEAD_WORD and the high word the crc32 of the
+// block. It returns the hash of the block with a zero transport header.
+func checkMinerBlock(block *common.RawBlock) (common.Hash, error) {
+	header := binary.LittleEndian.Uint64(block[:8])
+	if uint32(header) != uint32(common.BLOCK_HEAD_WORD) {
+		return common.Hash{}, ErrBlockHeader
+	}
+	binary.LittleEndian.PutUint64(block[:8], uint64(uint32(header)))
+	if crc32.ChecksumIEEE(block[:]) != uint32(header>>32) {
+		return common.Hash{}, ErrBlockCrc
+	}
+	binary.LittleEndian.PutUint64(block[:8], 0)
+	return crypto.HashTwice(block[:]), nil
+}
+
+func workerName(data []byte) string {
+	if i := strings.IndexByte(string(data), 0); i >= 0 {
+		data = data[:i]
+	}
+	name := string(data)
+	if !utils.IsAsciiPrintable(name) {
+		return ""
+	}
+	return name
+}
+
+// addressOf returns the hash low used as miner address
+func addressOf(hash common.Hash) common.Hash {
+	var res common.Hash
+	copy(res[8:], hash[8:])
+	return res
+}
+
+func (s *Server) login(c *conn, hash common.Hash) error {
+	address := addressOf(hash)
+	s.Lock()
+	defer s.Unlock()
+	m, ok := s.miners[address]
+	if !ok {
+		m = &miner{stats: MinerStats{Address: address, Difficulty: new(big.Int)}}
+		s.miners[address] = m
+	}
+	if limit := s.config.MaxMinerPerAccount(); limit > 0 && m.conns >= limit {
+		return ErrAccountLimit
+	}
+	m.conns++
+	c.miner = m
+	c.hash = hash
+	log.Debug("miner connected", log.Ctx{"address": utils.Hash2String(address), "host": c.host})
+	return nil
+}
+
+// setWorker sets the worker name of the next shares of c. A connection has
+// at most MAX_CONN_WORKERS names and the miner stats keep MAX_MINER_WORKERS.
+func (s *Server) setWorker(c *conn, name string) {
+	if len(name) > MAX_WORKER_NAME_LEN {
+		log.Debug("worker name too long", log.Ctx{"host": c.host, "len": len(name)})
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	known := false
+	for _, w := range c.workers {
+		if w == name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		if len(c.workers) >= MAX_CONN_WORKERS {
+			log.Debug("too many worker names", log.Ctx{"host": c.host, "name": name})
+			return
+		}
+		c.workers = append(c.workers, name)
+	}
+	c.worker = name
+	for _, w := range c.miner.stats.Workers {
+		if w == name {
+			return
+		}
+	}
+	if len(c.miner.stats.Workers) < MAX_MINER_WORKERS {
+		c.miner.stats.Workers = append(c.miner.stats.Workers, name)
+	}
+}
+
+// submit checks a share, a share starts with the first 24 bytes of the miner
+// address block hash followed by the nonce
+func (s *Server) submit(c *conn, nonce common.Field) error {
+	if string(nonce[:24]) != string(c.hash[:24]) {
+		s.reject(c, nonce, consensus.SHARE_INVALID)
+		return ErrWrongAddress
+	}
+
+	s.Lock()
+	task := s.task
+	if task == nil {
+		s.Unlock()
+		s.reject(c, nonce, consensus.SHARE_STALE)
+		return nil
+	}
+	if c.taskIndex != task.TaskIndex() {
+		c.taskIndex = task.TaskIndex()
+		c.shareCount = 0
+		c.nonces = make(map[common.Field]struct{})
+	}
+	_, dup := c.nonces[nonce]
+	limit := s.config.MaxShareCountPerChannel()
+	if dup || (limit > 0 && c.shareCount >= limit) {
+		s.Unlock()
+		s.reject(c, nonce, consensus.SHARE_INVALID)
+		return nil
+	}
+	c.nonces[nonce] = struct{}{}
+	c.shareCount++
+	account, worker := utils.Hash2String(c.miner.stats.Address), c.worker
+	s.Unlock()
+
+	hash := task.ShareHash(nonce)
+	share := consensus.Share{
+		Account:          account,
+		Worker:           worker,
+		TaskIndex:        task.TaskIndex(),
+		Nonce:            nonce,
+		Hash:             hash,
+		ActualDifficulty: consensus.HashDifficulty(hash),
+		Time:             s.now(),
+	}
+
+	s.Lock()
+	share.Difficulty = c.shareWeight(share.ActualDifficulty)
+	stats := &c.miner.stats
+	stats.Shares++
+	stats.Difficulty.Add(stats.Difficulty, share.Difficulty)
+	stats.LastShare = share.Time
+	if best, ok := s.best[share.TaskIndex]; !ok || share.ActualDifficulty.Cmp(best.ActualDifficulty) > 0 {
+		s.best[share.TaskIndex] = share
+	}
+	handler := s.handler
+	s.Unlock()
+	if handler != nil {
+		handler(share)
+	}
+	return nil
+}
+
+// reject counts a refused share and reports it
+func (s *Server) reject(c *conn, nonce common.Field, status consensus.ShareStatus) {
+	s.Lock()
+	c.miner.stats.Rejected++
+	share := consensus.Share{
+		Account: utils.Hash2String(c.miner.stats.Address),
+		Worker:  c.worker,
+		Nonce:   nonce,
+		Time:    s.now(),
+	}
+	handler := s.rejectHandler
+	s.Unlock()
+	if handler != nil {
+		handler(share, status)
+	}
+}
+
+// BestShare returns the share with the highest difficulty found for a task
+func (s *Server) BestShare(taskIndex uint64) (consensus.Share, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	share, ok := s.best[taskIndex]
+	return share, ok
+}
+
+// Miners returns the share accounting of every miner address seen
+func (s *Server) Miners() []MinerStats {
+	s.RLock()
+	defer s.RUnlock()
+	res := make([]MinerStats, 0, len(s.miners))
+	for _, m := range s.miners {
+		stats := m.stats
+		stats.Workers = append([]string{}, m.stats.Workers...)
+		stats.Difficulty = new(big.Int).Set(m.stats.Difficulty)
+		res = append(res, stats)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return utils.Hash2String(res[i].Address) < utils.Hash2String(res[j].Address)
+	})
+	return res
+}
+
+func (s *Server) Task() *consensus.Task {
+	s.RLock()
+	defer s.RUnlock()
+	return s.task
+}
+
+// SetTask replaces the current task and sends it to every logged in miner
+func (s *Server) SetTask(task *consensus.Task) {
+	s.Lock()
+	s.task = task
+	for index := range s.best {
+		if index+1 < task.TaskIndex() {
+			delete(s.best, index)
+		}
+	}
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		if c.miner != nil {
+			conns = append(conns, c)
+		}
+	}
+	s.Unlock()
+	for _, c := range conns {
+		if err := s.sendTask(c); err != nil {
+			c.conn.Close()
+		}
+	}
+}
+
+// sendTask sends the SHA-256 state of the first 14 fields and the 15th field
+// of the current task, the miner fills in the last one
+func (s *Server) sendTask(c *conn) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	task := s.Task()
+	if task == nil {
+		return nil
+	}
+	var data [2 * common.XDAG_FIELD_SIZE]byte
+	midstate := task.Midstate()
+	block := task.Block()
+	copy(data[:], midstate[:])
+	copy(data[common.XDAG_FIELD_SIZE:], block[(common.XDAG_BLOCK_FIELDS-2)*common.XDAG_FIELD_SIZE:])
+	c.nfieldOut = s.crypt.EncryptFields(data[:], c.nfieldOut)
+	_, err := c.conn.Write(data[:])
+	return err
+}
@@ -0,0 +1,374 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package pool
+
+import (
+	"encoding/binary"
+	"github.com/magiconair/properties/assert"
+	"hash/crc32"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"xdago/common"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/crypto"
+	"xdago/crypto/dfslib"
+)
+
+// testMiner speaks the miner side of the protocol
+type testMiner struct {
+	conn      net.Conn
+	crypt     *dfslib.Crypt
+	hash      common.Hash
+	nfieldIn  uint64
+	nfieldOut uint64
+}
+
+func dialMiner(t *testing.T, address string, seed byte) *testMiner {
+	conn, err := net.Dial("tcp", address)
+	assert.Equal(t, err, nil)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	m := &testMiner{conn: conn, crypt: dfslib.MinerCrypt(common.MINERS_PWD)}
+
+	var block common.RawBlock
+	for i := range block {
+		block[i] = byte(i) ^ seed
+	}
+	binary.LittleEndian.PutUint64(block[:8], 0)
+	m.hash = crypto.HashTwice(block[:])
+	binary.LittleEndian.PutUint64(block[:8], uint64(common.BLOCK_HEAD_WORD))
+	crc := crc32.ChecksumIEEE(block[:])
+	binary.LittleEndian.PutUint64(block[:8], uint64(crc)<<32|uint64(common.BLOCK_HEAD_WORD))
+	m.send(t, block[:])
+	return m
+}
+
+func (m *testMiner) send(t *testing.T, data []byte) {
+	buf := append([]byte{}, data...)
+	m.nfieldOut = m.crypt.EncryptFields(buf, m.nfieldOut)
+	_, err := m.conn.Write(buf)
+	assert.Equal(t, err, nil)
+}
+
+func (m *testMiner) readTask(t *testing.T) (common.Field, common.Field) {
+	var data [2 * common.XDAG_FIELD_SIZE]byte
+	_, err := io.ReadFull(m.conn, data[:])
+	assert.Equal(t, err, nil)
+	m.nfieldIn = m.crypt.DecryptFields(data[:], m.nfieldIn)
+	var midstate, field common.Field
+	copy(midstate[:], data[:32])
+	copy(field[:], data[32:])
+	return midstate, field
+}
+
+func (m *testMiner) sendWorker(t *testing.T, name string) {
+	var field common.Field
+	binary.LittleEndian.PutUint32(field[:4], common.WORKERNAME_HEADER_WORD)
+	copy(field[4:], name)
+	m.send(t, field[:])
+}
+
+func (m *testMiner) share(nonce uint64) common.Field {
+	var field common.Field
+	copy(field[:24], m.hash[:24])
+	binary.LittleEndian.PutUint64(field[24:], nonce)
+	return field
+}
+
+func (m *testMiner) sendShare(t *testing.T, nonce uint64) {
+	field := m.share(nonce)
+	m.send(t, field[:])
+}
+
+func testTask(index uint64) *consensus.Task {
+	var block common.RawBlock
+	for i := range block {
+		block[i] = byte(i*3 + int(index))
+	}
+	return consensus.NewTask(block, 0x1234<<16, index)
+}
+
+func startServer(t *testing.T, c *config.Config) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("tcp not available:", err)
+	}
+	s := NewServer(c)
+	s.Serve(l)
+	return s, l.Addr().String()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not reached in time")
+}
+
+// shareWeight is the weight of the last share of diffs on a new conn
+func shareWeight(diffs ...*big.Int) *big.Int {
+	c := &conn{}
+	var w *big.Int
+	for _, d := range diffs {
+		w = c.shareWeight(d)
+	}
+	return w
+}
+
+func TestShareWeight(t *testing.T) {
+	// a lucky share moves the weight by its log only
+	d := new(big.Int).Lsh(big.NewInt(1), 40)
+	lucky := new(big.Int).Lsh(big.NewInt(1), 80)
+	diffs := []*big.Int{d, d, d, lucky}
+	w := shareWeight(diffs...)
+	assert.Equal(t, w.Cmp(new(big.Int).Lsh(big.NewInt(1), 49)) > 0, true)
+	assert.Equal(t, w.Cmp(new(big.Int).Lsh(big.NewInt(1), 51)) < 0, true)
+	assert.Equal(t, shareWeight(d), d)
+}
+
+func TestPoolShares(t *testing.T) {
+	c := &config.Config{}
+	c.SetMaxShareCountPerChannel(2)
+	s, address := startServer(t, c)
+	defer s.Stop()
+
+	var lock sync.Mutex
+	var accepted []consensus.Share
+	s.SetShareHandler(func(share consensus.Share) {
+		lock.Lock()
+		accepted = append(accepted, share)
+		lock.Unlock()
+	})
+	var rejected []consensus.ShareStatus
+	s.SetRejectHandler(func(share consensus.Share, status consensus.ShareStatus) {
+		lock.Lock()
+		rejected = append(rejected, status)
+		lock.Unlock()
+	})
+	task := testTask(1)
+	s.SetTask(task)
+
+	m := dialMiner(t, address, 1)
+	defer m.conn.Close()
+	midstate, field := m.readTask(t)
+	block := task.Block()
+	assert.Equal(t, midstate, task.Midstate())
+	assert.Equal(t, field[:], block[448:480])
+
+	m.sendWorker(t, "rig1")
+	m.sendShare(t, 1)
+	m.sendShare(t, 1) // duplicate
+	m.sendShare(t, 2)
+	m.sendShare(t, 3) // over maxShareCountPerChannel
+	waitFor(t, func() bool {
+		miners := s.Miners()
+		return len(miners) == 1 && miners[0].Shares+miners[0].Rejected == 4
+	})
+
+	miners := s.Miners()
+	assert.Equal(t, miners[0].Shares, uint64(2))
+	assert.Equal(t, miners[0].Rejected, uint64(2))
+	assert.Equal(t, miners[0].Workers, []string{"rig1"})
+	lock.Lock()
+	assert.Equal(t, len(accepted), 2)
+	assert.Equal(t, rejected, []consensus.ShareStatus{consensus.SHARE_INVALID, consensus.SHARE_INVALID})
+	assert.Equal(t, accepted[0].Worker, "rig1")
+	assert.Equal(t, accepted[0].Hash, task.ShareHash(m.share(1)))
+	assert.Equal(t, accepted[0].ActualDifficulty, consensus.HashDifficulty(accepted[0].Hash))
+	// the weight is the geometric mean of the share difficulty of the conn
+	assert.Equal(t, accepted[0].Difficulty, shareWeight(accepted[0].ActualDifficulty))
+	assert.Equal(t, accepted[1].Difficulty, shareWeight(accepted[0].ActualDifficulty, accepted[1].ActualDifficulty))
+	total := new(big.Int).Add(accepted[0].Difficulty, accepted[1].Difficulty)
+	lock.Unlock()
+	assert.Equal(t, miners[0].Difficulty.Cmp(total), 0)
+	_, ok := s.BestShare(1)
+	assert.Equal(t, ok, true)
+
+	// a new task is pushed to connected miners
+	next := testTask(2)
+	s.SetTask(next)
+	midstate, _ = m.readTask(t)
+	assert.Equal(t, midstate, next.Midstate())
+	m.sendShare(t, 3)
+	waitFor(t, func() bool {
+		return s.Miners()[0].Shares == 3
+	})
+
+	// a share for another address drops the connection
+	other := m.share(4)
+	other[0] ^= 0xff
+	m.send(t, other[:])
+	_, err := m.conn.Read(make([]byte, 1))
+	assert.Equal(t, err != nil, true)
+}
+
+func TestPoolRejectBadBlock(t *testing.T) {
+	s, address := startServer(t, &config.Config{})
+	defer s.Stop()
+	s.SetTask(testTask(1))
+
+	conn, err := net.Dial("tcp", address)
+	assert.Equal(t, err, nil)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	data := make([]byte, common.XDAG_BLOCK_SIZE)
+	binary.LittleEndian.PutUint64(data, uint64(common.BLOCK_HEAD_WORD))
+	dfslib.MinerCrypt(common.MINERS_PWD).EncryptFields(data, 0)
+	_, err = conn.Write(data)
+	assert.Equal(t, err, nil)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, err != nil, true)
+	assert.Equal(t, len(s.Miners()), 0)
+}
+
+func TestPoolAccountLimit(t *testing.T) {
+	c := &config.Config{}
+	c.SetMaxMinerPerAccount(1)
+	s, address := startServer(t, c)
+	defer s.Stop()
+	s.SetTask(testTask(1))
+
+	m1 := dialMiner(t, address, 7)
+	defer m1.conn.Close()
+	m1.readTask(t)
+
+	// the same address again is over the limit
+	m2 := dialMiner(t, address, 7)
+	defer m2.conn.Close()
+	_, err := m2.conn.Read(make([]byte, 1))
+	assert.Equal(t, err != nil, true)
+
+	m3 := dialMiner(t, address, 8)
+	defer m3.conn.Close()
+	m3.readTask(t)
+	assert.Equal(t, len(s.Miners()), 2)
+}
+
+func TestPoolWorkerLimits(t *testing.T) {
+	s, address := startServer(t, &config.Config{})
+	defer s.Stop()
+	var lock sync.Mutex
+	var workers []string
+	s.SetShareHandler(func(share consensus.Share) {
+		lock.Lock()
+		workers = append(workers, share.Worker)
+		lock.Unlock()
+	})
+	s.SetTask(testTask(1))
+
+	m := dialMiner(t, address, 3)
+	defer m.conn.Close()
+	m.readTask(t)
+	var names []string
+	for i := 0; i < MAX_CONN_WORKERS+2; i++ {
+		name := "rig" + strconv.Itoa(i)
+		names = append(names, name)
+		m.sendWorker(t, name)
+	}
+	m.sendShare(t, 1)
+	// a long name is refused and the worker stays the last accepted one
+	m.sendWorker(t, "a-worker-name-over-the-limit")
+	m.sendShare(t, 2)
+	m.sendWorker(t, "rig0")
+	m.sendShare(t, 3)
+	waitFor(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(workers) == 3
+	})
+	assert.Equal(t, s.Miners()[0].Workers, names[:MAX_CONN_WORKERS])
+	lock.Lock()
+	assert.Equal(t, workers, []string{names[MAX_CONN_WORKERS-1], names[MAX_CONN_WORKERS-1], "rig0"})
+	lock.Unlock()
+}
+
+// errListener fails Accept with the queued errors and then as closed
+type errListener struct {
+	net.Listener
+	lock    sync.Mutex
+	errs    []error
+	accepts int
+}
+
+type tempError struct{}
+
+func (tempError) Error() string   { return "temporary" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+func (l *errListener) Accept() (net.Conn, error) {
+	l.lock.Lock()
+	l.accepts++
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		l.lock.Unlock()
+		return nil, err
+	}
+	l.lock.Unlock()
+	return nil, net.ErrClosed
+}
+
+func (l *errListener) count() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.accepts
+}
+
+func (l *errListener) Close() error { return nil }
+
+func (l *errListener) Addr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func TestPoolAcceptErrors(t *testing.T) {
+	// temporary errors are retried, another error stops the accept loop
+	l := &errListener{errs: []error{tempError{}, tempError{}, io.ErrUnexpectedEOF}}
+	s := NewServer(&config.Config{})
+	s.Serve(l)
+	waitFor(t, func() bool { return l.count() == 3 })
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, l.count(), 3)
+	s.Stop()
+
+	// a server stopped while miners connect waits for their goroutines
+	s, address := startServer(t, &config.Config{})
+	s.SetTask(testTask(1))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if conn, err := net.Dial("tcp", address); err == nil {
+				conn.Close()
+			}
+		}()
+	}
+	var stops sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		stops.Add(1)
+		go func() {
+			defer stops.Done()
+			s.Stop()
+		}()
+	}
+	stops.Wait()
+	wg.Wait()
+	assert.Equal(t, len(s.Miners()), 0)
+
+	// a stopped server does not serve again
+	s.Serve(l)
+	s.RLock()
+	assert.Equal(t, s.listener != l, true)
+	s.RUnlock()
+}
@@ -0,0 +1,389 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"strings"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/consensus"
+	"xdago/core"
+	"xdago/net/auth"
+	"xdago/utils"
+)
+
+const (
+	MAX_MAIN_BLOCKS   = 100
+	MINER_HISTORY     = 64 // default epochs of xdag_getMinerHistory
+	ERR_POOL_DISABLED = "pool is not enabled"
+)
+
+type method func(s *Server, params json.RawMessage) (interface{}, *Error)
+
+var methods = map[string]method{
+	"xdag_getBlockByHash":           (*Server).getBlockByHash,
+	"xdag_getBlockByNumber":         (*Server).getBlockByNumber,
+	"xdag_blockNumber":              (*Server).blockNumber,
+	"xdag_getMainBlocks":            (*Server).getMainBlocks,
+	"xdag_getBalance":               (*Server).getBalance,
+	"xdag_getTotalBalance":          (*Server).getTotalBalance,
+	"xdag_getSupply":                (*Server).getSupply,
+	"xdag_getStatus":                (*Server).getStatus,
+	"xdag_sendRawTransaction":       (*Server).sendRawTransaction,
+	"xdag_personal_sendTransaction": (*Server).sendTransaction,
+	"xdag_generate":                 (*Server).generate,
+	"xdag_getMiners":                (*Server).getMiners,
+	"xdag_getMinerHistory":          (*Server).getMinerHistory,
+	"xdag_getPoolHashrate":          (*Server).getPoolHashrate,
+}
+
+// groups of the methods for the allowlists of rpc.credentials and
+// rpc.anonymous. The read methods are safe to expose, the wallet ones see or
+// move our xdag, the node ones change the local chain.
+var groups = auth.Groups{
+	"read": {"xdag_getBlockByHash", "xdag_getBlockByNumber", "xdag_blockNumber", "xdag_getMainBlocks",
+		"xdag_getBalance", "xdag_getSupply", "xdag_getStatus", "xdag_subscribe", "xdag_unsubscribe",
+		"xdag_getMiners", "xdag_getMinerHistory", "xdag_getPoolHashrate"},
+	"send":   {"xdag_sendRawTransaction"},
+	"wallet": {"xdag_getTotalBalance", "xdag_personal_sendTransaction"},
+	"node":   {"xdag_generate"},
+}
+
+// parseParams decodes the positional params into args, the params after
+// required are optional
+func parseParams(params json.RawMessage, required int, args ...interface{}) *Error {
+	var list []json.RawMessage
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &list); err != nil {
+			return newError(ErrCodeInvalidParams, "params must be an array")
+		}
+	}
+	if len(list) < required || len(list) > len(args) {
+		return newError(ErrCodeInvalidParams, "wrong number of params")
+	}
+	for i, raw := range list {
+		if err := json.Unmarshal(raw, args[i]); err != nil {
+			return newError(ErrCodeInvalidParams, "param "+strconv.Itoa(i)+": "+err.Error())
+		}
+	}
+	return nil
+}
+
+// parseHash accepts an xdag address or a hashlow as printed by utils.Hash2String
+func parseHash(s string) (common.Hash, *Error) {
+	h, err := codec.ParseHash(s)
+	if err != nil {
+		return h, newError(ErrCodeInvalidParams, "invalid address "+s)
+	}
+	return h, nil
+}
+
+func diffString(diff *big.Int) string {
+	if diff == nil {
+		return "0"
+	}
+	return "0x" + diff.Text(16)
+}
+
+func blockResult(b *core.Block) *BlockResult {
+	info := b.Info()
+	res := &BlockResult{
+		Address:   codec.Hash2Address(b.GetHashLow()),
+		Hash:      utils.Hash2String(b.GetHash()),
+		Type:      utils.Type2String(b.GetType()),
+		Timestamp: b.GetTimestamp(),
+		Fee:       common.Amount(b.GetFee()).String(),
+		Inputs:    []LinkResult{},
+		Outputs:   []LinkResult{},
+	}
+	if info != nil {
+		res.Height = info.Height
+		res.Balance = common.Amount(info.Amount).String()
+		res.Flags = info.Flags
+		res.Difficulty = diffString(info.Difficulty)
+		res.Remark = strings.TrimRight(string(info.Remark[:]), "\x00")
+	}
+	for _, in := range b.Inputs {
+		res.Inputs = append(res.Inputs, LinkResult{codec.Hash2Address(in.HashLow), common.Amount(in.GetAmount()).String()})
+	}
+	for _, out := range b.Outputs {
+		res.Outputs = append(res.Outputs, LinkResult{codec.Hash2Address(out.HashLow), common.Amount(out.GetAmount()).String()})
+	}
+	return res
+}
+
+func (s *Server) getBlockByHash(params json.RawMessage) (interface{}, *Error) {
+	var address string
+	if err := parseParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+	hash, err := parseHash(address)
+	if err != nil {
+		return nil, err
+	}
+	b := s.chain.GetBlockByHash(hash, false)
+	if b == nil {
+		return nil, nil
+	}
+	return blockResult(b), nil
+}
+
+func (s *Server) getBlockByNumber(params json.RawMessage) (interface{}, *Error) {
+	var height uint64
+	if err := parseParams(params, 1, &height); err != nil {
+		return nil, err
+	}
+	b := s.chain.GetBlockByHeight(height)
+	if b == nil {
+		return nil, nil
+	}
+	return blockResult(b), nil
+}
+
+func (s *Server) blockNumber(params json.RawMessage) (interface{}, *Error) {
+	return s.chain.GetXDAGStats().NMain, nil
+}
+
+func (s *Server) getMainBlocks(params json.RawMessage) (interface{}, *Error) {
+	count := 20
+	if err := parseParams(params, 0, &count); err != nil {
+		return nil, err
+	}
+	if count <= 0 || count > MAX_MAIN_BLOCKS {
+		return nil, newError(ErrCodeInvalidParams, "count must be 1 to "+strconv.Itoa(MAX_MAIN_BLOCKS))
+	}
+	res := []*BlockResult{}
+	for _, b := range s.chain.ListMainBlock(count) {
+		res = append(res, blockResult(b))
+	}
+	return res, nil
+}
+
+func (s *Server) getBalance(params json.RawMessage) (interface{}, *Error) {
+	var address string
+	if err := parseParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+	hash, err := parseHash(address)
+	if err != nil {
+		return nil, err
+	}
+	b := s.chain.GetBlockByHash(hash, false)
+	if b == nil || b.Info() == nil {
+		return common.Amount(0).String(), nil
+	}
+	return common.Amount(b.Info().Amount).String(), nil
+}
+
+func (s *Server) getTotalBalance(params json.RawMessage) (interface{}, *Error) {
+	return common.Amount(s.chain.GetXDAGStats().Balance).String(), nil
+}
+
+func (s *Server) getSupply(params json.RawMessage) (interface{}, *Error) {
+	return common.Amount(s.chain.GetSupply(s.chain.GetXDAGStats().NMain)).String(), nil
+}
+
+func (s *Server) getStatus(params json.RawMessage) (interface{}, *Error) {
+	st := s.chain.GetXDAGStats()
+	res := &StatusResult{
+		NBlocks:       st.NBlocks,
+		TotalNBlocks:  st.TotalNBlocks,
+		NMain:         st.NMain,
+		TotalNMain:    st.TotalNMain,
+		NHosts:        st.NHosts,
+		TotalNHosts:   st.TotalNHosts,
+		Difficulty:    diffString(st.Difficulty),
+		MaxDifficulty: diffString(st.MaxDifficulty()),
+		Supply:        common.Amount(s.chain.GetSupply(st.NMain)).String(),
+		NetSupply:     common.Amount(s.chain.GetSupply(st.TotalNMain)).String(),
+		Balance:       common.Amount(st.Balance).String(),
+	}
+	s.Lock()
+	if s.state != nil {
+		res.State = s.state.ToString()
+	}
+	s.Unlock()
+	return res, nil
+}
+
+func (s *Server) sendRawTransaction(params json.RawMessage) (interface{}, *Error) {
+	var data string
+	if err := parseParams(params, 1, &data); err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(strings.TrimPrefix(data, "0x"))
+	if err != nil || len(raw) != common.XDAG_BLOCK_SIZE {
+		return nil, newError(ErrCodeInvalidParams, "block must be 512 bytes in hex")
+	}
+	b := core.NewBlockFromXdag(core.NewXdagBlock(raw))
+	res := s.chain.TryToConnect(b)
+	if !res.IsNormal() {
+		msg := "block rejected"
+		if res.ErrorInfo != "" {
+			msg += ": " + res.ErrorInfo
+		}
+		return nil, newError(ErrCodeServer, msg)
+	}
+	return codec.Hash2Address(b.GetHashLow()), nil
+}
+
+// sendTransaction transfers from the wallet, when a transfer block fails the
+// blocks sent before it are kept by the chain and listed in the error data
+func (s *Server) sendTransaction(params json.RawMessage) (interface{}, *Error) {
+	var args TransferArgs
+	var password string
+	if err := parseParams(params, 2, &args, &password); err != nil {
+		return nil, err
+	}
+	amount, err := common.ParseAmount(args.Value)
+	if err != nil || amount == 0 {
+		return nil, newError(ErrCodeInvalidParams, "invalid value "+args.Value)
+	}
+	to, e := parseHash(args.To)
+	if e != nil {
+		return nil, e
+	}
+	if s.wallet == nil || s.wallet.IsLocked() {
+		return nil, newError(ErrCodeServer, consensus.ErrWalletLocked.Error())
+	}
+	if password != s.wallet.GetPassword() {
+		return nil, newError(ErrCodeServer, "wrong password")
+	}
+	txs, err := consensus.Transfer(s.chain, s.wallet, to, amount, args.Remark)
+	res := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		res = append(res, codec.Hash2Address(tx))
+	}
+	if err != nil {
+		e := newError(ErrCodeServer, err.Error())
+		if len(res) > 0 {
+			e.Data = res
+		}
+		return nil, e
+	}
+	return res, nil
+}
+
+// generate makes count (1 by default) main blocks on the regtest network
+func (s *Server) generate(params json.RawMessage) (interface{}, *Error) {
+	count := 1
+	if err := parseParams(params, 0, &count); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	generator := s.generator
+	s.Unlock()
+	if generator == nil {
+		return nil, newError(ErrCodeServer, consensus.ErrNotRegtest.Error())
+	}
+	hashes, err := generator.Generate(count)
+	if err == consensus.ErrGenerateCount {
+		return nil, newError(ErrCodeInvalidParams, err.Error())
+	}
+	if err != nil {
+		return nil, newError(ErrCodeServer, err.Error())
+	}
+	res := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		res = append(res, codec.Hash2Address(h))
+	}
+	return res, nil
+}
+
+func (s *Server) getRegistry() (*consensus.MinerRegistry, *Error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.registry == nil {
+		return nil, newError(ErrCodeServer, ERR_POOL_DISABLED)
+	}
+	return s.registry, nil
+}
+
+func workerResult(w consensus.WorkerStats) WorkerResult {
+	res := WorkerResult{
+		Worker:     w.Worker,
+		Epoch:      w.Epoch,
+		Accepted:   w.Accepted,
+		Stale:      w.Stale,
+		Invalid:    w.Invalid,
+		Difficulty: diffString(w.Difficulty),
+	}
+	if !w.LastShare.IsZero() {
+		res.LastShare = w.LastShare.UnixMilli()
+	}
+	return res
+}
+
+// getMiners returns the miners seen in the hash rate window with the stats
+// of their workers in the current epoch, only the miner of address if given
+func (s *Server) getMiners(params json.RawMessage) (interface{}, *Error) {
+	var address string
+	if err := parseParams(params, 0, &address); err != nil {
+		return nil, err
+	}
+	if address != "" {
+		hash, err := parseHash(address)
+		if err != nil {
+			return nil, err
+		}
+		address = codec.Hash2Address(hash)
+	}
+	registry, e := s.getRegistry()
+	if e != nil {
+		return nil, e
+	}
+	res := []MinerResult{}
+	for _, m := range registry.Miners() {
+		if address != "" && m.Address != address {
+			continue
+		}
+		miner := MinerResult{Address: m.Address, Hashrate: m.Hashrate, Workers: []WorkerResult{}}
+		for _, w := range m.Workers {
+			worker := workerResult(w)
+			worker.Hashrate = registry.Hashrate(m.Address, w.Worker)
+			miner.Workers = append(miner.Workers, worker)
+		}
+		res = append(res, miner)
+	}
+	return res, nil
+}
+
+// getMinerHistory returns the saved worker stats of address over the last
+// epochs (64 by default)
+func (s *Server) getMinerHistory(params json.RawMessage) (interface{}, *Error) {
+	var address string
+	epochs := MINER_HISTORY
+	if err := parseParams(params, 1, &address, &epochs); err != nil {
+		return nil, err
+	}
+	if epochs <= 0 || epochs > consensus.MINER_HISTORY_EPOCHS {
+		return nil, newError(ErrCodeInvalidParams, "epochs must be 1 to "+strconv.Itoa(consensus.MINER_HISTORY_EPOCHS))
+	}
+	hash, e := parseHash(address)
+	if e != nil {
+		return nil, e
+	}
+	registry, e := s.getRegistry()
+	if e != nil {
+		return nil, e
+	}
+	var from uint64
+	if now := utils.GetCurrentEpoch(); now >= uint64(epochs) {
+		from = now - uint64(epochs)
+	}
+	res := []WorkerResult{}
+	for _, w := range registry.History(codec.Hash2Address(hash), from) {
+		res = append(res, workerResult(w))
+	}
+	return res, nil
+}
+
+func (s *Server) getPoolHashrate(params json.RawMessage) (interface{}, *Error) {
+	registry, e := s.getRegistry()
+	if e != nil {
+		return nil, e
+	}
+	return &PoolHashrateResult{Hashrate: registry.PoolHashrate(), Miners: len(registry.Miners())}, nil
+}
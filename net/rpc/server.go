@@ -0,0 +1,257 @@
+// Package rpc is the json-rpc 2.0 api of the node over http. The methods are
+// prefixed by xdag_ and take positional params, see methods.go. A request
+// body holding an array is a batch. The callers authenticate by a bearer
+// token or http basic auth, see net/auth.
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/core"
+	"xdago/log"
+	"xdago/net/auth"
+	"xdago/wallet"
+)
+
+const (
+	MAX_REQUEST_SIZE = 1 << 20
+	MAX_BATCH_SIZE   = 100
+	HTTP_TIMEOUT     = 30 * time.Second
+)
+
+var ErrRpcDisabled = errors.New("rpc: rpc.enabled is false")
+
+// Chain is the part of core.IBlockchain used by the api
+type Chain interface {
+	consensus.TransferChain
+	GetBlockByHeight(height uint64) *core.Block
+	ListMainBlock(count int) []*core.Block
+	GetXDAGStats() *core.XDAGStats
+	GetSupply(nMain uint64) uint64
+}
+
+// Server serves the json-rpc api
+type Server struct {
+	sync.Mutex
+	config    *config.Config
+	chain     Chain
+	wallet    *wallet.Wallet
+	state     *core.XdagState
+	generator *consensus.Generator
+	registry  *consensus.MinerRegistry
+	auth      *auth.Authenticator
+	http      *http.Server
+}
+
+func NewServer(config *config.Config, chain Chain, wallet *wallet.Wallet) *Server {
+	s := &Server{
+		config: config,
+		chain:  chain,
+		wallet: wallet,
+		auth:   auth.NewAuthenticator(config.RpcCredentials(), config.RpcAnonymous(), groups),
+	}
+	config.OnReload(s.reloadAuth)
+	s.http = &http.Server{
+		Handler:      s,
+		ReadTimeout:  HTTP_TIMEOUT,
+		WriteTimeout: HTTP_TIMEOUT,
+	}
+	return s
+}
+
+// SetState sets the node state reported by xdag_getStatus
+func (s *Server) SetState(state *core.XdagState) {
+	s.Lock()
+	defer s.Unlock()
+	s.state = state
+}
+
+// SetGenerator sets the regtest block generator of xdag_generate
+func (s *Server) SetGenerator(generator *consensus.Generator) {
+	s.Lock()
+	defer s.Unlock()
+	s.generator = generator
+}
+
+// SetRegistry sets the miner registry of the pool methods
+func (s *Server) SetRegistry(registry *consensus.MinerRegistry) {
+	s.Lock()
+	defer s.Unlock()
+	s.registry = registry
+}
+
+// Start listens on rpc.http.host:port, in tls when rpc.tls is set
+func (s *Server) Start() error {
+	if !s.config.RpcEnabled() {
+		return ErrRpcDisabled
+	}
+	address := net.JoinHostPort(s.config.RpcHost(), strconv.Itoa(s.config.RpcPortHttp()))
+	l, err := auth.Listen(address, s.config.RpcTlsCert(), s.config.RpcTlsKey())
+	if err != nil {
+		return err
+	}
+	s.Serve(l)
+	return nil
+}
+
+// Serve answers the http requests on l in the background
+func (s *Server) Serve(l net.Listener) {
+	log.Info("rpc server started", log.Ctx{"address": l.Addr().String()})
+	go func() {
+		if err := s.http.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Error("rpc server failed", log.Ctx{"err": err.Error()})
+		}
+	}()
+}
+
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.http.Shutdown(ctx)
+	log.Info("rpc server stopped")
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, MAX_REQUEST_SIZE+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > MAX_REQUEST_SIZE {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	res := s.Handle(id, body)
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// reloadAuth replaces the credentials and rate limits by the reloaded ones
+func (s *Server) reloadAuth(c *config.Config) {
+	a := auth.NewAuthenticator(c.RpcCredentials(), c.RpcAnonymous(), groups)
+	s.Lock()
+	defer s.Unlock()
+	s.auth = a
+}
+
+// authenticate answers 401 to a request without valid credentials
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*auth.Identity, bool) {
+	s.Lock()
+	authenticator := s.auth
+	s.Unlock()
+	id, err := authenticator.Request(r)
+	if err != nil {
+		log.Debug("rpc request refused", log.Ctx{"remote": r.RemoteAddr, "err": err.Error()})
+		w.Header().Set("WWW-Authenticate", `Bearer realm="xdag"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return id, true
+}
+
+// Handle answers a request or a batch of the caller id, it returns nil when
+// there is nothing to answer, i.e. notifications only
+func (s *Server) Handle(id *auth.Identity, body []byte) []byte {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return encode(errorResponse(nil, newError(ErrCodeParse, "parse error")))
+		}
+		if len(batch) == 0 {
+			return encode(errorResponse(nil, newError(ErrCodeInvalidRequest, "empty batch")))
+		}
+		if len(batch) > MAX_BATCH_SIZE {
+			return encode(errorResponse(nil, newError(ErrCodeInvalidRequest, "batch too large")))
+		}
+		var responses []*Response
+		for _, raw := range batch {
+			if res := s.handleOne(id, raw); res != nil {
+				responses = append(responses, res)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		return encode(responses)
+	}
+	if res := s.handleOne(id, body); res != nil {
+		return encode(res)
+	}
+	return nil
+}
+
+func encode(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(errorResponse(nil, newError(ErrCodeInternal, err.Error())))
+	}
+	return data
+}
+
+func errorResponse(id *json.RawMessage, err *Error) *Response {
+	return &Response{JSONRPC: JSONRPC_VERSION, ID: id, Error: err}
+}
+
+// handleOne runs one call, notifications get no response
+func (s *Server) handleOne(id *auth.Identity, raw []byte) *Response {
+	var req Request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return errorResponse(nil, newError(ErrCodeParse, "parse error"))
+		}
+		return errorResponse(nil, newError(ErrCodeInvalidRequest, "invalid request"))
+	}
+	if req.JSONRPC != JSONRPC_VERSION || req.Method == "" {
+		return errorResponse(req.ID, newError(ErrCodeInvalidRequest, "invalid request"))
+	}
+	method, ok := methods[req.Method]
+	var result interface{}
+	var err *Error
+	if !ok {
+		err = newError(ErrCodeNotFound, "method "+req.Method+" not found")
+	} else if err = checkCall(id, req.Method); err == nil {
+		result, err = method(s, req.Params)
+	}
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return errorResponse(req.ID, err)
+	}
+	return &Response{JSONRPC: JSONRPC_VERSION, ID: req.ID, Result: result}
+}
+
+// checkCall refuses the methods out of the allowlist of id and the calls
+// over its rate limit
+func checkCall(id *auth.Identity, method string) *Error {
+	if !id.Allowed(method) {
+		return newError(ErrCodeForbidden, "method "+method+" not allowed")
+	}
+	if !id.Allow() {
+		return newError(ErrCodeLimit, "rate limit exceeded")
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/magiconair/properties/assert"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/core"
+	"xdago/db/factory"
+	"xdago/net/auth"
+	"xdago/secp256k1"
+	"xdago/utils"
+	"xdago/wallet"
+)
+
+type fakeChain struct {
+	sync.Mutex
+	blocks map[common.Hash]*core.Block
+	ours   map[common.Hash]int
+	main   []*core.Block
+	txs    []*core.Block
+	reject bool
+	fails  int // the imports rejected after len(txs) reaches it, 0 for none
+}
+
+func (c *fakeChain) GetBlockByHash(hash common.Hash, isRaw bool) *core.Block {
+	return c.blocks[hash]
+}
+
+func (c *fakeChain) GetBlockByHeight(height uint64) *core.Block {
+	if height == 0 || height > uint64(len(c.main)) {
+		return nil
+	}
+	return c.main[height-1]
+}
+
+func (c *fakeChain) ListMainBlock(count int) []*core.Block {
+	if count > len(c.main) {
+		count = len(c.main)
+	}
+	return c.main[:count]
+}
+
+func (c *fakeChain) GetMemOurBlocks() map[common.Hash]int {
+	return c.ours
+}
+
+func (c *fakeChain) GetXDAGStats() *core.XDAGStats {
+	return &core.XDAGStats{Difficulty: big.NewInt(0xabc), NMain: 2, TotalNMain: 3, Balance: 3 << 32}
+}
+
+func (c *fakeChain) GetSupply(nMain uint64) uint64 {
+	return nMain * 1024 << 32
+}
+
+func (c *fakeChain) CreateNewBlock(pairs map[core.Address]*secp256k1.PrivateKey, to []core.Address,
+	mining bool, remark string) *core.Block {
+	return core.NewBlock(&config.Config{}, utils.GetCurrentTimestamp(), nil, nil, false, nil, remark, -1)
+}
+
+func (c *fakeChain) TryToConnect(block *core.Block) core.ImportResult {
+	c.Lock()
+	defer c.Unlock()
+	if c.reject || (c.fails > 0 && len(c.txs) >= c.fails) {
+		return core.ImportResult{Status: common.INVALID_BLOCK, ErrorInfo: "bad signature"}
+	}
+	c.txs = append(c.txs, block)
+	return core.ImportResult{Status: common.IMPORTED_NOT_BEST, HashLow: block.GetHashLow()}
+}
+
+func (c *fakeChain) CheckNewMain() {
+}
+
+func (c *fakeChain) GetXDAGTopStatus() *core.XDAGTopStatus {
+	top := core.NewXDAGTopStatus()
+	return &top
+}
+
+// testConfig opens all the methods to the callers without credentials
+func testConfig() *config.Config {
+	c := &config.Config{}
+	c.SetRpcAnonymous(config.Credential{Methods: []string{auth.ALL}})
+	return c
+}
+
+func newTestServer(t *testing.T) (*fakeChain, string) {
+	return startTestServer(t, testConfig())
+}
+
+func startTestServer(t *testing.T, c *config.Config) (*fakeChain, string) {
+	w := wallet.NewWallet(c)
+	w.UnlockWallet("walletpass")
+	w.AddAccountRandom()
+	chain := &fakeChain{blocks: make(map[common.Hash]*core.Block), ours: make(map[common.Hash]int)}
+	for i := uint64(1); i <= 2; i++ {
+		b := core.GenerateAddressBlock(c, w.GetAccount(0), 0x1000<<16+i)
+		b.Info().Height = i
+		b.Info().Amount = i << 32
+		chain.blocks[b.GetHashLow()] = b
+		chain.ours[b.GetHashLow()] = 0
+		chain.main = append(chain.main, b)
+	}
+
+	s := NewServer(c, chain, &w)
+	s.SetState(&core.XdagState{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	s.Serve(l)
+	t.Cleanup(s.Stop)
+	return chain, "http://" + l.Addr().String()
+}
+
+func post(t *testing.T, url, body string) (int, []byte) {
+	return postAuth(t, url, body, "")
+}
+
+// postAuth posts body with the Authorization header
+func postAuth(t *testing.T, url, body, authorization string) (int, []byte) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	assert.Equal(t, err, nil)
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, err, nil)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	assert.Equal(t, err, nil)
+	return resp.StatusCode, data
+}
+
+type testResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+func call(t *testing.T, url, method string, params ...interface{}) testResponse {
+	return callAuth(t, url, "", method, params...)
+}
+
+func callAuth(t *testing.T, url, authorization, method string, params ...interface{}) testResponse {
+	body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	code, data := postAuth(t, url, string(body), authorization)
+	assert.Equal(t, code, http.StatusOK)
+	var res testResponse
+	assert.Equal(t, json.Unmarshal(data, &res), nil)
+	return res
+}
+
+func TestRpcMethods(t *testing.T) {
+	chain, url := newTestServer(t)
+	second := chain.main[1]
+
+	var block BlockResult
+	res := call(t, url, "xdag_getBlockByHash", codec.Hash2Address(second.GetHashLow()))
+	assert.Equal(t, json.Unmarshal(res.Result, &block), nil)
+	assert.Equal(t, block.Height, uint64(2))
+	assert.Equal(t, block.Balance, "2.000000000")
+	assert.Equal(t, block.Address, codec.Hash2Address(second.GetHashLow()))
+
+	res = call(t, url, "xdag_getBlockByNumber", 1)
+	assert.Equal(t, json.Unmarshal(res.Result, &block), nil)
+	assert.Equal(t, block.Address, codec.Hash2Address(chain.main[0].GetHashLow()))
+	assert.Equal(t, string(call(t, url, "xdag_getBlockByNumber", 9).Result), "null")
+
+	var blocks []BlockResult
+	assert.Equal(t, json.Unmarshal(call(t, url, "xdag_getMainBlocks", 5).Result, &blocks), nil)
+	assert.Equal(t, len(blocks), 2)
+	assert.Equal(t, string(call(t, url, "xdag_blockNumber").Result), "2")
+	assert.Equal(t, string(call(t, url, "xdag_getBalance", utils.Hash2String(second.GetHashLow())).Result), `"2.000000000"`)
+	assert.Equal(t, string(call(t, url, "xdag_getTotalBalance").Result), `"3.000000000"`)
+	assert.Equal(t, string(call(t, url, "xdag_getSupply").Result), `"2048.000000000"`)
+
+	var status StatusResult
+	assert.Equal(t, json.Unmarshal(call(t, url, "xdag_getStatus").Result, &status), nil)
+	assert.Equal(t, status.TotalNMain, uint64(3))
+	assert.Equal(t, status.Difficulty, "0xabc")
+	assert.Equal(t, status.State, "Pool Initializing....")
+
+	// errors
+	assert.Equal(t, call(t, url, "xdag_nothing").Error.Code, ErrCodeNotFound)
+	assert.Equal(t, call(t, url, "xdag_getBalance").Error.Code, ErrCodeInvalidParams)
+	assert.Equal(t, call(t, url, "xdag_getBalance", "zzz").Error.Code, ErrCodeInvalidParams)
+	code, data := post(t, url, "{")
+	assert.Equal(t, code, http.StatusOK)
+	res = testResponse{}
+	assert.Equal(t, json.Unmarshal(data, &res), nil)
+	assert.Equal(t, res.Error.Code, ErrCodeParse)
+	resp, err := http.Get(url)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+}
+
+func TestRpcBatch(t *testing.T) {
+	_, url := newTestServer(t)
+	code, data := post(t, url, `[
+		{"jsonrpc":"2.0","id":1,"method":"xdag_blockNumber"},
+		{"jsonrpc":"2.0","method":"xdag_blockNumber"},
+		{"jsonrpc":"2.0","id":2,"method":"xdag_nothing"},
+		{"jsonrpc":"1.0","id":3,"method":"xdag_blockNumber"}
+	]`)
+	assert.Equal(t, code, http.StatusOK)
+	var res []testResponse
+	assert.Equal(t, json.Unmarshal(data, &res), nil)
+	assert.Equal(t, len(res), 3)
+	assert.Equal(t, res[0].ID, 1)
+	assert.Equal(t, string(res[0].Result), "2")
+	assert.Equal(t, res[1].Error.Code, ErrCodeNotFound)
+	assert.Equal(t, res[2].Error.Code, ErrCodeInvalidRequest)
+
+	// notifications only
+	code, data = post(t, url, `[{"jsonrpc":"2.0","method":"xdag_blockNumber"}]`)
+	assert.Equal(t, code, http.StatusNoContent)
+	assert.Equal(t, len(data), 0)
+	_, data = post(t, url, `[]`)
+	var empty testResponse
+	assert.Equal(t, json.Unmarshal(data, &empty), nil)
+	assert.Equal(t, empty.Error.Code, ErrCodeInvalidRequest)
+}
+
+func TestRpcSend(t *testing.T) {
+	chain, url := newTestServer(t)
+
+	key, _ := secp256k1.GeneratePrivateKey()
+	b := core.GenerateAddressBlock(&config.Config{}, key, utils.GetCurrentTimestamp())
+	raw := hex.EncodeToString(b.ToBytes())
+	res := call(t, url, "xdag_sendRawTransaction", raw)
+	assert.Equal(t, string(res.Result), `"`+codec.Hash2Address(b.GetHashLow())+`"`)
+	assert.Equal(t, len(chain.txs), 1)
+	assert.Equal(t, call(t, url, "xdag_sendRawTransaction", raw[:100]).Error.Code, ErrCodeInvalidParams)
+	chain.reject = true
+	assert.Equal(t, call(t, url, "xdag_sendRawTransaction", raw).Error.Message, "block rejected: bad signature")
+	chain.reject = false
+
+	var to common.Hash
+	to[8] = 5
+	args := TransferArgs{To: codec.Hash2Address(to), Value: "2.5", Remark: "rpc"}
+	assert.Equal(t, call(t, url, "xdag_personal_sendTransaction", args, "nope").Error.Message, "wrong password")
+	var txs []string
+	res = call(t, url, "xdag_personal_sendTransaction", args, "walletpass")
+	assert.Equal(t, json.Unmarshal(res.Result, &txs), nil)
+	assert.Equal(t, len(txs), 1)
+	assert.Equal(t, len(chain.txs), 2)
+	args.Value = "100"
+	assert.Equal(t, call(t, url, "xdag_personal_sendTransaction", args, "walletpass").Error.Message, "balance not enough")
+}
+
+func TestRpcSendPartial(t *testing.T) {
+	chain, url := newTestServer(t)
+	// 7 addresses take two transfer blocks, the second one is rejected
+	key, _ := secp256k1.GeneratePrivateKey()
+	for i := uint64(3); i <= 7; i++ {
+		b := core.GenerateAddressBlock(&config.Config{}, key, 0x1000<<16+i)
+		b.Info().Amount = 1 << 32
+		chain.blocks[b.GetHashLow()] = b
+		chain.ours[b.GetHashLow()] = 0
+	}
+	chain.fails = 1
+	args := TransferArgs{To: codec.Hash2Address(chain.main[1].GetHashLow()), Value: "7"}
+	res := call(t, url, "xdag_personal_sendTransaction", args, "walletpass")
+	assert.Equal(t, res.Error.Message, consensus.ErrTransferRejected.Error())
+	assert.Equal(t, res.Error.Data, []interface{}{codec.Hash2Address(chain.txs[0].GetHashLow())})
+	assert.Equal(t, len(chain.txs), 1)
+
+	// nothing sent, no data
+	chain.fails, chain.reject = 0, true
+	res = call(t, url, "xdag_personal_sendTransaction", args, "walletpass")
+	assert.Equal(t, res.Error.Data == nil, true)
+}
+
+func TestRpcAuth(t *testing.T) {
+	c := &config.Config{}
+	c.SetRpcCredentials([]config.Credential{
+		{Name: "reader", Token: "rtoken", Methods: []string{"read"}},
+		{Name: "payer", User: "alice", Password: "pw", Methods: []string{"read", "wallet"}, RateLimit: 0.001, Burst: 3},
+	})
+	_, url := startTestServer(t, c)
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:pw"))
+
+	// no anonymous access
+	code, _ := post(t, url, `{"jsonrpc":"2.0","id":1,"method":"xdag_blockNumber"}`)
+	assert.Equal(t, code, http.StatusUnauthorized)
+	code, _ = postAuth(t, url, `{"jsonrpc":"2.0","id":1,"method":"xdag_blockNumber"}`, "Bearer wrong")
+	assert.Equal(t, code, http.StatusUnauthorized)
+	code, _ = postAuth(t, url, `{"jsonrpc":"2.0","id":1,"method":"xdag_blockNumber"}`,
+		"Basic "+base64.StdEncoding.EncodeToString([]byte("alice:wrong")))
+	assert.Equal(t, code, http.StatusUnauthorized)
+
+	// the reader can't see nor move our xdag
+	assert.Equal(t, string(callAuth(t, url, "Bearer rtoken", "xdag_blockNumber").Result), "2")
+	assert.Equal(t, callAuth(t, url, "Bearer rtoken", "xdag_getTotalBalance").Error.Code, ErrCodeForbidden)
+	assert.Equal(t, callAuth(t, url, "Bearer rtoken", "xdag_sendRawTransaction", "00").Error.Code, ErrCodeForbidden)
+	_, data := postAuth(t, url, `[{"jsonrpc":"2.0","id":1,"method":"xdag_blockNumber"},
+		{"jsonrpc":"2.0","id":2,"method":"xdag_personal_sendTransaction","params":[{},"walletpass"]}]`, "Bearer rtoken")
+	var res []testResponse
+	assert.Equal(t, json.Unmarshal(data, &res), nil)
+	assert.Equal(t, res[0].Error == nil, true)
+	assert.Equal(t, res[1].Error.Code, ErrCodeForbidden)
+
+	// the payer may, within its burst
+	assert.Equal(t, string(callAuth(t, url, basic, "xdag_getTotalBalance").Result), `"3.000000000"`)
+	assert.Equal(t, string(callAuth(t, url, basic, "xdag_blockNumber").Result), "2")
+	assert.Equal(t, callAuth(t, url, basic, "xdag_getSupply").Error == nil, true)
+	assert.Equal(t, callAuth(t, url, basic, "xdag_blockNumber").Error.Code, ErrCodeLimit)
+	assert.Equal(t, string(callAuth(t, url, "Bearer rtoken", "xdag_blockNumber").Result), "2")
+
+	// the read-only api may be open to everyone
+	c = &config.Config{}
+	c.SetRpcAnonymous(config.Credential{Methods: []string{"read"}})
+	_, url = startTestServer(t, c)
+	assert.Equal(t, string(call(t, url, "xdag_blockNumber").Result), "2")
+	assert.Equal(t, call(t, url, "xdag_personal_sendTransaction", TransferArgs{}, "walletpass").Error.Code, ErrCodeForbidden)
+}
+
+func TestRpcGenerate(t *testing.T) {
+	c := testConfig()
+	chain := &fakeChain{blocks: make(map[common.Hash]*core.Block), ours: make(map[common.Hash]int)}
+	s := NewServer(c, chain, nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	s.Serve(l)
+	t.Cleanup(s.Stop)
+	url := "http://" + l.Addr().String()
+	assert.Equal(t, call(t, url, "xdag_generate", 1).Error.Message, consensus.ErrNotRegtest.Error())
+
+	c.SetNetwork(common.REGTEST)
+	key, _ := secp256k1.GeneratePrivateKey()
+	g, err := consensus.NewGenerator(c, chain, key)
+	assert.Equal(t, err, nil)
+	s.SetGenerator(g)
+	var addresses []string
+	assert.Equal(t, json.Unmarshal(call(t, url, "xdag_generate", 2).Result, &addresses), nil)
+	assert.Equal(t, len(addresses), 2)
+	assert.Equal(t, len(chain.txs), 2)
+	assert.Equal(t, addresses[1], codec.Hash2Address(chain.txs[1].GetHashLow()))
+	assert.Equal(t, json.Unmarshal(call(t, url, "xdag_generate").Result, &addresses), nil)
+	assert.Equal(t, len(addresses), 1)
+	assert.Equal(t, call(t, url, "xdag_generate", 0).Error.Code, ErrCodeInvalidParams)
+}
+
+func TestRpcMiners(t *testing.T) {
+	c := testConfig()
+	c.SetStoreDir(t.TempDir())
+	s := NewServer(c, &fakeChain{}, nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	s.Serve(l)
+	t.Cleanup(s.Stop)
+	url := "http://" + l.Addr().String()
+	assert.Equal(t, call(t, url, "xdag_getPoolHashrate").Error.Message, ERR_POOL_DISABLED)
+
+	kvFactory := factory.NewKvStoreFactory(c)
+	source := kvFactory.GetDB(common.DB_MINERS)
+	source.Init()
+	t.Cleanup(kvFactory.Close)
+	registry := consensus.NewMinerRegistry(source)
+	var h common.Hash
+	h[8] = 7
+	address := codec.Hash2Address(h)
+	now := utils.Now() // the clock of the node, the generate test moved it
+	diff := new(big.Int).Lsh(big.NewInt(1), 40)
+	for _, worker := range []string{"rig1", "rig2", "rig2"} {
+		registry.AddShare(consensus.Share{Account: address, Worker: worker, Difficulty: diff, Time: now})
+	}
+	registry.Add(consensus.Share{Account: address, Worker: "rig1", Time: now}, consensus.SHARE_STALE)
+	registry.AddShare(consensus.Share{Account: "other", Worker: "w", Difficulty: diff, Time: now})
+	registry.Flush()
+	s.SetRegistry(registry)
+
+	var miners []MinerResult
+	assert.Equal(t, json.Unmarshal(call(t, url, "xdag_getMiners").Result, &miners), nil)
+	assert.Equal(t, len(miners), 2)
+	assert.Equal(t, json.Unmarshal(call(t, url, "xdag_getMiners", utils.Hash2String(h)).Result, &miners), nil)
+	assert.Equal(t, len(miners), 1)
+	assert.Equal(t, miners[0].Address, address)
+	assert.Equal(t, miners[0].Hashrate, registry.Hashrate(address, ""))
+	assert.Equal(t, len(miners[0].Workers), 2)
+	rig1, rig2 := miners[0].Workers[0], miners[0].Workers[1]
+	assert.Equal(t, rig1.Worker, "rig1")
+	assert.Equal(t, rig1.Accepted, uint64(1))
+	assert.Equal(t, rig1.Stale, uint64(1))
+	assert.Equal(t, rig1.LastShare, now.UnixMilli())
+	assert.Equal(t, rig2.Difficulty, "0x20000000000")
+	assert.Equal(t, rig2.Hashrate, 2*rig1.Hashrate)
+
+	var history []WorkerResult
+	assert.Equal(t, json.Unmarshal(call(t, url, "xdag_getMinerHistory", address).Result, &history), nil)
+	assert.Equal(t, len(history), 2)
+	assert.Equal(t, history[0].Epoch, utils.GetEpoch(utils.Ms2XdagTimestamp(uint64(now.UnixMilli()))))
+	assert.Equal(t, call(t, url, "xdag_getMinerHistory", address, 0).Error.Code, ErrCodeInvalidParams)
+	assert.Equal(t, call(t, url, "xdag_getMinerHistory").Error.Code, ErrCodeInvalidParams)
+
+	var pool PoolHashrateResult
+	assert.Equal(t, json.Unmarshal(call(t, url, "xdag_getPoolHashrate").Result, &pool), nil)
+	assert.Equal(t, pool.Miners, 2)
+	assert.Equal(t, pool.Hashrate, registry.PoolHashrate())
+}
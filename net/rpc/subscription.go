@@ -0,0 +1,370 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/core"
+	"xdago/log"
+	"xdago/net/auth"
+)
+
+// subscription kinds of xdag_subscribe
+const (
+	SUB_NEW_BLOCKS      = "newBlocks"
+	SUB_NEW_MAIN_BLOCKS = "newMainBlocks"
+	SUB_REORG           = "reorg"
+	SUB_ADDRESS         = "addressActivity" // params: the address
+)
+
+const (
+	WS_SEND_QUEUE     = 256  // notifications waiting for a slow client, it is dropped when full
+	EVENT_QUEUE       = 1024 // chain events waiting for the dispatch
+	MAX_SUBSCRIPTIONS = 64   // per connection
+)
+
+var ErrWsSlowClient = errors.New("websocket: client too slow")
+
+// ReorgResult is the notification of a main block that lost its height
+type ReorgResult struct {
+	Height  uint64 `json:"height"`
+	Address string `json:"address"`
+}
+
+// ActivityResult is the notification of a block moving xdag of an address
+type ActivityResult struct {
+	Address   string `json:"address"`
+	Tx        string `json:"tx"`
+	Direction string `json:"direction"` // in or out
+	Amount    string `json:"amount"`
+}
+
+type subscription struct {
+	seq     uint64
+	id      string
+	kind    string
+	address common.Hash
+}
+
+type wsClient struct {
+	id   *auth.Identity
+	conn *wsConn
+	send chan []byte
+	subs map[string]*subscription
+	once sync.Once
+}
+
+// WsServer serves the api and the subscriptions to the chain events over
+// websocket on rpc.ws.port. The notifications are
+// {"jsonrpc":"2.0","method":"xdag_subscription","params":{"subscription":id,"result":...}}
+type WsServer struct {
+	sync.Mutex
+	rpc     *Server
+	events  *core.EventBus
+	http    *http.Server
+	clients map[*wsClient]struct{}
+	cancel  func()
+	stopped bool
+	nextId  uint64
+	wg      sync.WaitGroup
+}
+
+func NewWsServer(rpc *Server, events *core.EventBus) *WsServer {
+	s := &WsServer{
+		rpc:     rpc,
+		events:  events,
+		clients: make(map[*wsClient]struct{}),
+	}
+	s.http = &http.Server{Handler: s, ReadHeaderTimeout: HTTP_TIMEOUT}
+	return s
+}
+
+// Start listens on rpc.http.host:rpc.ws.port, in tls when rpc.tls is set
+func (s *WsServer) Start() error {
+	c := s.rpc.config
+	if !c.RpcEnabled() {
+		return ErrRpcDisabled
+	}
+	l, err := auth.Listen(net.JoinHostPort(c.RpcHost(), strconv.Itoa(c.RpcPortWs())), c.RpcTlsCert(), c.RpcTlsKey())
+	if err != nil {
+		return err
+	}
+	s.Serve(l)
+	return nil
+}
+
+// Serve accepts the websocket clients on l in the background
+func (s *WsServer) Serve(l net.Listener) {
+	events, cancel := s.events.Subscribe(EVENT_QUEUE)
+	s.Lock()
+	s.cancel = cancel
+	s.Unlock()
+	s.wg.Add(1)
+	go s.dispatch(events)
+	log.Info("websocket server started", log.Ctx{"address": l.Addr().String()})
+	go func() {
+		if err := s.http.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Error("websocket server failed", log.Ctx{"err": err.Error()})
+		}
+	}()
+}
+
+func (s *WsServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.http.Shutdown(ctx)
+	s.Lock()
+	s.stopped = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	// hijacked connections are not closed by Shutdown
+	for c := range s.clients {
+		s.drop(c, wsCloseNormal)
+	}
+	s.Unlock()
+	s.wg.Wait()
+	log.Info("websocket server stopped")
+}
+
+// drop closes a client, s is locked
+func (s *WsServer) drop(c *wsClient, code uint16) {
+	delete(s.clients, c)
+	c.once.Do(func() {
+		close(c.send)
+		c.conn.Close(code)
+	})
+}
+
+// ServeHTTP authenticates the handshake, the connection keeps its identity
+func (s *WsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.rpc.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conn, err := wsUpgrade(w, r)
+	if err != nil {
+		return
+	}
+	c := &wsClient{
+		id:   id,
+		conn: conn,
+		send: make(chan []byte, WS_SEND_QUEUE),
+		subs: make(map[string]*subscription),
+	}
+	s.Lock()
+	s.clients[c] = struct{}{}
+	s.Unlock()
+
+	s.wg.Add(1)
+	go s.writeLoop(c)
+	defer func() {
+		s.Lock()
+		s.drop(c, wsCloseNormal)
+		s.Unlock()
+	}()
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if res := s.handle(c, msg); res != nil {
+			if !s.queue(c, res) {
+				return
+			}
+		}
+	}
+}
+
+func (s *WsServer) writeLoop(c *wsClient) {
+	defer s.wg.Done()
+	for msg := range c.send {
+		if err := c.conn.WriteMessage(WS_TEXT, msg); err != nil {
+			return
+		}
+	}
+}
+
+// queue hands a message to the writer, a client that can't keep up is dropped
+func (s *WsServer) queue(c *wsClient, msg []byte) bool {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.clients[c]; !ok {
+		return false
+	}
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		log.Warn("websocket client dropped", log.Ctx{"remote": c.conn.conn.RemoteAddr().String(), "err": ErrWsSlowClient.Error()})
+		s.drop(c, wsCloseTooBig)
+		return false
+	}
+}
+
+// handle answers the subscription calls, the other calls go to the api
+func (s *WsServer) handle(c *wsClient, msg []byte) []byte {
+	var req Request
+	if json.Unmarshal(msg, &req) != nil || (req.Method != "xdag_subscribe" && req.Method != "xdag_unsubscribe") {
+		return s.rpc.Handle(c.id, msg)
+	}
+	var result interface{}
+	err := checkCall(c.id, req.Method)
+	switch {
+	case err != nil:
+	case req.Method == "xdag_subscribe":
+		result, err = s.subscribe(c, req.Params)
+	default:
+		result, err = s.unsubscribe(c, req.Params)
+	}
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return encode(errorResponse(req.ID, err))
+	}
+	return encode(&Response{JSONRPC: JSONRPC_VERSION, ID: req.ID, Result: result})
+}
+
+func (s *WsServer) subscribe(c *wsClient, params json.RawMessage) (interface{}, *Error) {
+	var kind, address string
+	if err := parseParams(params, 1, &kind, &address); err != nil {
+		return nil, err
+	}
+	sub := &subscription{kind: kind}
+	switch kind {
+	case SUB_NEW_BLOCKS, SUB_NEW_MAIN_BLOCKS, SUB_REORG:
+	case SUB_ADDRESS:
+		hash, err := parseHash(address)
+		if err != nil {
+			return nil, err
+		}
+		sub.address = hash
+	default:
+		return nil, newError(ErrCodeInvalidParams, "unknown subscription "+kind)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if len(c.subs) >= MAX_SUBSCRIPTIONS {
+		return nil, newError(ErrCodeServer, "too many subscriptions")
+	}
+	s.nextId++
+	sub.seq = s.nextId
+	sub.id = "0x" + strconv.FormatUint(sub.seq, 16)
+	c.subs[sub.id] = sub
+	return sub.id, nil
+}
+
+func (s *WsServer) unsubscribe(c *wsClient, params json.RawMessage) (interface{}, *Error) {
+	var id string
+	if err := parseParams(params, 1, &id); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	_, ok := c.subs[id]
+	delete(c.subs, id)
+	return ok, nil
+}
+
+type notification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  notificationParams `json:"params"`
+}
+
+type notificationParams struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+func (s *WsServer) dispatch(events <-chan core.ChainEvent) {
+	defer s.wg.Done()
+	for {
+		for ev := range events {
+			s.notify(ev)
+		}
+		// the bus closed a queue that fell behind, the clients missed events:
+		// they are dropped to resync and the server subscribes again
+		s.Lock()
+		if s.stopped {
+			s.Unlock()
+			return
+		}
+		log.Warn("chain events lost, websocket clients dropped", log.Ctx{"clients": len(s.clients)})
+		for c := range s.clients {
+			s.drop(c, wsCloseTryAgain)
+		}
+		events, s.cancel = s.events.Subscribe(EVENT_QUEUE)
+		s.Unlock()
+	}
+}
+
+// notify queues the notifications of ev to the subscribed clients
+func (s *WsServer) notify(ev core.ChainEvent) {
+	type target struct {
+		c   *wsClient
+		msg []byte
+	}
+	var targets []target
+	s.Lock()
+	for c := range s.clients {
+		// an event is notified in the order of the subscriptions
+		subs := make([]*subscription, 0, len(c.subs))
+		for _, sub := range c.subs {
+			subs = append(subs, sub)
+		}
+		sort.Slice(subs, func(i, j int) bool { return subs[i].seq < subs[j].seq })
+		for _, sub := range subs {
+			for _, result := range eventResults(sub, ev) {
+				msg := encode(&notification{JSONRPC: JSONRPC_VERSION, Method: "xdag_subscription",
+					Params: notificationParams{Subscription: sub.id, Result: result}})
+				targets = append(targets, target{c, msg})
+			}
+		}
+	}
+	s.Unlock()
+	for _, t := range targets {
+		s.queue(t.c, t.msg)
+	}
+}
+
+// eventResults returns the notifications of ev for sub
+func eventResults(sub *subscription, ev core.ChainEvent) []interface{} {
+	switch {
+	case sub.kind == SUB_NEW_BLOCKS && ev.Type == core.EVENT_NEW_BLOCK:
+		return []interface{}{blockResult(ev.Block)}
+	case sub.kind == SUB_NEW_MAIN_BLOCKS && ev.Type == core.EVENT_NEW_MAIN_BLOCK:
+		res := blockResult(ev.Block)
+		res.Height = ev.Height
+		return []interface{}{res}
+	case sub.kind == SUB_REORG && ev.Type == core.EVENT_REORG:
+		return []interface{}{&ReorgResult{Height: ev.Height, Address: codec.Hash2Address(ev.Block.GetHashLow())}}
+	case sub.kind == SUB_ADDRESS && ev.Type == core.EVENT_NEW_BLOCK:
+		var res []interface{}
+		activity := func(links []core.Address, direction string) {
+			for _, l := range links {
+				if l.HashLow == sub.address {
+					res = append(res, &ActivityResult{
+						Address:   codec.Hash2Address(sub.address),
+						Tx:        codec.Hash2Address(ev.Block.GetHashLow()),
+						Direction: direction,
+						Amount:    common.Amount(l.GetAmount()).String(),
+					})
+				}
+			}
+		}
+		activity(ev.Block.Outputs, "in")
+		activity(ev.Block.Inputs, "out")
+		return res
+	}
+	return nil
+}
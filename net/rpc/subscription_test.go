@@ -0,0 +1,212 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/magiconair/properties/assert"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/core"
+	"xdago/secp256k1"
+	"xdago/utils"
+)
+
+// dialWs opens a websocket, the headers are "Name: value" lines
+func dialWs(t *testing.T, address string, headers ...string) *wsConn {
+	conn, err := net.Dial("tcp", address)
+	assert.Equal(t, err, nil)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET / HTTP/1.1\r\nHost: " + address + "\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	for _, h := range headers {
+		req += h + "\r\n"
+	}
+	req += "\r\n"
+	_, err = conn.Write([]byte(req))
+	assert.Equal(t, err, nil)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.StatusCode, http.StatusSwitchingProtocols)
+	assert.Equal(t, resp.Header.Get("Sec-Websocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	return &wsConn{conn: conn, r: r, client: true}
+}
+
+type testNotification struct {
+	Method string `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+func wsCall(t *testing.T, c *wsConn, method string, params ...interface{}) testResponse {
+	body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	assert.Equal(t, c.WriteMessage(WS_TEXT, body), nil)
+	_, msg, err := c.ReadMessage()
+	assert.Equal(t, err, nil)
+	var res testResponse
+	assert.Equal(t, json.Unmarshal(msg, &res), nil)
+	return res
+}
+
+func wsNotification(t *testing.T, c *wsConn) testNotification {
+	_, msg, err := c.ReadMessage()
+	assert.Equal(t, err, nil)
+	var n testNotification
+	assert.Equal(t, json.Unmarshal(msg, &n), nil)
+	assert.Equal(t, n.Method, "xdag_subscription")
+	return n
+}
+
+func TestWsSubscriptions(t *testing.T) {
+	chain, _ := newTestServer(t)
+	events := core.NewEventBus()
+	s := NewWsServer(NewServer(testConfig(), chain, nil), events)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	s.Serve(l)
+	defer s.Stop()
+
+	c := dialWs(t, l.Addr().String())
+	// the api is served over websocket too
+	assert.Equal(t, string(wsCall(t, c, "xdag_blockNumber").Result), "2")
+
+	var to common.Hash
+	to[8] = 0x42
+	var ids []string
+	for _, params := range [][]interface{}{{SUB_NEW_BLOCKS}, {SUB_NEW_MAIN_BLOCKS}, {SUB_REORG}, {SUB_ADDRESS, codec.Hash2Address(to)}} {
+		var id string
+		assert.Equal(t, json.Unmarshal(wsCall(t, c, "xdag_subscribe", params...).Result, &id), nil)
+		ids = append(ids, id)
+	}
+	assert.Equal(t, wsCall(t, c, "xdag_subscribe", "nothing").Error.Code, ErrCodeInvalidParams)
+	assert.Equal(t, wsCall(t, c, "xdag_subscribe", SUB_ADDRESS, "bad").Error.Code, ErrCodeInvalidParams)
+
+	// a payment to the address
+	key, _ := secp256k1.GeneratePrivateKey()
+	from := chain.main[0].GetHashLow()
+	links := []core.Address{
+		core.AddressFromAmount(from, common.XDAG_FIELD_IN, 3<<31),
+		core.AddressFromAmount(to, common.XDAG_FIELD_OUT, 3<<31),
+	}
+	tx := core.NewBlock(&config.Config{}, utils.GetCurrentTimestamp(), links, nil, false, []*secp256k1.PublicKey{key.PubKey()}, "", 0)
+	tx.SignOut(key)
+	events.Publish(core.ChainEvent{Type: core.EVENT_NEW_BLOCK, Block: tx})
+	n := wsNotification(t, c)
+	assert.Equal(t, n.Params.Subscription, ids[0])
+	var block BlockResult
+	assert.Equal(t, json.Unmarshal(n.Params.Result, &block), nil)
+	assert.Equal(t, block.Address, codec.Hash2Address(tx.GetHashLow()))
+	n = wsNotification(t, c)
+	assert.Equal(t, n.Params.Subscription, ids[3])
+	var activity ActivityResult
+	assert.Equal(t, json.Unmarshal(n.Params.Result, &activity), nil)
+	assert.Equal(t, activity, ActivityResult{Address: codec.Hash2Address(to), Tx: codec.Hash2Address(tx.GetHashLow()),
+		Direction: "in", Amount: "1.500000000"})
+
+	events.Publish(core.ChainEvent{Type: core.EVENT_NEW_MAIN_BLOCK, Block: chain.main[1], Height: 7})
+	n = wsNotification(t, c)
+	assert.Equal(t, n.Params.Subscription, ids[1])
+	assert.Equal(t, json.Unmarshal(n.Params.Result, &block), nil)
+	assert.Equal(t, block.Height, uint64(7))
+
+	events.Publish(core.ChainEvent{Type: core.EVENT_REORG, Block: chain.main[1], Height: 7})
+	n = wsNotification(t, c)
+	assert.Equal(t, n.Params.Subscription, ids[2])
+	var reorg ReorgResult
+	assert.Equal(t, json.Unmarshal(n.Params.Result, &reorg), nil)
+	assert.Equal(t, reorg, ReorgResult{Height: 7, Address: codec.Hash2Address(chain.main[1].GetHashLow())})
+
+	// unsubscribed feeds are quiet
+	for _, id := range ids[:3] {
+		assert.Equal(t, string(wsCall(t, c, "xdag_unsubscribe", id).Result), "true")
+	}
+	assert.Equal(t, string(wsCall(t, c, "xdag_unsubscribe", ids[0]).Result), "false")
+	events.Publish(core.ChainEvent{Type: core.EVENT_NEW_MAIN_BLOCK, Block: chain.main[1], Height: 8})
+	assert.Equal(t, string(wsCall(t, c, "xdag_blockNumber").Result), "2")
+
+	// ping is answered
+	assert.Equal(t, c.WriteMessage(WS_PING, []byte("hi")), nil)
+	_, op, _, err := c.readFrame()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, op, byte(WS_PONG))
+}
+
+func TestWsEventOverflow(t *testing.T) {
+	chain, _ := newTestServer(t)
+	events := core.NewEventBus()
+	s := NewWsServer(NewServer(testConfig(), chain, nil), events)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	s.Serve(l)
+	defer s.Stop()
+	c := dialWs(t, l.Addr().String())
+	assert.Equal(t, string(wsCall(t, c, "xdag_blockNumber").Result), "2")
+
+	// the dispatch is held until its queue overflows, the client missed
+	// events and is dropped
+	s.Lock()
+	for h := uint64(0); h < EVENT_QUEUE+2; h++ {
+		events.Publish(core.ChainEvent{Type: core.EVENT_NEW_MAIN_BLOCK, Block: chain.main[1], Height: h})
+	}
+	s.Unlock()
+	_, _, err = c.ReadMessage()
+	assert.Equal(t, err, ErrWsClosed)
+
+	// the server subscribed again, a new client gets the events
+	c = dialWs(t, l.Addr().String())
+	var id string
+	assert.Equal(t, json.Unmarshal(wsCall(t, c, "xdag_subscribe", SUB_NEW_MAIN_BLOCKS).Result, &id), nil)
+	events.Publish(core.ChainEvent{Type: core.EVENT_NEW_MAIN_BLOCK, Block: chain.main[1], Height: 9})
+	n := wsNotification(t, c)
+	assert.Equal(t, n.Params.Subscription, id)
+	var block BlockResult
+	assert.Equal(t, json.Unmarshal(n.Params.Result, &block), nil)
+	assert.Equal(t, block.Height, uint64(9))
+}
+
+func TestWsHandshake(t *testing.T) {
+	chain, _ := newTestServer(t)
+	s := NewWsServer(NewServer(testConfig(), chain, nil), core.NewEventBus())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	s.Serve(l)
+	defer s.Stop()
+	resp, err := http.Get("http://" + l.Addr().String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+
+	// an unmasked client frame breaks the protocol
+	c := dialWs(t, l.Addr().String())
+	c.client = false
+	c.WriteMessage(WS_TEXT, []byte("{}"))
+	c.client = true
+	_, _, err = c.ReadMessage()
+	assert.Equal(t, err, ErrWsClosed)
+}
+
+func TestWsAuth(t *testing.T) {
+	chain, _ := newTestServer(t)
+	c := &config.Config{}
+	c.SetRpcCredentials([]config.Credential{{Name: "reader", Token: "rtoken", Methods: []string{"read"}}})
+	s := NewWsServer(NewServer(c, chain, nil), core.NewEventBus())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	s.Serve(l)
+	defer s.Stop()
+
+	resp, err := http.Get("http://" + l.Addr().String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.StatusCode, http.StatusUnauthorized)
+
+	ws := dialWs(t, l.Addr().String(), "Authorization: Bearer rtoken")
+	assert.Equal(t, wsCall(t, ws, "xdag_subscribe", SUB_NEW_BLOCKS).Error == nil, true)
+	assert.Equal(t, wsCall(t, ws, "xdag_getTotalBalance").Error.Code, ErrCodeForbidden)
+}
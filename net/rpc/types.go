@@ -0,0 +1,132 @@
+package rpc
+
+import (
+	"encoding/json"
+)
+
+const JSONRPC_VERSION = "2.0"
+
+// json-rpc 2.0 error codes
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeNotFound       = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+	ErrCodeServer         = -32000 // the call failed, e.g. a rejected block
+	ErrCodeForbidden      = -32003 // the credential may not call the method
+	ErrCodeLimit          = -32005 // the rate limit of the credential is exceeded
+)
+
+// Request is a json-rpc call, a notification when ID is nil
+type Request struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type Response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// MarshalJSON leaves out the result of an error response, a nil result of a
+// success is kept as null
+func (r *Response) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string           `json:"jsonrpc"`
+			ID      *json.RawMessage `json:"id"`
+			Error   *Error           `json:"error"`
+		}{r.JSONRPC, r.ID, r.Error})
+	}
+	type plain Response
+	return json.Marshal((*plain)(r))
+}
+
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"` // e.g. the blocks sent before the failure
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code int, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// LinkResult is an input or output of a block
+type LinkResult struct {
+	Address string `json:"address"`
+	Amount  string `json:"amount"`
+}
+
+// BlockResult is a block as returned by the block methods
+type BlockResult struct {
+	Height     uint64       `json:"height"`
+	Address    string       `json:"address"`
+	Hash       string       `json:"hash"`
+	Balance    string       `json:"balance"`
+	Type       string       `json:"type"`
+	Flags      int          `json:"flags"`
+	Timestamp  uint64       `json:"timestamp"` // xdag time, 1/1024 seconds
+	Difficulty string       `json:"difficulty"`
+	Fee        string       `json:"fee"`
+	Remark     string       `json:"remark"`
+	Inputs     []LinkResult `json:"inputs"`
+	Outputs    []LinkResult `json:"outputs"`
+}
+
+// StatusResult is the answer of xdag_getStatus
+type StatusResult struct {
+	NBlocks       uint64 `json:"nblock"`
+	TotalNBlocks  uint64 `json:"totalNblocks"`
+	NMain         uint64 `json:"nmain"`
+	TotalNMain    uint64 `json:"totalNmain"`
+	NHosts        int    `json:"nhosts"`
+	TotalNHosts   int    `json:"totalNhosts"`
+	Difficulty    string `json:"curDiff"`
+	MaxDifficulty string `json:"netDiff"`
+	Supply        string `json:"ourSupply"`
+	NetSupply     string `json:"netSupply"`
+	Balance       string `json:"balance"`
+	State         string `json:"state"`
+}
+
+// TransferArgs are the arguments of xdag_personal_sendTransaction
+type TransferArgs struct {
+	To     string `json:"to"`
+	Value  string `json:"value"` // xdag
+	Remark string `json:"remark"`
+}
+
+// MinerResult is a miner address of xdag_getMiners
+type MinerResult struct {
+	Address  string         `json:"address"`
+	Hashrate float64        `json:"hashrate"` // hashes per second
+	Workers  []WorkerResult `json:"workers"`
+}
+
+// WorkerResult is the shares of a worker in one epoch
+type WorkerResult struct {
+	Worker     string  `json:"worker"`
+	Epoch      uint64  `json:"epoch"`
+	Accepted   uint64  `json:"accepted"`
+	Stale      uint64  `json:"stale"`
+	Invalid    uint64  `json:"invalid"`
+	Difficulty string  `json:"difficulty"`         // sum of the accepted share difficulty
+	Hashrate   float64 `json:"hashrate,omitempty"` // of the current epoch only
+	LastShare  int64   `json:"lastShare"`          // unix time in ms
+}
+
+// PoolHashrateResult is the answer of xdag_getPoolHashrate
+type PoolHashrateResult struct {
+	Hashrate float64 `json:"hashrate"`
+	Miners   int     `json:"miners"`
+}
@@ -0,0 +1,247 @@
+package rpc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocket opcodes, RFC 6455
+const (
+	WS_CONTINUATION = 0x0
+	WS_TEXT         = 0x1
+	WS_BINARY       = 0x2
+	WS_CLOSE        = 0x8
+	WS_PING         = 0x9
+	WS_PONG         = 0xa
+)
+
+const (
+	wsGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	MAX_WS_MESSAGE   = MAX_REQUEST_SIZE
+	wsCloseNormal    = 1000
+	wsCloseTooBig    = 1009
+	wsCloseProtocol  = 1002
+	wsCloseTryAgain  = 1013
+	wsMaxControlSize = 125
+)
+
+var (
+	ErrWsHandshake = errors.New("websocket: bad handshake")
+	ErrWsProtocol  = errors.New("websocket: protocol error")
+	ErrWsTooBig    = errors.New("websocket: message too big")
+	ErrWsClosed    = errors.New("websocket: closed")
+)
+
+// wsConn is a websocket connection. The server side reads masked frames and
+// writes plain ones, the client side the other way round.
+type wsConn struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	writeLock sync.Mutex
+	client    bool
+}
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsUpgrade takes over the http connection of a websocket handshake
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-Websocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-Websocket-Version") != "13" {
+		http.Error(w, "websocket handshake expected", http.StatusBadRequest)
+		return nil, ErrWsHandshake
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, ErrWsHandshake
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n"
+	if _, err = conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// readFrame reads one frame, the payload is unmasked
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.r, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	if head[0]&0x70 != 0 || masked == c.client {
+		err = ErrWsProtocol
+		return
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if opcode >= WS_CLOSE && (length > wsMaxControlSize || !fin) {
+		err = ErrWsProtocol
+		return
+	}
+	if length > MAX_WS_MESSAGE {
+		err = ErrWsTooBig
+		return
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// ReadMessage returns the next text or binary message, the control frames
+// are answered meanwhile
+func (c *wsConn) ReadMessage() (byte, []byte, error) {
+	var msgType byte
+	var msg []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			if err == ErrWsTooBig {
+				c.Close(wsCloseTooBig)
+			} else if err == ErrWsProtocol {
+				c.Close(wsCloseProtocol)
+			}
+			return 0, nil, err
+		}
+		switch opcode {
+		case WS_PING:
+			if err = c.WriteMessage(WS_PONG, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case WS_PONG:
+			continue
+		case WS_CLOSE:
+			c.Close(wsCloseNormal)
+			return 0, nil, ErrWsClosed
+		case WS_CONTINUATION:
+			if msg == nil {
+				return 0, nil, ErrWsProtocol
+			}
+		case WS_TEXT, WS_BINARY:
+			if msg != nil {
+				return 0, nil, ErrWsProtocol
+			}
+			msgType = opcode
+			msg = []byte{}
+		default:
+			return 0, nil, ErrWsProtocol
+		}
+		if len(msg)+len(payload) > MAX_WS_MESSAGE {
+			c.Close(wsCloseTooBig)
+			return 0, nil, ErrWsTooBig
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msgType, msg, nil
+		}
+	}
+}
+
+// WriteMessage writes data in one frame
+func (c *wsConn) WriteMessage(opcode byte, data []byte) error {
+	frame := make([]byte, 0, len(data)+14)
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(data) < 126:
+		frame = append(frame, maskBit|byte(len(data)))
+	case len(data) <= 0xffff:
+		frame = append(frame, maskBit|126, byte(len(data)>>8), byte(len(data)))
+	default:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(len(data)))
+		frame = append(frame, maskBit|127)
+		frame = append(frame, b[:]...)
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range data {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, data...)
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with code and closes the connection
+func (c *wsConn) Close(code uint16) error {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], code)
+	// a stuck peer must not hold the close
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.WriteMessage(WS_CLOSE, b[:])
+	return c.conn.Close()
+}
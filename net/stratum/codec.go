@@ -1 +1,129 @@
 package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// stratum error codes
+const (
+	ErrCodeOther         = 20
+	ErrCodeJobNotFound   = 21
+	ErrCodeDuplicate     = 22
+	ErrCodeLowDifficulty = 23
+	ErrCodeUnauthorized  = 24
+	ErrCodeNotSubscribed = 25
+	ErrCodeLimit         = 26
+)
+
+// Request is a stratum call from the miner, or a notification from the pool
+// when ID is nil
+type Request struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params []interface{}    `json:"params"`
+}
+
+type Response struct {
+	ID     *json.RawMessage `json:"id"`
+	Result interface{}      `json:"result"`
+	Error  *Error           `json:"error"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// MarshalJSON encodes the error as the [code, message, null] triple miners expect
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+func (e *Error) UnmarshalJSON(data []byte) error {
+	var arr []interface{}
+	if err := json.Unmarshal(data, &arr); err == nil && len(arr) >= 2 {
+		if code, ok := arr[0].(float64); ok {
+			e.Code = int(code)
+		}
+		if msg, ok := arr[1].(string); ok {
+			e.Message = msg
+		}
+		return nil
+	}
+	type plain Error
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+func newError(code int, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Codec reads and writes newline delimited JSON messages
+type Codec struct {
+	sync.Mutex
+	r *bufio.Reader
+	w io.Writer
+}
+
+func NewCodec(rw io.ReadWriter) *Codec {
+	return &Codec{
+		r: bufio.NewReaderSize(rw, 4096),
+		w: rw,
+	}
+}
+
+// ReadLine returns the next line without the trailing newline
+func (c *Codec) ReadLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, io.ErrShortBuffer
+		}
+		return nil, err
+	}
+	return line, nil
+}
+
+func (c *Codec) ReadRequest() (*Request, error) {
+	line, err := c.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	var req Request
+	if err = json.Unmarshal(line, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (c *Codec) ReadResponse() (*Response, error) {
+	line, err := c.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err = json.Unmarshal(line, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Write encodes v as a single line, it is safe for concurrent use
+func (c *Codec) Write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	c.Lock()
+	defer c.Unlock()
+	_, err = c.w.Write(data)
+	return err
+}
@@ -0,0 +1,539 @@
+package stratum
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/log"
+	"xdago/utils"
+)
+
+var (
+	ErrServerStopped = errors.New("stratum server stopped")
+	ErrGlobalLimit   = errors.New("stratum: global miner limit reached")
+	ErrIpLimit       = errors.New("stratum: connection limit per ip reached")
+)
+
+// Server hands out the current consensus.Task to stratum miners, validates
+// the shares they submit and keeps the best share of every task.
+type Server struct {
+	sync.RWMutex
+	config   *config.Config
+	listener net.Listener
+	task     *consensus.Task
+	clients  map[*client]struct{}
+	ipCount  map[string]int
+	accounts map[string]int // account -> authorized workers
+	best     map[uint64]consensus.Share
+	handler  func(consensus.Share)
+	// rejectHandler is called for refused shares
+	rejectHandler func(consensus.Share, consensus.ShareStatus)
+
+	minDifficulty uint64
+	targetTime    time.Duration
+	retargetTime  time.Duration
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	now  func() time.Time
+}
+
+type client struct {
+	conn       net.Conn
+	codec      *Codec
+	host       string
+	subscribed bool
+	account    string
+	worker     string
+	vardiff    *VarDiff
+	taskIndex  uint64 // task the share counters belong to
+	shareCount int
+	nonces     map[common.Field]struct{}
+
+	// the last job sent and the share difficulty sent with it, a retarget
+	// applies from the next job
+	jobIndex      uint64
+	jobDifficulty uint64
+}
+
+func (c *client) authorized() bool {
+	return c.account != ""
+}
+
+func NewServer(config *config.Config) *Server {
+	return &Server{
+		config:        config,
+		clients:       make(map[*client]struct{}),
+		ipCount:       make(map[string]int),
+		accounts:      make(map[string]int),
+		best:          make(map[uint64]consensus.Share),
+		minDifficulty: MIN_SHARE_DIFFICULTY,
+		targetTime:    VARDIFF_TARGET_TIME,
+		retargetTime:  VARDIFF_RETARGET_TIME,
+		quit:          make(chan struct{}),
+		now:           utils.Now,
+	}
+}
+
+// SetDifficulty sets the starting and minimum share difficulty of new workers
+func (s *Server) SetDifficulty(minDifficulty uint64) {
+	s.Lock()
+	defer s.Unlock()
+	s.minDifficulty = minDifficulty
+}
+
+// SetVarDiff sets the wanted time between shares and between retargets
+func (s *Server) SetVarDiff(targetTime, retargetTime time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.targetTime = targetTime
+	s.retargetTime = retargetTime
+}
+
+// SetShareHandler registers a callback called for every accepted share
+func (s *Server) SetShareHandler(handler func(consensus.Share)) {
+	s.Lock()
+	defer s.Unlock()
+	s.handler = handler
+}
+
+// SetRejectHandler registers a callback called for every refused share of an
+// authorized worker
+func (s *Server) SetRejectHandler(handler func(consensus.Share, consensus.ShareStatus)) {
+	s.Lock()
+	defer s.Unlock()
+	s.rejectHandler = handler
+}
+
+// Start listens on pool.ip:pool.stratumPort, pool.port is left to the xdag
+// miner protocol
+func (s *Server) Start() error {
+	address := net.JoinHostPort(s.config.PoolIp(), strconv.Itoa(s.config.StratumPort()))
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.Serve(l)
+	return nil
+}
+
+// Serve accepts miners on l in the background
+func (s *Server) Serve(l net.Listener) {
+	s.Lock()
+	s.listener = l
+	s.Unlock()
+	log.Info("stratum server started", log.Ctx{"address": l.Addr().String()})
+	s.wg.Add(2)
+	go s.acceptLoop(l)
+	go s.retargetLoop()
+}
+
+// retargetLoop retargets the workers on a timer, a worker whose difficulty
+// is too high sends no share to retarget it
+func (s *Server) retargetLoop() {
+	defer s.wg.Done()
+	s.RLock()
+	interval := s.retargetTime
+	s.RUnlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.retarget()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *Server) retarget() {
+	now := s.now()
+	s.RLock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		if c.authorized() {
+			clients = append(clients, c)
+		}
+	}
+	s.RUnlock()
+	for _, c := range clients {
+		if diff, changed := c.vardiff.Retarget(now); changed {
+			c.codec.Write(Request{Method: "mining.set_difficulty", Params: []interface{}{diff}})
+		}
+	}
+}
+
+func (s *Server) Stop() {
+	select {
+	case <-s.quit:
+		return
+	default:
+	}
+	close(s.quit)
+	s.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.Unlock()
+	s.wg.Wait()
+	log.Info("stratum server stopped")
+}
+
+func (s *Server) acceptLoop(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Warn("accept miner failed", log.Ctx{"err": err.Error()})
+			continue
+		}
+		c, err := s.addClient(conn)
+		if err != nil {
+			log.Debug("reject miner", log.Ctx{"remote": conn.RemoteAddr().String(), "err": err.Error()})
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveClient(c)
+		}()
+	}
+}
+
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (s *Server) addClient(conn net.Conn) (*client, error) {
+	host := hostOf(conn.RemoteAddr())
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.quit:
+		return nil, ErrServerStopped
+	default:
+	}
+	if limit := s.config.GlobalMinerLimit(); limit > 0 && len(s.clients) >= limit {
+		return nil, ErrGlobalLimit
+	}
+	if limit := s.config.MaxConnectPerIp(); limit > 0 && s.ipCount[host] >= limit {
+		return nil, ErrIpLimit
+	}
+	c := &client{
+		conn:    conn,
+		codec:   NewCodec(conn),
+		host:    host,
+		vardiff: NewVarDiff(s.minDifficulty, s.targetTime, s.retargetTime, s.now()),
+		nonces:  make(map[common.Field]struct{}),
+	}
+	s.clients[c] = struct{}{}
+	s.ipCount[host]++
+	return c, nil
+}
+
+func (s *Server) removeClient(c *client) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.clients, c)
+	if s.ipCount[c.host]--; s.ipCount[c.host] <= 0 {
+		delete(s.ipCount, c.host)
+	}
+	if c.authorized() {
+		if s.accounts[c.account]--; s.accounts[c.account] <= 0 {
+			delete(s.accounts, c.account)
+		}
+	}
+}
+
+// MinerCount returns the number of connected and authorized workers
+func (s *Server) MinerCount() (connected, authorized int) {
+	s.RLock()
+	defer s.RUnlock()
+	for c := range s.clients {
+		if c.authorized() {
+			authorized++
+		}
+	}
+	return len(s.clients), authorized
+}
+
+func (s *Server) serveClient(c *client) {
+	defer func() {
+		c.conn.Close()
+		s.removeClient(c)
+	}()
+	for {
+		req, err := c.codec.ReadRequest()
+		if err != nil {
+			return
+		}
+		result, rerr := s.handle(c, req)
+		resp := Response{ID: req.ID, Result: result}
+		if rerr != nil {
+			resp.Error = rerr
+		}
+		if req.ID != nil {
+			if err = c.codec.Write(resp); err != nil {
+				return
+			}
+		}
+		if req.Method == "mining.authorize" && rerr == nil {
+			s.sendWork(c)
+		}
+	}
+}
+
+func (s *Server) handle(c *client, req *Request) (interface{}, *Error) {
+	switch req.Method {
+	case "mining.subscribe":
+		s.Lock()
+		c.subscribed = true
+		s.Unlock()
+		id := hex.EncodeToString([]byte(c.conn.RemoteAddr().String()))
+		return []interface{}{[]interface{}{[]string{"mining.notify", id}}, "", 0}, nil
+	case "mining.authorize":
+		return s.authorize(c, req.Params)
+	case "mining.submit":
+		return s.submit(c, req.Params)
+	default:
+		return nil, newError(ErrCodeOther, "unknown method "+req.Method)
+	}
+}
+
+// splitUser splits a stratum user name "account.worker"
+func splitUser(user string) (string, string) {
+	if i := strings.IndexByte(user, '.'); i >= 0 {
+		return user[:i], user[i+1:]
+	}
+	return user, ""
+}
+
+func stringParam(params []interface{}, i int) (string, bool) {
+	if i >= len(params) {
+		return "", false
+	}
+	str, ok := params[i].(string)
+	return str, ok
+}
+
+func (s *Server) authorize(c *client, params []interface{}) (interface{}, *Error) {
+	user, ok := stringParam(params, 0)
+	if !ok || user == "" {
+		return false, newError(ErrCodeOther, "missing user name")
+	}
+	account, worker := splitUser(user)
+	if account == "" {
+		return false, newError(ErrCodeOther, "missing account")
+	}
+	// the account is paid, it must be an address; a hashlow is kept as the
+	// address so both count as one account
+	hash, err := codec.ParseHash(account)
+	if err != nil {
+		return false, newError(ErrCodeOther, "invalid account "+account)
+	}
+	account = codec.Hash2Address(hash)
+
+	s.Lock()
+	defer s.Unlock()
+	if !c.subscribed {
+		return false, newError(ErrCodeNotSubscribed, "not subscribed")
+	}
+	if c.authorized() {
+		if c.account == account {
+			c.worker = worker
+			return true, nil
+		}
+		return false, newError(ErrCodeOther, "already authorized")
+	}
+	if limit := s.config.MaxMinerPerAccount(); limit > 0 && s.accounts[account] >= limit {
+		return false, newError(ErrCodeLimit, "too many miners for account")
+	}
+	c.account = account
+	c.worker = worker
+	s.accounts[account]++
+	log.Debug("miner authorized", log.Ctx{"account": account, "worker": worker, "host": c.host})
+	return true, nil
+}
+
+// rejected reports a share of an authorized worker that was refused
+func (s *Server) rejected(account, worker string, nonce common.Field, status consensus.ShareStatus) {
+	s.RLock()
+	handler := s.rejectHandler
+	s.RUnlock()
+	if handler != nil {
+		handler(consensus.Share{Account: account, Worker: worker, Nonce: nonce, Time: s.now()}, status)
+	}
+}
+
+func jobId(task *consensus.Task) string {
+	return strconv.FormatUint(task.TaskIndex(), 16)
+}
+
+func (s *Server) submit(c *client, params []interface{}) (interface{}, *Error) {
+	job, ok1 := stringParam(params, 1)
+	nonceHex, ok2 := stringParam(params, 2)
+	if !ok1 || !ok2 {
+		return false, newError(ErrCodeOther, "invalid params")
+	}
+	var nonce common.Field
+	b, err := hex.DecodeString(nonceHex)
+	if err != nil || len(b) != common.XDAG_FIELD_SIZE {
+		return false, newError(ErrCodeOther, "invalid nonce")
+	}
+	copy(nonce[:], b)
+
+	s.Lock()
+	if !c.authorized() {
+		s.Unlock()
+		return false, newError(ErrCodeUnauthorized, "unauthorized worker")
+	}
+	account, worker := c.account, c.worker
+	task := s.task
+	if task == nil || job != jobId(task) || c.jobIndex != task.TaskIndex() {
+		s.Unlock()
+		s.rejected(account, worker, nonce, consensus.SHARE_STALE)
+		return false, newError(ErrCodeJobNotFound, "job not found")
+	}
+	if c.taskIndex != task.TaskIndex() {
+		c.taskIndex = task.TaskIndex()
+		c.shareCount = 0
+		c.nonces = make(map[common.Field]struct{})
+	}
+	if _, ok := c.nonces[nonce]; ok {
+		s.Unlock()
+		s.rejected(account, worker, nonce, consensus.SHARE_INVALID)
+		return false, newError(ErrCodeDuplicate, "duplicate share")
+	}
+	if limit := s.config.MaxShareCountPerChannel(); limit > 0 && c.shareCount >= limit {
+		s.Unlock()
+		s.rejected(account, worker, nonce, consensus.SHARE_INVALID)
+		return false, newError(ErrCodeLimit, "too many shares")
+	}
+	c.nonces[nonce] = struct{}{}
+	c.shareCount++
+	target := new(big.Int).SetUint64(c.jobDifficulty)
+	s.Unlock()
+
+	hash := task.ShareHash(nonce)
+	difficulty := consensus.HashDifficulty(hash)
+	if difficulty.Cmp(target) < 0 {
+		s.rejected(account, worker, nonce, consensus.SHARE_INVALID)
+		return false, newError(ErrCodeLowDifficulty, "low difficulty share")
+	}
+
+	now := s.now()
+	// the share is counted for the target it met
+	share := consensus.Share{
+		Account:          account,
+		Worker:           worker,
+		TaskIndex:        task.TaskIndex(),
+		Nonce:            nonce,
+		Hash:             hash,
+		Difficulty:       target,
+		ActualDifficulty: difficulty,
+		Time:             now,
+	}
+	s.Lock()
+	if best, ok := s.best[share.TaskIndex]; !ok || difficulty.Cmp(best.ActualDifficulty) > 0 {
+		s.best[share.TaskIndex] = share
+	}
+	handler := s.handler
+	s.Unlock()
+	if handler != nil {
+		handler(share)
+	}
+
+	if diff, changed := c.vardiff.AddShare(now); changed {
+		c.codec.Write(Request{Method: "mining.set_difficulty", Params: []interface{}{diff}})
+	}
+	return true, nil
+}
+
+// BestShare returns the share with the highest difficulty found for a task
+func (s *Server) BestShare(taskIndex uint64) (consensus.Share, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	share, ok := s.best[taskIndex]
+	return share, ok
+}
+
+func (s *Server) Task() *consensus.Task {
+	s.RLock()
+	defer s.RUnlock()
+	return s.task
+}
+
+// SetTask replaces the current task, shares of previous tasks become stale.
+// The new work is sent to every authorized worker.
+func (s *Server) SetTask(task *consensus.Task) {
+	s.Lock()
+	s.task = task
+	// keep the best share of the previous task until it has been used
+	for index := range s.best {
+		if index+1 < task.TaskIndex() {
+			delete(s.best, index)
+		}
+	}
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		if c.authorized() {
+			clients = append(clients, c)
+		}
+	}
+	s.Unlock()
+	for _, c := range clients {
+		s.sendWork(c)
+	}
+}
+
+// notifyParams builds mining.notify params: job id, the block without its
+// nonce field, the task field and clean jobs flag
+func notifyParams(task *consensus.Task) []interface{} {
+	block := task.Block()
+	field := task.Task()
+	return []interface{}{
+		jobId(task),
+		hex.EncodeToString(block[:(common.XDAG_BLOCK_FIELDS-1)*common.XDAG_FIELD_SIZE]),
+		hex.EncodeToString(field.Data[:]),
+		true,
+	}
+}
+
+// sendWork sends the current task with the difficulty its shares must reach
+func (s *Server) sendWork(c *client) {
+	s.Lock()
+	task := s.task
+	if task == nil {
+		s.Unlock()
+		return
+	}
+	c.jobIndex = task.TaskIndex()
+	c.jobDifficulty = c.vardiff.Difficulty()
+	difficulty := c.jobDifficulty
+	s.Unlock()
+	c.codec.Write(Request{Method: "mining.set_difficulty", Params: []interface{}{difficulty}})
+	c.codec.Write(Request{Method: "mining.notify", Params: notifyParams(task)})
+}
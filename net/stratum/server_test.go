@@ -0,0 +1,355 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package stratum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/magiconair/properties/assert"
+	"math"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/crypto"
+	"xdago/utils"
+)
+
+type testMiner struct {
+	conn   net.Conn
+	codec  *Codec
+	id     int
+	notify []Request
+}
+
+func dialMiner(t *testing.T, address string) *testMiner {
+	conn, err := net.Dial("tcp", address)
+	assert.Equal(t, err, nil)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testMiner{conn: conn, codec: NewCodec(conn)}
+}
+
+// call sends a request and returns its response, notifications received
+// meanwhile are kept in m.notify
+func (m *testMiner) call(t *testing.T, method string, params ...interface{}) *Response {
+	m.id++
+	id := json.RawMessage(strconv.Itoa(m.id))
+	assert.Equal(t, m.codec.Write(Request{ID: &id, Method: method, Params: params}), nil)
+	for {
+		line, err := m.codec.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp Response
+		assert.Equal(t, json.Unmarshal(line, &resp), nil)
+		if resp.ID != nil {
+			return &resp
+		}
+		var req Request
+		assert.Equal(t, json.Unmarshal(line, &req), nil)
+		m.notify = append(m.notify, req)
+	}
+}
+
+// readNotify waits for the next notification
+func (m *testMiner) readNotify(t *testing.T) Request {
+	req, err := m.codec.ReadRequest()
+	assert.Equal(t, err, nil)
+	return *req
+}
+
+func (m *testMiner) login(t *testing.T, user string) *Response {
+	assert.Equal(t, m.call(t, "mining.subscribe").Error == nil, true)
+	return m.call(t, "mining.authorize", user, "x")
+}
+
+var acc = testAccount(1)
+
+// testAccount returns the address of a test miner
+func testAccount(i byte) string {
+	var h common.Hash
+	h[8] = i
+	return codec.Hash2Address(h)
+}
+
+func nonceHex(i byte) string {
+	var nonce common.Field
+	nonce[0] = i
+	nonce[31] = 0xa5
+	return hex.EncodeToString(nonce[:])
+}
+
+func testTask(index uint64) *consensus.Task {
+	var block common.RawBlock
+	for i := range block {
+		block[i] = byte(i * 7)
+	}
+	return consensus.NewTask(block, 0x1234<<16, index)
+}
+
+func startServer(t *testing.T, c *config.Config) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("tcp not available:", err)
+	}
+	s := NewServer(c)
+	s.Serve(l)
+	return s, l.Addr().String()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not reached in time")
+}
+
+func TestHashDifficulty(t *testing.T) {
+	var hash common.Hash
+	assert.Equal(t, consensus.HashDifficulty(hash), new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1)))
+	for i := range hash {
+		hash[i] = 0xff
+	}
+	assert.Equal(t, consensus.HashDifficulty(hash), new(big.Int).SetUint64(MIN_SHARE_DIFFICULTY))
+
+	task := testTask(1)
+	var nonce common.Field
+	nonce[3] = 9
+	block := task.Block()
+	copy(block[(common.XDAG_BLOCK_FIELDS-1)*common.XDAG_FIELD_SIZE:], nonce[:])
+	assert.Equal(t, task.ShareHash(nonce), common.Hash(crypto.HashTwice(block[:])))
+}
+
+func TestStratumShares(t *testing.T) {
+	c := &config.Config{}
+	c.SetMaxMinerPerAccount(1)
+	c.SetMaxShareCountPerChannel(3)
+	s, address := startServer(t, c)
+	defer s.Stop()
+
+	var lock sync.Mutex
+	var accepted []consensus.Share
+	s.SetShareHandler(func(share consensus.Share) {
+		lock.Lock()
+		accepted = append(accepted, share)
+		lock.Unlock()
+	})
+	var rejected []consensus.ShareStatus
+	s.SetRejectHandler(func(share consensus.Share, status consensus.ShareStatus) {
+		lock.Lock()
+		rejected = append(rejected, status)
+		lock.Unlock()
+	})
+	s.SetTask(testTask(1))
+
+	m := dialMiner(t, address)
+	defer m.conn.Close()
+	assert.Equal(t, m.call(t, "mining.authorize", acc+".w1", "x").Error.Code, ErrCodeNotSubscribed)
+	// the account must be an address or a hashlow
+	for _, user := range []string{"acc.w1", "xdag.w1", acc[:31] + ".w1"} {
+		assert.Equal(t, m.login(t, user).Error.Code, ErrCodeOther)
+	}
+	resp := m.call(t, "mining.authorize", acc+".w1", "x")
+	assert.Equal(t, resp.Result, true)
+	assert.Equal(t, m.readNotify(t).Method, "mining.set_difficulty")
+	work := m.readNotify(t)
+	assert.Equal(t, work.Method, "mining.notify")
+	assert.Equal(t, work.Params[0], "1")
+
+	// a second worker on the same account exceeds maxMinerPerAccount
+	m2 := dialMiner(t, address)
+	defer m2.conn.Close()
+	assert.Equal(t, m2.login(t, acc+".w2").Error.Code, ErrCodeLimit)
+	var h common.Hash
+	h[8] = 1
+	assert.Equal(t, m2.call(t, "mining.authorize", utils.Hash2String(h)+".w2", "x").Error.Code, ErrCodeLimit)
+
+	assert.Equal(t, m.call(t, "mining.submit", acc+".w1", "1", nonceHex(1)).Result, true)
+	assert.Equal(t, m.call(t, "mining.submit", acc+".w1", "1", nonceHex(1)).Error.Code, ErrCodeDuplicate)
+	assert.Equal(t, m.call(t, "mining.submit", acc+".w1", "2", nonceHex(2)).Error.Code, ErrCodeJobNotFound)
+	assert.Equal(t, m.call(t, "mining.submit", acc+".w1", "1", "00").Error.Code, ErrCodeOther)
+	assert.Equal(t, m.call(t, "mining.submit", acc+".w1", "1", nonceHex(2)).Result, true)
+	assert.Equal(t, m.call(t, "mining.submit", acc+".w1", "1", nonceHex(3)).Result, true)
+	assert.Equal(t, m.call(t, "mining.submit", acc+".w1", "1", nonceHex(4)).Error.Code, ErrCodeLimit)
+
+	lock.Lock()
+	assert.Equal(t, len(accepted), 3)
+	assert.Equal(t, rejected, []consensus.ShareStatus{consensus.SHARE_INVALID, consensus.SHARE_STALE, consensus.SHARE_INVALID})
+	best := accepted[0]
+	for _, share := range accepted {
+		assert.Equal(t, share.Account, acc)
+		assert.Equal(t, share.Worker, "w1")
+		// counted for the worker difficulty whatever the luck of the hash
+		assert.Equal(t, share.Difficulty, new(big.Int).SetUint64(MIN_SHARE_DIFFICULTY))
+		assert.Equal(t, share.ActualDifficulty, consensus.HashDifficulty(share.Hash))
+		if share.ActualDifficulty.Cmp(best.ActualDifficulty) > 0 {
+			best = share
+		}
+	}
+	lock.Unlock()
+	got, ok := s.BestShare(1)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, got.Nonce, best.Nonce)
+
+	// new task: old job is stale and the share counter starts again
+	s.SetTask(testTask(2))
+	assert.Equal(t, m.readNotify(t).Method, "mining.set_difficulty")
+	assert.Equal(t, m.readNotify(t).Params[0], "2")
+	assert.Equal(t, m.call(t, "mining.submit", acc+".w1", "1", nonceHex(5)).Error.Code, ErrCodeJobNotFound)
+	assert.Equal(t, m.call(t, "mining.submit", acc+".w1", "2", nonceHex(1)).Result, true)
+
+	// shares must reach the worker difficulty
+	s.SetDifficulty(math.MaxUint64)
+	m3 := dialMiner(t, address)
+	defer m3.conn.Close()
+	assert.Equal(t, m3.login(t, testAccount(2)).Result, true)
+	assert.Equal(t, m3.call(t, "mining.submit", testAccount(2), "2", nonceHex(1)).Error.Code, ErrCodeLowDifficulty)
+}
+
+func TestStratumConnectionLimits(t *testing.T) {
+	c := &config.Config{}
+	c.SetMaxConnectPerIp(2)
+	c.SetGlobalMinerLimit(10)
+	s, address := startServer(t, c)
+	defer s.Stop()
+
+	m1 := dialMiner(t, address)
+	defer m1.conn.Close()
+	m2 := dialMiner(t, address)
+	defer m2.conn.Close()
+	waitFor(t, func() bool {
+		connected, _ := s.MinerCount()
+		return connected == 2
+	})
+
+	// the third connection from the same ip is closed at once
+	m3 := dialMiner(t, address)
+	defer m3.conn.Close()
+	_, err := m3.codec.ReadLine()
+	assert.Equal(t, err != nil, true)
+
+	m1.conn.Close()
+	waitFor(t, func() bool {
+		connected, _ := s.MinerCount()
+		return connected == 1
+	})
+	m4 := dialMiner(t, address)
+	defer m4.conn.Close()
+	assert.Equal(t, m4.login(t, acc).Result, true)
+	_, authorized := s.MinerCount()
+	assert.Equal(t, authorized, 1)
+}
+
+func TestVarDiff(t *testing.T) {
+	start := time.Unix(1000, 0)
+	v := NewVarDiff(0, 10*time.Second, time.Minute, start)
+	assert.Equal(t, v.Difficulty(), MIN_SHARE_DIFFICULTY)
+
+	// 60 shares in a minute is 10 times too fast, the step is clamped to 4
+	for i := 1; i < 60; i++ {
+		_, changed := v.AddShare(start.Add(time.Duration(i) * time.Second))
+		assert.Equal(t, changed, false)
+	}
+	diff, changed := v.AddShare(start.Add(time.Minute))
+	assert.Equal(t, changed, true)
+	assert.Equal(t, diff, 4*MIN_SHARE_DIFFICULTY)
+
+	// 3 shares in the next minute is 2 times too slow
+	v.AddShare(start.Add(80 * time.Second))
+	v.AddShare(start.Add(100 * time.Second))
+	diff, changed = v.AddShare(start.Add(2 * time.Minute))
+	assert.Equal(t, changed, true)
+	assert.Equal(t, diff, 2*MIN_SHARE_DIFFICULTY)
+
+	// never below the minimum
+	diff, _ = v.AddShare(start.Add(time.Hour))
+	assert.Equal(t, diff, MIN_SHARE_DIFFICULTY)
+
+	// a worker without shares is retargeted on the timer
+	v = NewVarDiff(0, 10*time.Second, time.Minute, start)
+	for i := 1; i <= 60; i++ {
+		v.AddShare(start.Add(time.Duration(i) * time.Second))
+	}
+	assert.Equal(t, v.Difficulty(), 4*MIN_SHARE_DIFFICULTY)
+	_, changed = v.Retarget(start.Add(90 * time.Second))
+	assert.Equal(t, changed, false)
+	diff, changed = v.Retarget(start.Add(3 * time.Minute))
+	assert.Equal(t, changed, true)
+	assert.Equal(t, diff, MIN_SHARE_DIFFICULTY)
+}
+
+func TestStratumJobDifficulty(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("tcp not available:", err)
+	}
+	var clock int64 = 1000 * int64(time.Second)
+	s := NewServer(&config.Config{})
+	s.now = func() time.Time { return time.Unix(0, atomic.LoadInt64(&clock)) }
+	s.SetVarDiff(10*time.Second, 20*time.Millisecond)
+	s.Serve(l)
+	defer s.Stop()
+
+	var lock sync.Mutex
+	var accepted []consensus.Share
+	s.SetShareHandler(func(share consensus.Share) {
+		lock.Lock()
+		accepted = append(accepted, share)
+		lock.Unlock()
+	})
+	s.SetTask(testTask(1))
+	m := dialMiner(t, l.Addr().String())
+	defer m.conn.Close()
+	assert.Equal(t, m.login(t, acc).Result, true)
+	assert.Equal(t, m.readNotify(t).Params[0], float64(MIN_SHARE_DIFFICULTY))
+	m.readNotify(t)
+	for i := byte(1); i <= 3; i++ {
+		assert.Equal(t, m.call(t, "mining.submit", acc, "1", nonceHex(i)).Result, true)
+	}
+
+	// three shares in a second are too many, the timer raises the difficulty
+	atomic.AddInt64(&clock, int64(time.Second))
+	retarget := m.readNotify(t)
+	assert.Equal(t, retarget.Method, "mining.set_difficulty")
+	assert.Equal(t, retarget.Params[0], float64(4*MIN_SHARE_DIFFICULTY))
+
+	// the shares of the current job keep the difficulty sent with it
+	assert.Equal(t, m.call(t, "mining.submit", acc, "1", nonceHex(4)).Result, true)
+	s.SetTask(testTask(2))
+	assert.Equal(t, m.readNotify(t).Params[0], float64(4*MIN_SHARE_DIFFICULTY))
+	m.readNotify(t)
+	low := 0
+	for i := byte(1); i <= 16; i++ {
+		resp := m.call(t, "mining.submit", acc, "2", nonceHex(i))
+		if resp.Error != nil {
+			assert.Equal(t, resp.Error.Code, ErrCodeLowDifficulty)
+			low++
+		}
+	}
+	assert.Equal(t, low > 0, true)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, len(accepted), 4+16-low)
+	for i, share := range accepted {
+		want := MIN_SHARE_DIFFICULTY
+		if i >= 4 {
+			want = 4 * MIN_SHARE_DIFFICULTY
+		}
+		assert.Equal(t, share.Difficulty, new(big.Int).SetUint64(want))
+	}
+}
@@ -0,0 +1,99 @@
+package stratum
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// MIN_SHARE_DIFFICULTY is reached by any hash, the xdag difficulty of a
+	// hash is never below 2^32
+	MIN_SHARE_DIFFICULTY uint64 = 1 << 32
+
+	VARDIFF_TARGET_TIME   = 10 * time.Second // wanted time between two shares of a worker
+	VARDIFF_RETARGET_TIME = 60 * time.Second // minimum time between two adjustments
+	VARDIFF_MAX_STEP      = 4                // a retarget changes difficulty by this factor at most
+)
+
+// VarDiff adjusts the share difficulty of a worker so it submits a share
+// about every targetTime
+type VarDiff struct {
+	sync.Mutex
+	difficulty   uint64
+	minimum      uint64
+	targetTime   time.Duration
+	retargetTime time.Duration
+	lastRetarget time.Time
+	shares       uint64
+}
+
+func NewVarDiff(minimum uint64, targetTime, retargetTime time.Duration, now time.Time) *VarDiff {
+	if minimum == 0 {
+		minimum = MIN_SHARE_DIFFICULTY
+	}
+	return &VarDiff{
+		difficulty:   minimum,
+		minimum:      minimum,
+		targetTime:   targetTime,
+		retargetTime: retargetTime,
+		lastRetarget: now,
+	}
+}
+
+func (v *VarDiff) Difficulty() uint64 {
+	v.Lock()
+	defer v.Unlock()
+	return v.difficulty
+}
+
+// AddShare records an accepted share and returns the new difficulty and true
+// when it has been retargeted
+func (v *VarDiff) AddShare(now time.Time) (uint64, bool) {
+	v.Lock()
+	defer v.Unlock()
+	v.shares++
+	return v.retarget(now)
+}
+
+// Retarget adjusts the difficulty of a worker which may not send shares, it
+// is called on a timer so a difficulty too high for the worker comes down
+func (v *VarDiff) Retarget(now time.Time) (uint64, bool) {
+	v.Lock()
+	defer v.Unlock()
+	return v.retarget(now)
+}
+
+// retarget must be called with the lock held, a period without share is
+// taken as one share at its end
+func (v *VarDiff) retarget(now time.Time) (uint64, bool) {
+	elapsed := now.Sub(v.lastRetarget)
+	if elapsed < v.retargetTime {
+		return v.difficulty, false
+	}
+	shares := v.shares
+	if shares == 0 {
+		shares = 1
+	}
+	actual := elapsed / time.Duration(shares)
+	ratio := float64(v.targetTime) / float64(actual)
+	if ratio > VARDIFF_MAX_STEP {
+		ratio = VARDIFF_MAX_STEP
+	} else if ratio < 1.0/VARDIFF_MAX_STEP {
+		ratio = 1.0 / VARDIFF_MAX_STEP
+	}
+	next := uint64(math.MaxUint64)
+	if f := float64(v.difficulty) * ratio; f < math.MaxUint64 {
+		next = uint64(f)
+	}
+	if next < v.minimum {
+		next = v.minimum
+	}
+	v.lastRetarget = now
+	v.shares = 0
+	if next == v.difficulty {
+		return next, false
+	}
+	v.difficulty = next
+	return next, true
+}
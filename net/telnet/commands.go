@@ -0,0 +1,433 @@
+package telnet
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/core"
+	"xdago/net/auth"
+	"xdago/utils"
+)
+
+const (
+	DEFAULT_LIST_COUNT = 20
+	MAX_LIST_COUNT     = 100
+	TABLE_LINE         = "-----------------------------------------------------------------------------------------------------------"
+)
+
+// groups of the commands for the methods of admin.telnet.credentials
+const (
+	GROUP_READ   = "read"
+	GROUP_WALLET = "wallet"
+	GROUP_NODE   = "node"
+)
+
+type command struct {
+	group string // empty for the commands of everyone
+	usage string
+	help  string
+	run   func(s *Server, ss *session, args []string) bool // false ends the session
+}
+
+var commands = map[string]command{
+	"account":     {GROUP_READ, "account [N]", "print the first N (20 by default) our addresses with their balances", (*Server).account},
+	"balance":     {GROUP_READ, "balance [A]", "print the balance of the address A or the total balance of our addresses", (*Server).balance},
+	"xfer":        {GROUP_WALLET, "xfer S A [R]", "transfer S XDAG to the address A with the remark R", (*Server).xfer},
+	"stats":       {GROUP_READ, "stats", "print the statistics of the network", (*Server).stats},
+	"state":       {GROUP_READ, "state", "print the state of the node", (*Server).state},
+	"lastblocks":  {GROUP_READ, "lastblocks [N]", "print the addresses of the latest N (20 by default, 100 at most) main blocks", (*Server).lastBlocks},
+	"mainblocks":  {GROUP_READ, "mainblocks [N]", "print the latest N (20 by default, 100 at most) main blocks", (*Server).mainBlocks},
+	"minedblocks": {GROUP_READ, "minedblocks [N]", "print the latest N (20 by default, 100 at most) main blocks mined by us", (*Server).minedBlocks},
+	"block":       {GROUP_READ, "block A", "print the block of the address or hash A", (*Server).block},
+	"miners":      {GROUP_READ, "miners", "print the connected miners and their hash rate", (*Server).miners},
+	"net":         {GROUP_READ, "net conn", "print the connections to the other nodes", (*Server).net},
+	"pool":        {GROUP_READ, "pool", "print the pool settings and the blocks waiting for their payout", (*Server).pool},
+	"generate":    {GROUP_NODE, "generate N", "make N main blocks at once, on the regtest network only", (*Server).generate},
+	"reload":      {GROUP_NODE, "reload", "reload the white ips, miner limits, pool rations, log verbosity and rpc credentials of the config", (*Server).reload},
+	"terminate":   {GROUP_NODE, "terminate", "stop the node", (*Server).terminateNode},
+	"exit":        {"", "exit", "close the console", func(*Server, *session, []string) bool { return false }},
+}
+
+// execute runs one command line, it returns false to end the session
+func (s *Server) execute(ss *session, args []string) bool {
+	if args[0] == "help" {
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !ss.allowed(name) {
+				continue
+			}
+			ss.println(fmt.Sprintf("%-16s - %s", commands[name].usage, commands[name].help))
+		}
+		return true
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		ss.println("Illegal command " + args[0] + ", type help for the commands.")
+		return true
+	}
+	if !ss.allowed(args[0]) {
+		ss.println("Command " + args[0] + " is not allowed.")
+		return true
+	}
+	if cmd.group != "" && ss.id != nil && !ss.id.Allow() {
+		ss.println("Rate limit exceeded.")
+		return true
+	}
+	return cmd.run(s, ss, args[1:])
+}
+
+// commandGroups lists the commands of the groups
+func commandGroups() auth.Groups {
+	groups := make(auth.Groups)
+	for name, cmd := range commands {
+		if cmd.group != "" {
+			groups[cmd.group] = append(groups[cmd.group], name)
+		}
+	}
+	return groups
+}
+
+func timeString(xdagTime uint64) string {
+	return time.UnixMilli(int64(utils.XdagTimestamp2Ms(xdagTime))).Format("2006-01-02 15:04:05.000")
+}
+
+// listCount parses the optional N of the list commands
+func listCount(ss *session, args []string) (int, bool) {
+	if len(args) == 0 {
+		return DEFAULT_LIST_COUNT, true
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		ss.println("Illegal number.")
+		return 0, false
+	}
+	if n > MAX_LIST_COUNT {
+		n = MAX_LIST_COUNT
+	}
+	return n, true
+}
+
+func (s *Server) account(ss *session, args []string) bool {
+	n, ok := listCount(ss, args)
+	if !ok {
+		return true
+	}
+	addresses := consensus.OurAddresses(s.chain)
+	if len(addresses) > n {
+		addresses = addresses[:n]
+	}
+	for _, a := range addresses {
+		ss.println(fmt.Sprintf("%s %20s  key %d", codec.Hash2Address(a.Hash), a.Balance.String(), a.Key))
+	}
+	return true
+}
+
+func (s *Server) balance(ss *session, args []string) bool {
+	if len(args) == 0 {
+		ss.println("Balance: " + common.Amount(s.chain.GetXDAGStats().Balance).String() + " XDAG")
+		return true
+	}
+	hash, err := codec.ParseHash(args[0])
+	if err != nil {
+		ss.println("Address format error.")
+		return true
+	}
+	block := s.chain.GetBlockByHash(hash, false)
+	if block == nil || block.Info() == nil {
+		ss.println("Block is not found.")
+		return true
+	}
+	ss.println("Balance: " + common.Amount(block.Info().Amount).String() + " XDAG")
+	return true
+}
+
+func (s *Server) xfer(ss *session, args []string) bool {
+	if len(args) < 2 {
+		ss.println("Usage: xfer S A [R]")
+		return true
+	}
+	amount, err := common.ParseAmount(args[0])
+	if err != nil || amount == 0 {
+		ss.println("Xfer: incorrect amount.")
+		return true
+	}
+	to, err := codec.ParseHash(args[1])
+	if err != nil {
+		ss.println("Xfer: incorrect address.")
+		return true
+	}
+	remark := strings.Join(args[2:], " ")
+	if s.wallet == nil || s.wallet.IsLocked() {
+		ss.println("Xfer: wallet is locked.")
+		return true
+	}
+	ss.print("Enter password: ")
+	password, err := ss.readLine()
+	if err != nil {
+		return false
+	}
+	if password != s.wallet.GetPassword() {
+		ss.println("Xfer: wrong password.")
+		return true
+	}
+	txs, err := consensus.Transfer(s.chain, s.wallet, to, amount, remark)
+	for _, tx := range txs {
+		ss.println("Transaction: " + codec.Hash2Address(tx))
+	}
+	if err != nil {
+		ss.println("Xfer: " + err.Error() + ".")
+		return true
+	}
+	ss.println("Xfer: transferred " + amount.String() + " XDAG to the address " + codec.Hash2Address(to) + ".")
+	return true
+}
+
+func (s *Server) stats(ss *session, args []string) bool {
+	st := s.chain.GetXDAGStats()
+	ss.println("Statistics for ours and maximum known parameters:")
+	ss.println(fmt.Sprintf("            hosts: %d of %d", st.NHosts, st.TotalNHosts))
+	ss.println(fmt.Sprintf("           blocks: %d of %d", st.NBlocks, st.TotalNBlocks))
+	ss.println(fmt.Sprintf("      main blocks: %d of %d", st.NMain, st.TotalNMain))
+	ss.println(fmt.Sprintf("     extra blocks: %d", st.NExtra))
+	ss.println(fmt.Sprintf("    orphan blocks: %d", st.NnoRef))
+	ss.println(fmt.Sprintf(" wait sync blocks: %d", st.NWaitSync))
+	ss.println(fmt.Sprintf(" chain difficulty: %s of %s", diffString(st.Difficulty), diffString(st.MaxDifficulty())))
+	ss.println(fmt.Sprintf("      XDAG supply: %s of %s", common.Amount(s.chain.GetSupply(st.NMain)).String(),
+		common.Amount(s.chain.GetSupply(st.TotalNMain)).String()))
+	s.Lock()
+	registry := s.registry
+	s.Unlock()
+	if registry != nil {
+		ss.println(fmt.Sprintf("pool hashrate MHs: %.2f", registry.PoolHashrate()/1e6))
+	}
+	return true
+}
+
+func diffString(diff *big.Int) string {
+	if diff == nil {
+		return "0"
+	}
+	return diff.Text(16)
+}
+
+func (s *Server) state(ss *session, args []string) bool {
+	st := s.chain.GetXDAGStats()
+	if st.TotalNMain > st.NMain {
+		ss.println("Synchronizing with the network.")
+		return true
+	}
+	ss.println("Synchronized with the main network. Normal operation.")
+	return true
+}
+
+func (s *Server) lastBlocks(ss *session, args []string) bool {
+	n, ok := listCount(ss, args)
+	if !ok {
+		return true
+	}
+	for _, b := range s.chain.ListMainBlock(n) {
+		ss.println(codec.Hash2Address(b.GetHashLow()))
+	}
+	return true
+}
+
+func (s *Server) printBlocks(ss *session, blocks []*core.Block) {
+	ss.println(TABLE_LINE)
+	ss.println(fmt.Sprintf("%-12s %-32s %-23s %s", "height", "address", "time", "state"))
+	ss.println(TABLE_LINE)
+	for _, b := range blocks {
+		var height uint64
+		if b.Info() != nil {
+			height = b.Info().Height
+		}
+		ss.println(fmt.Sprintf("%-12d %-32s %-23s %s", height, codec.Hash2Address(b.GetHashLow()),
+			timeString(b.GetTimestamp()), "Main"))
+	}
+}
+
+func (s *Server) mainBlocks(ss *session, args []string) bool {
+	if n, ok := listCount(ss, args); ok {
+		s.printBlocks(ss, s.chain.ListMainBlock(n))
+	}
+	return true
+}
+
+func (s *Server) minedBlocks(ss *session, args []string) bool {
+	if n, ok := listCount(ss, args); ok {
+		s.printBlocks(ss, s.chain.ListMinedBlock(n))
+	}
+	return true
+}
+
+func (s *Server) block(ss *session, args []string) bool {
+	if len(args) == 0 {
+		ss.println("Usage: block A")
+		return true
+	}
+	hash, err := codec.ParseHash(args[0])
+	if err != nil {
+		ss.println("Address format error.")
+		return true
+	}
+	b := s.chain.GetBlockByHash(hash, false)
+	if b == nil || b.Info() == nil {
+		ss.println("Block is not found.")
+		return true
+	}
+	info := b.Info()
+	ss.println(fmt.Sprintf("      time: %s", timeString(info.Timestamp)))
+	ss.println(fmt.Sprintf(" timestamp: %x", info.Timestamp))
+	ss.println(fmt.Sprintf("     flags: %x", info.Flags))
+	ss.println(fmt.Sprintf("    height: %d", info.Height))
+	ss.println(fmt.Sprintf("      hash: %s", utils.Hash2String(info.Hash)))
+	ss.println(fmt.Sprintf("difficulty: %s", diffString(info.Difficulty)))
+	ss.println(fmt.Sprintf("   balance: %s %20s", codec.Hash2Address(info.HashLow), common.Amount(info.Amount).String()))
+	ss.println(TABLE_LINE)
+	ss.println("                               block as transaction: details")
+	ss.println(fmt.Sprintf("%10s: %-32s %20s", "direction", "address", "amount"))
+	ss.println(TABLE_LINE)
+	ss.println(fmt.Sprintf("%10s: %-32s %20s", "fee", "", common.Amount(info.Fee).String()))
+	for _, in := range b.Inputs {
+		ss.println(fmt.Sprintf("%10s: %-32s %20s", "input", codec.Hash2Address(in.HashLow), common.Amount(in.GetAmount()).String()))
+	}
+	for _, out := range b.Outputs {
+		ss.println(fmt.Sprintf("%10s: %-32s %20s", "output", codec.Hash2Address(out.HashLow), common.Amount(out.GetAmount()).String()))
+	}
+	return true
+}
+
+func (s *Server) miners(ss *session, args []string) bool {
+	s.Lock()
+	registry := s.registry
+	s.Unlock()
+	if registry == nil {
+		ss.println("Pool is not enabled.")
+		return true
+	}
+	ss.println(TABLE_LINE)
+	ss.println(fmt.Sprintf("%-4s %-32s %-16s %8s %6s %8s %14s", "No.", "address", "worker", "accepted", "stale", "invalid", "hashrate MHs"))
+	ss.println(TABLE_LINE)
+	for i, m := range registry.Miners() {
+		ss.println(fmt.Sprintf("%-4s %-32s %-16s %8s %6s %8s %14.2f", strconv.Itoa(i+1)+".", m.Address, "", "", "", "", m.Hashrate/1e6))
+		for _, w := range m.Workers {
+			ss.println(fmt.Sprintf("%-4s %-32s %-16s %8d %6d %8d %14.2f", "", "", w.Worker, w.Accepted, w.Stale, w.Invalid,
+				registry.Hashrate(m.Address, w.Worker)/1e6))
+		}
+	}
+	return true
+}
+
+func (s *Server) net(ss *session, args []string) bool {
+	if len(args) == 0 || args[0] != "conn" {
+		ss.println("Usage: net conn")
+		return true
+	}
+	if s.peers == nil {
+		return true
+	}
+	ss.println(TABLE_LINE)
+	ss.println(fmt.Sprintf("%-22s %-8s %-12s %12s %12s %10s", "host", "dir", "uptime", "in bytes", "out bytes", "latency"))
+	ss.println(TABLE_LINE)
+	for _, p := range s.peers.Peers() {
+		direction := "out"
+		if p.Inbound {
+			direction = "in"
+		}
+		ss.println(fmt.Sprintf("%-22s %-8s %-12s %12d %12d %10s", p.Node.Host+":"+strconv.Itoa(p.Node.Port), direction,
+			time.Since(p.Connected).Truncate(time.Second).String(), p.BytesIn.Get(), p.BytesOut.Get(),
+			p.Latency().Truncate(time.Millisecond).String()))
+	}
+	return true
+}
+
+func (s *Server) pool(ss *session, args []string) bool {
+	c := s.config
+	ss.println(fmt.Sprintf("      address: %s:%d", c.PoolIp(), c.PoolPort()))
+	ss.println(fmt.Sprintf("  payout mode: %s", c.PayoutMode()))
+	ss.println(fmt.Sprintf("      rations: fee %.2f%%, reward %.2f%%, fund %.2f%%, direct %.2f%%",
+		c.PoolRation(), c.RewardRation(), c.FundRation(), c.DirectRation()))
+	ss.println(fmt.Sprintf("       epochs: wait %d, award %d", c.WaitEpoch(), c.AwardEpoch()))
+	s.Lock()
+	registry, awards := s.registry, s.awards
+	s.Unlock()
+	if registry != nil {
+		ss.println(fmt.Sprintf("       miners: %d, hashrate %.2f MHs", len(registry.Miners()), registry.PoolHashrate()/1e6))
+	}
+	if awards == nil {
+		return true
+	}
+	pending := awards.Pending()
+	ss.println(fmt.Sprintf("pending award: %d", len(pending)))
+	for _, r := range pending {
+		ss.println(fmt.Sprintf("               %s height %d due epoch %x", codec.Hash2Address(r.Hash), r.Height, awards.DueEpoch(&r)))
+	}
+	return true
+}
+
+func (s *Server) generate(ss *session, args []string) bool {
+	if len(args) != 1 {
+		ss.println("Usage: generate N")
+		return true
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		ss.println("Generate: incorrect count.")
+		return true
+	}
+	s.Lock()
+	generator := s.generator
+	s.Unlock()
+	if generator == nil {
+		ss.println("Generate: " + consensus.ErrNotRegtest.Error() + ".")
+		return true
+	}
+	hashes, err := generator.Generate(n)
+	for _, h := range hashes {
+		ss.println(codec.Hash2Address(h))
+	}
+	if err != nil {
+		ss.println("Generate: " + err.Error() + ".")
+	}
+	return true
+}
+
+func (s *Server) reload(ss *session, args []string) bool {
+	changes, err := s.config.Reload(config.RELOAD_ADMIN)
+	if err != nil {
+		ss.println("Reload: " + err.Error() + ".")
+		return true
+	}
+	if len(changes) == 0 {
+		ss.println("Reload: no change.")
+		return true
+	}
+	for _, ch := range changes {
+		ss.println("Reload: " + ch.String())
+	}
+	return true
+}
+
+func (s *Server) terminateNode(ss *session, args []string) bool {
+	s.Lock()
+	terminate := s.terminate
+	s.Unlock()
+	if terminate == nil {
+		ss.println("Terminate is not supported.")
+		return true
+	}
+	ss.println("Terminating the node.")
+	// terminate stops this server, it can't wait for this session
+	go terminate()
+	return false
+}
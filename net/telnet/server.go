@@ -0,0 +1,299 @@
+// Package telnet is the line oriented admin console of the node. The console
+// listens on admin.telnet.ip:port, in tls when admin.telnet.tls is set, asks
+// for the telnet password and then runs the classic xdag commands, see
+// commands.go. With admin.telnet.credentials it asks for a login instead and
+// the commands are limited to the allowlist of the login.
+package telnet
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/core"
+	"xdago/log"
+	"xdago/net/auth"
+	"xdago/net/node"
+	"xdago/wallet"
+)
+
+const (
+	MAX_AUTH_TRIES = 3
+	PROMPT         = "xdag> "
+)
+
+var (
+	ErrNoPassword    = errors.New("telnet: neither admin.telnet.password nor admin.telnet.credentials is set")
+	ErrWrongPassword = errors.New("telnet: wrong password")
+)
+
+// Chain is the part of core.IBlockchain used by the console
+type Chain interface {
+	consensus.TransferChain
+	ListMainBlock(count int) []*core.Block
+	ListMinedBlock(count int) []*core.Block
+	GetXDAGStats() *core.XDAGStats
+	GetSupply(nMain uint64) uint64
+}
+
+// Server is the admin console
+type Server struct {
+	sync.Mutex
+	config    *config.Config
+	chain     Chain
+	wallet    *wallet.Wallet
+	peers     *node.PeerManager
+	registry  *consensus.MinerRegistry
+	awards    *consensus.AwardManager
+	generator *consensus.Generator
+	terminate func()
+	logins    *auth.Authenticator
+
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewServer(config *config.Config, chain Chain, wallet *wallet.Wallet, peers *node.PeerManager) *Server {
+	return &Server{
+		config: config,
+		chain:  chain,
+		wallet: wallet,
+		peers:  peers,
+		logins: newLogins(config.TelnetCredentials()),
+		conns:  make(map[net.Conn]struct{}),
+		quit:   make(chan struct{}),
+	}
+}
+
+// newLogins checks the logins of the console, there is no anonymous one
+func newLogins(creds []config.Credential) *auth.Authenticator {
+	return auth.NewAuthenticator(creds, config.Credential{}, commandGroups())
+}
+
+// SetRegistry sets the miner registry shown by the miners and pool commands
+func (s *Server) SetRegistry(registry *consensus.MinerRegistry) {
+	s.Lock()
+	defer s.Unlock()
+	s.registry = registry
+}
+
+// SetAwardManager sets the award manager shown by the pool command
+func (s *Server) SetAwardManager(awards *consensus.AwardManager) {
+	s.Lock()
+	defer s.Unlock()
+	s.awards = awards
+}
+
+// SetGenerator sets the regtest block generator of the generate command
+func (s *Server) SetGenerator(generator *consensus.Generator) {
+	s.Lock()
+	defer s.Unlock()
+	s.generator = generator
+}
+
+// SetTerminate sets the function the terminate command calls to stop the node
+func (s *Server) SetTerminate(terminate func()) {
+	s.Lock()
+	defer s.Unlock()
+	s.terminate = terminate
+}
+
+// Start listens on admin.telnet.ip:port
+func (s *Server) Start() error {
+	if s.config.TelnetPassword() == "" && s.logins.Len() == 0 {
+		return ErrNoPassword
+	}
+	address := net.JoinHostPort(s.config.TelnetIp(), strconv.Itoa(s.config.TelnetPort()))
+	l, err := auth.Listen(address, s.config.TelnetTlsCert(), s.config.TelnetTlsKey())
+	if err != nil {
+		return err
+	}
+	s.Serve(l)
+	return nil
+}
+
+// Serve accepts admin connections on l in the background
+func (s *Server) Serve(l net.Listener) {
+	s.Lock()
+	s.listener = l
+	s.Unlock()
+	log.Info("telnet server started", log.Ctx{"address": l.Addr().String()})
+	s.wg.Add(1)
+	go s.acceptLoop(l)
+}
+
+func (s *Server) Stop() {
+	select {
+	case <-s.quit:
+		return
+	default:
+	}
+	close(s.quit)
+	s.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.Unlock()
+	s.wg.Wait()
+	log.Info("telnet server stopped")
+}
+
+func (s *Server) acceptLoop(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Warn("accept telnet failed", log.Ctx{"err": err.Error()})
+			continue
+		}
+		s.Lock()
+		s.conns[c] = struct{}{}
+		s.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := s.serveConn(c); err != nil && err != io.EOF {
+				log.Debug("telnet disconnected", log.Ctx{"remote": c.RemoteAddr().String(), "err": err.Error()})
+			}
+		}()
+	}
+}
+
+// session is one authenticated console, id is nil for the telnet password
+// which may run all the commands
+type session struct {
+	r  *bufio.Reader
+	w  io.Writer
+	id *auth.Identity
+}
+
+func (ss *session) allowed(name string) bool {
+	group := commands[name].group
+	return ss.id == nil || group == "" || ss.id.Allowed(name)
+}
+
+func (ss *session) print(s string) {
+	io.WriteString(ss.w, s)
+}
+
+func (ss *session) println(s string) {
+	io.WriteString(ss.w, s+"\r\n")
+}
+
+// readLine reads a line without the telnet option negotiation bytes
+func (ss *session) readLine() (string, error) {
+	line, err := ss.r.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	return cleanLine(line), nil
+}
+
+// cleanLine drops the IAC sequences and the line end of a telnet line
+func cleanLine(line []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == 0xff { // IAC
+			if i+1 < len(line) && line[i+1] >= 0xfb && line[i+1] <= 0xfe {
+				i += 2 // WILL, WONT, DO, DONT and the option
+			} else {
+				i++
+			}
+			continue
+		}
+		if c == '\r' || c == '\n' || c == 0 {
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (s *Server) serveConn(c net.Conn) error {
+	defer func() {
+		c.Close()
+		s.Lock()
+		delete(s.conns, c)
+		s.Unlock()
+	}()
+	ss := &session{r: bufio.NewReader(c), w: c}
+	if err := s.auth(ss); err != nil {
+		log.Warn("telnet login failed", log.Ctx{"remote": c.RemoteAddr().String()})
+		return err
+	}
+	if ss.id != nil {
+		log.Info("telnet login", log.Ctx{"remote": c.RemoteAddr().String(), "login": ss.id.Name})
+	} else {
+		log.Info("telnet login", log.Ctx{"remote": c.RemoteAddr().String()})
+	}
+	for {
+		ss.print(PROMPT)
+		line, err := ss.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			continue
+		}
+		if !s.execute(ss, strings.Fields(line)) {
+			return nil
+		}
+	}
+}
+
+func (s *Server) auth(ss *session) error {
+	if s.logins.Len() > 0 {
+		return s.login(ss)
+	}
+	password := []byte(s.config.TelnetPassword())
+	for i := 0; i < MAX_AUTH_TRIES; i++ {
+		ss.print("Password: ")
+		line, err := ss.readLine()
+		if err != nil {
+			return err
+		}
+		if len(password) > 0 && subtle.ConstantTimeCompare([]byte(line), password) == 1 {
+			return nil
+		}
+		ss.println("Wrong password.")
+	}
+	return ErrWrongPassword
+}
+
+// login asks for the user and password of admin.telnet.credentials
+func (s *Server) login(ss *session) error {
+	for i := 0; i < MAX_AUTH_TRIES; i++ {
+		ss.print("Login: ")
+		user, err := ss.readLine()
+		if err != nil {
+			return err
+		}
+		ss.print("Password: ")
+		password, err := ss.readLine()
+		if err != nil {
+			return err
+		}
+		if ss.id = s.logins.Basic(user, password); ss.id != nil {
+			return nil
+		}
+		ss.println("Wrong login.")
+	}
+	return ErrWrongPassword
+}
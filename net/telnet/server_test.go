@@ -0,0 +1,289 @@
+//go:build pebble && !rocksdb
+
+////go:build rocksdb && !pebble
+//conditional build switch for KV store
+
+package telnet
+
+import (
+	"bufio"
+	"github.com/magiconair/properties/assert"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"xdago/codec"
+	"xdago/common"
+	"xdago/config"
+	"xdago/consensus"
+	"xdago/core"
+	"xdago/db/factory"
+	"xdago/net/auth"
+	"xdago/net/node"
+	"xdago/secp256k1"
+	"xdago/utils"
+	"xdago/wallet"
+)
+
+type fakeChain struct {
+	sync.Mutex
+	blocks map[common.Hash]*core.Block
+	ours   map[common.Hash]int
+	main   []*core.Block
+	pairs  map[core.Address]*secp256k1.PrivateKey
+	to     []core.Address
+	txs    []*core.Block
+}
+
+func (c *fakeChain) GetBlockByHash(hash common.Hash, isRaw bool) *core.Block {
+	return c.blocks[hash]
+}
+
+func (c *fakeChain) ListMainBlock(count int) []*core.Block {
+	if count > len(c.main) {
+		count = len(c.main)
+	}
+	return c.main[:count]
+}
+
+func (c *fakeChain) ListMinedBlock(count int) []*core.Block {
+	return c.ListMainBlock(count)[:1]
+}
+
+func (c *fakeChain) GetMemOurBlocks() map[common.Hash]int {
+	return c.ours
+}
+
+func (c *fakeChain) GetXDAGStats() *core.XDAGStats {
+	return &core.XDAGStats{Difficulty: big.NewInt(0x1234), NMain: 2, TotalNMain: 2, Balance: 3 << 32}
+}
+
+func (c *fakeChain) GetSupply(nMain uint64) uint64 {
+	return nMain * 1024 << 32
+}
+
+func (c *fakeChain) CreateNewBlock(pairs map[core.Address]*secp256k1.PrivateKey, to []core.Address,
+	mining bool, remark string) *core.Block {
+	c.Lock()
+	defer c.Unlock()
+	c.pairs, c.to = pairs, to
+	return core.NewBlock(&config.Config{}, utils.GetCurrentTimestamp(), nil, nil, false, nil, remark, -1)
+}
+
+func (c *fakeChain) TryToConnect(block *core.Block) core.ImportResult {
+	c.Lock()
+	defer c.Unlock()
+	c.txs = append(c.txs, block)
+	return core.ImportResult{Status: common.IMPORTED_NOT_BEST, HashLow: block.GetHashLow()}
+}
+
+type console struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialConsole(t *testing.T, address string) *console {
+	conn, err := net.Dial("tcp", address)
+	assert.Equal(t, err, nil)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &console{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// expect reads the output up to the given prompt
+func (c *console) expect(t *testing.T, prompt string) string {
+	var b strings.Builder
+	for !strings.HasSuffix(b.String(), prompt) {
+		ch, err := c.r.ReadByte()
+		if err != nil {
+			t.Fatalf("read %q: %v", b.String(), err)
+		}
+		b.WriteByte(ch)
+	}
+	return strings.TrimSuffix(b.String(), prompt)
+}
+
+func (c *console) run(t *testing.T, line, prompt string) string {
+	c.conn.Write([]byte(line + "\r\n"))
+	return c.expect(t, prompt)
+}
+
+func newTestServer(t *testing.T) (*Server, *fakeChain, string) {
+	c := &config.Config{}
+	c.SetTelnetPassword("secret")
+	return startTestServer(t, c)
+}
+
+func startTestServer(t *testing.T, c *config.Config) (*Server, *fakeChain, string) {
+	c.SetStoreDir(t.TempDir())
+	c.SetPayoutMode(common.PAYOUT_MODE_PROP)
+
+	w := wallet.NewWallet(c)
+	w.UnlockWallet("walletpass")
+	w.AddAccountRandom()
+	w.AddAccountRandom()
+
+	chain := &fakeChain{blocks: make(map[common.Hash]*core.Block), ours: make(map[common.Hash]int)}
+	for i, amount := range []uint64{1 << 32, 2 << 32} {
+		b := core.GenerateAddressBlock(c, w.GetAccount(i), 0x1000<<16)
+		b.Info().Amount = amount
+		b.Info().HashLow = b.GetHashLow()
+		chain.blocks[b.GetHashLow()] = b
+		chain.ours[b.GetHashLow()] = i
+		chain.main = append(chain.main, b)
+	}
+
+	peers := node.NewPeerManager(c)
+	_, err := peers.AddPeer(node.NewNode("10.0.0.1", 8001), true)
+	assert.Equal(t, err, nil)
+
+	kvFactory := factory.NewKvStoreFactory(c)
+	source := kvFactory.GetDB(common.DB_MINERS)
+	source.Init()
+	t.Cleanup(kvFactory.Close)
+	registry := consensus.NewMinerRegistry(source)
+	var h common.Hash
+	h[8] = 7
+	registry.AddShare(consensus.Share{Account: codec.Hash2Address(h), Worker: "rig1",
+		Difficulty: new(big.Int).Lsh(big.NewInt(1), 40), Time: time.Now()})
+
+	s := NewServer(c, chain, &w, peers)
+	s.SetRegistry(registry)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	s.Serve(l)
+	t.Cleanup(s.Stop)
+	return s, chain, l.Addr().String()
+}
+
+func TestConsoleAuth(t *testing.T) {
+	s := NewServer(&config.Config{}, nil, nil, nil)
+	assert.Equal(t, s.Start(), ErrNoPassword)
+
+	_, _, address := newTestServer(t)
+	c := dialConsole(t, address)
+	c.expect(t, "Password: ")
+	for i := 0; i < MAX_AUTH_TRIES-1; i++ {
+		assert.Equal(t, c.run(t, "wrong", "Password: "), "Wrong password.\r\n")
+	}
+	c.conn.Write([]byte("wrong\r\n"))
+	c.expect(t, "Wrong password.\r\n")
+	_, err := c.r.ReadByte()
+	assert.Equal(t, err != nil, true)
+
+	// telnet negotiation bytes are ignored
+	c = dialConsole(t, address)
+	c.expect(t, "Password: ")
+	c.conn.Write([]byte{0xff, 0xfb, 0x01})
+	c.run(t, "secret", PROMPT)
+}
+
+func TestConsoleCommands(t *testing.T) {
+	s, chain, address := newTestServer(t)
+	c := dialConsole(t, address)
+	c.expect(t, "Password: ")
+	c.run(t, "secret", PROMPT)
+	rich, poor := chain.main[1], chain.main[0]
+
+	out := c.run(t, "account", PROMPT)
+	lines := strings.Split(strings.TrimSpace(out), "\r\n")
+	assert.Equal(t, len(lines), 2)
+	assert.Equal(t, strings.HasPrefix(lines[0], codec.Hash2Address(rich.GetHashLow())), true)
+	assert.Equal(t, strings.Contains(lines[0], "2.000000000  key 1"), true)
+	assert.Equal(t, len(strings.Split(strings.TrimSpace(c.run(t, "account 1", PROMPT)), "\r\n")), 1)
+
+	assert.Equal(t, c.run(t, "balance", PROMPT), "Balance: 3.000000000 XDAG\r\n")
+	assert.Equal(t, c.run(t, "balance "+codec.Hash2Address(poor.GetHashLow()), PROMPT), "Balance: 1.000000000 XDAG\r\n")
+	assert.Equal(t, c.run(t, "balance nothing", PROMPT), "Address format error.\r\n")
+
+	out = c.run(t, "block "+utils.Hash2String(rich.GetHashLow()), PROMPT)
+	assert.Equal(t, strings.Contains(out, "   balance: "+codec.Hash2Address(rich.GetHashLow())), true)
+	out = c.run(t, "lastblocks 5", PROMPT)
+	assert.Equal(t, out, codec.Hash2Address(poor.GetHashLow())+"\r\n"+codec.Hash2Address(rich.GetHashLow())+"\r\n")
+	assert.Equal(t, strings.Count(c.run(t, "mainblocks", PROMPT), "Main"), 2)
+	assert.Equal(t, strings.Count(c.run(t, "minedblocks", PROMPT), "Main"), 1)
+	assert.Equal(t, strings.Contains(c.run(t, "stats", PROMPT), "main blocks: 2 of 2"), true)
+	assert.Equal(t, c.run(t, "state", PROMPT), "Synchronized with the main network. Normal operation.\r\n")
+	assert.Equal(t, strings.Contains(c.run(t, "net conn", PROMPT), "10.0.0.1:8001"), true)
+	assert.Equal(t, strings.Contains(c.run(t, "miners", PROMPT), "rig1"), true)
+	assert.Equal(t, strings.Contains(c.run(t, "pool", PROMPT), "payout mode: prop"), true)
+	assert.Equal(t, strings.HasPrefix(c.run(t, "foo", PROMPT), "Illegal command foo"), true)
+	assert.Equal(t, strings.Contains(c.run(t, "help", PROMPT), "xfer S A [R]"), true)
+
+	// 2.5 xdag take the whole richest address and half of the other one
+	var to common.Hash
+	to[8] = 9
+	c.run(t, "xfer 2.5 "+codec.Hash2Address(to)+" rent", "Enter password: ")
+	assert.Equal(t, c.run(t, "nope", PROMPT), "Xfer: wrong password.\r\n")
+	assert.Equal(t, len(chain.txs), 0)
+	c.run(t, "xfer 2.5 "+codec.Hash2Address(to)+" rent", "Enter password: ")
+	out = c.run(t, "walletpass", PROMPT)
+	assert.Equal(t, strings.HasSuffix(out, "Xfer: transferred 2.500000000 XDAG to the address "+codec.Hash2Address(to)+".\r\n"), true)
+	assert.Equal(t, len(chain.txs), 1)
+	assert.Equal(t, len(chain.pairs), 2)
+	for a := range chain.pairs {
+		if a.HashLow == rich.GetHashLow() {
+			assert.Equal(t, a.Amount, uint64(2<<32))
+		} else {
+			assert.Equal(t, a.Amount, uint64(1<<31))
+		}
+	}
+	assert.Equal(t, chain.to[0].HashLow, to)
+	assert.Equal(t, chain.to[0].Amount, uint64(5<<31))
+	c.run(t, "xfer 10 "+codec.Hash2Address(to), "Enter password: ")
+	assert.Equal(t, c.run(t, "walletpass", PROMPT), "Xfer: balance not enough.\r\n")
+
+	assert.Equal(t, c.run(t, "generate 2", PROMPT), "Generate: blocks are generated on the regtest network only.\r\n")
+
+	// the test config has no file to reload
+	assert.Equal(t, strings.HasPrefix(c.run(t, "reload", PROMPT), "Reload: read config"), true)
+
+	// terminate ends the session and calls the node
+	done := make(chan struct{})
+	s.SetTerminate(func() { close(done) })
+	c.conn.Write([]byte("terminate\r\n"))
+	c.expect(t, "Terminating the node.\r\n")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("terminate not called")
+	}
+}
+
+func TestConsoleLogins(t *testing.T) {
+	c := &config.Config{}
+	c.SetTelnetCredentials([]config.Credential{
+		{Name: "viewer", User: "viewer", Password: "vp", Methods: []string{GROUP_READ}, RateLimit: 0.001, Burst: 2},
+		{Name: "admin", User: "admin", Password: "ap", Methods: []string{auth.ALL}},
+	})
+	_, chain, address := startTestServer(t, c)
+
+	// the telnet password is not asked anymore
+	con := dialConsole(t, address)
+	con.expect(t, "Login: ")
+	con.run(t, "viewer", "Password: ")
+	assert.Equal(t, con.run(t, "ap", "Login: "), "Wrong login.\r\n")
+	con.run(t, "viewer", "Password: ")
+	con.run(t, "vp", PROMPT)
+
+	help := con.run(t, "help", PROMPT)
+	assert.Equal(t, strings.Contains(help, "xfer"), false)
+	assert.Equal(t, strings.Contains(help, "balance [A]"), true)
+	var to common.Hash
+	to[8] = 9
+	assert.Equal(t, con.run(t, "xfer 1 "+codec.Hash2Address(to), PROMPT), "Command xfer is not allowed.\r\n")
+	assert.Equal(t, con.run(t, "terminate", PROMPT), "Command terminate is not allowed.\r\n")
+	assert.Equal(t, con.run(t, "balance", PROMPT), "Balance: 3.000000000 XDAG\r\n")
+	assert.Equal(t, con.run(t, "state", PROMPT), "Synchronized with the main network. Normal operation.\r\n")
+	assert.Equal(t, con.run(t, "balance", PROMPT), "Rate limit exceeded.\r\n")
+
+	con = dialConsole(t, address)
+	con.expect(t, "Login: ")
+	con.run(t, "admin", "Password: ")
+	con.run(t, "ap", PROMPT)
+	con.run(t, "xfer 1 "+codec.Hash2Address(to), "Enter password: ")
+	out := con.run(t, "walletpass", PROMPT)
+	assert.Equal(t, strings.HasSuffix(out, "Xfer: transferred 1.000000000 XDAG to the address "+codec.Hash2Address(to)+".\r\n"), true)
+	assert.Equal(t, len(chain.txs), 1)
+}
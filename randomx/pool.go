@@ -0,0 +1,174 @@
+package randomx
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"xdago/common"
+)
+
+var ErrSeedNotPrepared = errors.New("randomx: seed not prepared")
+
+// seedMemory is the cache, dataset and vms of one seed
+type seedMemory struct {
+	inUse   sync.RWMutex // read locked by the running hashes
+	cache   Cache
+	dataset Dataset
+	vms     chan VM
+	count   int
+}
+
+// Pool keeps the RandomX memory of the prepared seeds and hands out vms to
+// concurrent hashers, it implements consensus.PowHasher
+type Pool struct {
+	sync.Mutex
+	flags    Flags
+	fullMem  bool
+	vmCount  int
+	memories map[string]*seedMemory
+}
+
+// NewPool creates a pool with vmCount vms per seed. fullMem builds the 2 GiB
+// dataset for fast hashing, otherwise vms run in light mode from the cache.
+func NewPool(vmCount int, fullMem, largePages bool) *Pool {
+	if vmCount <= 0 {
+		vmCount = runtime.NumCPU()
+	}
+	flags := GetFlags()
+	if largePages {
+		flags |= FlagLargePages
+	}
+	if fullMem {
+		flags |= FlagFullMEM
+	}
+	return &Pool{
+		flags:    flags,
+		fullMem:  fullMem,
+		vmCount:  vmCount,
+		memories: make(map[string]*seedMemory),
+	}
+}
+
+// InitDatasetParallel initializes dataset from cache with threads goroutines
+func InitDatasetParallel(dataset Dataset, cache Cache, threads int) {
+	count := DatasetItemCount()
+	if threads <= 1 {
+		InitDataset(dataset, cache, 0, count)
+		return
+	}
+	var wg sync.WaitGroup
+	per := count / uint32(threads)
+	for i := 0; i < threads; i++ {
+		start := uint32(i) * per
+		n := per
+		if i == threads-1 {
+			n = count - start
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			InitDataset(dataset, cache, start, n)
+		}()
+	}
+	wg.Wait()
+}
+
+func (m *seedMemory) release() {
+	for i := 0; i < m.count; i++ {
+		DestroyVM(<-m.vms)
+	}
+	if m.dataset != nil {
+		ReleaseDataset(m.dataset)
+	}
+	ReleaseCache(m.cache)
+}
+
+// Prepare allocates and initializes the memory of seed
+func (p *Pool) Prepare(seed []byte) error {
+	p.Lock()
+	_, ok := p.memories[string(seed)]
+	p.Unlock()
+	if ok {
+		return nil
+	}
+
+	m := &seedMemory{vms: make(chan VM, p.vmCount)}
+	cache, err := AllocCache(p.flags &^ FlagFullMEM)
+	if err != nil {
+		return err
+	}
+	m.cache = cache
+	InitCache(cache, seed)
+	if p.fullMem {
+		dataset, err := AllocDataset(p.flags)
+		if err != nil {
+			m.release()
+			return err
+		}
+		m.dataset = dataset
+		InitDatasetParallel(dataset, cache, runtime.NumCPU())
+	}
+	for i := 0; i < p.vmCount; i++ {
+		vm, err := CreateVM(m.cache, m.dataset, p.flags)
+		if err != nil {
+			m.release()
+			return err
+		}
+		m.vms <- vm
+		m.count++
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.memories[string(seed)]; ok {
+		m.release()
+		return nil
+	}
+	p.memories[string(seed)] = m
+	return nil
+}
+
+// Hash calculates the RandomX hash of data with the memory of seed, it blocks
+// while all vms of the seed are busy
+func (p *Pool) Hash(seed, data []byte) (common.Hash, error) {
+	p.Lock()
+	m, ok := p.memories[string(seed)]
+	if !ok {
+		p.Unlock()
+		return common.Hash{}, ErrSeedNotPrepared
+	}
+	// taken before the memory can leave the map, so a release waits for it
+	m.inUse.RLock()
+	p.Unlock()
+	defer m.inUse.RUnlock()
+	vm := <-m.vms
+	var hash common.Hash
+	copy(hash[:], CalculateHash(vm, data))
+	m.vms <- vm
+	return hash, nil
+}
+
+// Release frees the memory of seed once the running hashes are done, the
+// later hashes of seed fail with ErrSeedNotPrepared
+func (p *Pool) Release(seed []byte) {
+	p.Lock()
+	m, ok := p.memories[string(seed)]
+	delete(p.memories, string(seed))
+	p.Unlock()
+	if ok {
+		m.inUse.Lock()
+		m.release()
+	}
+}
+
+// Close releases the memory of all seeds
+func (p *Pool) Close() {
+	p.Lock()
+	memories := p.memories
+	p.memories = make(map[string]*seedMemory)
+	p.Unlock()
+	for _, m := range memories {
+		m.inUse.Lock()
+		m.release()
+	}
+}
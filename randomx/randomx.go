@@ -27,6 +27,8 @@ const (
 	FlagArgon2      C.randomx_flags = 96 // = avx2 + sse3
 )
 
+type Flags = C.randomx_flags
+
 type Cache *C.randomx_cache
 
 type Dataset *C.randomx_dataset
@@ -112,7 +114,7 @@ func CreateVM(cache Cache, dataset Dataset, flags ...C.randomx_flags) (VM, error
 		SumFlag = SumFlag | flag
 	}
 
-	if dataset == nil {
+	if dataset == nil && SumFlag&FlagFullMEM != 0 {
 		panic("failed creating vm: using empty dataset")
 	}
 
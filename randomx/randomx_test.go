@@ -278,3 +278,38 @@ func BenchmarkCalculateHashLargePage(b *testing.B) {
 	randomx.DestroyVM(vm)
 	randomx.ReleaseDataset(ds)
 }
+
+func TestPool(t *testing.T) {
+	pool := randomx.NewPool(2, false, false)
+	defer pool.Close()
+	seed := testPairs[0][0]
+	_, err := pool.Hash(seed, testPairs[0][1])
+	if err != randomx.ErrSeedNotPrepared {
+		t.Fatal("hash before prepare:", err)
+	}
+	if err := pool.Prepare(seed); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hash, err := pool.Hash(seed, testPairs[0][1])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if hex.EncodeToString(hash[:]) != string(testPairs[0][2]) {
+				t.Error("wrong hash", hex.EncodeToString(hash[:]))
+			}
+		}()
+	}
+	wg.Wait()
+
+	pool.Release(seed)
+	if _, err := pool.Hash(seed, testPairs[0][1]); err != randomx.ErrSeedNotPrepared {
+		t.Fatal("hash after release:", err)
+	}
+}
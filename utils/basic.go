@@ -2,6 +2,8 @@ package utils
 
 import (
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"math"
 	"unicode"
@@ -24,6 +26,8 @@ func IsAsciiPrintable(s string) bool {
 	return true
 }
 
+var ErrInvalidHash = errors.New("invalid hash")
+
 func Hash2String(h [32]byte) string {
 	return fmt.Sprintf("%016x%016x%016x%016x",
 		binary.LittleEndian.Uint64(h[24:]),
@@ -32,6 +36,19 @@ func Hash2String(h [32]byte) string {
 		binary.LittleEndian.Uint64(h[:8]))
 }
 
+// String2Hash 是Hash2String的逆运算
+func String2Hash(s string) ([32]byte, error) {
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return h, ErrInvalidHash
+	}
+	for i := range b {
+		h[i] = b[len(b)-1-i]
+	}
+	return h, nil
+}
+
 func Type2String(i uint64) string {
 	var b [8]byte
 	binary.LittleEndian.PutUint64(b[:], i)
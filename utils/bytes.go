@@ -15,6 +15,19 @@ func KeyStartWith(source, prefix []byte) bool {
 	return true
 }
 
+// KeyPrefixEnd returns the first key after all the keys starting with
+// prefix, nil when there is none
+func KeyPrefixEnd(prefix []byte) []byte {
+	end := Copy2(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
 func MergeBytes(array ...[]byte) []byte {
 	var total int
 	for _, arr := range array {
@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"sync/atomic"
 	"time"
 )
 
+// clockOffset is added to the system time, the regtest generator moves the
+// clock of the node past the blocks it makes
+var clockOffset uint64
+
 //GetEndOfEpoch 获取时间戳所属epoch的最后一个时间戳 主要用于mainblock
 func GetEndOfEpoch(t uint64) uint64 {
 	return t | 0xffff
@@ -20,6 +25,10 @@ func GetEpoch(t uint64) uint64 {
 
 //GetCurrentTimestamp 获取当前的xdag时间戳
 func GetCurrentTimestamp() uint64 {
+	return systemTimestamp() + atomic.LoadUint64(&clockOffset)
+}
+
+func systemTimestamp() uint64 {
 	t := time.Now().UTC().UnixNano()
 	sec := t / 1e9
 	usec := (t - sec*1e9) / 1e3
@@ -27,6 +36,24 @@ func GetCurrentTimestamp() uint64 {
 	return uint64(sec)<<10 | uint64(xmsec)
 }
 
+// Now returns the current time of the node, it is the system time unless the
+// clock was advanced
+func Now() time.Time {
+	return time.Now().Add(time.Duration(XdagTimestamp2Ms(atomic.LoadUint64(&clockOffset))) * time.Millisecond)
+}
+
+// AdvanceClock moves the current time to t when it is behind, the time never
+// goes back
+func AdvanceClock(t uint64) {
+	for {
+		offset := atomic.LoadUint64(&clockOffset)
+		now := systemTimestamp()
+		if now+offset >= t || atomic.CompareAndSwapUint64(&clockOffset, offset, t-now) {
+			return
+		}
+	}
+}
+
 //Ms2XdagTimestamp 把毫秒转为xdag时间戳
 func Ms2XdagTimestamp(ms uint64) uint64 {
 	sec := ms / 1e3